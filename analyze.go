@@ -11,11 +11,14 @@ package propagate
 
 import (
 	"fmt"
+	"go/ast"
 	"go/token"
 	"go/types"
+	"golang.org/x/tools/go/ast/astutil"
 	cg "golang.org/x/tools/go/callgraph"
+	"golang.org/x/tools/go/packages"
 	"golang.org/x/tools/go/ssa"
-	"log"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -25,12 +28,21 @@ func (cfg *analyzerConfig) analyze() {
 
 	// collect some preliminary information from the code base that is used later on during analysis
 	cfg.collectInterfacesAndThirdPartyEmbeds()
+	cfg.collectStreamIfaces()
+	cfg.eliminateDeadCode()
 	cfg.collectCollectionFnsAndMarkExternalInterfaceFns()
+	cfg.collectClosureBoundaryArgs()
 	cfg.markExternalParamFns()
+	cfg.markSkippedFileFns()
+	cfg.markFrozenFns()
+	cfg.markLinknameFns()
+	cfg.markCgoExportFns()
 	// start building work list of functions that need to be modified using "leaf" API calls
 	nodesWorkList, nodesVisited := cfg.processLeafCalls()
+	cfg.reportUnusedLibFns()
 	// process remaining items on the work list
 	cfg.collect(nodesWorkList, nodesVisited)
+	cfg.collectCarrierCtors(nodesWorkList, nodesVisited)
 
 	// Visit all functions again to see if any of the interface-type
 	// parameters takes a value of type that is not context-aware yet.
@@ -41,64 +53,197 @@ func (cfg *analyzerConfig) analyze() {
 	for added {
 		added = cfg.collectIfaces(namedModified)
 		cfg.collectNamedTypes(namedModified)
+		added = cfg.collectConversions(namedModified) || added
 	}
+	// Calls made through values extracted from interfaces via type
+	// assertions (or type switches) may not be represented in the
+	// call graph - make sure that they are updated if the asserted
+	// named function type has been modified.
+	cfg.collectTypeAssertCalls(namedModified)
+	cfg.collectBlankCtxParams()
+	cfg.collectCommandCtxCalls()
+	cfg.reportCtxEscapes()
+	cfg.reportLeafStats()
+	cfg.reportFrozenFns()
+	cfg.collectStats()
+	cfg.writeAffectedTests()
+	cfg.writePackageGraph()
+	cfg.traceResults()
+	cfg.explainResults()
 
 }
 
 // collectInterfacesAndThirdPartyEmbeds gathers information about all
 // defined interfaces and all struct types that embed a third-party
-// struct.
+// struct or a test suite struct.
 func (cfg *analyzerConfig) collectInterfacesAndThirdPartyEmbeds() {
 	cfg.ifaces = make(map[*types.Interface]*types.Package)
+	cfg.ifaceCopies = make(map[*types.Interface][]*types.Interface)
+	cfg.canonicalIfaces = make(map[*types.Interface]*types.Interface)
 	cfg.extRecvTypes = make(map[*types.Struct]bool)
+	cfg.testSuiteRecvTypes = make(map[*types.Struct]bool)
+	cfg.frozenIfaces = make(map[*types.Interface]bool)
+	canonicalByKey := make(map[string]*types.Interface)
 	for _, pkg := range cfg.initial {
 		for _, name := range pkg.Types.Scope().Names() {
 			typ := pkg.Types.Scope().Lookup(name).Type().Underlying()
 			// collect info about all interfaces
 			if i, ok := typ.(*types.Interface); ok {
-				cfg.ifaces[i] = pkg.Types
-				if pkg.PkgPath == cfg.LibPkgPath && pkg.Name == cfg.LibPkgName {
-					if name == cfg.LibIface {
-						cfg.libIfaces = append(cfg.libIfaces, i)
-					}
+				canonical := cfg.addIface(canonicalByKey, pkg.PkgPath+"."+name, i, pkg.Types)
+				if cfg.isLibPkg(pkg.PkgPath, pkg.Name) && name == cfg.LibIface && canonical == i {
+					cfg.libIfaces = append(cfg.libIfaces, i)
+				}
+				if ast.IsExported(name) && cfg.isPkgFrozen(pkg.PkgPath) {
+					cfg.frozenIfaces[canonical] = true
 				}
 			}
-			// collect info about all structs that embed a third-party struct type specified in the config file
+			// collect info about all structs that embed a third-party
+			// struct type or a test suite type specified in the config
+			// file
 			s, ok := typ.(*types.Struct)
 			if !ok {
 				// not a struct
 				continue
 			}
-			for i := 0; i < s.NumFields(); i++ {
-				f := s.Field(i)
-				if !f.Embedded() {
-					continue
-				}
-				named, ok := f.Type().(*types.Named)
-				if !ok {
-					// not a named type
-					continue
-				}
-				pkgPaths, exists := cfg.ExtEmbedTypes[named.Obj().Name()]
-				if !exists {
-					// named type is not external embedded type
+			if embedsType(s, cfg.ExtEmbedTypes) {
+				cfg.extRecvTypes[s] = true
+			}
+			if embedsType(s, cfg.TestSuiteTypes) {
+				cfg.testSuiteRecvTypes[s] = true
+			}
+		}
+		cfg.collectParamIfaceLits(canonicalByKey, pkg)
+	}
+}
+
+// addIface records an interface identified by a given name (qualified
+// with package path) unless it is a copy of an interface that has
+// already been recorded and returns the canonical instance of the
+// interface. The same package can be loaded more than once (e.g. as a
+// test variant) in which case each copy defines its own interface
+// with the same name and method set - all copies are represented by a
+// single (canonical) instance so that modifications of the interface
+// are recorded only once and its implementations are checked against
+// all copies.
+func (cfg *analyzerConfig) addIface(canonicalByKey map[string]*types.Interface, name string, i *types.Interface, pkg *types.Package) *types.Interface {
+	if canonical, exists := cfg.canonicalIfaces[i]; exists {
+		// the same interface under a different name (e.g. an alias)
+		return canonical
+	}
+	key := name + " " + types.TypeString(i, nil)
+	canonical, exists := canonicalByKey[key]
+	if !exists {
+		canonical = i
+		canonicalByKey[key] = i
+		cfg.ifaces[i] = pkg
+	}
+	cfg.canonicalIfaces[i] = canonical
+	cfg.ifaceCopies[canonical] = append(cfg.ifaceCopies[canonical], i)
+	return canonical
+}
+
+// collectParamIfaceLits gathers anonymous interfaces (interface
+// literals) used as parameter types in a given package - similarly to
+// interfaces defined in package scopes, their methods need to be
+// modified when their implementations are.
+func (cfg *analyzerConfig) collectParamIfaceLits(canonicalByKey map[string]*types.Interface, pkg *packages.Package) {
+	for _, f := range pkg.Syntax {
+		ast.Inspect(f, func(n ast.Node) bool {
+			ft, ok := n.(*ast.FuncType)
+			if !ok || ft.Params == nil {
+				return true
+			}
+			for _, fld := range ft.Params.List {
+				if _, ok := astutil.Unparen(fld.Type).(*ast.InterfaceType); !ok {
 					continue
 				}
-				pkgNames, exists := pkgPaths[named.Obj().Pkg().Path()]
-				if !exists {
-					// named type is not external embedded type
-					// (package path mismatch)
-					continue
+				if i, ok := pkg.TypesInfo.TypeOf(fld.Type).(*types.Interface); ok {
+					// interface literals are identified by their
+					// positions
+					cfg.addIface(canonicalByKey, pkg.PkgPath+" "+pkg.Fset.Position(fld.Type.Pos()).String(), i, pkg.Types)
 				}
+			}
+			return true
+		})
+	}
+}
 
-				if pkgNames[named.Obj().Pkg().Name()] {
-					cfg.extRecvTypes[s] = true
-				}
+// collectStreamIfaces gathers interfaces specified in the config file
+// as providing context (interfaces defined in packages that are not
+// loaded are ignored as no function can take them as parameters).
+func (cfg *analyzerConfig) collectStreamIfaces() {
+	cfg.streamIfaces = make(map[*types.Interface]string)
+	for _, info := range cfg.StreamContextExtract {
+		pkg := cfg.prog.ImportedPackage(info.PkgPath)
+		if pkg == nil {
+			continue
+		}
+		obj := pkg.Pkg.Scope().Lookup(info.InterfaceName)
+		if obj == nil {
+			fatal("interface " + info.PkgPath + "." + info.InterfaceName + " providing context not found")
+		}
+		iface, ok := obj.Type().Underlying().(*types.Interface)
+		if !ok {
+			fatal("type " + info.PkgPath + "." + info.InterfaceName + " providing context is not an interface")
+		}
+		hasMethod := false
+		for i := 0; i < iface.NumMethods(); i++ {
+			if iface.Method(i).Name() == info.MethodName {
+				hasMethod = true
+				break
 			}
 		}
+		if !hasMethod {
+			fatal("interface " + info.PkgPath + "." + info.InterfaceName + " has no method " + info.MethodName)
+		}
+		cfg.streamIfaces[iface] = info.MethodName
 	}
 }
 
+// embedsType determines if a given struct embeds one of the types
+// specified in the config file.
+func embedsType(s *types.Struct, embedTypes typeInfo) bool {
+	for i := 0; i < s.NumFields(); i++ {
+		f := s.Field(i)
+		if !f.Embedded() {
+			continue
+		}
+		t := f.Type()
+		if ptr, ok := t.(*types.Pointer); ok {
+			// embedded pointer to a named type
+			t = ptr.Elem()
+		}
+		named, ok := t.(*types.Named)
+		if !ok {
+			// not a named type
+			continue
+		}
+		if isListedType(named, embedTypes) {
+			return true
+		}
+	}
+	return false
+}
+
+// isListedType determines if a given named type is one of the types
+// specified in the config file.
+func isListedType(named *types.Named, typs typeInfo) bool {
+	if named.Obj().Pkg() == nil {
+		// predeclared type (e.g. error)
+		return false
+	}
+	pkgPaths, exists := typs[named.Obj().Name()]
+	if !exists {
+		return false
+	}
+	pkgNames, exists := pkgPaths[named.Obj().Pkg().Path()]
+	if !exists {
+		// package path mismatch
+		return false
+	}
+	return pkgNames[named.Obj().Pkg().Name()]
+}
+
 // collectCollectionFnsAndMarkExternalInterfaceFns collects signatures
 // of functions that can be stored in collections and marks functions
 // that implement external interfaces as being used externally.
@@ -106,7 +251,7 @@ func (cfg *analyzerConfig) collectCollectionFnsAndMarkExternalInterfaceFns() {
 	// The two pieces functionality are combined for performance
 	// reasons as they require iterating over all instructions.
 	for f, _ := range cfg.graph.Nodes {
-		if f != nil && f.Package() != nil && f.Blocks == nil {
+		if f == nil || f.Blocks == nil {
 			// not a "concrete" (with a body) function
 			continue
 		}
@@ -145,6 +290,7 @@ func (cfg *analyzerConfig) collectCollectionFnsAndMarkExternalInterfaceFns() {
 							sel := methodSet.At(j)
 							fun := cfg.prog.MethodValue(sel)
 							if fun != nil {
+								cfg.trace(fun, "converted to external interface "+named.String())
 								cfg.fnVisited[cfg.getUniquePosSSAFn(fun, fun.Pos())] = extFn
 							}
 						}
@@ -179,6 +325,11 @@ func (cfg *analyzerConfig) markExternalParamFns() {
 			// not an actual function
 			continue
 		}
+		if cfg.isClosureBoundaryFn(f) {
+			// named functions passed as arguments receive context
+			// of the caller (see collectClosureBoundaryArgs)
+			continue
+		}
 		for _, pkgPath := range cfg.ExtPkgPaths {
 			if !strings.HasPrefix(f.Package().Pkg.Path(), pkgPath) {
 				// not an external function
@@ -193,7 +344,7 @@ func (cfg *analyzerConfig) markExternalParamFns() {
 
 				if ok_func || ok_iface {
 					for _, caller := range n.In {
-						arg := getActualCallArg(caller.Site.Common(), i)
+						arg := getActualCallArg(caller.Site.Common(), params, i)
 						cfg.markParamAsExternalFn(&arg)
 					}
 				}
@@ -207,7 +358,7 @@ func (cfg *analyzerConfig) markExternalParamFns() {
 					// into the instruction stream when building SSA representation
 					// but I can't figure out a better way
 					getVarArgs := func(vals *[]*ssa.Value) {
-						arg := getActualCallArg(common, params.Len()-1)
+						arg := getActualCallArg(common, params, params.Len()-1)
 						s, ok := arg.(*ssa.Slice)
 						if !ok {
 							// argument is not a variadic (it's not a slice)
@@ -251,255 +402,1527 @@ func (cfg *analyzerConfig) markExternalParamFns() {
 	}
 }
 
-// processLeafCalls marks "leaf" API calls for addition of the context
-// argument (and optional renaming) and start processing their callers
-// transitively.
-func (cfg *analyzerConfig) processLeafCalls() ([]*cg.Node, map[int]bool) {
-	leafCalls := make(map[uniquePosInfo]bool)
-	nodesWorkList := make([]*cg.Node, 0)
-	nodesVisited := make(map[int]bool)
+// collectClosureBoundaryArgs collects named functions (and method
+// values) passed as arguments to closure-boundary functions. Rather
+// than receiving artificial context, these named functions receive
+// context of the function passing them, as their arguments are
+// rewritten into closures forwarding context.
+func (cfg *analyzerConfig) collectClosureBoundaryArgs() {
 	for f, n := range cfg.graph.Nodes {
-		if f == nil {
-			// not an actual function
+		if f == nil || f.Package() == nil || !cfg.isClosureBoundaryFn(f) {
 			continue
 		}
-		for libFnName, recvs := range cfg.LibFns {
-			sig := f.Signature
-			if f.Name() != libFnName {
-				// not a leaf function name
+		params := f.Signature.Params()
+		for _, in := range n.In {
+			if cfg.isPkgExternal(getFnPkgPath(in.Caller.Func)) {
+				// code in external packages is not rewritten
 				continue
 			}
-
-			// currently we support either specifying concrete leaf functions and methods (with renaming)
-			// or specifying interface in the library where leaf methods are defined (no renaming)
-			// TODO: this does not currently work for methods with receiver type that's a pointer
-			recv := sig.Recv()
-			if cfg.libIfaces != nil {
-				if recv == nil || (recv.Pkg() != nil && cfg.isPkgExternal(recv.Pkg().Path())) {
-					// ignore non-methods and methods whose receiver
-					// is defined externally (we can't do much about
-					// medthod implementations in third-party code)
-					continue
-				}
-				for _, li := range cfg.libIfaces {
-					if types.Implements(recv.Type(), li) {
-						msg := "WARNING: function " + f.Name() + " implements library interface " + cfg.LibIface + " and, consequently, receives context parameter but may in fact not use context"
-						cfg.writeWarning(cfg.getFset(f), f.Pos(), msg)
-						cfg.collectFnDef(nodesWorkList, nodesVisited, n, f.Name(), getTypeWithPkgFromVar(recv))
-					}
-				}
-				continue // we are specifying functions via an interface so skip the rest of the loop
-			}
-
-			for recv, callReplacement := range recvs {
-				pkg := f.Package()
-				if pkg == nil || pkg.Pkg.Path() != cfg.LibPkgPath || pkg.Pkg.Name() != cfg.LibPkgName {
-					// function definition does not match a given leaf
-					// function specified in the config file
-					continue
-				}
-				libFnRecvType := getTypeWithPkgFromVar(sig.Recv())
-				if libFnRecvType != recv {
-					// function's receiver does not match one
-					// (possibly nil) specified for a given leaf
-					// function in the config file
+			for i := 0; i < params.Len(); i++ {
+				arg := getActualCallArg(in.Site.Common(), params, i)
+				var argFn *ssa.Function
+				if mc, ok := arg.(*ssa.MakeClosure); ok {
+					argFn = mc.Fn.(*ssa.Function) // always a function
+				} else if argFn, ok = arg.(*ssa.Function); !ok {
 					continue
-
 				}
-				for _, in := range n.In {
-					uniquePos := cfg.getUniquePosSSAFn(in.Site.Parent(), in.Pos())
-					doRename := func(pkgPath string, pkgName string, recvType string, fnName string) {
-						if pkgPath == cfg.LibPkgPath && pkgName == cfg.LibPkgName && recvType == libFnRecvType && fnName == libFnName && callReplacement.newName != "" {
-							cfg.callSitesRenamed[uniquePos] = callReplacement.newName
-						}
-					}
-					calledViaLiteral := renameCall(in.Site.Common(), doRename)
-					if !calledViaLiteral {
-						// function is not called via a function
-						// literal (instead, for example, it's called
-						// via a variable)
+				if argFn.Synthetic != "" {
+					// method value or method expression
+					if argFn = cfg.getWrappedMethod(argFn); argFn == nil {
 						continue
 					}
-					leafCalls[uniquePos] = true
-					paramName := cfg.collectFnDef(nodesWorkList, nodesVisited, in.Caller, in.Caller.Func.Name(),
-						getTypeWithPkgFromVar(in.Caller.Func.Signature.Recv()))
-					if paramName == cfg.CtxParamName {
-						// use default context parameter name specified in the config file
-						cfg.callSites[uniquePos] = callReplacement
-					} else {
-						// use context parameter name specified in the caller
-						newCallReplacement := replacementInfo{callReplacement.newName,
-							callReplacement.argPos,
-							callReplacement.ctxImports,
-							callReplacement.ctxRegExpr,
-							replaceCtxExprWildcard(ctxWildcard, callReplacement.ctxRegExpr, paramName)}
-						cfg.callSites[uniquePos] = &newCallReplacement
-					}
+				} else if argFn.Parent() != nil {
+					// anonymous functions receive context of the
+					// enclosing function as a free variable
+					continue
 				}
+				cfg.closureBoundarySites[argFn] = append(cfg.closureBoundarySites[argFn], closureBoundarySite{in, i})
 			}
 		}
 	}
-	if cfg.debugLevel > 0 {
-		fmt.Println("LEAF FUNCTION CALLS: " + strconv.Itoa(len(leafCalls)))
+}
+
+// isClosureBoundaryFn checks if a given function is one of the
+// closure-boundary functions specified in the config file.
+func (cfg *analyzerConfig) isClosureBoundaryFn(f *ssa.Function) bool {
+	if f.Origin() != nil {
+		// instantiation of a generic function
+		f = f.Origin()
 	}
-	return nodesWorkList, nodesVisited
+	if f.Pkg == nil {
+		return false
+	}
+	recvs, exists := cfg.ClosureBoundaryFns[f.Name()]
+	if !exists {
+		return false
+	}
+	pkgPaths, exists := recvs[getTypeWithPkgFromVar(f.Signature.Recv())]
+	if !exists {
+		return false
+	}
+	pkgNames, exists := pkgPaths[f.Pkg.Pkg.Path()]
+	return exists && pkgNames[f.Pkg.Pkg.Name()]
 }
 
-// collect gathers information about call sites and function
-// definitions that must be re-written for context propagation.
-func (cfg *analyzerConfig) collect(nodesWorkList []*cg.Node, nodesVisited map[int]bool) {
-	l := len(nodesWorkList)
-	if l <= 0 {
-		// no more work items
-		return
+// isContextFlowBarrier checks if a given function is one of the
+// context flow barriers specified in the config file.
+func (cfg *analyzerConfig) isContextFlowBarrier(f *ssa.Function) bool {
+	if f.Pkg == nil {
+		return false
 	}
-	// get a node from the work list
-	n := nodesWorkList[l-1]
-	nodesWorkList = nodesWorkList[:l-1]
-	// iterate over this function's call sites
-	for _, in := range n.In {
-		if !in.Pos().IsValid() {
-			// TODO not sure what to do with functions that do not really exist in the source
-			cfg.collect(nodesWorkList, nodesVisited)
-			return
+	recvs, exists := cfg.ContextFlowBarriers[f.Name()]
+	if !exists {
+		return false
+	}
+	pkgPaths, exists := recvs[getTypeWithPkgFromVar(f.Signature.Recv())]
+	if !exists {
+		return false
+	}
+	pkgNames, exists := pkgPaths[f.Pkg.Pkg.Path()]
+	return exists && pkgNames[f.Pkg.Pkg.Name()]
+}
 
+// isClosureBoundaryCaller checks if a given function is a
+// closure-boundary function or is nested in one.
+func (cfg *analyzerConfig) isClosureBoundaryCaller(f *ssa.Function) bool {
+	for ; f != nil; f = f.Parent() {
+		if cfg.isClosureBoundaryFn(f) {
+			return true
 		}
-		if strings.ContainsAny(n.Func.Name(), "$") && n.Func.Parent() != in.Site.Parent() {
-			// if a call to anonymous function is not in the same scope as the function definition
-			// then the call graph information about this call is likely incorrect - ignore
+	}
+	return false
+}
+
+// collectClosureBoundarySites collects functions passing a given named
+// function to closure-boundary functions - these functions need
+// context to be forwarded to the named function by a closure
+// replacing it at the call site.
+func (cfg *analyzerConfig) collectClosureBoundarySites(nodesWorkList []*cg.Node, nodesVisited map[int]bool, fn *ssa.Function) {
+	for _, site := range cfg.closureBoundarySites[fn] {
+		caller := site.edge.Caller
+		if caller.Func.Pkg == nil {
 			continue
 		}
-		// record each call site; documentation for https://godoc.org/golang.org/x/tools/go/ssa#Call
-		// says: "Pos() returns the ast.CallExpr.Lparen, if explicit in the source"
+		recvType := getTypeWithPkgFromVar(caller.Func.Signature.Recv())
+		cfg.traceReach(caller.Func, fn, "passes "+fn.String()+" to closure-boundary function at "+cfg.tracePos(caller.Func, site.edge.Site.Common().Pos()))
+		paramName := cfg.collectFnDef(nodesWorkList, nodesVisited, caller, caller.Func.Name(), recvType)
+		uniquePos := cfg.getUniquePosCallSite(site.edge)
+		args, exists := cfg.closureArgs[uniquePos]
+		if !exists {
+			args = make(map[int]string)
+			cfg.closureArgs[uniquePos] = args
+		}
+		args[site.argIndex] = paramName
+	}
+}
 
-		// determine if the function containing the call site should have context argument injection skipped
-		skipContextParam := false
-		if !skipContextParam {
-			// skip if first parameter is context already
-			isParamContext, _, paramName, _, custom := cfg.isFirstParamContext(in.Site.Common().Signature())
-			skipContextParam = isParamContext && (custom || paramName == "_" || paramName == "" || paramName == cfg.CtxParamName)
+// markSkippedFileFns marks functions defined in files that will not
+// be transformed (due to their size) and in generated files (where
+// only call sites are transformed) so that propagation stops at these
+// functions instead of modifying their signatures.
+func (cfg *analyzerConfig) markSkippedFileFns() {
+	if len(cfg.skippedFiles) == 0 && len(cfg.generatedFiles) == 0 {
+		return
+	}
+	for _, p := range cfg.initial {
+		for ind, f := range p.Syntax {
+			var fnType int
+			if cfg.skippedFiles[p.CompiledGoFiles[ind]] {
+				fnType = skippedFileFn
+			} else if cfg.generatedFiles[p.CompiledGoFiles[ind]] {
+				fnType = generatedFn
+			} else {
+				continue
+			}
+			ast.Inspect(f, func(n ast.Node) bool {
+				var pos token.Pos
+				if fd, ok := n.(*ast.FuncDecl); ok {
+					pos = fd.Name.NamePos
+				} else if fl, ok := n.(*ast.FuncLit); ok {
+					pos = fl.Type.Func
+				} else {
+					return true
+				}
+				uniquePos := cfg.getUniquePosPkg(p.Types, pos)
+				if _, exists := cfg.fnVisited[uniquePos]; !exists {
+					cfg.fnVisited[uniquePos] = fnType
+				}
+				return true
+			})
 		}
+	}
+}
 
-		if !skipContextParam {
-			uniquePos := cfg.getUniquePosSSAFn(in.Site.Parent(), in.Pos())
-			caller := in.Caller
-			if caller.Func.Name() == "init" {
-				// syntheised package initializer as per https://godoc.org/golang.org/x/tools/go/ssa#Function
-				if cfg.debugLevel > 0 && cfg.callSites[uniquePos] != &cfg.nilCallReplacement {
-					if !cfg.isPkgExternal(caller.Func.Pkg.Pkg.Path()) {
-						msg := "WARNING: function " + in.Callee.Func.Name() + " is called from synthetic package initializer - receives ARTFICIAL context as an argument"
-						cfg.writeWarning(cfg.getFset(caller.Func), in.Pos(), msg)
+// markLinknameFns marks functions linked via //go:linkname directives
+// to functions of the runtime or of external packages as used by
+// these packages - such functions may be called (and may call "leaf"
+// functions) through paths invisible to the analysis, so their
+// signatures cannot be modified.
+func (cfg *analyzerConfig) markLinknameFns() {
+	for _, p := range cfg.initial {
+		for _, f := range p.Syntax {
+			for _, group := range f.Comments {
+				for _, c := range group.List {
+					fields := strings.Fields(c.Text)
+					if len(fields) != 3 || fields[0] != linknamePragma {
+						// not a directive linking a local
+						// function to a target one
+						continue
+					}
+					pkgPath := getLinknamePkgPath(fields[2])
+					if pkgPath != "runtime" && !strings.HasPrefix(pkgPath, "runtime/") && !cfg.isPkgExternal(pkgPath) {
+						continue
+					}
+					fn, ok := p.Types.Scope().Lookup(fields[1]).(*types.Func)
+					if !ok {
+						// not a package-level function
+						continue
+					}
+					uniquePos := cfg.getUniquePosPkg(p.Types, fn.Pos())
+					if _, exists := cfg.fnVisited[uniquePos]; !exists {
+						cfg.fnVisited[uniquePos] = extFn
+						cfg.linknameTargets[uniquePos] = fields[2]
 					}
 				}
-				cfg.callSites[uniquePos] = &cfg.nilCallReplacement
-			} else {
-
-				// if function called via a function parameter, record parameter for update
-				cfg.collectFnParam(nodesWorkList, nodesVisited, in)
-
-				// mark call site as visited
-				cfg.callSites[uniquePos] = &cfg.commonCallReplacement
+			}
+		}
+	}
+}
 
-				// put each caller on the work list
-				if caller.Func.Pkg != nil {
-					pkgPath := caller.Func.Pkg.Pkg.Path()
-					pkgName := caller.Func.Pkg.Pkg.Name()
-					fnName := caller.Func.Name()
-					recvType := getTypeWithPkgFromVar(caller.Func.Signature.Recv())
-					// check if propagation should stop with the selected function
-					if recvs, exists := cfg.PropagationStops[fnName]; exists {
-						if pkgPaths, exists := recvs[recvType]; exists {
-							if pkgNames, exists := pkgPaths[pkgPath]; exists {
-								if _, exists := pkgNames[pkgName]; exists {
-									continue
-								}
-							}
-						}
+// markCgoExportFns marks functions made callable from C code via
+// //export directives as used by external code - their signatures are
+// determined by the C interface and cannot be modified without
+// breaking the callers.
+func (cfg *analyzerConfig) markCgoExportFns() {
+	for _, p := range cfg.initial {
+		for _, f := range p.Syntax {
+			for _, group := range f.Comments {
+				for _, c := range group.List {
+					fields := strings.Fields(c.Text)
+					if len(fields) != 2 || fields[0] != cgoExportPragma {
+						continue
 					}
-					paramName := cfg.collectFnDef(nodesWorkList, nodesVisited, caller, fnName, recvType)
-					if paramName != cfg.CtxParamName {
-						newCallReplacement := replacementInfo{cfg.commonCallReplacement.newName,
-							cfg.commonCallReplacement.argPos,
-							cfg.commonCallReplacement.ctxImports,
-							cfg.commonCallReplacement.ctxRegExpr,
-							replaceCtxExprWildcard(ctxWildcard, cfg.commonCallReplacement.ctxRegExpr, paramName)}
-						cfg.callSites[uniquePos] = &newCallReplacement
+					fn, ok := p.Types.Scope().Lookup(fields[1]).(*types.Func)
+					if !ok {
+						// not a package-level function
+						continue
+					}
+					uniquePos := cfg.getUniquePosPkg(p.Types, fn.Pos())
+					if _, exists := cfg.fnVisited[uniquePos]; !exists {
+						cfg.fnVisited[uniquePos] = extFn
+						cfg.cgoExports[uniquePos] = true
 					}
 				}
 			}
 		}
 	}
-	cfg.collect(nodesWorkList, nodesVisited)
 }
 
-// collectFnParam collects function parameter declaration (of type
-// function) that will itself receive injection of the context
-// parameter (as a result of this function-type parameter being used
-// to call a freshly made context-sensitive function).
-func (cfg *analyzerConfig) collectFnParam(nodesWorkList []*cg.Node, nodesVisited map[int]bool, edge *cg.Edge) {
-	callValue := edge.Site.Common().Value
-	p, ok := callValue.(*ssa.Parameter)
-	if !ok {
-		// a function call at the call site is not performed via the
-		// enclosing function's parameter
-		return
+// getLinknamePkgPath returns the package path of a function
+// referenced by a //go:linkname directive (e.g. "runtime" for
+// "runtime.nanotime" or "example.com/pkg" for
+// "example.com/pkg.(*T).M").
+func getLinknamePkgPath(target string) string {
+	slash := strings.LastIndex(target, "/")
+	dot := strings.Index(target[slash+1:], ".")
+	if dot < 0 {
+		return target
 	}
+	return target[:slash+1+dot]
+}
 
-	uniquePos := cfg.getUniquePosSSAFn(p.Parent(), p.Pos())
-	_, exists := cfg.fnParamsVisited[uniquePos]
-
-	if exists {
-		// we have already processed a call made vi this parameter
+// markFrozenFns marks exported functions of packages whose API is
+// frozen (see FreezeExportedFuncsPkgPaths) and methods implementing
+// exported interfaces of these packages so that propagation stops at
+// these functions instead of modifying their signatures.
+func (cfg *analyzerConfig) markFrozenFns() {
+	if len(cfg.FreezeExportedFuncsPkgPaths) == 0 {
 		return
 	}
+	for f := range cfg.graph.Nodes {
+		if f == nil || f.Pkg == nil || f.Parent() != nil || f.Synthetic != "" {
+			// not a function declared in the source code
+			continue
+		}
+		if !cfg.isFrozenFn(f) {
+			continue
+		}
+		uniquePos := cfg.getUniquePosSSAFn(f, f.Pos())
+		if _, exists := cfg.fnVisited[uniquePos]; !exists {
+			cfg.trace(f, "exported by or implements an exported interface of a package with frozen API")
+			cfg.fnVisited[uniquePos] = frozenFn
+			cfg.frozenFns[uniquePos] = f
+		}
+	}
+}
 
-	if sig, ok := p.Type().(*types.Signature); ok {
-		// if the call happens through a parameter and type of this parameter
-		// represents a signature (which in this case it should), mark this parameter
-		// for addition of the context parameter unless it's already there
-		isParamContext, _, paramName, paramType, custom := cfg.isFirstParamContext(sig)
-		skipContextParam := isParamContext && (custom || paramName == "_" || paramName == "" || paramName == cfg.CtxParamName)
-		if skipContextParam {
-			return
+// isFrozenFn determines if a given function is an exported function
+// of a package whose API is frozen or a method implementing one of
+// the exported interfaces of such package (exported functions defined
+// in test files are not a part of the API).
+func (cfg *analyzerConfig) isFrozenFn(f *ssa.Function) bool {
+	if !ast.IsExported(f.Name()) || strings.HasSuffix(cfg.getFset(f).Position(f.Pos()).Filename, "_test.go") {
+		return false
+	}
+	if cfg.isPkgFrozen(f.Pkg.Pkg.Path()) {
+		return true
+	}
+	recv := f.Signature.Recv()
+	if recv == nil {
+		return false
+	}
+	for iface := range cfg.frozenIfaces {
+		if !cfg.implementsIface(recv.Type(), iface) {
+			continue
 		}
-		if cfg.debugLevel > 0 && paramType == cfg.CtxParamType && !cfg.isPkgExternal(edge.Caller.Func.Pkg.Pkg.Path()) {
-			msg := "WARNING: argument " + p.Name() + " of type function takes the first parameter that is of type " + cfg.CtxParamType + " defined in different package than " + cfg.CtxPkgPath + "/" + cfg.CtxPkgName
-			cfg.writeWarning(cfg.getFset(p.Parent()), p.Pos(), msg)
+		if m, _ := getMethodAndInterface(f.Name(), iface); m != nil {
+			return true
 		}
-		cfg.fnParamsVisited[uniquePos] = true
+	}
+	return false
+}
 
-		// find all other functions that can be called through this function argument
-		/// and add them to the work list so that context argument may be added
-		// to them as well (this may result in functions to receive context argument
-		// even though they don't need it, if the call graph is imprecise, which it
-		// sometime is)
-		for _, o := range edge.Caller.Out {
-			oUniquePos := cfg.getUniquePosSSAFn(o.Site.Parent(), o.Pos())
-			edgeUniquePos := cfg.getUniquePosSSAFn(edge.Site.Parent(), edge.Pos())
-			if oUniquePos == edgeUniquePos {
-				fnName := o.Callee.Func.Name()
-				recvType := getTypeWithPkgFromVar(o.Callee.Func.Signature.Recv())
-				cfg.collectFnDef(nodesWorkList, nodesVisited, o.Callee, fnName, recvType)
+// reportFrozenFns reports exported functions of packages whose API is
+// frozen (and methods implementing their exported interfaces) that
+// initialize artificial context as their signatures could not be
+// modified.
+func (cfg *analyzerConfig) reportFrozenFns() {
+	var frozen []map[string]string
+	for uniquePos, f := range cfg.frozenFns {
+		if cfg.artificialCtxFns[uniquePos] != frozenFn {
+			// propagation has not reached the function
+			continue
+		}
+		p := cfg.getFset(f).Position(f.Pos())
+		m := make(map[string]string)
+		m["fn"] = f.String()
+		m["file"] = cfg.relPath(p.Filename)
+		m["line"] = strconv.Itoa(p.Line)
+		frozen = append(frozen, m)
+	}
+	sort.Slice(frozen, func(i, j int) bool {
+		return frozen[i]["fn"] < frozen[j]["fn"]
+	})
+
+	if cfg.debugLevel > 0 && len(frozen) > 0 {
+		cfg.debugData.FrozenFns = frozen
+		fmt.Println("FROZEN EXPORTED FUNCTIONS:")
+		for _, f := range frozen {
+			fmt.Println(f["fn"] + " (" + f["file"] + ", line " + f["line"] + ")")
+		}
+	}
+}
+
+// eliminateDeadCode removes from the call graph functions that have no
+// callers (other than program entry points, package initializers, test
+// functions and methods of test suites) along with functions that are
+// only called by such functions so that they are not analyzed (and
+// not modified).
+func (cfg *analyzerConfig) eliminateDeadCode() {
+	if !cfg.opts.EliminateDeadCode {
+		return
+	}
+	eliminated := 0
+	for deleted := true; deleted; {
+		deleted = false
+		for f, n := range cfg.graph.Nodes {
+			if f == nil || len(n.In) > 0 {
+				// not an actual function or function has callers
+				continue
+			}
+			if f.Name() == "init" || isTestingInitOrMainFunction(f.Name(), f.Signature) || cfg.isTestSuiteReceiver(f.Signature) {
+				// function called by the runtime or by the test
+				// harness
+				continue
 			}
+			cfg.graph.DeleteNode(n)
+			deleted = true
+			eliminated++
 		}
 	}
+	if cfg.debugLevel > 0 {
+		fmt.Println("DEAD FUNCTIONS ELIMINATED: " + strconv.Itoa(eliminated))
+	}
 }
 
-// collectFnDef, given a call graph node, collects information about a
-// function definition that will receive injection of the context
-// parameter
+// processLeafCalls marks "leaf" API calls for addition of the context
+// argument (and optional renaming) and start processing their callers
+// transitively.
+func (cfg *analyzerConfig) processLeafCalls() ([]*cg.Node, map[int]bool) {
+	leafCalls := make(map[uniquePosInfo]bool)
+	nodesWorkList := make([]*cg.Node, 0)
+	nodesVisited := make(map[int]bool)
+	for libFnName := range cfg.LibFns {
+		cfg.libFnCalls[libFnName] = make(map[string]int)
+		cfg.leafCallers[libFnName] = make(map[string]map[uniquePosInfo]*ssa.Function)
+	}
+	if cfg.libIfaces != nil {
+		// we are specifying functions via an interface
+		cfg.processLeafIfaceMethods(nodesWorkList, nodesVisited)
+	}
+	cfg.forEachLeafCall(func(in *cg.Edge, libFnName string, recv string, callReplacement *replacementInfo) {
+		uniquePos := cfg.getUniquePosCallSite(in)
+		doRename := func(pkgPath string, pkgName string, recvType string, fnName string) {
+			if pkgPath == cfg.LibPkgPath && pkgName == cfg.LibPkgName && recvType == recv && fnName == libFnName && callReplacement.newName != "" {
+				cfg.callSitesRenamed[uniquePos] = callReplacement.newName
+			}
+		}
+		calledViaLiteral := renameCall(in.Site.Common(), doRename)
+		if !calledViaLiteral {
+			// function is not called via a function
+			// literal (instead, for example, it's called
+			// via a variable)
+			return
+		}
+		leafCalls[uniquePos] = true
+		cfg.libFnCalls[libFnName][recv]++
+		cfg.recordLeafCaller(libFnName, recv, uniquePos, in.Caller.Func)
+		if callReplacement.applyToResultCall {
+			cfg.recordLeafResultCalls(nodesWorkList, nodesVisited, in, libFnName, callReplacement)
+			return
+		}
+		cfg.recordLeafCall(nodesWorkList, nodesVisited, in.Caller, uniquePos, libFnName, callReplacement)
+	})
+	cfg.processLeafVarCalls(nodesWorkList, nodesVisited, leafCalls)
+	cfg.debugData.Stats.LeafCalls = len(leafCalls)
+	if cfg.debugLevel > 0 {
+		fmt.Println("LEAF FUNCTION CALLS: " + strconv.Itoa(cfg.debugData.Stats.LeafCalls))
+	}
+	return nodesWorkList, nodesVisited
+}
+
+// processLeafIfaceMethods starts processing methods implementing the
+// library interface (where "leaf" methods are defined) transitively.
+func (cfg *analyzerConfig) processLeafIfaceMethods(nodesWorkList []*cg.Node, nodesVisited map[int]bool) {
+	for f, n := range cfg.graph.Nodes {
+		if f == nil {
+			// not an actual function
+			continue
+		}
+		if _, exists := cfg.LibFns[f.Name()]; !exists {
+			// not a leaf function name
+			continue
+		}
+		// TODO: this does not currently work for methods with receiver type that's a pointer
+		recv := f.Signature.Recv()
+		if recv == nil || (recv.Pkg() != nil && cfg.isPkgExternal(recv.Pkg().Path())) {
+			// ignore non-methods and methods whose receiver
+			// is defined externally (we can't do much about
+			// medthod implementations in third-party code)
+			continue
+		}
+		for _, li := range cfg.libIfaces {
+			if cfg.implementsIface(recv.Type(), li) {
+				msg := "WARNING: function " + f.Name() + " implements library interface " + cfg.LibIface + " and, consequently, receives context parameter but may in fact not use context"
+				cfg.writeWarning(cfg.getFset(f), f.Pos(), msg)
+				cfg.collectFnDef(nodesWorkList, nodesVisited, n, f.Name(), getTypeWithPkgFromVar(recv))
+			}
+		}
+	}
+}
+
+// forEachLeafCall calls a given function for each call site of a
+// "leaf" function specified by describing its definition (leaf
+// functions specified via an interface and leaf functions that are
+// variables are not included).
+func (cfg *analyzerConfig) forEachLeafCall(visit func(in *cg.Edge, libFnName string, recv string, callReplacement *replacementInfo)) {
+	if cfg.libIfaces != nil {
+		// currently we support either specifying concrete leaf
+		// functions and methods (with renaming) or specifying
+		// interface in the library where leaf methods are defined
+		// (no renaming)
+		return
+	}
+	for f, n := range cfg.graph.Nodes {
+		if f == nil {
+			// not an actual function
+			continue
+		}
+		recvs, exists := cfg.LibFns[f.Name()]
+		if !exists {
+			// not a leaf function name
+			continue
+		}
+		for recv, callReplacement := range recvs {
+			if callReplacement.isVar {
+				// leaf function is a variable whose calls are
+				// processed separately
+				continue
+			}
+			pkg := f.Package()
+			if pkg == nil || pkg.Pkg.Path() != cfg.LibPkgPath || pkg.Pkg.Name() != cfg.LibPkgName {
+				// function definition does not match a given leaf
+				// function specified in the config file
+				continue
+			}
+			if getTypeWithPkgFromVar(f.Signature.Recv()) != recv {
+				// function's receiver does not match one
+				// (possibly nil) specified for a given leaf
+				// function in the config file
+				continue
+			}
+			for _, in := range n.In {
+				visit(in, f.Name(), recv, callReplacement)
+			}
+		}
+	}
+}
+
+// processLeafVarCalls marks calls made via package-level variables of
+// function type (e.g. var Send = defaultSend) that are specified as
+// "leaf" functions in the config file for addition of the context
+// argument (and optional renaming) and start processing their callers
+// transitively.
+func (cfg *analyzerConfig) processLeafVarCalls(nodesWorkList []*cg.Node, nodesVisited map[int]bool, leafCalls map[uniquePosInfo]bool) {
+	for f, n := range cfg.graph.Nodes {
+		if f == nil {
+			// not an actual function
+			continue
+		}
+		for _, b := range f.Blocks {
+			for _, instr := range b.Instrs {
+				site, ok := instr.(ssa.CallInstruction)
+				if !ok {
+					continue
+				}
+				// call via a variable loads the function value first
+				u, ok := site.Common().Value.(*ssa.UnOp)
+				if !ok || u.Op != token.MUL {
+					continue
+				}
+				g, ok := u.X.(*ssa.Global)
+				if !ok || !cfg.isLibVarDef(g) {
+					continue
+				}
+				callReplacement, exists := cfg.LibFns[g.Name()][""]
+				if !exists || !callReplacement.isVar {
+					// not a leaf variable
+					continue
+				}
+				uniquePos := cfg.getUniquePosSSAFn(f, site.Common().Pos())
+				if leafCalls[uniquePos] {
+					continue
+				}
+				leafCalls[uniquePos] = true
+				cfg.libFnCalls[g.Name()][""]++
+				cfg.recordLeafCaller(g.Name(), "", uniquePos, f)
+				if callReplacement.newName != "" {
+					cfg.callSitesRenamed[uniquePos] = callReplacement.newName
+				}
+				cfg.recordLeafCall(nodesWorkList, nodesVisited, n, uniquePos, g.Name(), callReplacement)
+			}
+		}
+	}
+}
+
+// recordLeafCall records replacement info for a "leaf" call at a
+// given call site and starts processing the caller.
+func (cfg *analyzerConfig) recordLeafCall(nodesWorkList []*cg.Node, nodesVisited map[int]bool, caller *cg.Node, uniquePos uniquePosInfo, libFnName string, callReplacement *replacementInfo) {
+	if callReplacement.ctxWrapExpr != "" && !cfg.hasExistingCtxParam(caller.Func) {
+		// context is only wrapped if it's not been injected
+		newCallReplacement := *callReplacement
+		newCallReplacement.ctxWrapExpr = ""
+		callReplacement = &newCallReplacement
+	}
+	cfg.traceReach(caller.Func, nil, "calls \"leaf\" function "+cfg.LibPkgPath+"."+libFnName+" at "+cfg.tracePos(caller.Func, uniquePos.pos))
+	paramName := cfg.collectFnDef(nodesWorkList, nodesVisited, caller, caller.Func.Name(),
+		getTypeWithPkgFromVar(caller.Func.Signature.Recv()))
+	if paramName == cfg.CtxParamName {
+		// use default context parameter name specified in the config file
+		cfg.callSites[uniquePos] = callReplacement
+	} else {
+		// use context parameter name specified in the caller
+		newCallReplacement := *callReplacement
+		newCallReplacement.ctxExpr = replaceCtxExprWildcard(ctxWildcard, callReplacement.ctxRegExpr, paramName)
+		cfg.callSites[uniquePos] = &newCallReplacement
+	}
+}
+
+// collectCarrierCtors starts processing constructors (functions
+// returning the type or a pointer to it) of context carrier types
+// whose methods use context as they need context to initialize the
+// context field. Iterate until no new carrier types are added (a
+// constructor of one carrier type may call methods of another).
+func (cfg *analyzerConfig) collectCarrierCtors(nodesWorkList []*cg.Node, nodesVisited map[int]bool) {
+	if len(cfg.CtxCarrierTypes) == 0 {
+		return
+	}
+	ctorsVisited := make(map[*ssa.Function]bool)
+	added := true
+	for added {
+		added = false
+		for f, n := range cfg.graph.Nodes {
+			if f == nil || ctorsVisited[f] || !cfg.isCarrierCtor(f) {
+				continue
+			}
+			ctorsVisited[f] = true
+			added = true
+			cfg.traceReach(f, nil, "constructs context carrier type")
+			paramName := cfg.collectFnDef(nodesWorkList, nodesVisited, n, f.Name(), getTypeWithPkgFromVar(f.Signature.Recv()))
+			cfg.carrierCtors[cfg.getUniquePosSSAFn(f, f.Pos())] = paramName
+		}
+	}
+}
+
+// isCarrierCtor determines if a given function is a constructor of a
+// context carrier type that needs the context field, that is a
+// (top-level) function that returns the type or a pointer to it and
+// that is not a method of a carrier type itself.
+func (cfg *analyzerConfig) isCarrierCtor(f *ssa.Function) bool {
+	if f.Pkg == nil || f.Parent() != nil || f.Synthetic != "" || cfg.isPkgExternal(f.Pkg.Pkg.Path()) {
+		return false
+	}
+	if recv := f.Signature.Recv(); recv != nil && getCarrierNamed(recv.Type(), cfg.CtxCarrierTypes) != nil {
+		return false
+	}
+	results := f.Signature.Results()
+	for i := 0; i < results.Len(); i++ {
+		named := getCarrierNamed(results.At(i).Type(), cfg.CtxCarrierTypes)
+		if named != nil && cfg.carrierTypes[cfg.getUniquePosPkg(named.Obj().Pkg(), named.Obj().Pos())] {
+			return true
+		}
+	}
+	return false
+}
+
+// hasExistingCtxParam checks if context used in a given function comes
+// from an existing context parameter (of this function or of one of
+// the functions it is nested in).
+func (cfg *analyzerConfig) hasExistingCtxParam(fn *ssa.Function) bool {
+	for ; fn != nil; fn = fn.Parent() {
+		if isParamContext, _, _, _, _ := cfg.isFirstParamContext(fn.Signature); isParamContext || cfg.getCtxFromStructField(fn.Signature) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// recordLeafResultCalls records replacement info for calls applied to
+// the result of a "leaf" call (e.g. Counter("name")(1), possibly with
+// the result stored in a variable first) rather than for the "leaf"
+// call itself, and starts processing the caller.
+func (cfg *analyzerConfig) recordLeafResultCalls(nodesWorkList []*cg.Node, nodesVisited map[int]bool, in *cg.Edge, libFnName string, callReplacement *replacementInfo) {
+	found := false
+	if v := in.Site.Value(); v != nil && v.Referrers() != nil {
+		for _, r := range *v.Referrers() {
+			site, ok := r.(ssa.CallInstruction)
+			if !ok || site.Common().Value != v {
+				// result is not the function being called
+				continue
+			}
+			found = true
+			uniquePos := cfg.getUniquePosSSAFn(site.Parent(), site.Common().Pos())
+			cfg.recordLeafCall(nodesWorkList, nodesVisited, in.Caller, uniquePos, libFnName, callReplacement)
+		}
+	}
+	if !found {
+		msg := "WARNING: result of function " + libFnName + " is not called directly by function " + in.Caller.Func.Name() + " and context argument cannot be added to its call"
+		cfg.writeWarning(cfg.getFset(in.Caller.Func), in.Site.Pos(), msg)
+	}
+}
+
+// reportUnusedLibFns reports "leaf" functions specified in the config
+// file for which no call sites have been found, either because no
+// matching function definition exists or because the function is
+// never called. Functions with the same name as a "leaf" function
+// with no matching definition are reported as candidates that may
+// have been meant instead. In strict mode, the run fails if no
+// matching definition exists for any of the "leaf" functions.
+func (cfg *analyzerConfig) reportUnusedLibFns() {
+	if cfg.libIfaces != nil {
+		// leaf functions specified via an interface
+		return
+	}
+	allFns, allGlobals := cfg.getDeclaredFnsAndVars()
+
+	var unused []map[string]string
+	var noDefs []string
+	for libFnName, recvs := range cfg.LibFns {
+		for recv, callReplacement := range recvs {
+			if cfg.libFnCalls[libFnName][recv] > 0 {
+				continue
+			}
+			defs := 0
+			candidatesFound := make(map[string]bool)
+			if callReplacement.isVar {
+				for _, g := range allGlobals {
+					if g.Name() != libFnName {
+						continue
+					}
+					if cfg.isLibVarDef(g) {
+						defs++
+					} else {
+						candidatesFound[g.String()] = true
+					}
+				}
+			}
+			for _, f := range allFns {
+				if callReplacement.isVar || f.Name() != libFnName {
+					continue
+				}
+				if cfg.isLibFnDef(f, recv) {
+					defs++
+				} else {
+					candidatesFound[f.String()] = true
+				}
+			}
+			fnDesc := libFnName
+			if recv != "" {
+				fnDesc += " (receiver " + recv + ")"
+			}
+			reason := "no calls"
+			var candidates []string
+			if defs == 0 {
+				reason = "no definition"
+				noDefs = append(noDefs, fnDesc)
+				for c := range candidatesFound {
+					candidates = append(candidates, c)
+				}
+				sort.Strings(candidates)
+			}
+			unused = append(unused, map[string]string{"fn": fnDesc, "reason": reason, "candidates": strings.Join(candidates, ", ")})
+		}
+	}
+	sort.Slice(unused, func(i, j int) bool { return unused[i]["fn"] < unused[j]["fn"] })
+	sort.Strings(noDefs)
+
+	if cfg.debugLevel > 0 && len(unused) > 0 {
+		cfg.debugData.UnusedLibFns = unused
+		fmt.Println("LEAF FUNCTIONS WITH NO CALLS FOUND:")
+		for _, u := range unused {
+			fmt.Println(cfg.LibPkgPath + ": " + u["fn"] + " - " + u["reason"])
+			if u["candidates"] != "" {
+				fmt.Println("  CANDIDATES: " + u["candidates"])
+			}
+		}
+	}
+	if cfg.opts.Strict && len(noDefs) > 0 {
+		fatal("no matching definition found for leaf functions specified in the config file: " + strings.Join(noDefs, ", "))
+	}
+}
+
+// recordLeafCaller records a function calling a given "leaf" function
+// at a given call site.
+func (cfg *analyzerConfig) recordLeafCaller(libFnName string, recv string, uniquePos uniquePosInfo, caller *ssa.Function) {
+	if cfg.leafCallers[libFnName][recv] == nil {
+		cfg.leafCallers[libFnName][recv] = make(map[uniquePosInfo]*ssa.Function)
+	}
+	cfg.leafCallers[libFnName][recv][uniquePos] = caller
+}
+
+// leafStats describes propagation fan-out of a single "leaf" function.
+type leafStats struct {
+	// callSites is the number of the "leaf" function's call sites.
+	callSites int
+	// modified are functions receiving context parameter because of
+	// the "leaf" function's calls (directly or transitively).
+	modified map[uniquePosInfo]bool
+	// artificialEnds are functions where propagation chains starting
+	// at the "leaf" function's calls end with artificial context.
+	artificialEnds map[uniquePosInfo]bool
+	// realEnds are functions where propagation chains starting at the
+	// "leaf" function's calls end with context that is already
+	// available (existing context parameter or context extracted from
+	// the receiver or another parameter).
+	realEnds map[uniquePosInfo]bool
+}
+
+// reportLeafStats reports, for each "leaf" function specified in the
+// config file, the number of its call sites, the number of functions
+// modified because of it, and the number of propagation chains
+// ending with artificial and with already available context. A
+// function reachable from multiple "leaf" functions is counted for
+// each of them and also reported as shared. Chains ending at
+// functions where propagation stops (see PropagationStops) are not
+// counted.
+func (cfg *analyzerConfig) reportLeafStats() {
+	if cfg.debugLevel <= 0 || len(cfg.leafCallers) == 0 {
+		return
+	}
+	stats := make(map[string]*leafStats)
+	modifiedBy := make(map[uniquePosInfo]int)
+	for libFnName, recvs := range cfg.leafCallers {
+		for recv, callers := range recvs {
+			if len(callers) == 0 {
+				continue
+			}
+			fnDesc := libFnName
+			if recv != "" {
+				fnDesc += " (receiver " + recv + ")"
+			}
+			s := &leafStats{
+				callSites:      len(callers),
+				modified:       make(map[uniquePosInfo]bool),
+				artificialEnds: make(map[uniquePosInfo]bool),
+				realEnds:       make(map[uniquePosInfo]bool),
+			}
+			visited := make(map[*ssa.Function]bool)
+			for _, f := range callers {
+				cfg.collectLeafStats(f, s, visited)
+			}
+			for pos := range s.modified {
+				modifiedBy[pos]++
+			}
+			stats[fnDesc] = s
+		}
+	}
+
+	var fnDescs []string
+	for fnDesc := range stats {
+		fnDescs = append(fnDescs, fnDesc)
+	}
+	sort.Strings(fnDescs)
+	fmt.Println("LEAF FUNCTION FAN-OUT (CALL SITES / FUNCTIONS MODIFIED / SHARED / ARTIFICIAL CONTEXT / EXISTING CONTEXT):")
+	for _, fnDesc := range fnDescs {
+		s := stats[fnDesc]
+		shared := 0
+		for pos := range s.modified {
+			if modifiedBy[pos] > 1 {
+				shared++
+			}
+		}
+		m := map[string]string{
+			"fn":             fnDesc,
+			"callSites":      strconv.Itoa(s.callSites),
+			"fnsModified":    strconv.Itoa(len(s.modified)),
+			"shared":         strconv.Itoa(shared),
+			"artificialEnds": strconv.Itoa(len(s.artificialEnds)),
+			"realEnds":       strconv.Itoa(len(s.realEnds)),
+		}
+		cfg.debugData.LeafStats = append(cfg.debugData.LeafStats, m)
+		fmt.Println(cfg.LibPkgPath + ": " + fnDesc + " - " + m["callSites"] + " / " + m["fnsModified"] + " / " + m["shared"] + " / " + m["artificialEnds"] + " / " + m["realEnds"])
+	}
+}
+
+// collectLeafStats follows propagation of context from a given
+// function (calling a "leaf" function or another function receiving
+// context parameter) to its callers and records the results in given
+// "leaf" function statistics.
+func (cfg *analyzerConfig) collectLeafStats(f *ssa.Function, s *leafStats, visited map[*ssa.Function]bool) {
+	if visited[f] {
+		return
+	}
+	visited[f] = true
+	uniquePos := cfg.getUniquePosSSAFn(f, f.Pos())
+	if isParamContext, _, _, _, _ := cfg.isFirstParamContext(f.Signature); isParamContext {
+		s.realEnds[uniquePos] = true
+		return
+	}
+	if parent := cfg.getEnclosingCtxFn(f); parent != nil {
+		// context is passed to nested functions as a free variable
+		cfg.collectLeafStats(parent, s, visited)
+		return
+	}
+	fnType, exists := cfg.fnVisited[uniquePos]
+	if !exists {
+		// propagation stops
+		return
+	}
+	if fnType != regularFn {
+		if _, exists := cfg.ctxInitExprs[uniquePos]; exists {
+			s.realEnds[uniquePos] = true
+		} else {
+			s.artificialEnds[uniquePos] = true
+		}
+		return
+	}
+	s.modified[uniquePos] = true
+	n := cfg.graph.Nodes[f]
+	if n == nil {
+		return
+	}
+	for _, in := range n.In {
+		if !in.Site.Common().Pos().IsValid() {
+			continue
+		}
+		replacement := cfg.callSites[cfg.getUniquePosCallSite(in)]
+		if replacement == nil {
+			continue
+		}
+		if cfg.isNilCallReplacement(replacement) {
+			// artificial context passed at the call site
+			s.artificialEnds[cfg.getUniquePosSSAFn(in.Caller.Func, in.Caller.Func.Pos())] = true
+			continue
+		}
+		cfg.collectLeafStats(in.Caller.Func, s, visited)
+	}
+}
+
+// getDeclaredFnsAndVars returns all package-level functions and
+// methods declared on package-level types (whether they are called or
+// not), as well as all package-level variables.
+func (cfg *analyzerConfig) getDeclaredFnsAndVars() ([]*ssa.Function, []*ssa.Global) {
+	var allFns []*ssa.Function
+	var allGlobals []*ssa.Global
+	for _, p := range cfg.prog.AllPackages() {
+		for _, m := range p.Members {
+			if f, ok := m.(*ssa.Function); ok {
+				allFns = append(allFns, f)
+			} else if g, ok := m.(*ssa.Global); ok {
+				allGlobals = append(allGlobals, g)
+			} else if t, ok := m.(*ssa.Type); ok {
+				named, ok := t.Type().(*types.Named)
+				if !ok {
+					continue
+				}
+				for i := 0; i < named.NumMethods(); i++ {
+					if f := cfg.prog.FuncValue(named.Method(i)); f != nil {
+						allFns = append(allFns, f)
+					}
+				}
+			}
+		}
+	}
+	return allFns, allGlobals
+}
+
+// isLibFnDef determines if a given function definition matches a
+// "leaf" function with a given (possibly empty) receiver specified in
+// the config file.
+func (cfg *analyzerConfig) isLibFnDef(f *ssa.Function, recv string) bool {
+	pkg := f.Package()
+	if pkg == nil || pkg.Pkg.Path() != cfg.LibPkgPath || pkg.Pkg.Name() != cfg.LibPkgName {
+		return false
+	}
+	return getTypeWithPkgFromVar(f.Signature.Recv()) == recv
+}
+
+// isLibVarDef determines if a given package-level variable is defined
+// in the library where "leaf" functions are defined.
+func (cfg *analyzerConfig) isLibVarDef(g *ssa.Global) bool {
+	return g.Pkg != nil && g.Pkg.Pkg.Path() == cfg.LibPkgPath && g.Pkg.Pkg.Name() == cfg.LibPkgName
+}
+
+// collect gathers information about call sites and function
+// definitions that must be re-written for context propagation.
+func (cfg *analyzerConfig) collect(nodesWorkList []*cg.Node, nodesVisited map[int]bool) {
+	l := len(nodesWorkList)
+	if l <= 0 {
+		// no more work items
+		return
+	}
+	// get a node from the work list
+	n := nodesWorkList[l-1]
+	nodesWorkList = nodesWorkList[:l-1]
+	// named function passed to closure-boundary functions is called
+	// on behalf of functions passing it
+	_, closureBoundaryArg := cfg.closureBoundarySites[n.Func]
+	cfg.collectClosureBoundarySites(nodesWorkList, nodesVisited, n.Func)
+	// context flow barrier receives context parameter but its
+	// callers pass artificial context instead of being traversed
+	flowBarrier := cfg.isContextFlowBarrier(n.Func)
+	// iterate over this function's call sites
+	for _, in := range n.In {
+		if closureBoundaryArg && cfg.isClosureBoundaryCaller(in.Caller.Func) {
+			// context is forwarded by the closure replacing the
+			// function at the call site of closure-boundary function
+			continue
+		}
+		if !in.Site.Common().Pos().IsValid() {
+			// call site does not exist in the source (e.g. in a
+			// synthetic wrapper of a method) - the remaining call
+			// sites must still be processed as the order of call
+			// graph edges is not deterministic
+			continue
+		}
+		if isOutOfScopeAnonCall(in) {
+			// if a call to anonymous function is not in the same scope as the function definition
+			// then the call graph information about this call is likely incorrect - ignore
+			continue
+		}
+		if isNilFnValue(in.Site.Common().Value) {
+			// a call through a nil function value (e.g. one of
+			// function type's zero value) has no actual callee
+			// that context could be propagated into
+			continue
+		}
+		// record each call site; documentation for https://godoc.org/golang.org/x/tools/go/ssa#Call
+		// says: "Pos() returns the ast.CallExpr.Lparen, if explicit in the source"
+
+		// determine if the function containing the call site should have context argument injection skipped
+		skipContextParam := false
+		if !skipContextParam {
+			// skip if first parameter is context already
+			isParamContext, _, paramName, _, custom := cfg.isFirstParamContext(in.Site.Common().Signature())
+			skipContextParam = isParamContext && (custom || paramName == "_" || paramName == "" || paramName == cfg.CtxParamName)
+			skipContextParam = skipContextParam || cfg.getAnyPositionCtxParam(in.Site.Common().Signature()) != ""
+		}
+
+		if !skipContextParam {
+			uniquePos := cfg.getUniquePosCallSite(in)
+			caller := in.Caller
+			if caller.Func.Name() == "init" {
+				// syntheised package initializer as per https://godoc.org/golang.org/x/tools/go/ssa#Function
+				if cfg.debugLevel > 0 && !cfg.isNilCallReplacement(cfg.callSites[uniquePos]) {
+					if !cfg.isPkgExternal(getFnPkgPath(caller.Func)) {
+						msg := "WARNING: function " + in.Callee.Func.Name() + " is called from synthetic package initializer - receives ARTFICIAL context as an argument"
+						cfg.writeWarning(cfg.getFset(caller.Func), in.Pos(), msg)
+					}
+				}
+				cfg.callSites[uniquePos] = cfg.getNilCallReplacement(initCallerCtxReason)
+			} else if flowBarrier {
+				cfg.trace(caller.Func, "calls context flow barrier "+n.Func.String()+" at "+cfg.tracePos(caller.Func, uniquePos.pos)+" (listed in ContextFlowBarriers)")
+				cfg.callSites[uniquePos] = cfg.getNilCallReplacement(flowBarrierCallerCtxReason)
+			} else {
+
+				// if function called via a function parameter, record parameter for update
+				cfg.collectFnParam(nodesWorkList, nodesVisited, in)
+				// if function called via a struct field, record field for update
+				cfg.collectFnField(nodesWorkList, nodesVisited, in)
+
+				// mark call site as visited
+				cfg.callSites[uniquePos] = &cfg.commonCallReplacement
+
+				// put each caller on the work list
+				if caller.Func.Pkg != nil {
+					paramName, examined := cfg.fnDefsCollected[caller.Func]
+					if caller != n || !examined {
+						// not a recursive call of an already examined
+						// function
+						pkgPath := caller.Func.Pkg.Pkg.Path()
+						pkgName := caller.Func.Pkg.Pkg.Name()
+						fnName := caller.Func.Name()
+						recvType := getTypeWithPkgFromVar(caller.Func.Signature.Recv())
+						cfg.traceReach(caller.Func, n.Func, "reached via call to "+n.Func.String()+" at "+cfg.tracePos(caller.Func, uniquePos.pos))
+						// check if propagation should stop with the selected function
+						if recvs, exists := cfg.PropagationStops[fnName]; exists {
+							if pkgPaths, exists := recvs[recvType]; exists {
+								if pkgNames, exists := pkgPaths[pkgPath]; exists {
+									if _, exists := pkgNames[pkgName]; exists {
+										cfg.trace(caller.Func, "propagation stops (listed in PropagationStops)")
+										continue
+									}
+								}
+							}
+						}
+						paramName = cfg.collectFnDef(nodesWorkList, nodesVisited, caller, fnName, recvType)
+					}
+					if paramName != cfg.CtxParamName {
+						newCallReplacement := replacementInfo{cfg.commonCallReplacement.newName,
+							cfg.commonCallReplacement.argPos,
+							cfg.commonCallReplacement.ctxImports,
+							cfg.commonCallReplacement.ctxRegExpr,
+							replaceCtxExprWildcard(ctxWildcard, cfg.commonCallReplacement.ctxRegExpr, paramName),
+							cfg.commonCallReplacement.isVar,
+							cfg.commonCallReplacement.applyToResultCall,
+							cfg.commonCallReplacement.ctxWrapExpr}
+						cfg.callSites[uniquePos] = &newCallReplacement
+					}
+				}
+			}
+		}
+	}
+	cfg.collect(nodesWorkList, nodesVisited)
+}
+
+// collectFnParam collects function parameter declaration (of type
+// function) that will itself receive injection of the context
+// parameter (as a result of this function-type parameter being used
+// to call a freshly made context-sensitive function).
+func (cfg *analyzerConfig) collectFnParam(nodesWorkList []*cg.Node, nodesVisited map[int]bool, edge *cg.Edge) {
+	callValue := edge.Site.Common().Value
+	deref := false
+	if u, ok := callValue.(*ssa.UnOp); ok && u.Op == token.MUL {
+		// a function call is performed via a pointer to function
+		// (e.g. (*fn)()) - follow the pointer
+		callValue = u.X
+		deref = true
+	}
+	if deref && isUnsafeFnCast(callValue) {
+		cfg.markUnsafeFnCastCaller(edge)
+		return
+	}
+	if phi, ok := callValue.(*ssa.Phi); ok {
+		// the enclosing function's parameter may be replaced before
+		// the call (e.g. with nil or with a default function)
+		callValue = getPhiParam(phi)
+	}
+	p, ok := callValue.(*ssa.Parameter)
+	if !ok {
+		// a function call at the call site is not performed via the
+		// enclosing function's parameter
+		return
+	}
+
+	uniquePos := cfg.getUniquePosSSAFn(p.Parent(), p.Pos())
+	_, exists := cfg.fnParamsVisited[uniquePos]
+
+	if exists {
+		// we have already processed a call made vi this parameter
+		return
+	}
+
+	fnType := p.Type()
+	if deref {
+		ptr, ok := types.Unalias(fnType).(*types.Pointer)
+		if !ok {
+			return
+		}
+		fnType = ptr.Elem()
+	}
+	alias, _ := fnType.(*types.Alias)
+	fnType = types.Unalias(fnType)
+
+	if sig, ok := fnType.(*types.Signature); ok {
+		// if the call happens through a parameter and type of this parameter
+		// represents a signature (which in this case it should), mark this parameter
+		// for addition of the context parameter unless it's already there
+		isParamContext, _, paramName, paramType, custom := cfg.isFirstParamContext(sig)
+		skipContextParam := isParamContext && (custom || paramName == "_" || paramName == "" || paramName == cfg.CtxParamName)
+		if skipContextParam {
+			return
+		}
+		if cfg.debugLevel > 0 && paramType == cfg.CtxParamType && !cfg.isPkgExternal(getFnPkgPath(edge.Caller.Func)) {
+			msg := "WARNING: argument " + p.Name() + " of type function takes the first parameter that is of type " + cfg.CtxParamType + " defined in different package than " + cfg.CtxPkgPath + "/" + cfg.CtxPkgName + " (list it in ForeignCtxTypes in the config file to avoid injecting another context parameter)"
+			cfg.writeWarning(cfg.getFset(p.Parent()), p.Pos(), msg)
+		}
+		if alias != nil {
+			// parameter is declared using an alias of the
+			// function type - the alias definition has to be
+			// modified rather than the parameter declaration
+			cfg.fnParamsVisited[uniquePos] = false
+			cfg.markAliasAsModified(alias, p)
+		} else {
+			cfg.fnParamsVisited[uniquePos] = true
+		}
+
+		// find all other functions that can be called through this function argument
+		/// and add them to the work list so that context argument may be added
+		// to them as well (this may result in functions to receive context argument
+		// even though they don't need it, if the call graph is imprecise, which it
+		// sometime is)
+		for _, o := range edge.Caller.Out {
+			oUniquePos := cfg.getUniquePosCallSite(o)
+			edgeUniquePos := cfg.getUniquePosCallSite(edge)
+			if oUniquePos == edgeUniquePos {
+				fnName := o.Callee.Func.Name()
+				recvType := getTypeWithPkgFromVar(o.Callee.Func.Signature.Recv())
+				cfg.traceReach(o.Callee.Func, p.Parent(), "may be called via parameter "+p.Name()+" of "+p.Parent().String())
+				cfg.collectFnDef(nodesWorkList, nodesVisited, o.Callee, fnName, recvType)
+			}
+		}
+	}
+}
+
+// collectFnField collects struct field declaration (of type function)
+// that will itself receive injection of the context parameter (as a
+// result of this function-type field being used to call a freshly
+// made context-sensitive function). Functions stored in the field
+// within the function making the call also receive context parameter
+// as the call graph may not connect them with the call site.
+func (cfg *analyzerConfig) collectFnField(nodesWorkList []*cg.Node, nodesVisited map[int]bool, edge *cg.Edge) {
+	u, ok := edge.Site.Common().Value.(*ssa.UnOp)
+	if !ok || u.Op != token.MUL {
+		// a function call is not performed via a struct field
+		// loaded from its address (e.g. o.fn())
+		return
+	}
+	field := getFieldVar(u.X)
+	if field == nil {
+		return
+	}
+	sig, ok := types.Unalias(field.Type()).(*types.Signature)
+	if !ok {
+		// field declared using a named (or aliased) function type -
+		// the type definition would have to be modified
+		return
+	}
+	uniquePos := cfg.getUniquePosPkg(field.Pkg(), field.Pos())
+	if cfg.fnFieldsVisited[uniquePos] {
+		// we have already processed a call made via this field
+		return
+	}
+	isParamContext, _, paramName, _, custom := cfg.isFirstParamContext(sig)
+	if isParamContext && (custom || paramName == "_" || paramName == "" || paramName == cfg.CtxParamName) {
+		return
+	}
+	caller := edge.Caller.Func
+	if field.Pkg() == nil || cfg.isPkgExternal(field.Pkg().Path()) {
+		msg := "WARNING: field " + field.Name() + " is defined in an external package and will not be modified to take context parameter"
+		cfg.writeWarning(cfg.getFset(caller), edge.Pos(), msg)
+		return
+	}
+	cfg.fnFieldsVisited[uniquePos] = true
+	cfg.trace(caller, "calls via field "+field.Name()+" at "+cfg.tracePos(caller, edge.Pos())+" (field declaration modified)")
+
+	// find functions that can be called through this field and add
+	// them to the work list so that context argument may be added to
+	// them as well
+	for _, o := range edge.Caller.Out {
+		if o.Site == edge.Site {
+			cfg.traceReach(o.Callee.Func, caller, "may be called via field "+field.Name()+" in "+caller.String())
+			cfg.collectFnDef(nodesWorkList, nodesVisited, o.Callee, o.Callee.Func.Name(), getTypeWithPkgFromVar(o.Callee.Func.Signature.Recv()))
+		}
+	}
+	stored := false
+	for _, b := range caller.Blocks {
+		for _, instr := range b.Instrs {
+			store, ok := instr.(*ssa.Store)
+			if !ok || getFieldVar(store.Addr) != field {
+				continue
+			}
+			stored = true
+			fn, ok := store.Val.(*ssa.Function)
+			if c, isClosure := store.Val.(*ssa.MakeClosure); isClosure {
+				fn, ok = c.Fn.(*ssa.Function)
+			}
+			if !ok || cfg.graph.Nodes[fn] == nil {
+				continue
+			}
+			cfg.traceReach(fn, caller, "stored in field "+field.Name()+" at "+cfg.tracePos(caller, store.Pos()))
+			cfg.collectFnDef(nodesWorkList, nodesVisited, cfg.graph.Nodes[fn], fn.Name(), getTypeWithPkgFromVar(fn.Signature.Recv()))
+		}
+	}
+	if !stored && cfg.debugLevel > 0 {
+		msg := "WARNING: field " + field.Name() + " is not assigned in function " + caller.Name() + " - functions assigned to it elsewhere may not receive context parameter"
+		cfg.writeWarning(cfg.getFset(caller), edge.Pos(), msg)
+	}
+}
+
+// getFieldVar returns struct field whose address is computed by a
+// given value (or nil if the value does not compute a field address).
+func getFieldVar(v ssa.Value) *types.Var {
+	fa, ok := v.(*ssa.FieldAddr)
+	if !ok {
+		return nil
+	}
+	ptr, ok := fa.X.Type().Underlying().(*types.Pointer)
+	if !ok {
+		return nil
+	}
+	st, ok := ptr.Elem().Underlying().(*types.Struct)
+	if !ok {
+		return nil
+	}
+	return st.Field(fa.Field)
+}
+
+// isOutOfScopeAnonCall determines if a given call graph edge
+// represents a call to an anonymous function made outside of the
+// function enclosing its definition and outside of anonymous functions
+// nested in the enclosing function. Synthetic functions (e.g. bound
+// method closures and wrappers) are not anonymous functions even
+// though their names may suggest otherwise.
+func isOutOfScopeAnonCall(e *cg.Edge) bool {
+	fn := e.Callee.Func
+	if fn.Synthetic != "" || fn.Parent() == nil {
+		return false
+	}
+	parent := getOriginFn(fn.Parent())
+	for f := e.Site.Parent(); f != nil; f = f.Parent() {
+		if getOriginFn(f) == parent {
+			return false
+		}
+	}
+	return true
+}
+
+// getOriginFn returns the generic function a given function has been
+// instantiated from (or the function itself if it is not an
+// instantiation).
+func getOriginFn(fn *ssa.Function) *ssa.Function {
+	if origin := fn.Origin(); origin != nil {
+		return origin
+	}
+	return fn
+}
+
+// isNilFnValue determines if a given value is a nil constant of
+// function type.
+func isNilFnValue(v ssa.Value) bool {
+	c, ok := v.(*ssa.Const)
+	if !ok || !c.IsNil() {
+		return false
+	}
+	_, ok = c.Type().Underlying().(*types.Signature)
+	return ok
+}
+
+// getPhiParam returns the only parameter of the enclosing function
+// among the values merged by a given phi node or nil if there is no
+// such parameter or if there is more than one.
+func getPhiParam(phi *ssa.Phi) ssa.Value {
+	var param *ssa.Parameter
+	for _, e := range phi.Edges {
+		p, ok := e.(*ssa.Parameter)
+		if !ok {
+			// other values (e.g. nil constants or functions)
+			// do not prevent the parameter from being modified
+			continue
+		}
+		if param != nil && param != p {
+			return nil
+		}
+		param = p
+	}
+	if param == nil {
+		return nil
+	}
+	return param
+}
+
+// isUnsafeFnCast determines if a given value is a pointer to function
+// obtained by converting unsafe.Pointer (e.g. the operand of the
+// dereference in *(*func() bool)(unsafe.Pointer(&v))).
+func isUnsafeFnCast(v ssa.Value) bool {
+	c, ok := v.(*ssa.Convert)
+	if !ok {
+		return false
+	}
+	if b, ok := c.X.Type().Underlying().(*types.Basic); !ok || b.Kind() != types.UnsafePointer {
+		return false
+	}
+	ptr, ok := c.Type().Underlying().(*types.Pointer)
+	if !ok {
+		return false
+	}
+	_, ok = ptr.Elem().Underlying().(*types.Signature)
+	return ok
+}
+
+// markUnsafeFnCastCaller marks the function containing a call made
+// through a function value cast from unsafe.Pointer (or, for nested
+// functions, the outermost enclosing function) to receive artificial
+// context - the type the value is cast to cannot be modified to take
+// context parameter so context propagation stops at this function.
+func (cfg *analyzerConfig) markUnsafeFnCastCaller(edge *cg.Edge) {
+	caller := edge.Caller.Func
+	if caller.Pkg == nil || cfg.isPkgExternal(caller.Pkg.Pkg.Path()) {
+		return
+	}
+	msg := "WARNING: function " + caller.Name() + " calls a function value cast from unsafe.Pointer - context propagation into unsafe function casts is not supported"
+	cfg.writeWarning(cfg.getFset(caller), edge.Site.Pos(), msg)
+	if parent := cfg.getEnclosingCtxFn(caller); parent != nil {
+		// context is passed to nested functions as a free variable
+		caller = parent
+	}
+	if isParamContext, _, _, _, _ := cfg.isFirstParamContext(caller.Signature); isParamContext {
+		// context is already available
+		return
+	}
+	uniquePos := cfg.getUniquePosSSAFn(caller, caller.Pos())
+	if _, exists := cfg.fnVisited[uniquePos]; !exists {
+		cfg.trace(caller, "calls a function value cast from unsafe.Pointer")
+		cfg.fnVisited[uniquePos] = unsafeCastFn
+	}
+}
+
+// markAliasAsModified marks definition of a given alias of function
+// type used as type of a given parameter for injection of context
+// parameter (unless the alias is defined in an external package).
+func (cfg *analyzerConfig) markAliasAsModified(alias *types.Alias, p *ssa.Parameter) {
+	obj := alias.Obj()
+	if obj.Pkg() == nil || cfg.isPkgExternal(obj.Pkg().Path()) {
+		msg := "WARNING: type " + obj.Name() + " of argument " + p.Name() + " is defined in an external package and will not be modified to take context parameter"
+		cfg.writeWarning(cfg.getFset(p.Parent()), p.Pos(), msg)
+		return
+	}
+	cfg.trace(p.Parent(), "parameter "+p.Name()+" declared using alias "+obj.Name()+" (alias definition modified)")
+	cfg.fnVisited[cfg.getUniquePosPkg(obj.Pkg(), obj.Pos())] = regularFn
+}
+
+// collectBlankCtxParams marks blank (or unnamed) context parameters
+// of all functions in non-external packages to be named using the
+// default context parameter name (see
+// Options.RewriteBlankIdentifier), including functions that are not
+// on the propagation path.
+func (cfg *analyzerConfig) collectBlankCtxParams() {
+	if !cfg.opts.RewriteBlankIdentifier {
+		return
+	}
+	allFns, _ := cfg.getDeclaredFnsAndVars()
+	for len(allFns) > 0 {
+		f := allFns[len(allFns)-1]
+		allFns = allFns[:len(allFns)-1]
+		allFns = append(allFns, f.AnonFuncs...)
+		if f.Pkg == nil || f.Synthetic != "" || cfg.isPkgExternal(f.Pkg.Pkg.Path()) {
+			continue
+		}
+		params := f.Signature.Params()
+		if params.Len() == 0 || getTypeWithPkgFromVar(params.At(0)) != cfg.ctxParamTypeWithPkgPathName {
+			// no context parameter
+			continue
+		}
+		if p := params.At(0); p.Name() == "_" || p.Name() == "" {
+			uniquePos := cfg.getUniquePosSSAFn(f, p.Pos())
+			if cfg.renameParamsVisited[uniquePos] {
+				// already renamed (function on the propagation
+				// path)
+				continue
+			}
+			if cfg.usesName(f, cfg.CtxParamName) {
+				cfg.writeWarning(cfg.getFset(f), p.Pos(), "WARNING: blank context parameter of function "+f.Name()+" cannot be named "+cfg.CtxParamName+" as the name is already used in the function")
+				continue
+			}
+			cfg.trace(f, "blank context parameter named")
+			cfg.renameParamsVisited[uniquePos] = true
+		}
+	}
+}
+
+// collectCommandCtxCalls marks calls to functions specified in the
+// config file as having context-aware variants (e.g. exec.Command
+// and exec.CommandContext) for renaming and addition of the context
+// argument, provided that context is propagated to the functions
+// making the calls.
+func (cfg *analyzerConfig) collectCommandCtxCalls() {
+	if len(cfg.CommandContextPatterns) == 0 {
+		return
+	}
+	callsNum := 0
+	allFns, _ := cfg.getDeclaredFnsAndVars()
+	for len(allFns) > 0 {
+		f := allFns[len(allFns)-1]
+		allFns = allFns[:len(allFns)-1]
+		allFns = append(allFns, f.AnonFuncs...)
+		if f.Pkg == nil || f.Synthetic != "" || cfg.isPkgExternal(f.Pkg.Pkg.Path()) {
+			continue
+		}
+		for _, b := range f.Blocks {
+			for _, instr := range b.Instrs {
+				site, ok := instr.(ssa.CallInstruction)
+				if !ok {
+					continue
+				}
+				pattern := cfg.getCommandCtxPattern(site.Common().StaticCallee())
+				if pattern == nil {
+					continue
+				}
+				uniquePos := cfg.getUniquePosSSAFn(f, site.Common().Pos())
+				if _, exists := cfg.callSites[uniquePos]; exists {
+					continue
+				}
+				paramName := cfg.getPropagatedCtxParamName(f)
+				if paramName == "" {
+					// context is not available in the function
+					continue
+				}
+				cfg.trace(f, "calls "+pattern.OldFunc+" to be replaced with "+pattern.NewFunc+" at "+cfg.tracePos(f, site.Common().Pos()))
+				argPos := pattern.CtxArgPos
+				if argPos == 0 {
+					argPos = 1
+				}
+				cfg.callSites[uniquePos] = &replacementInfo{pattern.NewFunc, argPos, nil, "", paramName, false, false, ""}
+				cfg.callSitesRenamed[uniquePos] = pattern.NewFunc
+				callsNum++
+			}
+		}
+	}
+	if cfg.debugLevel > 0 {
+		fmt.Println("COMMAND CONTEXT CALLS: " + strconv.Itoa(callsNum))
+	}
+}
+
+// getCommandCtxPattern returns config file's pattern describing
+// context-aware variant of a given function or nil if there is none.
+func (cfg *analyzerConfig) getCommandCtxPattern(callee *ssa.Function) *cmdCtxPatternInfo {
+	if callee == nil || callee.Pkg == nil || callee.Signature.Recv() != nil {
+		return nil
+	}
+	for i, pattern := range cfg.CommandContextPatterns {
+		if callee.Pkg.Pkg.Path() == pattern.PkgPath && callee.Name() == pattern.OldFunc {
+			return &cfg.CommandContextPatterns[i]
+		}
+	}
+	return nil
+}
+
+// getPropagatedCtxParamName returns name of the context parameter
+// available in a given function (possibly as a free variable of a
+// closure), either already existing or injected, or an empty string
+// if context is not propagated to the function.
+func (cfg *analyzerConfig) getPropagatedCtxParamName(f *ssa.Function) string {
+	for ; f != nil; f = f.Parent() {
+		if isParamContext, renameParamPos, paramName, _, _ := cfg.isFirstParamContext(f.Signature); isParamContext {
+			if paramName != "_" && paramName != "" {
+				return paramName
+			}
+			if cfg.renameParamsVisited[cfg.getUniquePosSSAFn(f, renameParamPos)] {
+				return cfg.CtxParamName
+			}
+			return ""
+		}
+		uniquePos := cfg.getUniquePosSSAFn(f, f.Pos())
+		if fnType, exists := cfg.fnVisited[uniquePos]; exists {
+			if fnType == regularFn {
+				return cfg.getCtxParamName(uniquePos)
+			}
+			return ""
+		}
+	}
+	return ""
+}
+
+// usesName determines if a given name is used in a given function's
+// signature or body (in which case naming a parameter with it could
+// result in a conflicting declaration or in shadowing of another
+// variable).
+func (cfg *analyzerConfig) usesName(f *ssa.Function, name string) bool {
+	if f.Signature.Recv() != nil && f.Signature.Recv().Name() == name {
+		return true
+	}
+	for _, vars := range []*types.Tuple{f.Signature.Params(), f.Signature.Results()} {
+		for i := 0; i < vars.Len(); i++ {
+			if vars.At(i).Name() == name {
+				return true
+			}
+		}
+	}
+	var body *ast.BlockStmt
+	switch syntax := f.Syntax().(type) {
+	case *ast.FuncDecl:
+		body = syntax.Body
+	case *ast.FuncLit:
+		body = syntax.Body
+	}
+	if body == nil {
+		return false
+	}
+	used := false
+	ast.Inspect(body, func(n ast.Node) bool {
+		if ident, ok := n.(*ast.Ident); ok && ident.Name == name {
+			used = true
+		}
+		return !used
+	})
+	return used
+}
+
+// collectFnDef, given a call graph node, collects information about a
+// function definition that will receive injection of the context
+// parameter and returns the name of the context parameter (or
+// variable) in this function. The outcome is memoized as the function
+// may be reached via many call sites (e.g. in clusters of mutually
+// recursive functions).
 func (cfg *analyzerConfig) collectFnDef(nodesWorkList []*cg.Node,
 	nodesVisited map[int]bool,
 	caller *cg.Node,
 	fnName string,
 	fnRecv string) string {
 
+	if paramName, exists := cfg.fnDefsCollected[caller.Func]; exists {
+		return paramName
+	}
+	cfg.fnDefsExamined++
+	paramName := cfg.examineFnDef(nodesWorkList, nodesVisited, caller, fnName, fnRecv)
+	cfg.fnDefsCollected[caller.Func] = paramName
+	return paramName
+}
+
+// examineFnDef performs the actual work of collectFnDef.
+func (cfg *analyzerConfig) examineFnDef(nodesWorkList []*cg.Node,
+	nodesVisited map[int]bool,
+	caller *cg.Node,
+	fnName string,
+	fnRecv string) string {
+
 	// check if the first parameter is a context parameter already in which case do nothing
 	var isParamContext bool
 	var renameParamPos token.Pos
 	var paramName string
 	var paramType string
 	if isParamContext, renameParamPos, paramName, paramType, _ = cfg.isFirstParamContext(caller.Func.Signature); isParamContext {
+		cfg.trace(caller.Func, "first parameter is context (named \""+paramName+"\")")
 		if paramName == "_" || paramName == "" {
 			// will be renamed to ctxParamName
 			cfg.renameParamsVisited[cfg.getUniquePosSSAFn(caller.Func, renameParamPos)] = true
@@ -509,8 +1932,22 @@ func (cfg *analyzerConfig) collectFnDef(nodesWorkList []*cg.Node,
 		// or different one (in which case all calls within function must use the new name)
 		return paramName
 	}
-	parent := caller.Func.Parent()
-	if parent != nil && cfg.graph.Nodes[parent] != nil {
+	cfg.trace(caller.Func, "first parameter is not context")
+	if paramName := cfg.getAnyPositionCtxParam(caller.Func.Signature); paramName != "" {
+		// context parameter exists in a different position - all
+		// calls within function must use its name
+		cfg.trace(caller.Func, "takes context parameter (named \""+paramName+"\") in a non-first position")
+		return paramName
+	}
+	if fieldExpr := cfg.getCtxFromStructField(caller.Func.Signature); fieldExpr != "" {
+		// context stored in the receiver's field is used as is at
+		// all call sites
+		cfg.trace(caller.Func, "receiver holds context in a field (accessed as \""+fieldExpr+"\")")
+		return fieldExpr
+	}
+	if parent := cfg.getEnclosingCtxFn(caller.Func); parent != nil {
+		cfg.trace(caller.Func, "nested in "+parent.String()+" (context passed as free variable)")
+		cfg.traceReach(parent, caller.Func, "contains nested function "+caller.Func.String()+" (context passed as free variable)")
 		// as we are trying to minimize changes, particularly for function signatures (that may be arguments for other functions, implement interfaces, etc.),
 		// for nested functions we pass context as a free variable to the closure
 		recvType := getTypeWithPkgFromVar(parent.Signature.Recv())
@@ -523,56 +1960,176 @@ func (cfg *analyzerConfig) collectFnDef(nodesWorkList []*cg.Node,
 	// "Pos() returns the declaring ast.FuncLit.Type.Func or the position
 	// of the ast.FuncDecl.Name, if the function was explicit in the source"
 	if nodesVisited[caller.ID] {
-		return cfg.CtxParamName
+		cfg.trace(caller.Func, "already visited")
+		return cfg.getCtxParamName(cfg.getUniquePosSSAFn(caller.Func, caller.Func.Pos()))
 	}
 
 	nodesVisited[caller.ID] = true
+	if caller.Func.Pkg == nil {
+		// no package information (e.g. a synthetic function) - there
+		// is no source code to rewrite
+		cfg.writeWarning(cfg.getFset(caller.Func), caller.Func.Pos(), "WARNING: function "+caller.Func.Name()+" has no package information and will not receive context parameter")
+		return cfg.CtxParamName
+	}
 	uniquePos := cfg.getUniquePosSSAFn(caller.Func, caller.Func.Pos())
+	ctxParamName := cfg.recordCtxParamName(uniquePos, caller.Func.Signature)
+	// the outcome is already known and functions reached again while
+	// processing this one's callers (recursively) need not be
+	// examined again
+	cfg.fnDefsCollected[caller.Func] = ctxParamName
 	fnType, exists := cfg.fnVisited[uniquePos]
 	if (!exists || fnType == extFn) && cfg.debugLevel > 0 && paramType == cfg.CtxParamType && !cfg.isPkgExternal(caller.Func.Pkg.Pkg.Path()) {
 
-		msg := "WARNING: function " + caller.Func.Name() + " takes the first parameter that is of type " + cfg.CtxParamType + " defined in different package than " + cfg.CtxPkgPath + "/" + cfg.CtxPkgName
+		msg := "WARNING: function " + caller.Func.Name() + " takes the first parameter that is of type " + cfg.CtxParamType + " defined in different package than " + cfg.CtxPkgPath + "/" + cfg.CtxPkgName + " (list it in ForeignCtxTypes in the config file to avoid injecting another context parameter)"
 		cfg.writeWarning(cfg.getFset(caller.Func), caller.Func.Pos(), msg)
 
 	}
 	if (exists && fnType != regularFn) || isTestingInitOrMainFunction(caller.Func.Name(), caller.Func.Signature) {
-		cfg.markFnAsFreshCtx(uniquePos, cfg.getFset(caller.Func), caller.Func.Name(), caller.Func.Pkg.Pkg.Path(), fnType, exists)
+		if exists && fnType != regularFn {
+			cfg.trace(caller.Func, "already categorized as "+fnTypeNames[fnType])
+		} else {
+			cfg.trace(caller.Func, "called by the test harness or the runtime")
+		}
+		cfg.markFnAsFreshCtx(uniquePos, caller.Func.Signature, cfg.getFset(caller.Func), caller.Func.Name(), caller.Func.Pkg.Pkg.Path(), fnType, exists)
+	} else if ctxExpr := cfg.getCtxFromReceiver(caller.Func.Signature); ctxExpr != "" {
+		cfg.trace(caller.Func, "context extracted from receiver")
+		cfg.markFnAsRecvCtx(uniquePos, ctxExpr)
+	} else if ctxExpr := cfg.getCtxFromForeignParam(caller.Func.Signature); ctxExpr != "" {
+		cfg.trace(caller.Func, "context extracted from first parameter of foreign context type")
+		cfg.markFnAsRecvCtx(uniquePos, ctxExpr)
+	} else if named := cfg.getCarrierRecv(caller.Func.Signature); named != nil {
+		cfg.trace(caller.Func, "receiver type is a context carrier")
+		cfg.markFnAsRecvCtx(uniquePos, caller.Func.Signature.Recv().Name()+"."+cfg.CtxParamName)
+		cfg.carrierTypes[cfg.getUniquePosPkg(named.Obj().Pkg(), named.Obj().Pos())] = true
 	} else if cfg.isMapOrSliceSig(caller.Func.Pkg, caller.Func.Signature) {
-		cfg.markFnAsFreshCtx(uniquePos, cfg.getFset(caller.Func), caller.Func.Name(), caller.Func.Pkg.Pkg.Path(), containerSig, exists)
+		cfg.trace(caller.Func, "signature used in map or slice construction")
+		cfg.markFnAsFreshCtx(uniquePos, caller.Func.Signature, cfg.getFset(caller.Func), caller.Func.Name(), caller.Func.Pkg.Pkg.Path(), containerSig, exists)
+	} else if cfg.isTestSuiteReceiver(caller.Func.Signature) {
+		cfg.trace(caller.Func, "receiver type embeds a test suite type")
+		cfg.markFnAsFreshCtx(uniquePos, caller.Func.Signature, cfg.getFset(caller.Func), caller.Func.Name(), caller.Func.Pkg.Pkg.Path(), testSuiteRecv, exists)
 	} else if cfg.isExtReceiver(caller.Func.Signature) {
-		cfg.markFnAsFreshCtx(uniquePos, cfg.getFset(caller.Func), caller.Func.Name(), caller.Func.Pkg.Pkg.Path(), extRecv, exists)
+		cfg.trace(caller.Func, "receiver type embeds an external type")
+		cfg.markFnAsFreshCtx(uniquePos, caller.Func.Signature, cfg.getFset(caller.Func), caller.Func.Name(), caller.Func.Pkg.Pkg.Path(), extRecv, exists)
 	} else {
-		modified := cfg.addIfacesModified(caller.Func.Signature, caller.Func.Name(), fnRecv)
+		modified := cfg.addIfacesModified(caller.Func, fnRecv)
 		if modified {
+			cfg.trace(caller.Func, "entered work list")
 			cfg.fnVisited[uniquePos] = regularFn
 			// put new function node in the work list
 			nodesWorkList = append(nodesWorkList, caller)
 			cfg.collect(nodesWorkList, nodesVisited)
 		} else {
-			cfg.markFnAsFreshCtx(uniquePos, cfg.getFset(caller.Func), caller.Func.Name(), caller.Func.Pkg.Pkg.Path(), extPkg, exists)
+			cfg.trace(caller.Func, "implements an external interface (or has //go:nointerface pragma)")
+			cfg.markFnAsFreshCtx(uniquePos, caller.Func.Signature, cfg.getFset(caller.Func), caller.Func.Name(), caller.Func.Pkg.Pkg.Path(), extPkg, exists)
+		}
+	}
+	return ctxParamName
+}
+
+// getEnclosingCtxFn returns the function whose context is passed as a
+// free variable to a given nested function: the nearest enclosing
+// function already taking context or otherwise the outermost enclosing
+// function present in the call graph (enclosing functions not present
+// in the call graph are skipped). It returns nil if the function is
+// not nested or if none of the enclosing functions are present in the
+// call graph.
+func (cfg *analyzerConfig) getEnclosingCtxFn(f *ssa.Function) *ssa.Function {
+	var enclosing *ssa.Function
+	for p := f.Parent(); p != nil; p = p.Parent() {
+		if cfg.graph.Nodes[p] == nil {
+			continue
+		}
+		enclosing = p
+		if isParamContext, _, _, _, _ := cfg.isFirstParamContext(p.Signature); isParamContext || cfg.getAnyPositionCtxParam(p.Signature) != "" {
+			break
 		}
 	}
-	return cfg.CtxParamName
+	return enclosing
+}
+
+// recordCtxParamName records name of the context parameter (or
+// variable) to be injected into a function with a given signature and
+// returns it. It is the default name unless it conflicts with the
+// name of the receiver, of one of the parameters or of one of the
+// named results.
+func (cfg *analyzerConfig) recordCtxParamName(uniquePos uniquePosInfo, sig *types.Signature) string {
+	names := make(map[string]bool)
+	if sig.Recv() != nil {
+		names[sig.Recv().Name()] = true
+	}
+	for _, vars := range []*types.Tuple{sig.Params(), sig.Results()} {
+		for i := 0; i < vars.Len(); i++ {
+			names[vars.At(i).Name()] = true
+		}
+	}
+	name := cfg.CtxParamName
+	for i := 1; names[name]; i++ {
+		name = cfg.CtxParamName + strconv.Itoa(i)
+	}
+	if name != cfg.CtxParamName {
+		cfg.ctxParamNames[uniquePos] = name
+	}
+	return name
 }
 
 // getUniquePosSSAFn returns unique position of a function described
-// by its SSA representation.
+// by its SSA representation. Unknown positions (e.g. of synthetic
+// functions) are distinguished by a per-function identifier as
+// otherwise all such functions would share the same position.
 func (cfg *analyzerConfig) getUniquePosSSAFn(fn *ssa.Function, pos token.Pos) uniquePosInfo {
+	var uniquePos uniquePosInfo
 	if fn.Pkg == nil {
-		return cfg.getUniquePosPkg(nil, pos)
+		uniquePos = cfg.getUniquePosPkg(nil, pos)
+	} else {
+		uniquePos = cfg.getUniquePosPkg(fn.Pkg.Pkg, pos)
 	}
-	return cfg.getUniquePosPkg(fn.Pkg.Pkg, pos)
+	if pos == token.NoPos {
+		id, exists := cfg.syntheticIDs[fn]
+		if !exists {
+			cfg.syntheticCounter++
+			id = cfg.syntheticCounter
+			cfg.syntheticIDs[fn] = id
+		}
+		uniquePos.syntheticID = id
+	}
+	return uniquePos
+}
+
+// getUniquePosCallSite returns unique position of a call site. For
+// all calls, including these in go and defer statements, it is the
+// position of the call expression's opening parenthesis so that calls
+// nested in a go or defer statement (e.g. as arguments) never share
+// a position with the go/defer call itself. Similarly, each call in a
+// method chain (e.g. c.WithRetry(3).Do(x)) has its own position.
+func (cfg *analyzerConfig) getUniquePosCallSite(e *cg.Edge) uniquePosInfo {
+	return cfg.getUniquePosSSAFn(e.Site.Parent(), e.Site.Common().Pos())
 }
 
 // getActualCallArg returns an argument for a function call at a given
-// position.
-func getActualCallArg(common *ssa.CallCommon, ind int) ssa.Value {
-	// if common represents a method, the first arguement is receiver
-	// which we don't want to analyze
-	if !common.IsInvoke() && common.Signature().Recv() != nil {
-		return common.Args[ind+1]
+// position (or nil if there is no such argument). The position is an
+// index into callee's declared parameters (params) which never
+// include the receiver. The receiver, however, may or may not be
+// present in the call's arguments:
+//   - in "invoke" mode (interface method call) it is not among the
+//     arguments (it's the call's value instead)
+//   - in a static method call and in a call via method expression
+//     (synthetic thunk) it is passed as the first argument
+//   - in a call via method value (bound closure) it is not among the
+//     arguments (it's bound to the closure instead)
+//
+// As variadic arguments are always packaged into a single slice
+// argument, any "extra" argument can only be the receiver.
+func getActualCallArg(common *ssa.CallCommon, params *types.Tuple, ind int) ssa.Value {
+	offset := 0
+	if !common.IsInvoke() {
+		offset = len(common.Args) - params.Len()
+	}
+	if offset < 0 || ind+offset >= len(common.Args) {
+		// call site does not match the callee (e.g. due to
+		// imprecise call graph)
+		return nil
 	}
-	return common.Args[ind]
+	return common.Args[ind+offset]
 }
 
 // markParamAsExternalFn marks a given parameter as one representing
@@ -588,12 +2145,39 @@ func (cfg *analyzerConfig) markParamAsExternalFn(arg *ssa.Value) {
 	} else if extFun, ok = (*arg).(*ssa.Function); !ok {
 		return
 	}
+	if extFun.Synthetic != "" {
+		// a method value (obj.Method) is represented as a closure
+		// over a synthetic wrapper with a bound receiver and a
+		// method expression (T.Method) as a synthetic thunk - it's
+		// the wrapped method that must be marked
+		if extFun = cfg.getWrappedMethod(extFun); extFun == nil {
+			return
+		}
+	}
 	// mark function as external so propagation stops here if context needs to be injected
 	// and "fake" context variable is injected at the begining of the function
+	cfg.trace(extFun, "passed as argument to a function from an external package")
 	cfg.fnVisited[cfg.getUniquePosSSAFn(extFun, extFun.Pos())] = extFn
 
 }
 
+// getWrappedMethod returns a concrete method wrapped by a synthetic
+// function (bound method closure or method expression thunk), or nil
+// if a given function does not wrap a concrete method (e.g. it wraps
+// an interface method).
+func (cfg *analyzerConfig) getWrappedMethod(fn *ssa.Function) *ssa.Function {
+	obj, ok := fn.Object().(*types.Func)
+	if !ok {
+		// not a wrapper of a declared function or method
+		return nil
+	}
+	if sig, ok := obj.Type().(*types.Signature); !ok || sig.Recv() == nil {
+		// not a method
+		return nil
+	}
+	return cfg.prog.FuncValue(obj)
+}
+
 // getTypeWithPkgFromVar returns a string representing type of a
 // variable qualified with its defining package name and path.
 func getTypeWithPkgFromVar(v *types.Var) string {
@@ -649,8 +2233,8 @@ func renameCall(call *ssa.CallCommon, doRename func(pkgPath string, pkgName stri
 		} else if f, ok = call.Value.(*ssa.Function); !ok {
 			return false
 		}
-		pkgPath := f.Pkg.Pkg.Path()
-		pkgName := f.Pkg.Pkg.Name()
+		pkgPath := getFnPkgPath(f)
+		pkgName := getFnPkgName(f)
 		recvType := getTypeWithPkgFromVar(f.Signature.Recv())
 		fnName := f.Name()
 		doRename(pkgPath, pkgName, recvType, fnName)
@@ -671,30 +2255,67 @@ func replaceCtxExprWildcard(wildcard string, ctxRegExpr string, ctxLit string) s
 	return ctxRegExpr
 }
 
-// getFset returns FileSet for a given function.
+// getFset returns FileSet for a given function (falls back to the
+// program's FileSet if function's package is unknown).
 func (cfg *analyzerConfig) getFset(fn *ssa.Function) *token.FileSet {
-	if cfg.largeCode {
-		return cfg.fsets[fn.Pkg.Pkg]
+	if cfg.largeCode && fn.Pkg != nil {
+		if fset, exists := cfg.fsets[fn.Pkg.Pkg]; exists {
+			return fset
+		}
+	}
+	return cfg.prog.Fset
+}
+
+// getFnPkgPath returns path of the package where a given function is
+// defined (or empty string if function has no package).
+func getFnPkgPath(fn *ssa.Function) string {
+	if fn.Pkg == nil {
+		return ""
+	}
+	return fn.Pkg.Pkg.Path()
+}
+
+// getFnPkgName returns name of the package where a given function is
+// defined (or empty string if function has no package).
+func getFnPkgName(fn *ssa.Function) string {
+	if fn.Pkg == nil {
+		return ""
 	}
-	return fn.Prog.Fset
+	return fn.Pkg.Pkg.Name()
 }
 
-// isFirstParamContext checks if the firs parameter is of specified context type and returns result as the first value.
+// isFirstParamContext checks if the first parameter is of specified context type and returns result as the first value.
+// The first parameter is skipped if it is of a foreign context type after which context parameter is placed (see ForeignCtxTypes).
 // The other return values represent, respectively:
 // - position of the context parameter (if any - otherwise invalid position)
 // - name in the function definition (to be used for callers needing context parameter)
-// - type in the function definition.
+// - type in the function definition (empty for foreign context types)
+// - whether the context parameter is a custom context.
+// A nil signature or a signature without parameters (the receiver,
+// if any, does not count) never has a context parameter - in this
+// case the default context parameter name and empty type are
+// returned.
 func (cfg *analyzerConfig) isFirstParamContext(sig *types.Signature) (bool, token.Pos, string, string, bool) {
-	params := sig.Params()
-	if params == nil {
+	if sig == nil || sig.Params().Len() == 0 {
 		return false, token.NoPos, cfg.CtxParamName, "", false
 	}
+	params := sig.Params()
 
 	v := params.At(0)
+	if cfg.isCtxAfterForeign(params) {
+		if params.Len() == 1 {
+			return false, token.NoPos, cfg.CtxParamName, "", false
+		}
+		v = params.At(1)
+	}
 	typeName := v.Type().String()
 	if named, ok := v.Type().(*types.Named); ok {
 		typeName = named.Obj().Name()
 	}
+	if cfg.getForeignCtx(v.Type()) != nil {
+		// not to be confused with the context type of the same name
+		typeName = ""
+	}
 
 	t := getTypeWithPkgFromVar(v)
 	if t == cfg.ctxParamTypeWithPkgPathName {
@@ -710,9 +2331,83 @@ func (cfg *analyzerConfig) isFirstParamContext(sig *types.Signature) (bool, toke
 		// be filled with the right value
 		return true, v.Pos(), replaceCtxExprWildcard(ctxCustomWildcard, cfg.CtxCustomExprExtract, v.Name()), typeName, true
 	}
+	if cfg.AcceptAssignableContext && cfg.isAssignableContext(v.Type()) {
+		// context parameter is of an interface type that can be
+		// used as context (e.g. it embeds the context type)
+		if !cfg.assignableCtxWarned[v] {
+			cfg.assignableCtxWarned[v] = true
+			fset := cfg.prog.Fset
+			if cfg.largeCode {
+				if f, exists := cfg.fsets[v.Pkg()]; exists {
+					fset = f
+				}
+			}
+			msg := "WARNING: parameter " + v.Name() + " of type " + typeName + " assignable to " + cfg.CtxParamType + " is used as context parameter"
+			cfg.writeWarning(fset, v.Pos(), msg)
+		}
+		return true, v.Pos(), v.Name(), typeName, false
+	}
 	return false, token.NoPos, cfg.CtxParamName, typeName, false
 }
 
+// getAnyPositionCtxParam returns the name of the first parameter of
+// the context type in a position other than the first one if such
+// parameters are to be treated as existing context parameters (see
+// UseAnyPositionCtxAsStop and CtxLastEverywhere) or an empty string
+// otherwise. Blank and
+// unnamed parameters are not taken into account as they cannot be
+// renamed without modifying the signature.
+func (cfg *analyzerConfig) getAnyPositionCtxParam(sig *types.Signature) string {
+	if (!cfg.UseAnyPositionCtxAsStop && !cfg.CtxLastEverywhere) || sig == nil {
+		return ""
+	}
+	params := sig.Params()
+	for i := 1; i < params.Len(); i++ {
+		v := params.At(i)
+		if v.Name() == "" || v.Name() == "_" {
+			continue
+		}
+		if getTypeWithPkgFromVar(v) == cfg.ctxParamTypeWithPkgPathName {
+			return v.Name()
+		}
+	}
+	return ""
+}
+
+// isAssignableContext determines if a given type is a named interface
+// type (other than the context type itself) whose values can be used
+// as context (e.g. because it embeds the context type).
+func (cfg *analyzerConfig) isAssignableContext(t types.Type) bool {
+	named, ok := t.(*types.Named)
+	if !ok || named.Obj().Pkg() == nil {
+		return false
+	}
+	if _, ok := named.Underlying().(*types.Interface); !ok {
+		return false
+	}
+	// the context type must be looked up in the package where the
+	// interface is defined or in one of its imports (the same package
+	// can be loaded more than once yielding different types)
+	ctxPkg := named.Obj().Pkg()
+	if ctxPkg.Path() != cfg.CtxPkgPath || ctxPkg.Name() != cfg.CtxPkgName {
+		ctxPkg = nil
+		for _, imp := range named.Obj().Pkg().Imports() {
+			if imp.Path() == cfg.CtxPkgPath && imp.Name() == cfg.CtxPkgName {
+				ctxPkg = imp
+				break
+			}
+		}
+	}
+	if ctxPkg == nil {
+		return false
+	}
+	ctxType, ok := ctxPkg.Scope().Lookup(cfg.CtxParamType).(*types.TypeName)
+	if !ok || types.Identical(ctxType.Type(), t) {
+		return false
+	}
+	return types.AssignableTo(t, ctxType.Type())
+}
+
 // mapSigToPkg adds a function signature to a pkg->funcSig map.
 func mapSigToPkg(sigMap map[*ssa.Package]map[*types.Signature]bool, pkg *ssa.Package, sig *types.Signature) {
 	var exists bool
@@ -774,8 +2469,20 @@ func isInitFuncName(n string) bool {
 // markFnAsFreshCtx marks a given function as the one that will
 // receive injection of artificial context variable at the beginnin of
 // its body.
-func (cfg *analyzerConfig) markFnAsFreshCtx(pos uniquePosInfo, fset *token.FileSet, name string, pkgPath string, fnType int, exists bool) {
-	if cfg.debugLevel > 0 && (!exists || fnType == extFn) {
+func (cfg *analyzerConfig) markFnAsFreshCtx(pos uniquePosInfo, sig *types.Signature, fset *token.FileSet, name string, pkgPath string, fnType int, exists bool) {
+	if fnType != skippedFileFn {
+		ctxExpr := cfg.getCtxFromStreamParam(sig)
+		if ctxExpr == "" {
+			ctxExpr = cfg.getCtxFromForeignParam(sig)
+		}
+		if ctxExpr != "" {
+			// context is available from the function's parameter
+			// and artificial context is not needed
+			cfg.markFnAsRecvCtx(pos, ctxExpr)
+			return
+		}
+	}
+	if cfg.debugLevel > 0 && (!exists || fnType == extFn || fnType == skippedFileFn || fnType == generatedFn || fnType == frozenFn) {
 		if cfg.isPkgExternal(pkgPath) {
 			// modifications of code in external packages is
 			// suppressed and warning generation must be suppressed
@@ -786,17 +2493,187 @@ func (cfg *analyzerConfig) markFnAsFreshCtx(pos uniquePosInfo, fset *token.FileS
 		msg := "WARNING: function " + name + " is a function used by the test harness (injecting ARTIFICIAL context)"
 		if fnType == containerSig {
 			msg = "WARNING: signature of function " + name + " is used as used as a type in construction of map or array/slice  (injecting ARTIFICIAL context)"
+		} else if target, exists := cfg.linknameTargets[pos]; exists && fnType == extFn {
+			msg = "WARNING: function " + name + " is linked to " + target + " via " + linknamePragma + " directive (injecting ARTIFICIAL context)"
+		} else if cfg.cgoExports[pos] && fnType == extFn {
+			msg = "WARNING: function " + name + " is exported to C code via " + cgoExportPragma + " directive and its signature is determined by the C interface (injecting ARTIFICIAL context)"
 		} else if fnType == extFn {
 			msg = "WARNING: function " + name + " is used as parameter by another function from an external package (injecting ARTIFICIAL context)"
 		} else if fnType == extPkg {
 			msg = "WARNING: function " + name + " implements interface from an external package (injecting ARTIFICIAL context)"
 		} else if fnType == extRecv {
 			msg = "WARNING: function " + name + " receiver type embeds another external type (injecting ARTIFICIAL context)"
+		} else if fnType == testSuiteRecv {
+			msg = "WARNING: function " + name + " receiver type embeds a test suite type (injecting ARTIFICIAL context)"
+		} else if fnType == frozenFn {
+			msg = "WARNING: function " + name + " is exported by or implements an exported interface of a package with frozen API (injecting ARTIFICIAL context)"
+		} else if fnType == generatedFn {
+			msg = "WARNING: function " + name + " is defined in a generated file whose function signatures are not modified (injecting ARTIFICIAL context)"
+		} else if fnType == skippedFileFn {
+			msg = "WARNING: function " + name + " is defined in a file exceeding maximum file size that will not be transformed (context propagation stops)"
 		}
 		cfg.writeWarning(fset, pos.pos, msg)
 
 	}
+	if fnType != freshCtxFn && fnType != skippedFileFn {
+		cfg.artificialCtxFns[pos] = fnType
+		if reason := artificialCtxReasons[fnType]; cfg.CtxParamInvalid[reason] != "" {
+			cfg.ctxInitReasons[pos] = reason
+		}
+	}
+	cfg.fnVisited[pos] = freshCtxFn
+}
+
+// getCtxFromReceiver returns expression extracting context from a
+// given method's receiver (or empty string if the receiver type is not
+// one of the types specified in the config file or if the receiver
+// has no name).
+func (cfg *analyzerConfig) getCtxFromReceiver(sig *types.Signature) string {
+	recv := sig.Recv()
+	if recv == nil || len(cfg.CtxFromReceiver) == 0 {
+		return ""
+	}
+	t := recv.Type()
+	if p, ok := t.(*types.Pointer); ok {
+		t = p.Elem()
+	}
+	named, ok := t.(*types.Named)
+	if !ok || named.Obj().Pkg() == nil {
+		return ""
+	}
+	expr, exists := cfg.CtxFromReceiver[named.Obj().Pkg().Path()+"."+named.Obj().Name()]
+	if !exists {
+		return ""
+	}
+	if recv.Name() == "" || recv.Name() == "_" {
+		// receiver cannot be referenced
+		return ""
+	}
+	return recv.Name() + expr
+}
+
+// getCtxFromStructField returns expression accessing context stored in
+// a field of a given method's receiver (or empty string if the
+// receiver type has none of the fields specified in the config file or
+// if the receiver has no name).
+func (cfg *analyzerConfig) getCtxFromStructField(sig *types.Signature) string {
+	if sig == nil || len(cfg.CtxStructFields) == 0 {
+		return ""
+	}
+	recv := sig.Recv()
+	if recv == nil || recv.Name() == "" || recv.Name() == "_" {
+		// receiver cannot be referenced
+		return ""
+	}
+	t := recv.Type()
+	if p, ok := t.(*types.Pointer); ok {
+		t = p.Elem()
+	}
+	named, ok := t.(*types.Named)
+	if !ok || named.Obj().Pkg() == nil {
+		return ""
+	}
+	for _, f := range cfg.CtxStructFields {
+		if named.Obj().Pkg().Path() != f.PkgPath || named.Obj().Name() != f.TypeName {
+			continue
+		}
+		obj, _, _ := types.LookupFieldOrMethod(named, true, named.Obj().Pkg(), f.FieldName)
+		if v, ok := obj.(*types.Var); !ok || !v.IsField() || getTypeWithPkgFromVar(v) != cfg.ctxParamTypeWithPkgPathName {
+			fatal("type " + f.PkgPath + "." + f.TypeName + " has no field " + f.FieldName + " of the context type")
+		}
+		return recv.Name() + "." + f.FieldName
+	}
+	return ""
+}
+
+// getCarrierRecv returns named type of a given method's receiver if
+// it is one of the context carrier types specified in the config file
+// (or nil if it is not or if the receiver has no name).
+func (cfg *analyzerConfig) getCarrierRecv(sig *types.Signature) *types.Named {
+	recv := sig.Recv()
+	if recv == nil || len(cfg.CtxCarrierTypes) == 0 {
+		return nil
+	}
+	if recv.Name() == "" || recv.Name() == "_" {
+		// receiver cannot be referenced
+		return nil
+	}
+	named := getCarrierNamed(recv.Type(), cfg.CtxCarrierTypes)
+	if named == nil {
+		return nil
+	}
+	if obj, _, _ := types.LookupFieldOrMethod(named, true, named.Obj().Pkg(), cfg.CtxParamName); obj != nil {
+		fatal("context carrier type " + named.Obj().Pkg().Path() + "." + named.Obj().Name() + " already has field or method named " + cfg.CtxParamName)
+	}
+	return named
+}
+
+// getCarrierNamed returns a given type (or type a given pointer type
+// points to) if it is a struct type listed as one of the given context
+// carrier types (or nil if it is not).
+func getCarrierNamed(t types.Type, carrierTypes typeInfo) *types.Named {
+	if p, ok := t.(*types.Pointer); ok {
+		t = p.Elem()
+	}
+	named, ok := t.(*types.Named)
+	if !ok {
+		return nil
+	}
+	if _, ok := named.Underlying().(*types.Struct); !ok || !isListedType(named, carrierTypes) {
+		return nil
+	}
+	return named
+}
+
+// getCtxFromStreamParam returns expression extracting context from
+// the first parameter of a function with a given signature (or empty
+// string if the parameter's type does not implement any of the
+// interfaces providing context specified in the config file or if the
+// parameter has no name).
+func (cfg *analyzerConfig) getCtxFromStreamParam(sig *types.Signature) string {
+	if len(cfg.streamIfaces) == 0 || sig.Params().Len() == 0 {
+		return ""
+	}
+	param := sig.Params().At(0)
+	if param.Name() == "" || param.Name() == "_" {
+		// parameter cannot be referenced
+		return ""
+	}
+	for iface, method := range cfg.streamIfaces {
+		if types.Implements(param.Type(), iface) {
+			return param.Name() + "." + method + "()"
+		}
+	}
+	return ""
+}
+
+// getCtxFromForeignParam returns expression extracting context from
+// the first parameter of a function with a given signature (or empty
+// string if the parameter is not of one of the foreign context types
+// specified in the config file with an extraction expression or if
+// the parameter has no name).
+func (cfg *analyzerConfig) getCtxFromForeignParam(sig *types.Signature) string {
+	if sig.Params().Len() == 0 {
+		return ""
+	}
+	param := sig.Params().At(0)
+	foreign := cfg.getForeignCtx(param.Type())
+	if foreign == nil || foreign.CtxExtract == "" {
+		return ""
+	}
+	if param.Name() == "" || param.Name() == "_" {
+		// parameter cannot be referenced
+		return ""
+	}
+	return param.Name() + foreign.CtxExtract
+}
+
+// markFnAsRecvCtx marks a function (method) as one whose context
+// variable is initialized using a given expression extracting context
+// from its receiver (or from its parameter).
+func (cfg *analyzerConfig) markFnAsRecvCtx(pos uniquePosInfo, ctxExpr string) {
 	cfg.fnVisited[pos] = freshCtxFn
+	cfg.ctxInitExprs[pos] = ctxExpr
 }
 
 // isMapOrSliceSig determines if a signature of a given function is
@@ -818,9 +2695,24 @@ func (cfg *analyzerConfig) isMapOrSliceSig(pkg *ssa.Package, sig *types.Signatur
 // that contains one of the embedded external types specified in the
 // config file.
 func (cfg *analyzerConfig) isExtReceiver(sig *types.Signature) bool {
+	s := getRecvStruct(sig)
+	return s != nil && cfg.extRecvTypes[s]
+}
+
+// isTestSuiteReceiver determines if a given method's receiver is of
+// type that contains one of the embedded test suite types specified in
+// the config file.
+func (cfg *analyzerConfig) isTestSuiteReceiver(sig *types.Signature) bool {
+	s := getRecvStruct(sig)
+	return s != nil && cfg.testSuiteRecvTypes[s]
+}
+
+// getRecvStruct returns struct type of a given method's receiver (or
+// nil if the receiver is not a struct or a pointer to one).
+func getRecvStruct(sig *types.Signature) *types.Struct {
 	recv := sig.Recv()
 	if recv == nil {
-		return false
+		return nil
 	}
 	var t types.Type
 	t = recv.Type()
@@ -828,28 +2720,39 @@ func (cfg *analyzerConfig) isExtReceiver(sig *types.Signature) bool {
 		t = ptr.Elem()
 	}
 
-	if s, ok := t.Underlying().(*types.Struct); ok && cfg.extRecvTypes[s] {
-		return true
+	if s, ok := t.Underlying().(*types.Struct); ok {
+		return s
 	}
-	return false
+	return nil
 }
 
 // addIfacesModified records an interface function declaration that
 // needs to be modified as a result of a concrete method
-// implementation (implementing this interface) being modified.
-func (cfg *analyzerConfig) addIfacesModified(sig *types.Signature,
-	fnName string,
-	fnRecv string) bool {
+// implementation (implementing this interface) being modified. It
+// returns false if the method's signature cannot be modified (e.g. it
+// implements an external interface or it is marked with the
+// //go:nointerface pragma).
+func (cfg *analyzerConfig) addIfacesModified(fn *ssa.Function, fnRecv string) bool {
 	if fnRecv == "" {
 		// no interface to modify, but function's signature must change
 		return true
 	}
+	if hasNoInterfacePragma(fn) {
+		// method is treated as not implementing any interface (e.g.
+		// it's a test double) so its signature cannot change
+		msg := "WARNING: method " + fn.Name() + " is marked with " + noInterfacePragma + " pragma and is treated as not implementing any interface"
+		cfg.writeWarning(cfg.getFset(fn), fn.Pos(), msg)
+		cfg.trace(fn, "marked with "+noInterfacePragma+" pragma")
+		return false
+	}
+	sig := fn.Signature
+	fnName := fn.Name()
 
 	var ifacesToModify []*types.Interface
 	var methodsToModify []*types.Func
 	modifiedNum := 0
 	for iface, _ := range cfg.ifaces {
-		if !types.Implements(sig.Recv().Type(), iface) {
+		if !cfg.implementsIface(sig.Recv().Type(), iface) {
 			continue
 		}
 		// method may implement embedded interface
@@ -858,8 +2761,10 @@ func (cfg *analyzerConfig) addIfacesModified(sig *types.Signature,
 			// interface not found - keep looking
 			continue
 		}
+		actualIface = cfg.canonicalIface(actualIface)
 		if _, exists := cfg.ifaces[actualIface]; !exists {
 			// external interface - do not modify any interface nor method's signature
+			cfg.trace(fn, "implements method "+m.FullName()+" of an external interface")
 			return false
 		}
 		ifacesToModify = append(ifacesToModify, actualIface)
@@ -886,6 +2791,21 @@ func (cfg *analyzerConfig) addIfacesModified(sig *types.Signature,
 	return true
 }
 
+// hasNoInterfacePragma determines if declaration of a given function
+// is marked with the //go:nointerface pragma.
+func hasNoInterfacePragma(fn *ssa.Function) bool {
+	fd, ok := fn.Syntax().(*ast.FuncDecl)
+	if !ok || fd.Doc == nil {
+		return false
+	}
+	for _, c := range fd.Doc.List {
+		if strings.TrimSpace(c.Text) == noInterfacePragma {
+			return true
+		}
+	}
+	return false
+}
+
 // getUniquePosTypesFn returns unique position of a function described
 // by its type.
 func (cfg *analyzerConfig) getUniquePosTypesFn(fn *types.Func, pos token.Pos) uniquePosInfo {
@@ -920,6 +2840,9 @@ func getMethodAndInterface(methodName string, iface *types.Interface) (*types.Fu
 // getFuncFromArg returns function definition representing a given
 // value (or nil if the value is not of function type).
 func getFuncFromArg(arg ssa.Value) *ssa.Function {
+	if arg == nil {
+		return nil
+	}
 	if ct, ok := arg.(*ssa.ChangeType); ok {
 		if mc, ok := ct.X.(*ssa.MakeClosure); ok {
 			return mc.Fn.(*ssa.Function) // always a function
@@ -930,7 +2853,7 @@ func getFuncFromArg(arg ssa.Value) *ssa.Function {
 	} else if c, ok := arg.(*ssa.Call); ok {
 		res := c.Common().Signature().Results()
 		if res.Len() != 1 {
-			log.Fatalf("function call argument has more than one return value (expected one of function type)")
+			fatal("function call argument has more than one return value (expected one of function type)")
 		}
 		// TODO: ignore for now, possibly deal with later if need be
 	} else if _, ok := arg.(*ssa.Parameter); ok {
@@ -944,7 +2867,7 @@ func getFuncFromArg(arg ssa.Value) *ssa.Function {
 	} else if _, ok := arg.(*ssa.Extract); ok {
 		// TODO: ignore for now, possibly deal with later if need be
 	} else {
-		log.Fatalf("unrecognized argument for parameter of type function")
+		fatal("unrecognized argument for parameter of type function")
 	}
 	return nil
 }
@@ -968,7 +2891,7 @@ func (cfg *analyzerConfig) collectIfaces(namedModified map[*types.Named]bool) bo
 		sig := f.Signature
 		if sig.Recv() != nil {
 			for iface, funcNames := range cfg.ifaceModified {
-				if types.Implements(sig.Recv().Type(), iface) {
+				if cfg.implementsIface(sig.Recv().Type(), iface) {
 					if _, exists := funcNames[f.Name()]; exists {
 						cfg.insertArtificialCtx(namedModified, f)
 					}
@@ -986,14 +2909,18 @@ func (cfg *analyzerConfig) collectIfaces(namedModified map[*types.Named]bool) bo
 				// parameter if not of interface type
 				continue
 			}
-			funcNames, exists := cfg.ifaceModified[iface]
+			funcNames, exists := cfg.ifaceModified[cfg.canonicalIface(iface)]
 			if !exists {
 				// interface type has not been modified
 				continue
 			}
 			// get the type of an argument at the call site for the selected function
 			for _, caller := range n.In {
-				arg := getActualCallArg(caller.Site.Common(), ind)
+				arg := getActualCallArg(caller.Site.Common(), params, ind)
+				if arg == nil {
+					// no matching argument at the call site
+					continue
+				}
 
 				// argType = arg.Type() does not work here (misses some cases)
 				var argType types.Type
@@ -1002,7 +2929,7 @@ func (cfg *analyzerConfig) collectIfaces(namedModified map[*types.Named]bool) bo
 				} else if c, ok := arg.(*ssa.Call); ok {
 					res := c.Common().Signature().Results()
 					if res.Len() != 1 {
-						log.Fatalf("function call argument has more than one return value (expected one of interface type)")
+						fatal("function call argument has more than one return value (expected one of interface type)")
 					}
 					argType = res.At(0).Type()
 				} else if p, ok := arg.(*ssa.Parameter); ok {
@@ -1020,7 +2947,7 @@ func (cfg *analyzerConfig) collectIfaces(namedModified map[*types.Named]bool) bo
 				} else if ci, ok := arg.(*ssa.ChangeInterface); ok {
 					argType = ci.Type()
 				} else {
-					log.Fatalf("unrecognized argument for parameter of type interface")
+					fatal("unrecognized argument for parameter of type interface")
 				}
 				methodSet := cfg.prog.MethodSets.MethodSet(argType)
 				// get all methods defined for the given argument type
@@ -1087,7 +3014,7 @@ func (cfg *analyzerConfig) collectNamedTypes(namedModified map[*types.Named]bool
 					continue
 				}
 				for _, caller := range n.In {
-					arg := getActualCallArg(caller.Site.Common(), ind)
+					arg := getActualCallArg(caller.Site.Common(), params, ind)
 					argFun := getFuncFromArg(arg)
 					if argFun != nil {
 						uniqueFnPos := cfg.getUniquePosSSAFn(argFun, argFun.Pos())
@@ -1124,7 +3051,7 @@ func (cfg *analyzerConfig) collectNamedTypes(namedModified map[*types.Named]bool
 					continue
 				}
 				for _, caller := range n.In {
-					arg := getActualCallArg(caller.Site.Common(), ind)
+					arg := getActualCallArg(caller.Site.Common(), params, ind)
 					fun := getFuncFromArg(arg)
 					if fun != nil {
 						cfg.insertArtificialCtx(namedModified, fun)
@@ -1140,6 +3067,174 @@ func (cfg *analyzerConfig) collectNamedTypes(namedModified map[*types.Named]bool
 	}
 }
 
+// collectConversions makes sure that both sides of conversions
+// between functions and named function types are either modified to
+// take context parameter or left alone. It returns true if additional
+// named types have been modified.
+func (cfg *analyzerConfig) collectConversions(namedModified map[*types.Named]bool) bool {
+	added := false
+	for f, _ := range cfg.graph.Nodes {
+		if f == nil || f.Blocks == nil || f.Pkg == nil || cfg.isPkgExternal(f.Pkg.Pkg.Path()) {
+			continue
+		}
+		for _, b := range f.Blocks {
+			for _, instr := range b.Instrs {
+				ct, ok := instr.(*ssa.ChangeType)
+				if !ok {
+					continue
+				}
+				named, sig := cfg.getUnmodifiedNamedFunctionType(ct.Type(), nil)
+				if named == nil {
+					// not a conversion to named function type
+					continue
+				}
+				fun := getFuncFromArg(ct)
+				if fun == nil {
+					// not a conversion of a function
+					continue
+				}
+				if isParamContext, _, _, _, _ := cfg.isFirstParamContext(sig); isParamContext {
+					// named type has context parameter already
+					continue
+				}
+				pos := ct.Pos()
+				if !pos.IsValid() {
+					// implicit conversion
+					pos = f.Pos()
+				}
+				fnType, fnExists := cfg.fnVisited[cfg.getUniquePosSSAFn(fun, fun.Pos())]
+				fnModified := fnExists && fnType == regularFn
+				namedType, namedExists := cfg.fnVisited[cfg.getUniquePosPkg(named.Obj().Pkg(), named.Obj().Pos())]
+				namedTypeModified := namedExists && namedType == regularFn
+				if fnModified && !namedTypeModified {
+					if named.Obj().Pkg() == nil || cfg.isPkgExternal(named.Obj().Pkg().Path()) {
+						msg := "WARNING: function " + fun.Name() + " has been modified to take context parameter but is converted to type " + named.Obj().Name() + " defined in an external package"
+						cfg.warnConversion(f, ct, pos, msg)
+						continue
+					}
+					// modify named type to match the function
+					cfg.fnVisited[cfg.getUniquePosPkg(named.Obj().Pkg(), named.Obj().Pos())] = regularFn
+					namedModified[named] = true
+					added = true
+				} else if !fnModified && namedTypeModified {
+					if fun.Blocks == nil || fun.Pkg == nil || cfg.isPkgExternal(fun.Pkg.Pkg.Path()) {
+						msg := "WARNING: function " + fun.Name() + " defined in an external package is converted to type " + named.Obj().Name() + " modified to take context parameter"
+						cfg.warnConversion(f, ct, pos, msg)
+						continue
+					}
+					// modify function to match the named type
+					cfg.insertArtificialCtx(namedModified, fun)
+				}
+			}
+		}
+	}
+	return added
+}
+
+// warnConversion reports (only once) a conversion between a function
+// and a named function type that cannot be reconciled.
+func (cfg *analyzerConfig) warnConversion(f *ssa.Function, ct *ssa.ChangeType, pos token.Pos, msg string) {
+	if cfg.conversionsWarned[ct] {
+		return
+	}
+	cfg.conversionsWarned[ct] = true
+	cfg.writeWarning(cfg.getFset(f), pos, msg)
+}
+
+// collectTypeAssertCalls gathers information about call sites where
+// a function is called through a value of modified named function
+// type obtained from a type assertion or a type switch.
+func (cfg *analyzerConfig) collectTypeAssertCalls(namedModified map[*types.Named]bool) {
+	for f, _ := range cfg.graph.Nodes {
+		if f == nil || f.Blocks == nil || f.Pkg == nil || cfg.isPkgExternal(f.Pkg.Pkg.Path()) {
+			continue
+		}
+		for _, b := range f.Blocks {
+			for _, instr := range b.Instrs {
+				call, ok := instr.(ssa.CallInstruction)
+				if !ok {
+					continue
+				}
+				named := getTypeAssertNamed(call.Common().Value)
+				if named == nil || !namedModified[named] {
+					// not a call through a value of modified named type
+					// obtained from a type assertion
+					continue
+				}
+				uniquePos := cfg.getUniquePosSSAFn(f, call.Common().Pos())
+				if _, exists := cfg.callSites[uniquePos]; exists {
+					// call site already processed (call graph
+					// contained the call)
+					continue
+				}
+				cfg.callSites[uniquePos] = cfg.getCtxReplacement(f, named, call.Common().Pos())
+			}
+		}
+	}
+}
+
+// getTypeAssertNamed returns named type a given value has been
+// asserted to (or nil if the value is not a result of type assertion
+// to a named type).
+func getTypeAssertNamed(v ssa.Value) *types.Named {
+	if e, ok := v.(*ssa.Extract); ok && e.Index == 0 {
+		// comma-ok form of the type assertion (also used for type switches)
+		v = e.Tuple
+	}
+	ta, ok := v.(*ssa.TypeAssert)
+	if !ok {
+		return nil
+	}
+	named, ok := ta.AssertedType.(*types.Named)
+	if !ok {
+		return nil
+	}
+	return named
+}
+
+// getCtxReplacement returns call replacement info for a call site
+// located in a given function, depending on how the context is
+// available in this function (or in one of its enclosing functions).
+func (cfg *analyzerConfig) getCtxReplacement(f *ssa.Function, named *types.Named, pos token.Pos) *replacementInfo {
+	for fn := f; fn != nil; fn = fn.Parent() {
+		uniquePos := cfg.getUniquePosSSAFn(fn, fn.Pos())
+		isParamContext, renameParamPos, paramName, _, _ := cfg.isFirstParamContext(fn.Signature)
+		if name := cfg.getAnyPositionCtxParam(fn.Signature); !isParamContext && name != "" {
+			isParamContext, paramName = true, name
+		}
+		if fieldExpr := cfg.getCtxFromStructField(fn.Signature); !isParamContext && fieldExpr != "" {
+			isParamContext, paramName = true, fieldExpr
+		}
+		if fnType, exists := cfg.fnVisited[uniquePos]; exists && (fnType == regularFn || fnType == freshCtxFn) {
+			// context parameter or context variable will be injected
+			isParamContext = true
+			paramName = cfg.getCtxParamName(uniquePos)
+		}
+		if !isParamContext {
+			continue
+		}
+		if paramName == "_" || paramName == "" {
+			// will be renamed to ctxParamName
+			cfg.renameParamsVisited[cfg.getUniquePosSSAFn(fn, renameParamPos)] = true
+			return &cfg.commonCallReplacement
+		}
+		if paramName != cfg.CtxParamName {
+			return &replacementInfo{cfg.commonCallReplacement.newName,
+				cfg.commonCallReplacement.argPos,
+				cfg.commonCallReplacement.ctxImports,
+				cfg.commonCallReplacement.ctxRegExpr,
+				replaceCtxExprWildcard(ctxWildcard, cfg.commonCallReplacement.ctxRegExpr, paramName),
+				cfg.commonCallReplacement.isVar,
+				cfg.commonCallReplacement.applyToResultCall,
+				cfg.commonCallReplacement.ctxWrapExpr}
+		}
+		return &cfg.commonCallReplacement
+	}
+	msg := "WARNING: function " + f.Name() + " calls a value of type " + named.Obj().Name() + " obtained from a type assertion but has no context available (injecting ARTIFICIAL context)"
+	cfg.writeWarning(cfg.getFset(f), pos, msg)
+	return &cfg.nilCallReplacement
+}
+
 // insertArtificialCtx injects artificial context variable at the
 // beginning of the function body (unless it already has a context
 // parameter) and injects artificial context argument to all its call
@@ -1156,27 +3251,37 @@ func (cfg *analyzerConfig) insertArtificialCtx(namedModified map[*types.Named]bo
 		cfg.renameParamsVisited[cfg.getUniquePosSSAFn(fun, renameParamPos)] = true
 	} else {
 		skipContextParam := isParamContext && (custom || paramName == "_" || paramName == "" || paramName == cfg.CtxParamName)
-		if skipContextParam {
+		if skipContextParam || cfg.getAnyPositionCtxParam(sig) != "" {
+			return
+		}
+		if fun.Pkg == nil {
+			// no package information (e.g. a synthetic function) -
+			// there is no source code to rewrite
+			cfg.writeWarning(cfg.getFset(fun), fun.Pos(), "WARNING: function "+fun.Name()+" has no package information and will not receive context parameter")
 			return
 		}
 		if cfg.debugLevel > 0 && paramType == cfg.CtxParamType && !cfg.isPkgExternal(fun.Pkg.Pkg.Path()) {
-			msg := "WARNING: function " + fun.Name() + " takes the first parameter that is of type " + cfg.CtxParamType + " defined in different package than " + cfg.CtxPkgPath + "/" + cfg.CtxPkgName
+			msg := "WARNING: function " + fun.Name() + " takes the first parameter that is of type " + cfg.CtxParamType + " defined in different package than " + cfg.CtxPkgPath + "/" + cfg.CtxPkgName + " (list it in ForeignCtxTypes in the config file to avoid injecting another context parameter)"
 			cfg.writeWarning(cfg.getFset(fun), fun.Pos(), msg)
 		}
 		uniquePos := cfg.getUniquePosSSAFn(fun, fun.Pos())
+		cfg.recordCtxParamName(uniquePos, sig)
+		cfg.trace(fun, "implements a modified interface method")
 		fnType, exists := cfg.fnVisited[uniquePos]
 		if (exists && fnType != regularFn) || isTestingInitOrMainFunction(fun.Name(), sig) {
-			cfg.markFnAsFreshCtx(uniquePos, cfg.getFset(fun), fun.Name(), fun.Pkg.Pkg.Path(), fnType, exists)
+			cfg.markFnAsFreshCtx(uniquePos, sig, cfg.getFset(fun), fun.Name(), fun.Pkg.Pkg.Path(), fnType, exists)
+		} else if ctxExpr := cfg.getCtxFromReceiver(sig); ctxExpr != "" {
+			cfg.markFnAsRecvCtx(uniquePos, ctxExpr)
 		} else if cfg.isMapOrSliceSig(fun.Pkg, fun.Signature) {
-			cfg.markFnAsFreshCtx(uniquePos, cfg.getFset(fun), fun.Name(), fun.Pkg.Pkg.Path(), containerSig, exists)
+			cfg.markFnAsFreshCtx(uniquePos, sig, cfg.getFset(fun), fun.Name(), fun.Pkg.Pkg.Path(), containerSig, exists)
+		} else if cfg.isTestSuiteReceiver(fun.Signature) {
+			cfg.markFnAsFreshCtx(uniquePos, sig, cfg.getFset(fun), fun.Name(), fun.Pkg.Pkg.Path(), testSuiteRecv, exists)
 		} else if cfg.isExtReceiver(fun.Signature) {
-			cfg.markFnAsFreshCtx(uniquePos, cfg.getFset(fun), fun.Name(), fun.Pkg.Pkg.Path(), extRecv, exists)
+			cfg.markFnAsFreshCtx(uniquePos, sig, cfg.getFset(fun), fun.Name(), fun.Pkg.Pkg.Path(), extRecv, exists)
 		} else {
 			// add all interfaces that this method's receiver implements to the set
 			// of these that still need to be processed (unless they are external interfaces)
-			modified := cfg.addIfacesModified(sig,
-				fun.Name(),
-				getTypeWithPkgFromVar(sig.Recv()))
+			modified := cfg.addIfacesModified(fun, getTypeWithPkgFromVar(sig.Recv()))
 			if modified {
 				cfg.fnVisited[uniquePos] = regularFn
 				funNode := cfg.graph.Nodes[fun]
@@ -1184,7 +3289,7 @@ func (cfg *analyzerConfig) insertArtificialCtx(namedModified map[*types.Named]bo
 					cfg.insertArtificialCtxCallsites(namedModified, funNode)
 				}
 			} else {
-				cfg.markFnAsFreshCtx(uniquePos, cfg.getFset(fun), fun.Name(), fun.Pkg.Pkg.Path(), extPkg, exists)
+				cfg.markFnAsFreshCtx(uniquePos, sig, cfg.getFset(fun), fun.Name(), fun.Pkg.Pkg.Path(), extPkg, exists)
 			}
 		}
 	}
@@ -1196,7 +3301,7 @@ func (cfg *analyzerConfig) insertArtificialCtxCallsites(namedModified map[*types
 	// find all call sites of a function we just modified and if no context argument
 	// is already passed, pass nil as the first argument
 	for _, in := range funNode.In {
-		uniquePos := cfg.getUniquePosSSAFn(in.Site.Parent(), in.Pos())
+		uniquePos := cfg.getUniquePosCallSite(in)
 		_, exists := cfg.callSites[uniquePos]
 		if exists {
 			// we have already processed this call site
@@ -1206,12 +3311,19 @@ func (cfg *analyzerConfig) insertArtificialCtxCallsites(namedModified map[*types
 		if !skipContextParam {
 			// skip if first parameter is context already
 			isParamContext, _, _, _, _ := cfg.isFirstParamContext(in.Site.Common().Signature())
-			skipContextParam = isParamContext
+			skipContextParam = isParamContext || cfg.getAnyPositionCtxParam(in.Site.Common().Signature()) != ""
 		}
 
 		if !skipContextParam {
 			// see if caller of this function has a context parameter
 			isParamContext, renameParamPos, paramName, _, _ := cfg.isFirstParamContext(in.Caller.Func.Signature)
+			if !isParamContext {
+				if name := cfg.getAnyPositionCtxParam(in.Caller.Func.Signature); name != "" {
+					isParamContext, paramName = true, name
+				} else if fieldExpr := cfg.getCtxFromStructField(in.Caller.Func.Signature); fieldExpr != "" {
+					isParamContext, paramName = true, fieldExpr
+				}
+			}
 			if isParamContext {
 				if paramName == "_" || paramName == "" {
 					// param name is "_" or ther isn't a name - change it to default context parameter name
@@ -1223,7 +3335,10 @@ func (cfg *analyzerConfig) insertArtificialCtxCallsites(namedModified map[*types
 						cfg.commonCallReplacement.argPos,
 						cfg.commonCallReplacement.ctxImports,
 						cfg.commonCallReplacement.ctxRegExpr,
-						replaceCtxExprWildcard(ctxWildcard, cfg.commonCallReplacement.ctxRegExpr, paramName)}
+						replaceCtxExprWildcard(ctxWildcard, cfg.commonCallReplacement.ctxRegExpr, paramName),
+						cfg.commonCallReplacement.isVar,
+						cfg.commonCallReplacement.applyToResultCall,
+						cfg.commonCallReplacement.ctxWrapExpr}
 					cfg.callSites[uniquePos] = &newCallReplacement
 				} else {
 					cfg.callSites[uniquePos] = &cfg.commonCallReplacement
@@ -1260,3 +3375,148 @@ func (cfg *analyzerConfig) getUnmodifiedNamedFunctionType(t types.Type, namedMod
 	}
 	return named, sig
 }
+
+// reportCtxEscapes reports goroutines started by anonymous functions
+// that reference the context parameter of the enclosing function
+// (existing or injected one), as such goroutines may outlive the
+// request and use context after it has been cancelled.
+func (cfg *analyzerConfig) reportCtxEscapes() {
+	if !cfg.opts.ContextEscapeAnalysis {
+		return
+	}
+	var escapes []map[string]string
+	for f := range cfg.graph.Nodes {
+		if f == nil || f.Pkg == nil || f.Parent() != nil || f.Blocks == nil || cfg.isPkgExternal(getFnPkgPath(f)) {
+			// not an actual function, a nested function (processed
+			// with its enclosing one) or function with no body
+			continue
+		}
+		ctxValues := make(map[ssa.Value]bool)
+		var paramName string
+		if isParamContext, _, _, _, _ := cfg.isFirstParamContext(f.Signature); isParamContext {
+			param := f.Params[0]
+			if f.Signature.Recv() != nil {
+				param = f.Params[1]
+			}
+			ctxValues[param] = true
+			paramName = param.Name()
+		} else {
+			uniquePos := cfg.getUniquePosSSAFn(f, f.Pos())
+			if fnType, exists := cfg.fnVisited[uniquePos]; !exists || fnType != regularFn {
+				// function does not take context parameter
+				continue
+			}
+			paramName = cfg.getCtxParamName(uniquePos)
+		}
+		for _, pos := range cfg.collectCtxEscapes(f, ctxValues) {
+			p := cfg.getFset(f).Position(pos)
+			m := make(map[string]string)
+			m["file"] = cfg.relPath(p.Filename)
+			m["line"] = strconv.Itoa(p.Line)
+			m["fn"] = f.String()
+			m["param"] = paramName
+			escapes = append(escapes, m)
+		}
+	}
+	sort.Slice(escapes, func(i, j int) bool {
+		if escapes[i]["file"] != escapes[j]["file"] {
+			return escapes[i]["file"] < escapes[j]["file"]
+		}
+		li, _ := strconv.Atoi(escapes[i]["line"])
+		lj, _ := strconv.Atoi(escapes[j]["line"])
+		return li < lj
+	})
+
+	if cfg.debugLevel > 0 && len(escapes) > 0 {
+		cfg.debugData.CtxEscapes = escapes
+		fmt.Println("CONTEXT ESCAPING TO GOROUTINES:")
+		for _, e := range escapes {
+			fmt.Println(e["fn"] + ": context parameter " + e["param"] + " referenced by goroutine")
+			fmt.Println(e["file"] + " (line " + e["line"] + ")")
+		}
+	}
+}
+
+// collectCtxEscapes returns positions of go statements (in a given
+// function and in anonymous functions nested in it) starting
+// anonymous functions that reference context - either by capturing
+// or receiving one of the given values representing context
+// parameter, or by passing context to functions they call.
+func (cfg *analyzerConfig) collectCtxEscapes(fn *ssa.Function, ctxValues map[ssa.Value]bool) []token.Pos {
+	var escapes []token.Pos
+	for _, b := range fn.Blocks {
+		for _, instr := range b.Instrs {
+			switch instr := instr.(type) {
+			case *ssa.Store:
+				// context parameter captured by a closure is stored
+				// in a local variable
+				if _, ok := instr.Addr.(*ssa.Alloc); ok && ctxValues[instr.Val] {
+					ctxValues[instr.Addr] = true
+				}
+			case *ssa.UnOp:
+				if instr.Op == token.MUL && ctxValues[instr.X] {
+					ctxValues[instr] = true
+				}
+			case *ssa.MakeClosure:
+				// context captured by a closure is represented by
+				// the closure's free variable
+				anon := instr.Fn.(*ssa.Function)
+				for i, binding := range instr.Bindings {
+					if ctxValues[binding] {
+						ctxValues[anon.FreeVars[i]] = true
+					}
+				}
+			case *ssa.Go:
+				var anon *ssa.Function
+				var values []ssa.Value
+				if mc, ok := instr.Call.Value.(*ssa.MakeClosure); ok {
+					anon = mc.Fn.(*ssa.Function)
+					values = append(values, mc.Bindings...)
+				} else if f, ok := instr.Call.Value.(*ssa.Function); ok && f.Parent() != nil {
+					anon = f
+				}
+				if anon == nil {
+					// not an anonymous function
+					continue
+				}
+				values = append(values, instr.Call.Args...)
+				referenced := cfg.passesCtx(anon)
+				for _, v := range values {
+					if ctxValues[v] {
+						referenced = true
+					}
+				}
+				if referenced {
+					escapes = append(escapes, instr.Pos())
+				}
+			}
+		}
+	}
+	for _, anon := range fn.AnonFuncs {
+		escapes = append(escapes, cfg.collectCtxEscapes(anon, ctxValues)...)
+	}
+	return escapes
+}
+
+// passesCtx determines if a given function (or an anonymous function
+// nested in it) contains call sites that will receive context
+// argument.
+func (cfg *analyzerConfig) passesCtx(fn *ssa.Function) bool {
+	for _, b := range fn.Blocks {
+		for _, instr := range b.Instrs {
+			site, ok := instr.(ssa.CallInstruction)
+			if !ok {
+				continue
+			}
+			if callReplacement, exists := cfg.callSites[cfg.getUniquePosSSAFn(fn, site.Common().Pos())]; exists && !cfg.isNilCallReplacement(callReplacement) {
+				return true
+			}
+		}
+	}
+	for _, anon := range fn.AnonFuncs {
+		if cfg.passesCtx(anon) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,310 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package propagate
+
+import (
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"golang.org/x/tools/go/callgraph/cha"
+	"golang.org/x/tools/go/ssa"
+	"golang.org/x/tools/go/ssa/ssautil"
+	"testing"
+)
+
+// getActualCallArgSrc defines calls to the same method made in all
+// possible ways as well as a call to a free function.
+const getActualCallArgSrc = `
+package p
+
+type T struct{}
+
+func (T) M(a int, f func()) {}
+
+type I interface {
+	M(a int, f func())
+}
+
+func free(a int, f func()) {}
+
+func g() {}
+
+func calls(t T, i I) {
+	t.M(1, g)
+	i.M(2, g)
+	T.M(t, 3, g)
+	mv := t.M
+	mv(4, g)
+	free(5, g)
+}
+`
+
+// buildTestSSA builds SSA representation of a single-file package.
+func buildTestSSA(t *testing.T, src string) *ssa.Package {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "p.go", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkg, _, err := ssautil.BuildPackage(&types.Config{Importer: importer.Default()}, fset, types.NewPackage("p", "p"), []*ast.File{f}, ssa.SanityCheckFunctions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pkg
+}
+
+func TestGetActualCallArg(t *testing.T) {
+	pkg := buildTestSSA(t, getActualCallArgSrc)
+	// all calls have the same declared parameters
+	params := pkg.Func("free").Signature.Params()
+	g := pkg.Func("g")
+
+	calls := 0
+	for _, b := range pkg.Func("calls").Blocks {
+		for _, inst := range b.Instrs {
+			c, ok := inst.(*ssa.Call)
+			if !ok {
+				continue
+			}
+			calls++
+			common := c.Common()
+			a, ok := getActualCallArg(common, params, 0).(*ssa.Const)
+			if !ok || a.Int64() != int64(calls) {
+				t.Errorf("call %d (%s): wrong first argument %v", calls, common, getActualCallArg(common, params, 0))
+			}
+			if f := getActualCallArg(common, params, 1); f != g {
+				t.Errorf("call %d (%s): wrong second argument %v", calls, common, f)
+			}
+			if arg := getActualCallArg(common, params, 2); arg != nil {
+				t.Errorf("call %d (%s): unexpected third argument %v", calls, common, arg)
+			}
+		}
+	}
+	if calls != 5 {
+		t.Errorf("expected 5 calls but found %d", calls)
+	}
+}
+
+// getTypeAssertNamedSrc defines calls through values obtained from
+// type assertions and type switches as well as a call through a value
+// that is not.
+const getTypeAssertNamedSrc = `
+package p
+
+type F func(a int)
+
+func calls(v interface{}, f F) {
+	v.(F)(1)
+	if h, ok := v.(F); ok {
+		h(2)
+	}
+	switch h := v.(type) {
+	case F:
+		h(3)
+	}
+	f(4)
+}
+`
+
+func TestGetTypeAssertNamed(t *testing.T) {
+	pkg := buildTestSSA(t, getTypeAssertNamedSrc)
+	named := pkg.Type("F").Type().(*types.Named)
+
+	calls := 0
+	for _, b := range pkg.Func("calls").Blocks {
+		for _, inst := range b.Instrs {
+			c, ok := inst.(*ssa.Call)
+			if !ok {
+				continue
+			}
+			calls++
+			common := c.Common()
+			a := common.Args[0].(*ssa.Const)
+			n := getTypeAssertNamed(common.Value)
+			if a.Int64() < 4 && n != named {
+				t.Errorf("call %d (%s): expected type assertion to %s but found %v", a.Int64(), common, named, n)
+			} else if a.Int64() == 4 && n != nil {
+				t.Errorf("call %d (%s): unexpected type assertion to %s", a.Int64(), common, n)
+			}
+		}
+	}
+	if calls != 4 {
+		t.Errorf("expected 4 calls but found %d", calls)
+	}
+}
+
+func TestGetUniquePosSSAFn(t *testing.T) {
+	cfg := &analyzerConfig{config: &config{syntheticIDs: make(map[*ssa.Function]int)}}
+	// package initializers are synthetic and have no position
+	init1 := buildTestSSA(t, "package p\nfunc f() {}").Func("init")
+	init2 := buildTestSSA(t, "package p\nfunc f() {}").Func("init")
+	if init1.Pos().IsValid() || init2.Pos().IsValid() {
+		t.Fatal("expected package initializers with no position")
+	}
+	pos1 := cfg.getUniquePosSSAFn(init1, init1.Pos())
+	pos2 := cfg.getUniquePosSSAFn(init2, init2.Pos())
+	if pos1 == pos2 {
+		t.Errorf("synthetic functions share the same position %v", pos1)
+	}
+	if pos := cfg.getUniquePosSSAFn(init1, init1.Pos()); pos != pos1 {
+		t.Errorf("synthetic function has different positions %v and %v", pos1, pos)
+	}
+	f := buildTestSSA(t, "package p\nfunc f() {}").Func("f")
+	if pos := cfg.getUniquePosSSAFn(f, f.Pos()); pos.syntheticID != 0 {
+		t.Errorf("function with known position has synthetic identifier %d", pos.syntheticID)
+	}
+}
+
+// isFirstParamContextSrc defines functions whose first parameter is
+// (or is not) of the context type as well as functions without any
+// parameters.
+const isFirstParamContextSrc = `
+package p
+
+type Context interface {
+	Done()
+}
+
+type T struct{}
+
+func (T) recvOnly() {}
+
+func none() {}
+
+func variadic(ctxs ...Context) {}
+
+func first(ctx Context, a int) {}
+
+func second(a int, ctx Context) {}
+`
+
+func TestIsFirstParamContext(t *testing.T) {
+	pkg := buildTestSSA(t, isFirstParamContextSrc)
+	cfg := &analyzerConfig{config: &config{jsonConfig: &jsonConfig{CtxParamName: "ctx", CtxParamType: "Context"}}}
+	cfg.ctxParamTypeWithPkgPathName = getQualifiedType("Context", "p", "p")
+	recvOnly := pkg.Type("T").Type().(*types.Named).Method(0).Type().(*types.Signature)
+
+	tests := []struct {
+		name     string
+		sig      *types.Signature
+		isCtx    bool
+		ctxName  string
+		ctxType  string
+		validPos bool
+	}{
+		{"nil", nil, false, "ctx", "", false},
+		{"empty", types.NewSignatureType(nil, nil, nil, types.NewTuple(), nil, false), false, "ctx", "", false},
+		{"receiver-only", recvOnly, false, "ctx", "", false},
+		{"no-params", pkg.Func("none").Signature, false, "ctx", "", false},
+		{"variadic-only", pkg.Func("variadic").Signature, false, "ctx", "[]p.Context", false},
+		{"context-first", pkg.Func("first").Signature, true, "ctx", "Context", true},
+		{"context-second", pkg.Func("second").Signature, false, "ctx", "int", false},
+	}
+	for _, test := range tests {
+		isCtx, pos, ctxName, ctxType, custom := cfg.isFirstParamContext(test.sig)
+		if isCtx != test.isCtx || pos.IsValid() != test.validPos || ctxName != test.ctxName || ctxType != test.ctxType || custom {
+			t.Errorf("%s: unexpected result (%v, %v, %q, %q, %v)", test.name, isCtx, pos, ctxName, ctxType, custom)
+		}
+	}
+}
+
+// isOutOfScopeAnonCallSrc defines calls to anonymous functions (in a
+// loop, in a deferred closure and through a parameter of function
+// type) and to a bound method closure created for a method value.
+const isOutOfScopeAnonCallSrc = `
+package p
+
+type T struct{}
+
+func (T) M() {}
+
+func apply(f func()) {
+	f()
+}
+
+func loop() {
+	for i := 0; i < 2; i++ {
+		f := func() {}
+		f()
+		apply(f)
+	}
+}
+
+func methodValue(t T) {
+	mv := t.M
+	mv()
+	apply(mv)
+}
+
+func deferred() {
+	defer func() {
+		g := func() {}
+		g()
+	}()
+}
+`
+
+func TestIsOutOfScopeAnonCall(t *testing.T) {
+	pkg := buildTestSSA(t, isOutOfScopeAnonCallSrc)
+	graph := cha.CallGraph(pkg.Prog)
+
+	// edges (described as "caller -> callee") mapped to whether they
+	// are expected to be skipped
+	expected := map[string]bool{
+		"p.loop -> p.loop$1":             false,
+		"p.apply -> p.loop$1":            true,
+		"p.methodValue -> (p.T).M$bound": false,
+		"p.apply -> (p.T).M$bound":       false,
+		"p.deferred -> p.deferred$1":     false,
+		"p.deferred$1 -> p.deferred$1$1": false,
+		"p.apply -> p.deferred$1":        true,
+		"p.apply -> p.deferred$1$1":      true,
+		"p.apply -> p.loop":              false,
+	}
+	found := make(map[string]bool)
+	for fn, n := range graph.Nodes {
+		if fn == nil || fn.Pkg != pkg {
+			continue
+		}
+		for _, e := range n.Out {
+			desc := e.Caller.Func.String() + " -> " + e.Callee.Func.String()
+			skip, exists := expected[desc]
+			if !exists {
+				continue
+			}
+			found[desc] = true
+			if isOutOfScopeAnonCall(e) != skip {
+				t.Errorf("%s: expected skipped to be %v", desc, skip)
+			}
+		}
+	}
+	for desc := range expected {
+		if !found[desc] {
+			t.Errorf("%s: edge not found", desc)
+		}
+	}
+}
+
+func TestGetLinknamePkgPath(t *testing.T) {
+	tests := map[string]string{
+		"runtime.nanotime":           "runtime",
+		"runtime/debug.setGCPercent": "runtime/debug",
+		"example.com/pkg.(*T).M":     "example.com/pkg",
+		"example.com/pkg.v2/sub.F":   "example.com/pkg.v2/sub",
+		"noDot":                      "noDot",
+	}
+	for target, expected := range tests {
+		if pkgPath := getLinknamePkgPath(target); pkgPath != expected {
+			t.Errorf("%s: expected package path %q but found %q", target, expected, pkgPath)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package propagate
+
+import (
+	"bytes"
+	"go/format"
+	"go/types"
+	"golang.org/x/tools/go/packages"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// assertionsFileName is the name of the generated file containing
+// interface assertions.
+const assertionsFileName = "context_propagated.go"
+
+// pkgAssertions describes content of a generated file containing
+// interface assertions for a single package.
+type pkgAssertions struct {
+	// pkgName is the name of the package.
+	pkgName string
+	// imports are packages imported by the file (path -> name).
+	imports map[string]string
+	// assertions are interface assertions (as source code).
+	assertions map[string]bool
+}
+
+// generateAssertions generates a file in each package defining types
+// whose methods have been modified to take context parameter. The file
+// contains compile-time assertions that these types still implement
+// modified interfaces, so that mismatches between modified methods and
+// modified interfaces are caught when compiling transformed code.
+func (cfg *config) generateAssertions() {
+	if !cfg.opts.GenerateAssertions {
+		return
+	}
+	files := make(map[string]*pkgAssertions)   // file path -> assertions
+	pkgs := make(map[string]*packages.Package) // file path -> package
+	for _, p := range cfg.initial {
+		if len(p.GoFiles) == 0 || strings.HasSuffix(p.Name, "_test") {
+			// assertions cannot be added to external test packages
+			continue
+		}
+		filePath := filepath.Join(filepath.Dir(p.GoFiles[0]), assertionsFileName)
+		scope := p.Types.Scope()
+		for _, name := range scope.Names() {
+			obj, ok := scope.Lookup(name).(*types.TypeName)
+			if !ok || obj.IsAlias() || strings.HasSuffix(p.Fset.Position(obj.Pos()).Filename, "_test.go") {
+				// type defined in test files (of the test variant of
+				// the package) cannot be referred to in a
+				// non-test file
+				continue
+			}
+			named, ok := obj.Type().(*types.Named)
+			if !ok || named.TypeParams().Len() > 0 || types.IsInterface(named) {
+				continue
+			}
+			for iface, methods := range cfg.ifaceModified {
+				ifaceName := cfg.getIfaceName(iface)
+				if ifaceName == nil || (!ifaceName.Exported() && ifaceName.Pkg().Path() != p.PkgPath) {
+					// interface cannot be referred to in the package
+					continue
+				}
+				if !cfg.implementsModified(named, iface, methods) {
+					continue
+				}
+				pa, exists := files[filePath]
+				if !exists {
+					pa = &pkgAssertions{p.Name, make(map[string]string), make(map[string]bool)}
+					files[filePath] = pa
+					pkgs[filePath] = p
+				}
+				qualifier := func(pkg *types.Package) string {
+					if pkg.Path() == p.PkgPath {
+						return ""
+					}
+					pa.imports[pkg.Path()] = pkg.Name()
+					return pkg.Name()
+				}
+				pa.assertions["_ "+types.TypeString(ifaceName.Type(), qualifier)+" = (*"+obj.Name()+")(nil)"] = true
+			}
+		}
+	}
+	for filePath, pa := range files {
+		cfg.addNewFile(pkgs[filePath], filePath, genAssertions(pa))
+	}
+}
+
+// getIfaceName returns type name of an interface (or nil if the
+// interface is not named).
+func (cfg *config) getIfaceName(iface *types.Interface) *types.TypeName {
+	pkg, exists := cfg.ifaces[iface]
+	if !exists {
+		return nil
+	}
+	for _, name := range pkg.Scope().Names() {
+		if obj, ok := pkg.Scope().Lookup(name).(*types.TypeName); ok && !obj.IsAlias() && obj.Type().Underlying() == iface {
+			return obj
+		}
+	}
+	return nil
+}
+
+// implementsModified checks if a named type implements a given
+// interface and if any of its methods implementing given (modified)
+// interface methods has been modified to take context parameter.
+func (cfg *config) implementsModified(named *types.Named, iface *types.Interface, methods map[string]bool) bool {
+	ptr := types.NewPointer(named)
+	if !cfg.implementsIface(ptr, iface) {
+		return false
+	}
+	for name := range methods {
+		obj, _, _ := types.LookupFieldOrMethod(ptr, false, named.Obj().Pkg(), name)
+		fn, ok := obj.(*types.Func)
+		if !ok {
+			continue
+		}
+		if fnType, exists := cfg.fnVisited[cfg.getUniquePosPkg(fn.Pkg(), fn.Pos())]; exists && fnType == regularFn {
+			return true
+		}
+	}
+	return false
+}
+
+// genAssertions returns content of a file containing interface
+// assertions for a single package.
+func genAssertions(pa *pkgAssertions) []byte {
+	var src bytes.Buffer
+	src.WriteString("// Code generated by go-context-propagate. DO NOT EDIT.\n\n")
+	src.WriteString("package " + pa.pkgName + "\n\n")
+	if len(pa.imports) > 0 {
+		paths := make([]string, 0, len(pa.imports))
+		for p := range pa.imports {
+			paths = append(paths, p)
+		}
+		sort.Strings(paths)
+		src.WriteString("import (\n")
+		for _, p := range paths {
+			if pa.imports[p] != path.Base(p) {
+				src.WriteString(pa.imports[p] + " ")
+			}
+			src.WriteString(strconv.Quote(p) + "\n")
+		}
+		src.WriteString(")\n\n")
+	}
+	assertions := make([]string, 0, len(pa.assertions))
+	for a := range pa.assertions {
+		assertions = append(assertions, a)
+	}
+	sort.Strings(assertions)
+	src.WriteString("// types whose methods have been modified to take context parameter\n")
+	src.WriteString("// must still implement modified interfaces\n")
+	src.WriteString("var (\n")
+	for _, a := range assertions {
+		src.WriteString(a + "\n")
+	}
+	src.WriteString(")\n")
+
+	formatted, err := format.Source(src.Bytes())
+	if err != nil {
+		fatal("error formatting generated interface assertions: " + err.Error())
+	}
+	return formatted
+}
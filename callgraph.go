@@ -0,0 +1,444 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package propagate
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/token"
+	cg "golang.org/x/tools/go/callgraph"
+	"golang.org/x/tools/go/callgraph/cha"
+	"golang.org/x/tools/go/callgraph/rta"
+	"golang.org/x/tools/go/ssa"
+	"golang.org/x/tools/go/ssa/ssautil"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+// callGraphComparison represents a comparison report of call graphs
+// constructed using RTA and CHA algorithms.
+type callGraphComparison struct {
+	// RTAEdges is the total number of edges in the RTA call graph.
+	RTAEdges int
+	// CHAEdges is the total number of edges in the CHA call graph.
+	CHAEdges int
+	// CHAOnly are edges present in the CHA call graph but not in the
+	// RTA call graph (CHA overapproximation).
+	CHAOnly []string
+	// RTAOnly are edges present in the RTA call graph but not in the
+	// CHA call graph (should never happen as CHA is more
+	// conservative than RTA).
+	RTAOnly []string
+}
+
+// compareCallGraphs writes a report comparing call graphs for a given
+// program constructed using RTA and CHA algorithms. The call graph
+// already constructed for the analysis is reused for the algorithm
+// that built it and only the other one is constructed anew.
+func (cfg *config) compareCallGraphs(prog *ssa.Program, graph *cg.Graph) {
+	if cfg.opts.CallGraphComparePath == "" {
+		return
+	}
+	rtaGraph, chaGraph := graph, graph
+	if cfgType != cfgRTA {
+		var roots []*ssa.Function
+		for f, _ := range ssautil.AllFunctions(prog) {
+			roots = append(roots, f)
+		}
+		res := rta.Analyze(roots, true)
+		if res == nil {
+			fatal("error building RTA callgraph")
+		}
+		rtaGraph = res.CallGraph
+	}
+	if cfgType != cfgCHA {
+		chaGraph = cha.CallGraph(prog)
+	}
+	rtaEdges := cfg.getEdges(prog, rtaGraph)
+	chaEdges := cfg.getEdges(prog, chaGraph)
+
+	report := callGraphComparison{
+		RTAEdges: len(rtaEdges),
+		CHAEdges: len(chaEdges),
+		CHAOnly:  []string{},
+		RTAOnly:  []string{},
+	}
+	for e := range chaEdges {
+		if !rtaEdges[e] {
+			report.CHAOnly = append(report.CHAOnly, e)
+		}
+	}
+	for e := range rtaEdges {
+		if !chaEdges[e] {
+			report.RTAOnly = append(report.RTAOnly, e)
+		}
+	}
+	sort.Strings(report.CHAOnly)
+	sort.Strings(report.RTAOnly)
+
+	reportData, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		fatal("error marshalling call graph comparison report")
+	}
+	if err := ioutil.WriteFile(cfg.opts.CallGraphComparePath, reportData, 0644); err != nil {
+		fatal("error writing call graph comparison report " + cfg.opts.CallGraphComparePath)
+	}
+}
+
+// getEdges returns string representations of all edges of a given
+// call graph between non-synthetic functions (so that call graphs
+// constructed using different algorithms can be compared).
+func (cfg *config) getEdges(prog *ssa.Program, graph *cg.Graph) map[string]bool {
+	graph.DeleteSyntheticNodes()
+	edges := make(map[string]bool)
+	cg.GraphVisitEdges(graph, func(e *cg.Edge) error {
+		if e.Caller.Func.Synthetic != "" || e.Callee.Func.Synthetic != "" {
+			// synthetic functions (e.g. bound method closures)
+			// surviving node removal depend on the order in which
+			// they were created by different algorithms
+			return nil
+		}
+		edge := e.Caller.Func.String() + " -> " + e.Callee.Func.String()
+		if e.Site != nil && e.Site.Pos().IsValid() {
+			pos := prog.Fset.Position(e.Site.Pos())
+			pos.Filename = cfg.relPath(pos.Filename)
+			edge += " at " + pos.String()
+		}
+		edges[edge] = true
+		return nil
+	})
+	return edges
+}
+
+// importedCallGraph is a call graph computed externally (e.g. using a
+// more precise analysis) to be used instead of the locally built one.
+type importedCallGraph struct {
+	// Nodes are functions of the call graph.
+	Nodes []importedNode
+	// Edges are calls between functions of the call graph.
+	Edges []importedEdge
+}
+
+// importedNode represents a function of the imported call graph.
+type importedNode struct {
+	// ID identifies the node within the imported call graph.
+	ID int
+	// PkgPath is the path of the package where the function is
+	// defined.
+	PkgPath string
+	// Name is the name of the function relative to its package (e.g.
+	// "Foo", "(*T).Foo" or "Foo$1" for anonymous functions).
+	Name string
+	// Pos is the position of the function (of its name or of the
+	// "func" keyword for anonymous functions).
+	Pos importedPos
+}
+
+// importedEdge represents a call of the imported call graph.
+type importedEdge struct {
+	// Caller is the ID of the calling function's node.
+	Caller int
+	// Callee is the ID of the called function's node.
+	Callee int
+	// Pos is the position of the call site (of the call's opening
+	// parenthesis).
+	Pos importedPos
+}
+
+// importedPos represents a position in the imported call graph.
+type importedPos struct {
+	// File is the path of the file (relative to the file prefix or
+	// absolute).
+	File   string
+	Line   int
+	Column int
+}
+
+// importCallGraph replaces edges of the call graph with edges of the
+// imported call graph at call sites present in both. Imported nodes
+// are matched with the program's functions by position, and call
+// sites with no matching imported edges keep the edges of the locally
+// built call graph.
+func (cfg *analyzerConfig) importCallGraph() {
+	if cfg.opts.CallGraphPath == "" {
+		return
+	}
+	data, err := ioutil.ReadFile(cfg.opts.CallGraphPath)
+	if err != nil {
+		fatal("error reading call graph file " + cfg.opts.CallGraphPath)
+	}
+	var imported importedCallGraph
+	if err := json.Unmarshal(data, &imported); err != nil {
+		fatal("error unmarshalling call graph file " + cfg.opts.CallGraphPath + ": " + err.Error())
+	}
+
+	// index the program's functions by position
+	fns := make(map[importedPos]*ssa.Function)
+	for f := range ssautil.AllFunctions(cfg.prog) {
+		if f.Synthetic != "" || f.Origin() != nil || f.Pkg == nil || !f.Pos().IsValid() {
+			// function with no source code of its own
+			continue
+		}
+		fns[cfg.getImportedPos(cfg.getFset(f), f.Pos())] = f
+	}
+
+	matchedNodes := make(map[int]*ssa.Function)
+	nameMismatches := 0
+	for _, n := range imported.Nodes {
+		n.Pos.File = filepath.ToSlash(n.Pos.File)
+		f, exists := fns[n.Pos]
+		if !exists {
+			continue
+		}
+		if f.Pkg.Pkg.Path() != n.PkgPath || f.RelString(f.Pkg.Pkg) != n.Name {
+			// function at a given position is not the same function
+			// (e.g. imported call graph computed for different code)
+			nameMismatches++
+			continue
+		}
+		matchedNodes[n.ID] = f
+	}
+
+	// group matched edges by call sites
+	siteEdges := make(map[ssa.CallInstruction][]*ssa.Function)
+	var sites []ssa.CallInstruction
+	matchedEdges := 0
+	for _, e := range imported.Edges {
+		caller, callee := matchedNodes[e.Caller], matchedNodes[e.Callee]
+		if caller == nil || callee == nil {
+			continue
+		}
+		e.Pos.File = filepath.ToSlash(e.Pos.File)
+		site := cfg.findCallSite(caller, e.Pos)
+		if site == nil {
+			continue
+		}
+		matchedEdges++
+		if _, exists := siteEdges[site]; !exists {
+			sites = append(sites, site)
+		}
+		siteEdges[site] = append(siteEdges[site], callee)
+	}
+	for _, site := range sites {
+		caller := cfg.graph.CreateNode(site.Parent())
+		deleteSiteEdges(caller, site)
+		for _, callee := range siteEdges[site] {
+			cg.AddEdge(caller, site, cfg.graph.CreateNode(callee))
+		}
+	}
+
+	if cfg.debugLevel > 0 {
+		fmt.Println("IMPORTED CALL GRAPH NODES: " + strconv.Itoa(len(imported.Nodes)) + " MATCHED: " + strconv.Itoa(len(matchedNodes)) + " UNMATCHED: " + strconv.Itoa(len(imported.Nodes)-len(matchedNodes)) + " (NAME MISMATCHES: " + strconv.Itoa(nameMismatches) + ")")
+		fmt.Println("IMPORTED CALL GRAPH EDGES: " + strconv.Itoa(len(imported.Edges)) + " MATCHED: " + strconv.Itoa(matchedEdges) + " UNMATCHED: " + strconv.Itoa(len(imported.Edges)-matchedEdges))
+		fmt.Println("CALL SITES USING IMPORTED CALL GRAPH: " + strconv.Itoa(len(sites)))
+	}
+}
+
+// getImportedPos returns representation of a given position used to
+// match positions in the imported call graph.
+func (cfg *analyzerConfig) getImportedPos(fset *token.FileSet, pos token.Pos) importedPos {
+	p := fset.Position(pos)
+	return importedPos{cfg.relPath(p.Filename), p.Line, p.Column}
+}
+
+// findCallSite returns call site at a given position in a given
+// function (nil if there is no such call site).
+func (cfg *analyzerConfig) findCallSite(fn *ssa.Function, pos importedPos) ssa.CallInstruction {
+	for _, b := range fn.Blocks {
+		for _, instr := range b.Instrs {
+			site, ok := instr.(ssa.CallInstruction)
+			if ok && site.Common().Pos().IsValid() && cfg.getImportedPos(cfg.getFset(fn), site.Common().Pos()) == pos {
+				return site
+			}
+		}
+	}
+	return nil
+}
+
+// deleteSiteEdges removes all edges of a given call site from the
+// call graph.
+func deleteSiteEdges(caller *cg.Node, site ssa.CallInstruction) {
+	var out []*cg.Edge
+	for _, e := range caller.Out {
+		if e.Site != site {
+			out = append(out, e)
+			continue
+		}
+		var in []*cg.Edge
+		for _, calleeIn := range e.Callee.In {
+			if calleeIn != e {
+				in = append(in, calleeIn)
+			}
+		}
+		e.Callee.In = in
+	}
+	caller.Out = out
+}
+
+// exportSSA returns the SSA representation of given packages (except
+// for external ones) to be written along with call graph edges once
+// the call graph is constructed (nil if SSA is not to be exported).
+func (cfg *config) exportSSA(prog *ssa.Program, pkgs []*ssa.Package) *bytes.Buffer {
+	if cfg.opts.ExportSSAPath == "" {
+		return nil
+	}
+	fns := make(map[*ssa.Package][]*ssa.Function)
+	for f := range ssautil.AllFunctions(prog) {
+		if f.Pkg != nil {
+			fns[f.Pkg] = append(fns[f.Pkg], f)
+		}
+	}
+	var buf bytes.Buffer
+	for _, p := range pkgs {
+		if p == nil || cfg.isPkgExternal(p.Pkg.Path()) {
+			continue
+		}
+		ssa.WritePackage(&buf, p)
+		pkgFns := fns[p]
+		sort.Slice(pkgFns, func(i, j int) bool {
+			return pkgFns[i].String() < pkgFns[j].String()
+		})
+		for _, f := range pkgFns {
+			ssa.WriteFunction(&buf, f)
+			buf.WriteString("\n")
+		}
+	}
+	return &buf
+}
+
+// writeSSAExport writes given SSA representation of the analyzed
+// packages followed by edges of the call graph used in the analysis.
+func (cfg *analyzerConfig) writeSSAExport(buf *bytes.Buffer) {
+	if buf == nil {
+		return
+	}
+	var edges []string
+	for e := range cfg.getEdges(cfg.prog, cfg.graph) {
+		edges = append(edges, e)
+	}
+	sort.Strings(edges)
+	buf.WriteString("CALL GRAPH EDGES:\n")
+	for _, e := range edges {
+		buf.WriteString(e + "\n")
+	}
+	if err := ioutil.WriteFile(cfg.opts.ExportSSAPath, buf.Bytes(), 0644); err != nil {
+		fatal("error writing SSA export " + cfg.opts.ExportSSAPath)
+	}
+}
+
+// affectedTests represents a list of test functions whose behavior
+// may change as a result of context propagation.
+type affectedTests struct {
+	// Tests are test functions (transitively) calling functions
+	// whose call sites have been modified.
+	Tests []affectedTest
+}
+
+// affectedTest describes a single test function.
+type affectedTest struct {
+	// PkgPath is the path of the package the test belongs to.
+	PkgPath string
+	// Name is the name of the test function.
+	Name string
+	// File is the path of the file where the test is defined.
+	File string
+	// Line is the line where the test is defined.
+	Line int
+}
+
+// writeAffectedTests writes a report listing test functions that
+// (transitively) call functions whose call sites have been modified
+// to pass context.
+func (cfg *analyzerConfig) writeAffectedTests() {
+	if cfg.opts.AffectedTestsPath == "" {
+		return
+	}
+	// start with functions containing modified call sites and
+	// follow call graph edges to their callers
+	var workList []*cg.Node
+	visited := make(map[*cg.Node]bool)
+	for _, n := range cfg.graph.Nodes {
+		for _, out := range n.Out {
+			if !out.Site.Common().Pos().IsValid() || cfg.callSites[cfg.getUniquePosCallSite(out)] == nil {
+				continue
+			}
+			visited[n] = true
+			workList = append(workList, n)
+			break
+		}
+	}
+	analyzedPkgs := make(map[string]bool)
+	for _, p := range cfg.initial {
+		analyzedPkgs[p.PkgPath] = true
+	}
+	report := affectedTests{Tests: []affectedTest{}}
+	testsFound := make(map[affectedTest]bool)
+	for len(workList) > 0 {
+		n := workList[len(workList)-1]
+		workList = workList[:len(workList)-1]
+		if isTestFunction(n.Func) && !cfg.isPkgExternal(getFnPkgPath(n.Func)) {
+			pos := cfg.getFset(n.Func).Position(n.Func.Pos())
+			test := affectedTest{PkgPath: getFnPkgPath(n.Func), Name: n.Func.Name(), File: cfg.relPath(pos.Filename), Line: pos.Line}
+			// the same test may be represented by multiple functions
+			// in package variants
+			if !testsFound[test] {
+				testsFound[test] = true
+				report.Tests = append(report.Tests, test)
+			}
+		}
+		callers := make([]*cg.Node, 0, len(n.In))
+		for _, in := range n.In {
+			if !analyzedPkgs[getFnPkgPath(in.Caller.Func)] {
+				// functions in other packages (e.g. testing.T.Run)
+				// may call any function passed to them and would
+				// make all their callers affected
+				continue
+			}
+			callers = append(callers, in.Caller)
+		}
+		if parent := n.Func.Parent(); parent != nil && cfg.graph.Nodes[parent] != nil {
+			// anonymous function is run on behalf of the function
+			// defining it
+			callers = append(callers, cfg.graph.Nodes[parent])
+		}
+		for _, caller := range callers {
+			if !visited[caller] {
+				visited[caller] = true
+				workList = append(workList, caller)
+			}
+		}
+	}
+	sort.Slice(report.Tests, func(i, j int) bool {
+		if report.Tests[i].File != report.Tests[j].File {
+			return report.Tests[i].File < report.Tests[j].File
+		}
+		return report.Tests[i].Line < report.Tests[j].Line
+	})
+
+	reportData, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		fatal("error marshalling affected tests report")
+	}
+	if err := ioutil.WriteFile(cfg.opts.AffectedTestsPath, reportData, 0644); err != nil {
+		fatal("error writing affected tests report " + cfg.opts.AffectedTestsPath)
+	}
+}
+
+// isTestFunction determines if a given function is a test function
+// run by the test harness (Test*(*testing.T)).
+func isTestFunction(fn *ssa.Function) bool {
+	if fn.Signature.Recv() != nil || fn.Parent() != nil || !isTestingInitOrMainFunction(fn.Name(), fn.Signature) {
+		return false
+	}
+	return fn.Signature.Params().At(0).Type().String() == testingTypeT
+}
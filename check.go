@@ -0,0 +1,196 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package propagate
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+	"log"
+	"sort"
+
+	cg "golang.org/x/tools/go/callgraph"
+)
+
+// Finding describes a problem found when checking code whose context
+// propagation has already been completed (see Check).
+type Finding struct {
+	// Pos is the position of the problem in the source code (file
+	// path is relative to the file prefix if it is known).
+	Pos token.Position
+	// Msg describes the problem.
+	Msg string
+}
+
+// String returns position of the problem followed by its description.
+func (f Finding) String() string {
+	return f.Pos.String() + ": " + f.Msg
+}
+
+// Check audits code whose context propagation has already been
+// completed and returns problems that would require it to be
+// propagated again: calls to "leaf" functions not taking context,
+// uses of the artificial context expression outside of allowed files
+// and uses of the artificial context expression in functions that
+// take context parameter.
+func Check(configFilePath string, srcPaths []string, opts *Options) []Finding {
+	res, err := tryCheck(configFilePath, srcPaths, opts)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return res
+}
+
+// tryCheck is the same as Check but it returns an error instead of
+// terminating the program if checking fails.
+func tryCheck(configFilePath string, srcPaths []string, opts *Options) (res []Finding, err error) {
+	cfg := initialize(configFilePath, 0, opts)
+	defer cfg.handleAbort("", &err)
+
+	analyzer := cfg.newAnalyzer(srcPaths, nil)
+	analyzer.collectInterfacesAndThirdPartyEmbeds()
+	res = append(analyzer.checkLeafCalls(), analyzer.checkArtificialCtx()...)
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Pos.Filename != res[j].Pos.Filename {
+			return res[i].Pos.Filename < res[j].Pos.Filename
+		}
+		if res[i].Pos.Line != res[j].Pos.Line {
+			return res[i].Pos.Line < res[j].Pos.Line
+		}
+		return res[i].Pos.Column < res[j].Pos.Column
+	})
+	return res, nil
+}
+
+// newFinding creates a finding at a given position.
+func (cfg *config) newFinding(fset *token.FileSet, pos token.Pos, msg string) Finding {
+	p := fset.Position(pos)
+	p.Filename = cfg.relPath(p.Filename)
+	return Finding{p, msg}
+}
+
+// checkLeafCalls finds calls to "leaf" functions that do not take
+// context (e.g. because they are to be replaced by functions with
+// different names).
+func (cfg *analyzerConfig) checkLeafCalls() []Finding {
+	var res []Finding
+	found := make(map[uniquePosInfo]bool)
+	cfg.forEachLeafCall(func(in *cg.Edge, libFnName string, recv string, callReplacement *replacementInfo) {
+		if cfg.isPkgExternal(getFnPkgPath(in.Caller.Func)) {
+			return
+		}
+		if isParamContext, _, _, _, _ := cfg.isFirstParamContext(in.Callee.Func.Signature); isParamContext {
+			// leaf function already takes context
+			return
+		}
+		uniquePos := cfg.getUniquePosCallSite(in)
+		if found[uniquePos] {
+			return
+		}
+		found[uniquePos] = true
+		msg := "call to " + libFnName + " that does not take context"
+		if callReplacement.newName != "" {
+			msg += " (" + callReplacement.newName + " should be called instead)"
+		}
+		res = append(res, cfg.newFinding(cfg.getFset(in.Caller.Func), in.Pos(), msg))
+	})
+	return res
+}
+
+// checkArtificialCtx finds uses of the artificial context expression
+// outside of allowed files and in functions that take context
+// parameter.
+func (cfg *analyzerConfig) checkArtificialCtx() []Finding {
+	var res []Finding
+	visitedFiles := make(map[string]bool)
+	for _, p := range cfg.initial {
+		if cfg.isPkgExternal(p.PkgPath) {
+			continue
+		}
+		transformer := &transformerConfig{config: cfg.config, currentPkg: p}
+		for ind, f := range p.Syntax {
+			filePath := p.CompiledGoFiles[ind]
+			if visitedFiles[filePath] {
+				continue
+			}
+			visitedFiles[filePath] = true
+			transformer.computeExistingImports(f)
+			transformer.initContextExpressions()
+			ctxExprs := map[string]bool{
+				transformer.ctxParamInvalidWithPkgAlias:       true,
+				transformer.ctxCallSiteArtificialWithPkgAlias: true,
+			}
+			for _, ctxExpr := range transformer.ctxParamInvalidReasonsWithPkgAlias {
+				ctxExprs[ctxExpr] = true
+			}
+			allowed := cfg.isArtificialCtxAllowed(filePath)
+
+			// functions enclosing the currently visited node
+			var fnStack []ast.Node
+			var nodeStack []ast.Node
+			ast.Inspect(f, func(n ast.Node) bool {
+				if n == nil {
+					if last := nodeStack[len(nodeStack)-1]; len(fnStack) > 0 && fnStack[len(fnStack)-1] == last {
+						fnStack = fnStack[:len(fnStack)-1]
+					}
+					nodeStack = nodeStack[:len(nodeStack)-1]
+					return true
+				}
+				if e, ok := n.(ast.Expr); ok && ctxExprs[types.ExprString(e)] {
+					ctxExpr := types.ExprString(e)
+					if fnName, paramName := cfg.enclosingCtxFn(p.TypesInfo, fnStack); paramName != "" {
+						res = append(res, cfg.newFinding(p.Fset, e.Pos(), "function "+fnName+" takes context parameter "+paramName+" but uses artificial context "+ctxExpr))
+					} else if !allowed {
+						res = append(res, cfg.newFinding(p.Fset, e.Pos(), "artificial context "+ctxExpr+" used outside of allowed files"))
+					}
+					return false
+				}
+				switch n.(type) {
+				case *ast.FuncDecl, *ast.FuncLit:
+					fnStack = append(fnStack, n)
+				}
+				nodeStack = append(nodeStack, n)
+				return true
+			})
+		}
+	}
+	return res
+}
+
+// enclosingCtxFn returns name of the innermost function (among given
+// enclosing functions) that takes context parameter along with the
+// name of this parameter (empty strings if there is no such
+// function).
+func (cfg *analyzerConfig) enclosingCtxFn(info *types.Info, fnStack []ast.Node) (string, string) {
+	for i := len(fnStack) - 1; i >= 0; i-- {
+		var sig *types.Signature
+		name := ""
+		switch fn := fnStack[i].(type) {
+		case *ast.FuncDecl:
+			name = fn.Name.Name
+			if obj, ok := info.Defs[fn.Name].(*types.Func); ok {
+				sig = obj.Type().(*types.Signature)
+			}
+		case *ast.FuncLit:
+			name = "literal"
+			if fd, ok := fnStack[0].(*ast.FuncDecl); ok {
+				name += " in " + fd.Name.Name
+			}
+			sig, _ = info.TypeOf(fn).(*types.Signature)
+		}
+		if sig == nil {
+			continue
+		}
+		if isParamContext, _, paramName, _, _ := cfg.isFirstParamContext(sig); isParamContext {
+			return name, paramName
+		}
+	}
+	return "", ""
+}
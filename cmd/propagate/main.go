@@ -10,7 +10,13 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
 
 	"github.com/uber-research/go-context-propagate"
 )
@@ -19,12 +25,183 @@ import (
 // additional information during context propagation.
 const DefaultDebugLevel = 2
 
+// conflictingFlags are pairs of flags that cannot be used together.
+var conflictingFlags = [][2]string{
+	{"config", "config-chain"},
+	// the code is not transformed when the plan is emitted
+	{"emit-plan", "apply-plan"},
+	{"emit-plan", "preserve-formatting"},
+	{"emit-plan", "generate-assertions"},
+	{"emit-plan", "migration-guide"},
+	{"emit-plan", "edit-report"},
+	{"emit-plan", "check-interface-completeness"},
+	// the tool keeps running until interrupted in the watch mode
+	{"watch", "timeout"},
+}
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "check" {
+		os.Exit(check(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "revert" {
+		os.Exit(revert(os.Args[2:]))
+	}
+
 	// input to the tool
 	configFilePath := flag.String("config", "", "path to the JSON configuration file")
 	// additional output from the tool
 	debugFilePath := flag.String("debug", "", "path to the JSON file containing additional comments and warnings")
+	// limit on how long the tool can run
+	timeout := flag.Duration("timeout", 0, "maximum duration of the whole run, e.g. 30m (no limit by default)")
+	// files too large to be transformed
+	maxFileSize := flag.Int64("max-file-size", 0, "maximum size (in bytes) of a file to be transformed (no limit by default)")
+	// mock implementation of the context interface for tests
+	mockFilePath := flag.String("generate-mock", "", "path to the file where mock implementation of the context interface is generated")
+	// comparison of call graph construction algorithms
+	callGraphComparePath := flag.String("callgraph-compare", "", "path to the JSON file where a report comparing RTA and CHA call graphs is written")
+	// minimal diffs of transformed files
+	preserveFormatting := flag.Bool("preserve-formatting", false, "only change the necessary parts of transformed files instead of reformatting them as a whole")
+	// phased migrations
+	configChain := flag.String("config-chain", "", "comma-separated paths to JSON configuration files applied sequentially (instead of -config)")
+	// interface assertions for transformed code
+	generateAssertions := flag.Bool("generate-assertions", false, "generate context_propagated.go file with interface assertions in each package with modified methods")
+	// misconfigured leaf functions
+	strict := flag.Bool("strict", false, "fail if no matching definition is found for a leaf function specified in the configuration file")
+	// documentation of changed signatures
+	migrationGuidePath := flag.String("migration-guide", "", "path to the Markdown file where a guide describing all changed function signatures is written")
+	// smaller call graph
+	eliminateDeadCode := flag.Bool("eliminate-dead-code", false, "exclude functions with no callers (other than main, init and test functions) from the analysis")
+	// offline package loading
+	packageListPath := flag.String("package-list", "", "path to the JSON file produced by \"go list -deps -test -compiled -json\" from which packages are loaded instead of invoking the go command")
+	// reverting edits later
+	editReportPath := flag.String("edit-report", "", "path to the JSON file where a report describing all edits is written (to be used by the revert subcommand)")
+	// context misuse
+	contextEscapeAnalysis := flag.Bool("context-escape-analysis", false, "report goroutines started by anonymous functions referencing context parameter of the enclosing function")
+	// two-phase operation
+	emitPlanPath := flag.String("emit-plan", "", "path to the JSON file where analysis results are written instead of transforming the code")
+	applyPlanPath := flag.String("apply-plan", "", "path to the JSON file containing analysis results (produced with -emit-plan) used to transform the code instead of performing the analysis")
+	// more precise call graph
+	callGraphPath := flag.String("callgraph-file", "", "path to the JSON file containing an externally computed call graph used instead of the RTA call graph wherever it matches the analyzed code")
+	// debugging the analysis
+	exportSSAPath := flag.String("export-ssa", "", "path to the text file where the SSA representation of the analyzed packages and the call graph edges are written")
+	traceFunc := flag.String("trace-func", "", "name of the function (e.g. \"pkg/path.FuncName\") whose analysis decisions are logged to stderr and to the debug file")
+	explainFunc := flag.String("explain", "", "name of the function (e.g. \"pkg/path.FuncName\" or \"pkg/path.(*Type).Method\") for which an explanation of how the analysis has categorized it is printed")
+	// reviewing the results
+	affectedTestsPath := flag.String("affected-tests", "", "path to the JSON file where test functions (transitively) calling modified call sites are listed")
+	packageGraphPath := flag.String("package-graph", "", "path to the DOT file where a graph of packages whose functions are modified (with edges to modified packages they import) is written")
+	// unused context parameters
+	rewriteBlankIdentifier := flag.Bool("rewrite-blank-identifier", false, "name blank (or unnamed) context parameters of all functions using the context parameter name from the configuration file")
+	// broken interface implementations
+	checkIfaceCompleteness := flag.Bool("check-interface-completeness", false, "type-check transformed code and fail if types implementing modified interfaces no longer implement them")
+	// observability in CI pipelines
+	metricsAddr := flag.String("metrics-addr", "", "address (e.g. \"localhost:9090\") of an HTTP server serving Prometheus metrics at /metrics while the tool runs")
+	// iterative migration
+	watchMode := flag.Bool("watch", false, "after the initial run, watch files of the loaded packages and re-run propagation each time they change")
 	flag.Parse()
+	checkFlags()
+
+	ctx := context.Background()
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+
+	opts := propagate.Options{
+		MaxFileSize:            *maxFileSize,
+		MockFilePath:           *mockFilePath,
+		CallGraphComparePath:   *callGraphComparePath,
+		PreserveFormatting:     *preserveFormatting,
+		GenerateAssertions:     *generateAssertions,
+		Strict:                 *strict,
+		MigrationGuidePath:     *migrationGuidePath,
+		EliminateDeadCode:      *eliminateDeadCode,
+		PackageListPath:        *packageListPath,
+		EditReportPath:         *editReportPath,
+		ContextEscapeAnalysis:  *contextEscapeAnalysis,
+		EmitPlanPath:           *emitPlanPath,
+		ApplyPlanPath:          *applyPlanPath,
+		CallGraphPath:          *callGraphPath,
+		ExportSSAPath:          *exportSSAPath,
+		TraceFunc:              *traceFunc,
+		ExplainFunc:            *explainFunc,
+		AffectedTestsPath:      *affectedTestsPath,
+		PackageGraphPath:       *packageGraphPath,
+		RewriteBlankIdentifier: *rewriteBlankIdentifier,
+		CheckIfaceCompleteness: *checkIfaceCompleteness,
+		MetricsAddr:            *metricsAddr,
+		Watch:                  *watchMode,
+	}
+	if *configChain != "" {
+		opts.ConfigChain = strings.Split(*configChain, ",")
+	}
+	err := propagate.RunWithOptions(ctx, *configFilePath, *debugFilePath, nil, DefaultDebugLevel, &opts)
+	if err != nil && ctx.Err() != nil {
+		// debug info collected so far has been output
+		fmt.Fprintln(os.Stderr, "timeout exceeded ("+timeout.String()+"): "+err.Error())
+		os.Exit(2)
+	} else if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// checkFlags terminates the program if conflicting flags (see
+// conflictingFlags) have been set.
+func checkFlags() {
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	for _, c := range conflictingFlags {
+		if set[c[0]] && set[c[1]] {
+			fmt.Fprintln(os.Stderr, "flags -"+c[0]+" and -"+c[1]+" cannot be used together")
+			os.Exit(1)
+		}
+	}
+}
+
+// check runs the "check" subcommand auditing code whose context
+// propagation has already been completed and returns the exit code
+// (non-zero if any problems have been found).
+func check(args []string) int {
+	flags := flag.NewFlagSet("check", flag.ExitOnError)
+	configFilePath := flags.String("config", "", "path to the JSON configuration file")
+	packageListPath := flags.String("package-list", "", "path to the JSON file produced by \"go list -deps -test -compiled -json\" from which packages are loaded instead of invoking the go command")
+	flags.Parse(args)
+
+	findings := propagate.Check(*configFilePath, flags.Args(), &propagate.Options{PackageListPath: *packageListPath})
+	for _, f := range findings {
+		fmt.Println(f)
+	}
+	if len(findings) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// revert runs the "revert" subcommand reverting edits described in an
+// edit report in selected packages and returns the exit code
+// (non-zero if some edits have to be reverted manually). Reverted
+// files are written to the same locations as original files with the
+// added "mod" extension.
+func revert(args []string) int {
+	flags := flag.NewFlagSet("revert", flag.ExitOnError)
+	reportPath := flags.String("report", "", "path to the JSON file containing the edit report")
+	pkgPatterns := flags.String("pkg", "", "comma-separated package paths whose edits are reverted (path/... matches path and its subpackages)")
+	flags.Parse(args)
 
-	propagate.Run(*configFilePath, *debugFilePath, nil, DefaultDebugLevel)
+	res := propagate.Revert(*reportPath, strings.Split(*pkgPatterns, ","))
+	for path, src := range res.Files {
+		if err := ioutil.WriteFile(path+".mod", src, 0644); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+	}
+	for _, m := range res.Manual {
+		fmt.Println("REVERT MANUALLY: " + m)
+	}
+	if len(res.Manual) > 0 {
+		return 1
+	}
+	return 0
 }
@@ -0,0 +1,210 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package propagate
+
+import (
+	"bytes"
+	"go/ast"
+	"sort"
+	"strconv"
+)
+
+// Diff describes differences between results of two context
+// propagation runs (see CompareTransformations).
+type Diff struct {
+	// OnlyInFirst are results appearing only in the first run.
+	OnlyInFirst DiffEntries
+	// OnlyInSecond are results appearing only in the second run.
+	OnlyInSecond DiffEntries
+	// Both are results appearing in both runs.
+	Both DiffEntries
+}
+
+// DiffEntries are (sorted) descriptions of results of a context
+// propagation run.
+type DiffEntries struct {
+	// Functions are functions categorized by the analysis, each
+	// described by its name (or position if it is not represented in
+	// SSA form, e.g. an interface method) followed by its category.
+	Functions []string
+	// CallSites are call sites receiving context argument or renamed,
+	// each described by its position followed by context argument
+	// and the new name of the called function (if any).
+	CallSites []string
+	// Files are paths of modified files - a file modified by both
+	// runs but in a different way appears in both OnlyInFirst and
+	// OnlyInSecond.
+	Files []string
+}
+
+// transformationSummary describes results of a context propagation
+// run to be compared with another one.
+type transformationSummary struct {
+	functions []string
+	callSites []string
+	files     map[string][]byte // file path -> file content
+}
+
+// CompareTransformations runs context propagation (without writing
+// modified files) for the same source paths using two different
+// config files and returns differences between the results, e.g. to
+// verify that a config file change has only the intended effect.
+func CompareTransformations(config1 string, config2 string, srcPaths []string) (*Diff, error) {
+	first, err := summarizeTransformation(config1, srcPaths)
+	if err != nil {
+		return nil, err
+	}
+	second, err := summarizeTransformation(config2, srcPaths)
+	if err != nil {
+		return nil, err
+	}
+	diff := &Diff{}
+	diff.OnlyInFirst.Functions, diff.OnlyInSecond.Functions, diff.Both.Functions = compareEntries(first.functions, second.functions)
+	diff.OnlyInFirst.CallSites, diff.OnlyInSecond.CallSites, diff.Both.CallSites = compareEntries(first.callSites, second.callSites)
+	for path, content := range first.files {
+		if secondContent, exists := second.files[path]; exists && bytes.Equal(content, secondContent) {
+			diff.Both.Files = append(diff.Both.Files, path)
+		} else {
+			diff.OnlyInFirst.Files = append(diff.OnlyInFirst.Files, path)
+		}
+	}
+	for path, content := range second.files {
+		if firstContent, exists := first.files[path]; !exists || !bytes.Equal(content, firstContent) {
+			diff.OnlyInSecond.Files = append(diff.OnlyInSecond.Files, path)
+		}
+	}
+	sort.Strings(diff.OnlyInFirst.Files)
+	sort.Strings(diff.OnlyInSecond.Files)
+	sort.Strings(diff.Both.Files)
+	return diff, nil
+}
+
+// compareEntries returns (sorted) entries appearing only in the first
+// list, only in the second list and in both lists.
+func compareEntries(first []string, second []string) ([]string, []string, []string) {
+	inFirst := make(map[string]bool)
+	for _, e := range first {
+		inFirst[e] = true
+	}
+	inSecond := make(map[string]bool)
+	for _, e := range second {
+		inSecond[e] = true
+	}
+	var onlyInFirst, onlyInSecond, both []string
+	for e := range inFirst {
+		if inSecond[e] {
+			both = append(both, e)
+		} else {
+			onlyInFirst = append(onlyInFirst, e)
+		}
+	}
+	for e := range inSecond {
+		if !inFirst[e] {
+			onlyInSecond = append(onlyInSecond, e)
+		}
+	}
+	sort.Strings(onlyInFirst)
+	sort.Strings(onlyInSecond)
+	sort.Strings(both)
+	return onlyInFirst, onlyInSecond, both
+}
+
+// summarizeTransformation runs context propagation for a given config
+// file and returns a summary of its results. It returns an error
+// instead of terminating the program if analysis or transformation
+// fails.
+func summarizeTransformation(configFilePath string, srcPaths []string) (summary *transformationSummary, err error) {
+	cfg := initialize(configFilePath, 0, nil)
+	defer cfg.handleAbort("", &err)
+
+	analyzer := cfg.analyzeCode(srcPaths, nil)
+	transformer := transformerConfig{
+		config:           cfg,
+		astIfaceModified: make(map[*ast.InterfaceType]bool),
+	}
+	modified := (&transformer).transform()
+
+	summary = &transformationSummary{
+		functions: analyzer.summarizeFns(),
+		callSites: analyzer.summarizeCallSites(),
+		files:     make(map[string][]byte),
+	}
+	for p, nodes := range modified {
+		for n, ind := range nodes {
+			path := p.CompiledGoFiles[ind]
+			summary.files[cfg.relPath(path)] = formatResult(p.Fset, n, path, nil, nil)
+		}
+	}
+	return summary, nil
+}
+
+// summarizeFns describes functions categorized by the analysis.
+func (cfg *analyzerConfig) summarizeFns() []string {
+	names := make(map[uniquePosInfo]string)
+	allFns, _ := cfg.getDeclaredFnsAndVars()
+	for len(allFns) > 0 {
+		f := allFns[len(allFns)-1]
+		allFns = allFns[:len(allFns)-1]
+		allFns = append(allFns, f.AnonFuncs...)
+		if f.Pkg != nil {
+			names[cfg.getUniquePosSSAFn(f, f.Pos())] = f.String()
+		}
+	}
+	var fns []string
+	for uniquePos, fnType := range cfg.fnVisited {
+		name, exists := names[uniquePos]
+		if !exists {
+			name = cfg.summaryPos(uniquePos)
+		}
+		fns = append(fns, name+": "+fnTypeNames[fnType])
+	}
+	return fns
+}
+
+// summarizeCallSites describes call sites receiving context argument
+// or renamed.
+func (cfg *analyzerConfig) summarizeCallSites() []string {
+	var sites []string
+	for uniquePos, callReplacement := range cfg.callSites {
+		site := cfg.summaryPos(uniquePos) + ": "
+		if reason := cfg.getNilCallReason(callReplacement); reason != "" {
+			site += "artificial context (" + reason + ")"
+		} else if callReplacement.ctxExpr != "" {
+			site += callReplacement.ctxExpr
+		} else {
+			site += replaceCtxExprWildcard(ctxWildcard, callReplacement.ctxRegExpr, cfg.CtxParamName)
+		}
+		if newName, exists := cfg.callSitesRenamed[uniquePos]; exists {
+			site += " (renamed to " + newName + ")"
+		}
+		sites = append(sites, site)
+	}
+	for uniquePos, newName := range cfg.callSitesRenamed {
+		if _, exists := cfg.callSites[uniquePos]; !exists {
+			sites = append(sites, cfg.summaryPos(uniquePos)+": renamed to "+newName)
+		}
+	}
+	return sites
+}
+
+// summaryPos returns a human-readable representation of a given
+// position to be included in a summary of context propagation
+// results.
+func (cfg *analyzerConfig) summaryPos(uniquePos uniquePosInfo) string {
+	if !uniquePos.pos.IsValid() {
+		return "synthetic function " + strconv.Itoa(uniquePos.syntheticID)
+	}
+	fset := uniquePos.fset
+	if fset == nil {
+		fset = cfg.prog.Fset
+	}
+	p := fset.Position(uniquePos.pos)
+	return cfg.relPath(p.Filename) + ":" + strconv.Itoa(p.Line) + ":" + strconv.Itoa(p.Column)
+}
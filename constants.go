@@ -9,10 +9,28 @@
 
 package propagate
 
+import "time"
+
 // argBytesLimit establishes the total max length load paths argument
 // can have.
 const argBytesLimit = 200000
 
+// wildcardExpansionFactor is a conservative estimate of how many times
+// longer the arguments resulting from expansion of a load path
+// containing "..." wildcard are than the load path itself.
+const wildcardExpansionFactor = 5
+
+// The following describe timing of re-running context propagation in
+// watch mode (see Options.Watch).
+const (
+	// watchInterval is the interval at which watched files are
+	// checked for modifications.
+	watchInterval = 100 * time.Millisecond
+	// watchDebounce is the time since the last modification after
+	// which propagation is re-run so that rapid edits are batched.
+	watchDebounce = 500 * time.Millisecond
+)
+
 // The following describe different call graph construction
 // algorithms.
 const (
@@ -31,10 +49,20 @@ const (
 	ctxWildcard       = "<?CTX?>"
 	ctxCustomWildcard = "<?CTX_CUSTOM?>"
 	ctxPrefWildcard   = "<?CTX_PREF?>"
+	libPrefWildcard   = "<?LIB_PREF?>"
 	pathWildCard      = "<?PATH?>"
 	aliasWildCard     = "<?ALIAS1?>"
 )
 
+// The following describe reasons for using artificial context (in
+// addition to function kinds in fnVisited map) that can have their
+// own expressions specified in the config file.
+const (
+	defaultCtxReason           = "default"
+	initCallerCtxReason        = "init-caller"
+	flowBarrierCallerCtxReason = "flow-barrier-caller"
+)
+
 // The following describe argument types of functions in the testing
 // harness.
 const (
@@ -42,6 +70,18 @@ const (
 	testingTypeM = "*testing.M"
 )
 
+// noInterfacePragma is a pragma marking methods that are to be
+// treated as not implementing any interface.
+const noInterfacePragma = "//go:nointerface"
+
+// linknamePragma is a pragma linking a function to a function defined
+// in another package.
+const linknamePragma = "//go:linkname"
+
+// cgoExportPragma is a directive making a function callable from C
+// code.
+const cgoExportPragma = "//export"
+
 // The following describe different different function types in fnVisited map.
 const (
 	regularFn = iota
@@ -50,4 +90,9 @@ const (
 	extFn
 	extPkg
 	extRecv
+	testSuiteRecv
+	skippedFileFn
+	unsafeCastFn
+	generatedFn
+	frozenFn
 )
@@ -0,0 +1,323 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package propagate
+
+import (
+	"bytes"
+	"encoding/json"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Kinds of edits recorded in the edit report. Edits of the first four
+// kinds can be reverted automatically (see Revert), edits of the
+// remaining kinds have to be reverted manually.
+const (
+	// context parameter added to a function declaration
+	paramEdit = "param"
+	// artificial context variable initialized at the beginning of a
+	// function declaration's body
+	initEdit = "init"
+	// context argument added at a call site (possibly renaming the
+	// called function)
+	argEdit = "arg"
+	// context parameter added to an interface method
+	ifaceParamEdit = "ifaceParam"
+	// context parameter added to a function literal
+	literalParamEdit = "literalParam"
+	// artificial context variable initialized at the beginning of a
+	// function literal's body
+	literalInitEdit = "literalInit"
+	// context parameter added to a function type of another
+	// function's parameter
+	fnTypeParamEdit = "fnTypeParam"
+	// context parameter added to a function type of a struct field
+	fnTypeFieldEdit = "fnTypeField"
+	// context parameter added to a named function type
+	namedTypeEdit = "namedType"
+	// existing unnamed (or blank) context parameter named
+	paramRenameEdit = "paramRename"
+	// called function renamed without adding context argument
+	renameEdit = "rename"
+	// named function argument wrapped in a closure forwarding context
+	closureEdit = "closure"
+	// context field added to a context carrier type
+	carrierFieldEdit = "carrierField"
+	// context field initialized in a literal of a context carrier
+	// type
+	carrierInitEdit = "carrierInit"
+	// reference to the library interface replaced with a reference to
+	// its context-aware copy
+	shadowRefEdit = "shadowRef"
+	// unused import of the library package removed
+	importRemovedEdit = "importRemoved"
+)
+
+// editReport describes all edits made during context propagation so
+// that they can be reverted later (see Revert).
+type editReport struct {
+	// FilePrefix is the prefix of the source files path that file
+	// paths are relative to (empty if file paths are absolute).
+	FilePrefix string
+	// Files describe edits in each modified file.
+	Files []*fileEdits
+}
+
+// fileEdits describes edits made in a single file.
+type fileEdits struct {
+	// Path is the path of the file (relative to the file prefix if
+	// it is known).
+	Path string
+	// PkgPath is the path of the package the file belongs to.
+	PkgPath string
+	// ImportsAdded are paths of imports added to the file.
+	ImportsAdded []string
+	// Edits are edits of the file's code.
+	Edits []edit
+}
+
+// edit describes a single edit. Edits are located by the name of the
+// top-level symbol (function or type) they have been made in rather
+// than by their offsets so that they can be found even if the file
+// has changed since.
+type edit struct {
+	// Kind is the kind of the edit.
+	Kind string
+	// Func is the name of the top-level symbol containing the edit:
+	// function name (qualified with receiver type for methods) or
+	// type name (empty for other declarations).
+	Func string
+	// Name is the name of the added context parameter or variable
+	// (or of the interface method for interface edits, or the path
+	// of the removed import).
+	Name string `json:",omitempty"`
+	// Type is the type of the added context parameter.
+	Type string `json:",omitempty"`
+	// Expr is the added context expression (argument or artificial
+	// context variable initializer).
+	Expr string `json:",omitempty"`
+	// Callee is the called function expression after the edit.
+	Callee string `json:",omitempty"`
+	// OrigCallee is the called function expression before the edit
+	// (empty if the called function has not been renamed).
+	OrigCallee string `json:",omitempty"`
+	// ArgIndex is the index of the added context argument (or
+	// parameter).
+	ArgIndex int `json:",omitempty"`
+	// Line is the line of the edit at the time it was made (for
+	// informational purposes only).
+	Line int
+}
+
+// declSymbol describes the source code range of a top-level symbol.
+type declSymbol struct {
+	pos  token.Pos
+	end  token.Pos
+	name string
+}
+
+// collectDeclSymbols records names and ranges of top-level symbols in
+// a given AST before it is transformed (needed for the edit report).
+func (cfg *transformerConfig) collectDeclSymbols(f *ast.File, path string) {
+	cfg.declSymbols = nil
+	cfg.currentEdits = nil
+	if cfg.opts.EditReportPath == "" && cfg.MaxEditsPerFileWarn <= 0 && cfg.MaxEditsPerFileError <= 0 {
+		return
+	}
+	cfg.currentEdits = &fileEdits{Path: cfg.relPath(path), PkgPath: cfg.currentPkg.PkgPath}
+	for _, d := range f.Decls {
+		switch d := d.(type) {
+		case *ast.FuncDecl:
+			cfg.declSymbols = append(cfg.declSymbols, declSymbol{d.Pos(), d.End(), cfg.fnDeclName(d)})
+		case *ast.GenDecl:
+			for _, s := range d.Specs {
+				if ts, ok := s.(*ast.TypeSpec); ok {
+					cfg.declSymbols = append(cfg.declSymbols, declSymbol{ts.Pos(), ts.End(), ts.Name.Name})
+				}
+			}
+		}
+	}
+}
+
+// fnDeclName returns name of a given function declaration (qualified
+// with receiver type for methods).
+func (cfg *transformerConfig) fnDeclName(fd *ast.FuncDecl) string {
+	name := fd.Name.Name
+	if fd.Recv != nil && len(fd.Recv.List) > 0 {
+		var buf bytes.Buffer
+		if err := format.Node(&buf, cfg.currentPkg.Fset, fd.Recv.List[0].Type); err != nil {
+			fatal("error formatting receiver of function " + fd.Name.Name + ": " + err.Error())
+		}
+		name = "(" + buf.String() + ")." + name
+	}
+	return name
+}
+
+// addEdit records an edit made at a given position (to be included in
+// the edit report).
+func (cfg *transformerConfig) addEdit(pos token.Pos, e edit) {
+	if cfg.currentEdits == nil {
+		return
+	}
+	for _, s := range cfg.declSymbols {
+		if pos >= s.pos && pos < s.end {
+			e.Func = s.name
+			break
+		}
+	}
+	e.Line = cfg.currentPkg.Fset.Position(pos).Line
+	cfg.currentEdits.Edits = append(cfg.currentEdits.Edits, e)
+}
+
+// addIfaceParamEdit records context parameter added to a given
+// interface method.
+func (cfg *transformerConfig) addIfaceParamEdit(fld *ast.Field) {
+	if cfg.currentEdits == nil || len(fld.Names) == 0 {
+		return
+	}
+	ft, ok := fld.Type.(*ast.FuncType)
+	if !ok || len(ft.Params.List) == 0 {
+		return
+	}
+	i := cfg.getCtxParamIndex(ft.Params)
+	cfg.addEdit(fld.Pos(), edit{Kind: ifaceParamEdit, Name: fld.Names[0].Name, Type: types.ExprString(ft.Params.List[i].Type), ArgIndex: i})
+}
+
+// paramName returns name of a given parameter (empty if the parameter
+// is unnamed).
+func paramName(fld *ast.Field) string {
+	if len(fld.Names) == 0 {
+		return ""
+	}
+	return fld.Names[0].Name
+}
+
+// addFileEdits records edits made in the currently transformed AST.
+func (cfg *transformerConfig) addFileEdits() {
+	if cfg.currentEdits == nil {
+		return
+	}
+	sort.Strings(cfg.currentEdits.ImportsAdded)
+	cfg.fileEdits = append(cfg.fileEdits, cfg.currentEdits)
+}
+
+// checkEditLimits checks if the number of edits made in the currently
+// transformed AST exceeds limits specified in the config file and
+// returns true if the file is not to be transformed.
+func (cfg *transformerConfig) checkEditLimits(fset *token.FileSet, f *ast.File) bool {
+	if cfg.currentEdits == nil {
+		return false
+	}
+	editsNum := len(cfg.currentEdits.Edits)
+	limit := cfg.MaxEditsPerFileError
+	skipped := limit > 0 && editsNum > limit
+	if !skipped {
+		limit = cfg.MaxEditsPerFileWarn
+		if limit <= 0 || editsNum <= limit {
+			return false
+		}
+	}
+	kindsNum := make(map[string]int)
+	for _, e := range cfg.currentEdits.Edits {
+		kindsNum[e.Kind]++
+	}
+	var kinds []string
+	for k, n := range kindsNum {
+		kinds = append(kinds, k+": "+strconv.Itoa(n))
+	}
+	sort.Strings(kinds)
+	m := make(map[string]string)
+	m["file"] = cfg.currentEdits.Path
+	m["edits"] = strconv.Itoa(editsNum)
+	m["kinds"] = strings.Join(kinds, ", ")
+	m["skipped"] = strconv.FormatBool(skipped)
+	cfg.debugData.EditLimitsExceeded = append(cfg.debugData.EditLimitsExceeded, m)
+	msg := "WARNING: file has " + m["edits"] + " edits (" + m["kinds"] + ") exceeding the limit of " + strconv.Itoa(limit) + " edits per file"
+	if skipped {
+		msg += " and will not be transformed"
+	}
+	cfg.writeWarning(fset, f.Package, msg)
+	return skipped
+}
+
+// reportEditLimitsExceeded fails the run if some files have not been
+// transformed because of the number of their edits.
+func (cfg *transformerConfig) reportEditLimitsExceeded() {
+	var files []string
+	for _, m := range cfg.debugData.EditLimitsExceeded {
+		if m["skipped"] == "true" {
+			files = append(files, m["file"]+" ("+m["edits"]+" edits)")
+		}
+	}
+	if len(files) > 0 {
+		fatal("files exceeding the limit of edits per file specified in the config file: " + strings.Join(files, ", "))
+	}
+}
+
+// recordOrigCallee records the called function expression at a given
+// call site before it is renamed.
+func (cfg *transformerConfig) recordOrigCallee(uniquePos uniquePosInfo, fun ast.Expr) {
+	if cfg.currentEdits == nil {
+		return
+	}
+	if cfg.origCallees == nil {
+		cfg.origCallees = make(map[uniquePosInfo]string)
+	}
+	cfg.origCallees[uniquePos] = types.ExprString(fun)
+}
+
+// addArgEdit records context argument added at a given call site.
+func (cfg *transformerConfig) addArgEdit(e *ast.CallExpr, uniquePos uniquePosInfo, argIndex int) {
+	if cfg.currentEdits == nil {
+		return
+	}
+	cfg.addEdit(e.Lparen, edit{
+		Kind:       argEdit,
+		Expr:       normalizeExpr(types.ExprString(e.Args[argIndex])),
+		Callee:     types.ExprString(e.Fun),
+		OrigCallee: cfg.origCallees[uniquePos],
+		ArgIndex:   argIndex,
+	})
+}
+
+// normalizeExpr returns a canonical representation of an expression
+// (injected expressions are represented as identifiers with
+// arbitrary code as their names and must be printed the same way as
+// after they are parsed).
+func normalizeExpr(expr string) string {
+	e, err := parser.ParseExpr(expr)
+	if err != nil {
+		return expr
+	}
+	return types.ExprString(e)
+}
+
+// writeEditReport writes a JSON document describing all edits made
+// during context propagation.
+func (cfg *transformerConfig) writeEditReport() {
+	if cfg.opts.EditReportPath == "" {
+		return
+	}
+	report := editReport{FilePrefix: cfg.filePrefix, Files: cfg.fileEdits}
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		fatal("error encoding edit report: " + err.Error())
+	}
+	if err := ioutil.WriteFile(cfg.opts.EditReportPath, data, 0644); err != nil {
+		fatal("error writing edit report " + cfg.opts.EditReportPath)
+	}
+}
@@ -0,0 +1,380 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package propagate
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/token"
+	cg "golang.org/x/tools/go/callgraph"
+	"golang.org/x/tools/go/ssa"
+	"io"
+	"sort"
+	"strconv"
+)
+
+// Kinds of context flow along the edges of the context flow graph.
+const (
+	// context parameter (existing or injected) passed to a function
+	// receiving injected context parameter (or to a "leaf" function)
+	flowPropagated = "propagated"
+	// artificial context passed at a call site where no context is
+	// available
+	flowArtificial = "artificial"
+	// context captured as a free variable by an anonymous function
+	// defined in a function having context
+	flowClosureCaptured = "closure-captured"
+	// context passed to a function already taking context parameter
+	flowAlreadyPresent = "already-present"
+)
+
+// Kinds of functions in the context flow graph.
+const (
+	// function receiving injected context parameter
+	flowFnInjected = "injected"
+	// function initializing artificial context
+	flowFnArtificial = "artificial"
+	// function initializing context using its receiver (or its
+	// parameter)
+	flowFnExtracted = "extracted"
+	// function already taking context parameter
+	flowFnExisting = "existing"
+	// anonymous function capturing context of the enclosing function
+	flowFnClosure = "closure"
+	// "leaf" function (or another function receiving context without
+	// being analyzed)
+	flowFnLeaf = "leaf"
+	// function with no context passing artificial context at its
+	// call sites
+	flowFnNone = "none"
+)
+
+// ContextFlowGraph is a directed graph representing how context flows
+// through the program. It is a subset of the call graph including only
+// functions involved in context propagation.
+type ContextFlowGraph struct {
+	// Nodes are functions involved in context propagation.
+	Nodes []ContextFlowNode
+	// Edges describe context flowing between functions.
+	Edges []ContextFlowEdge
+}
+
+// ContextFlowNode represents a function in the context flow graph.
+type ContextFlowNode struct {
+	// Name is the name of the function qualified with package path
+	// (and receiver type for methods).
+	Name string
+	// Kind describes how the function obtains context ("injected",
+	// "artificial", "extracted", "existing", "closure", "leaf" or
+	// "none").
+	Kind string
+	// Pos is the position of the function definition ("file:line",
+	// empty if unknown).
+	Pos string `json:",omitempty"`
+}
+
+// ContextFlowEdge represents context flowing from one function to
+// another in the context flow graph.
+type ContextFlowEdge struct {
+	// Caller is the name of the function context flows from.
+	Caller string
+	// Callee is the name of the function context flows to.
+	Callee string
+	// Flow is the kind of the flow ("propagated", "artificial",
+	// "closure-captured" or "already-present").
+	Flow string
+	// Pos is the position of the call site (or of the definition of
+	// an anonymous function capturing context) as "file:line".
+	Pos string
+}
+
+// flowEdgeKey identifies an edge of the call graph in the analysis
+// results.
+type flowEdgeKey struct {
+	caller string
+	callee string
+	pos    token.Pos
+}
+
+// flowInfo describes context flowing along an edge of the call graph
+// (or the kind of a function in the context flow graph) along with
+// the position of the call site (or of the function).
+type flowInfo struct {
+	kind string
+	pos  string
+}
+
+// CallGraph returns the call graph constructed during analysis.
+func CallGraph(result *AnalysisResult) *cg.Graph {
+	if result == nil {
+		return nil
+	}
+	return result.graph
+}
+
+// BuildContextFlowGraph builds the context flow graph from the
+// analysis results and a given call graph (which must represent the
+// analyzed program, e.g. the one returned by CallGraph).
+func BuildContextFlowGraph(result *AnalysisResult, graph *cg.Graph) *ContextFlowGraph {
+	g := &ContextFlowGraph{Nodes: []ContextFlowNode{}, Edges: []ContextFlowEdge{}}
+	if result == nil || graph == nil {
+		return g
+	}
+	nodesAdded := make(map[string]bool)
+	addNode := func(name string) {
+		if nodesAdded[name] {
+			return
+		}
+		nodesAdded[name] = true
+		g.Nodes = append(g.Nodes, ContextFlowNode{Name: name, Kind: result.flowFns[name].kind, Pos: result.flowFns[name].pos})
+	}
+	edgesAdded := make(map[ContextFlowEdge]bool)
+	addEdge := func(caller *ssa.Function, callee *ssa.Function, pos token.Pos) {
+		info, exists := result.flowEdges[flowEdgeKey{caller.String(), callee.String(), pos}]
+		if !exists {
+			return
+		}
+		e := ContextFlowEdge{Caller: caller.String(), Callee: callee.String(), Flow: info.kind, Pos: info.pos}
+		// the same edge may be represented multiple times in
+		// package variants
+		if edgesAdded[e] {
+			return
+		}
+		edgesAdded[e] = true
+		g.Edges = append(g.Edges, e)
+		addNode(e.Caller)
+		addNode(e.Callee)
+	}
+	for f, n := range graph.Nodes {
+		if f == nil {
+			continue
+		}
+		for _, out := range n.Out {
+			addEdge(f, out.Callee.Func, out.Pos())
+		}
+		if parent := f.Parent(); parent != nil {
+			// anonymous function may capture context of the
+			// enclosing function
+			addEdge(parent, f, f.Pos())
+		}
+	}
+	sort.Slice(g.Nodes, func(i, j int) bool { return g.Nodes[i].Name < g.Nodes[j].Name })
+	sort.Slice(g.Edges, func(i, j int) bool {
+		if g.Edges[i].Caller != g.Edges[j].Caller {
+			return g.Edges[i].Caller < g.Edges[j].Caller
+		}
+		if g.Edges[i].Callee != g.Edges[j].Callee {
+			return g.Edges[i].Callee < g.Edges[j].Callee
+		}
+		return g.Edges[i].Pos < g.Edges[j].Pos
+	})
+	return g
+}
+
+// WriteDOT writes the context flow graph in the DOT format (e.g. to be
+// rendered using Graphviz).
+func (g *ContextFlowGraph) WriteDOT(w io.Writer) error {
+	if _, err := fmt.Fprintln(w, "digraph ctxflow {"); err != nil {
+		return err
+	}
+	for _, n := range g.Nodes {
+		if _, err := fmt.Fprintln(w, "\t"+strconv.Quote(n.Name)+" [label="+strconv.Quote(n.Name+"\n("+n.Kind+")")+"];"); err != nil {
+			return err
+		}
+	}
+	for _, e := range g.Edges {
+		if _, err := fmt.Fprintln(w, "\t"+strconv.Quote(e.Caller)+" -> "+strconv.Quote(e.Callee)+" [label="+strconv.Quote(e.Flow)+"];"); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintln(w, "}")
+	return err
+}
+
+// WriteJSON writes the context flow graph in the JSON format.
+func (g *ContextFlowGraph) WriteJSON(w io.Writer) error {
+	data, err := json.MarshalIndent(g, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// PropagationGraph is a directed graph of calls along which context
+// flows, derived from the call graph and filtered to functions
+// involved in context propagation. Unlike propagation itself, which
+// proceeds upward from "leaf" functions to their callers, the graph
+// is traversed downward from callers to callees.
+type PropagationGraph struct {
+	// callees maps names of functions (qualified with package path)
+	// to names of functions they pass context to (sorted).
+	callees map[string][]string
+	// leaves are names of "leaf" functions.
+	leaves map[string]bool
+}
+
+// BuildPropagationGraph builds the propagation graph from the analysis
+// results and a given call graph (which must represent the analyzed
+// program, e.g. the one returned by CallGraph).
+func BuildPropagationGraph(result *AnalysisResult, graph *cg.Graph) *PropagationGraph {
+	g := &PropagationGraph{callees: make(map[string][]string), leaves: make(map[string]bool)}
+	flowGraph := BuildContextFlowGraph(result, graph)
+	for _, n := range flowGraph.Nodes {
+		if n.Kind == flowFnLeaf {
+			g.leaves[n.Name] = true
+		}
+	}
+	for _, e := range flowGraph.Edges {
+		callees := g.callees[e.Caller]
+		// edges are sorted so multiple call sites of the same
+		// callee are adjacent
+		if len(callees) > 0 && callees[len(callees)-1] == e.Callee {
+			continue
+		}
+		g.callees[e.Caller] = append(callees, e.Callee)
+	}
+	return g
+}
+
+// ShortestPath returns the shortest call chain from one function to
+// another (or to the nearest "leaf" function if the target is empty)
+// as a list of function names qualified with package path, including
+// both ends of the chain. It returns nil if no such chain exists.
+func (g *PropagationGraph) ShortestPath(from, to string) []string {
+	isTarget := func(name string) bool {
+		if to == "" {
+			return g.leaves[name]
+		}
+		return name == to
+	}
+	// breadth-first search recording the predecessor of each
+	// visited function
+	prev := map[string]string{from: ""}
+	workList := []string{from}
+	for len(workList) > 0 {
+		name := workList[0]
+		workList = workList[1:]
+		if isTarget(name) {
+			var path []string
+			for ; name != from; name = prev[name] {
+				path = append(path, name)
+			}
+			path = append(path, from)
+			for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+				path[i], path[j] = path[j], path[i]
+			}
+			return path
+		}
+		for _, callee := range g.callees[name] {
+			if _, visited := prev[callee]; !visited {
+				prev[callee] = name
+				workList = append(workList, callee)
+			}
+		}
+	}
+	return nil
+}
+
+// collectContextFlow records how context flows along the edges of the
+// call graph in the analysis results.
+func (cfg *analyzerConfig) collectContextFlow(res *AnalysisResult) {
+	res.graph = cfg.graph
+	res.flowFns = make(map[string]flowInfo)
+	res.flowEdges = make(map[flowEdgeKey]flowInfo)
+	for f, n := range cfg.graph.Nodes {
+		if f == nil {
+			continue
+		}
+		callerKind := cfg.getFlowFnKind(f)
+		if callerKind == flowFnClosure {
+			parent := f.Parent()
+			res.flowEdges[flowEdgeKey{parent.String(), f.String(), f.Pos()}] = flowInfo{flowClosureCaptured, cfg.flowPos(f, f.Pos())}
+			res.flowFns[parent.String()] = flowInfo{cfg.getFlowFnKind(parent), cfg.flowPos(parent, parent.Pos())}
+			res.flowFns[f.String()] = flowInfo{callerKind, cfg.flowPos(f, f.Pos())}
+		}
+		for _, out := range n.Out {
+			var flow string
+			callee := out.Callee.Func
+			calleeKind := cfg.getFlowFnKind(callee)
+			replacement := cfg.callSites[cfg.getUniquePosCallSite(out)]
+			if cfg.isNilCallReplacement(replacement) {
+				flow = flowArtificial
+			} else if replacement != nil && callerKind != "" {
+				flow = flowPropagated
+			} else if calleeKind == flowFnExisting && callerKind != "" && callerKind != flowFnExisting {
+				// context present along the edge before
+				// propagation is only included if the caller
+				// is involved in propagation
+				flow = flowAlreadyPresent
+			} else {
+				continue
+			}
+			if callerKind == "" {
+				callerKind = flowFnNone
+			}
+			if calleeKind == "" {
+				calleeKind = flowFnLeaf
+			}
+			res.flowEdges[flowEdgeKey{f.String(), callee.String(), out.Pos()}] = flowInfo{flow, cfg.flowPos(f, out.Pos())}
+			res.flowFns[f.String()] = flowInfo{callerKind, cfg.flowPos(f, f.Pos())}
+			res.flowFns[callee.String()] = flowInfo{calleeKind, cfg.flowPos(callee, callee.Pos())}
+		}
+	}
+}
+
+// getFlowFnKind returns the kind of a given function in the context
+// flow graph (or empty string if the function has no context).
+func (cfg *analyzerConfig) getFlowFnKind(f *ssa.Function) string {
+	if isParamContext, _, _, _, _ := cfg.isFirstParamContext(f.Signature); isParamContext || cfg.getCtxFromStructField(f.Signature) != "" {
+		return flowFnExisting
+	}
+	uniquePos := cfg.getUniquePosSSAFn(f, f.Pos())
+	if fnType, exists := cfg.fnVisited[uniquePos]; exists && fnType == regularFn {
+		return flowFnInjected
+	} else if exists && fnType == freshCtxFn {
+		if _, exists := cfg.ctxInitExprs[uniquePos]; exists {
+			return flowFnExtracted
+		}
+		return flowFnArtificial
+	}
+	if parent := f.Parent(); parent != nil && cfg.getFlowFnKind(parent) != "" && cfg.passesCtxAtCallSites(f) {
+		return flowFnClosure
+	}
+	return ""
+}
+
+// passesCtxAtCallSites determines if a given function (or one of the
+// anonymous functions it defines) has call sites passing context.
+func (cfg *analyzerConfig) passesCtxAtCallSites(f *ssa.Function) bool {
+	if n := cfg.graph.Nodes[f]; n != nil {
+		for _, out := range n.Out {
+			if cfg.callSites[cfg.getUniquePosCallSite(out)] != nil {
+				return true
+			}
+		}
+	}
+	for _, anon := range f.AnonFuncs {
+		if cfg.passesCtxAtCallSites(anon) {
+			return true
+		}
+	}
+	return false
+}
+
+// flowPos returns a given position in a given function as "file:line"
+// (or empty string if the position is unknown).
+func (cfg *analyzerConfig) flowPos(f *ssa.Function, pos token.Pos) string {
+	if !pos.IsValid() {
+		return ""
+	}
+	p := cfg.getFset(f).Position(pos)
+	return cfg.relPath(p.Filename) + ":" + strconv.Itoa(p.Line)
+}
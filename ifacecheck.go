@@ -0,0 +1,188 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package propagate
+
+import (
+	"fmt"
+	"go/ast"
+	"go/types"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// ifaceImpl describes a type implementing a modified interface before
+// transformation.
+type ifaceImpl struct {
+	ifacePkgPath string
+	ifaceName    string
+	typePkgPath  string
+	typeName     string
+}
+
+// checkIfaceCompleteness verifies that all types implementing modified
+// interfaces before transformation still implement them after
+// transformation (see Options.CheckIfaceCompleteness). Some of their
+// methods may have kept their signatures (e.g. when used by an
+// external package) and only type information of the transformed code
+// reveals it, so the transformed packages are loaded again.
+func (cfg *transformerConfig) checkIfaceCompleteness(modified map[*packages.Package]map[*ast.File]int, overlay map[string][]byte) {
+	if !cfg.opts.CheckIfaceCompleteness {
+		return
+	}
+	impls := cfg.collectIfaceImpls()
+	if len(impls) == 0 {
+		return
+	}
+
+	transformed := make(map[string][]byte)
+	for path, src := range overlay {
+		transformed[path] = src
+	}
+	for p, files := range modified {
+		for n, ind := range files {
+			transformed[p.CompiledGoFiles[ind]] = formatResult(p.Fset, n, p.CompiledGoFiles[ind], cfg.opts, overlay)
+		}
+	}
+	var loadPaths []string
+	pathsAdded := make(map[string]bool)
+	for _, impl := range impls {
+		if !pathsAdded[impl.typePkgPath] {
+			pathsAdded[impl.typePkgPath] = true
+			loadPaths = append(loadPaths, impl.typePkgPath)
+		}
+	}
+	loadConfig := &packages.Config{Mode: packages.LoadAllSyntax, Tests: true, Overlay: transformed}
+	loaded, err := packages.Load(loadConfig, loadPaths...)
+	if err != nil {
+		fatal("error loading transformed packages: " + err.Error())
+	}
+
+	reported := make(map[ifaceImpl]bool)
+	var incomplete []map[string]string
+	for _, p := range loaded {
+		if p.Types == nil {
+			continue
+		}
+		for _, impl := range impls {
+			if impl.typePkgPath != p.PkgPath || reported[impl] {
+				continue
+			}
+			obj, ok := p.Types.Scope().Lookup(impl.typeName).(*types.TypeName)
+			if !ok {
+				// type defined in test files of another variant
+				// of the package
+				continue
+			}
+			iface := lookupIface(p, impl.ifacePkgPath, impl.ifaceName)
+			if iface == nil {
+				continue
+			}
+			ptr := types.NewPointer(obj.Type())
+			if types.Implements(ptr, iface) {
+				continue
+			}
+			reported[impl] = true
+			method, _ := types.MissingMethod(ptr, iface, true)
+			pos := p.Fset.Position(obj.Pos())
+			incomplete = append(incomplete, map[string]string{
+				"iface":  impl.ifacePkgPath + "." + impl.ifaceName,
+				"type":   impl.typePkgPath + "." + impl.typeName,
+				"method": method.Name(),
+				"file":   cfg.relPath(pos.Filename),
+				"line":   strconv.Itoa(pos.Line),
+			})
+		}
+	}
+	sort.Slice(incomplete, func(i, j int) bool {
+		if incomplete[i]["type"] != incomplete[j]["type"] {
+			return incomplete[i]["type"] < incomplete[j]["type"]
+		}
+		return incomplete[i]["iface"] < incomplete[j]["iface"]
+	})
+	cfg.debugData.IncompleteIfaceImpls = incomplete
+	if len(incomplete) == 0 {
+		return
+	}
+	var msgs []string
+	for _, m := range incomplete {
+		msgs = append(msgs, "type "+m["type"]+" ("+m["file"]+" line "+m["line"]+") does not implement "+m["iface"]+" (method "+m["method"]+" has not been updated)")
+	}
+	if cfg.debugLevel > 0 {
+		fmt.Println("ERROR: INTERFACE IMPLEMENTATIONS NOT UPDATED:")
+		for _, msg := range msgs {
+			fmt.Println(msg)
+		}
+	}
+	fatal("interface implementations not updated: " + strings.Join(msgs, ", "))
+}
+
+// collectIfaceImpls collects named types defined in non-external
+// packages that implement modified interfaces before transformation.
+func (cfg *transformerConfig) collectIfaceImpls() []ifaceImpl {
+	var res []ifaceImpl
+	added := make(map[ifaceImpl]bool)
+	for iface := range cfg.ifaceModified {
+		ifaceName := cfg.getIfaceName(iface)
+		if ifaceName == nil {
+			// implementations of unnamed interfaces cannot be
+			// found in the transformed code
+			continue
+		}
+		for _, p := range cfg.initial {
+			if cfg.isPkgExternal(p.PkgPath) {
+				continue
+			}
+			scope := p.Types.Scope()
+			for _, name := range scope.Names() {
+				obj, ok := scope.Lookup(name).(*types.TypeName)
+				if !ok || obj.IsAlias() {
+					continue
+				}
+				named, ok := obj.Type().(*types.Named)
+				if !ok || named.TypeParams().Len() > 0 || types.IsInterface(named) {
+					continue
+				}
+				if !cfg.implementsIface(types.NewPointer(named), iface) {
+					continue
+				}
+				impl := ifaceImpl{ifaceName.Pkg().Path(), ifaceName.Name(), p.PkgPath, name}
+				if !added[impl] {
+					added[impl] = true
+					res = append(res, impl)
+				}
+			}
+		}
+	}
+	return res
+}
+
+// lookupIface returns an interface with a given name defined in a
+// package with a given path that is either a given package or one of
+// its (direct or indirect) imports (nil if there is no such
+// interface).
+func lookupIface(p *packages.Package, pkgPath string, name string) *types.Interface {
+	var res *types.Interface
+	packages.Visit([]*packages.Package{p}, func(p *packages.Package) bool {
+		if res != nil {
+			return false
+		}
+		if p.PkgPath != pkgPath || p.Types == nil {
+			return true
+		}
+		if obj, ok := p.Types.Scope().Lookup(name).(*types.TypeName); ok {
+			res, _ = obj.Type().Underlying().(*types.Interface)
+		}
+		return false
+	}, nil)
+	return res
+}
@@ -11,7 +11,8 @@ package propagate
 
 import (
 	"encoding/json"
-	"log"
+	"path"
+	"strings"
 )
 
 // UnmarshalJSON unmarshals function replacement info from JSON byte
@@ -25,40 +26,118 @@ func (m fnReplacementInfo) UnmarshalJSON(b []byte) error {
 	for _, mapping := range data {
 		fnDesc := mapping.(map[string]interface{})
 		name := fnDesc["Name"].(string)
-		recvMapping := fnDesc["Recv"]
-		recv := getRecvStringFromJson(recvMapping)
-		callReplacement := replacementInfo{}
-		if fnDesc["NewName"] != nil {
-			callReplacement.newName = fnDesc["NewName"].(string)
-		} else {
-			callReplacement.newName = ""
-		}
-		if fnDesc["ArgPos"] != nil {
-			callReplacement.argPos = int(fnDesc["ArgPos"].(float64))
-		} else {
-			callReplacement.argPos = 1
+		recv := getRecvStringFromJson(fnDesc["Recv"])
+		mapFnToReplacementInfo(m, name, recv, getReplacementInfoFromJson(fnDesc, name))
+	}
+	return nil
+}
+
+// UnmarshalJSON unmarshals groups of functions sharing the same
+// replacement info from JSON byte data (each group is expanded into
+// individual function entries).
+func (m fnGroupReplacementInfo) UnmarshalJSON(b []byte) error {
+	var data []interface{}
+	err := json.Unmarshal(b, &data)
+	if err != nil {
+		return err
+	}
+	for _, mapping := range data {
+		groupDesc := mapping.(map[string]interface{})
+		recv := getRecvStringFromJson(groupDesc["Recv"])
+		for _, n := range groupDesc["Names"].([]interface{}) {
+			name := n.(string)
+			// each function gets its own copy of replacement info
+			mapFnToReplacementInfo(fnReplacementInfo(m), name, recv, getReplacementInfoFromJson(groupDesc, name))
 		}
-		if fnDesc["CtxImports"] != nil {
-			callReplacement.ctxImports = make(map[string]string)
-			for _, mapping := range fnDesc["CtxImports"].([]interface{}) {
-				ctxImports := mapping.(map[string]interface{})
-				impStr := ctxImports["Import"].(string)
-				if ctxImports["Alias"] == nil {
-					callReplacement.ctxImports[impStr] = ""
-				} else {
-					callReplacement.ctxImports[impStr] = ctxImports["Alias"].(string)
-				}
+	}
+	return nil
+}
+
+// getReplacementInfoFromJson computes replacement info for a function
+// with a given name from JSON representation.
+func getReplacementInfoFromJson(fnDesc map[string]interface{}, name string) *replacementInfo {
+	callReplacement := replacementInfo{}
+	if fnDesc["NewName"] != nil {
+		callReplacement.newName = fnDesc["NewName"].(string)
+	} else {
+		callReplacement.newName = ""
+	}
+	if fnDesc["ArgPos"] != nil {
+		callReplacement.argPos = int(fnDesc["ArgPos"].(float64))
+	} else {
+		callReplacement.argPos = 1
+	}
+	if fnDesc["CtxImports"] != nil {
+		callReplacement.ctxImports = make(map[string]string)
+		for _, mapping := range fnDesc["CtxImports"].([]interface{}) {
+			ctxImports := mapping.(map[string]interface{})
+			impStr := ctxImports["Import"].(string)
+			if ctxImports["Alias"] == nil {
+				callReplacement.ctxImports[impStr] = ""
+			} else {
+				callReplacement.ctxImports[impStr] = ctxImports["Alias"].(string)
 			}
-		} else {
-			callReplacement.ctxImports = nil
 		}
-		if fnDesc["CtxExpr"] != nil {
-			callReplacement.ctxRegExpr = fnDesc["CtxExpr"].(string)
-		} else {
-			callReplacement.ctxRegExpr = ""
+	} else {
+		callReplacement.ctxImports = nil
+	}
+	if fnDesc["CtxExpr"] != nil {
+		callReplacement.ctxRegExpr = fnDesc["CtxExpr"].(string)
+	} else {
+		callReplacement.ctxRegExpr = ""
+	}
+	callReplacement.ctxExpr = ""
+	if fnDesc["ApplyToResultCall"] != nil {
+		callReplacement.applyToResultCall = fnDesc["ApplyToResultCall"].(bool)
+	}
+	if fnDesc["Kind"] != nil {
+		kind := fnDesc["Kind"].(string)
+		if kind == "var" {
+			callReplacement.isVar = true
+		} else if kind != "func" {
+			fatal("unknown kind " + kind + " of function " + name + " in the config file (expected \"func\" or \"var\")")
 		}
-		callReplacement.ctxExpr = ""
-		mapFnToReplacementInfo(m, name, recv, &callReplacement)
+	}
+	if qualifier, _ := splitQualifiedName(callReplacement.newName); qualifier != "" && getQualifierImport(qualifier, callReplacement.ctxImports) == "" {
+		fatal("new name " + callReplacement.newName + " of function " + name + " in the config file is qualified with a package but there is no corresponding import")
+	}
+	return &callReplacement
+}
+
+// UnmarshalJSON unmarshals context wrapping info from JSON byte data.
+func (m ctxWrapInfo) UnmarshalJSON(b []byte) error {
+	var data []interface{}
+	err := json.Unmarshal(b, &data)
+	if err != nil {
+		return err
+	}
+	for _, mapping := range data {
+		wrapDesc := mapping.(map[string]interface{})
+		name := wrapDesc["LeafFn"].(string)
+		wrapExpr := wrapDesc["WrapExpr"].(string)
+		if !strings.Contains(wrapExpr, ctxWildcard) {
+			fatal("context wrapping expression " + wrapExpr + " for function " + name + " in the config file does not contain context wildcard " + ctxWildcard)
+		}
+		m[name] = wrapExpr
+	}
+	return nil
+}
+
+// UnmarshalJSON unmarshals artificial context expressions from JSON
+// byte data (a single expression is used as the default one).
+func (m ctxExprInfo) UnmarshalJSON(b []byte) error {
+	var expr string
+	if err := json.Unmarshal(b, &expr); err == nil {
+		m[defaultCtxReason] = expr
+		return nil
+	}
+	var data map[string]string
+	err := json.Unmarshal(b, &data)
+	if err != nil {
+		return err
+	}
+	for reason, expr := range data {
+		m[reason] = expr
 	}
 	return nil
 }
@@ -116,13 +195,13 @@ func getRecvStringFromJson(mapping interface{}) string {
 // a pointer type) qualified with package name and path.
 func getQualifiedType(orgTypName string, pkgPath string, pkgName string) string {
 	if len(orgTypName) == 0 {
-		log.Fatalf("unexpected empty type in config file")
+		fatal("unexpected empty type in config file")
 	}
 	if orgTypName[0:1] == "*" {
 		// receiver is a pointer type
 		typName := orgTypName[1:]
 		if typName[0:1] == "*" {
-			log.Fatalf("unexpected multiple level pointer type in config file")
+			fatal("unexpected multiple level pointer type in config file")
 		}
 		return "*" + pkgPath + pkgName + "." + typName
 	}
@@ -140,6 +219,19 @@ func mapTypeToPkgInfo(types typeInfo, typeName string, pkgPath string, pkgName s
 	mapPkgInfo(pkgs, pkgPath, pkgName)
 }
 
+// defaultClosureBoundaryFns returns closure-boundary functions that are
+// recognized even if not specified in the config file: errgroup's
+// methods spawning goroutines and fx's lifecycle hook constructors.
+func defaultClosureBoundaryFns() fnInfo {
+	fns := make(fnInfo)
+	errgroupRecv := getQualifiedType("*Group", "golang.org/x/sync/errgroup", "errgroup")
+	mapFnToPkgInfo(fns, "Go", errgroupRecv, "golang.org/x/sync/errgroup", "errgroup")
+	mapFnToPkgInfo(fns, "TryGo", errgroupRecv, "golang.org/x/sync/errgroup", "errgroup")
+	mapFnToPkgInfo(fns, "StartHook", "", "go.uber.org/fx", "fx")
+	mapFnToPkgInfo(fns, "StopHook", "", "go.uber.org/fx", "fx")
+	return fns
+}
+
 // mapFnToPkgInfo adds package info and receiver info to a
 // func/method->receiver->pkgInfo map.
 func mapFnToPkgInfo(fns fnInfo, fnName string, recv string, pkgPath string, pkgName string) {
@@ -167,6 +259,30 @@ func mapPkgInfo(pkgs pkgInfo, pkgPath string, pkgName string) {
 	pkgNames[pkgName] = true
 }
 
+// splitQualifiedName splits name qualified with a package alias
+// (e.g. "pkg.Fn") into the alias and the name itself (the alias is an
+// empty string for unqualified names).
+func splitQualifiedName(qualifiedName string) (string, string) {
+	ind := strings.LastIndex(qualifiedName, ".")
+	if ind < 0 {
+		return "", qualifiedName
+	}
+	return qualifiedName[:ind], qualifiedName[ind+1:]
+}
+
+// getQualifierImport returns path of the import (among imports
+// specified in the config file) that defines a given package
+// qualifier, either as an alias or as the last element of the import
+// path (empty string is returned if no such import exists).
+func getQualifierImport(qualifier string, imports map[string]string) string {
+	for imp, alias := range imports {
+		if alias == qualifier || (alias == "" && path.Base(imp) == qualifier) {
+			return imp
+		}
+	}
+	return ""
+}
+
 // mapFnToReplacementInfo adds function/method info and replacement
 // info to a func/method->receiver->replacementInfo map.
 func mapFnToReplacementInfo(fns fnReplacementInfo, fnName string, recv string, replacement *replacementInfo) {
@@ -0,0 +1,35 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package propagate
+
+import "testing"
+
+func TestLibFnGroups(t *testing.T) {
+	cfg := initialize("testdata/config/test_fn_groups.json", 0, nil)
+	if r := cfg.LibFns["A"][""]; r == nil || r.newName != "CtxA" {
+		t.Fatal("function defined outside of groups not found")
+	}
+	h := cfg.LibFns["H"]["*liblib.Rec"]
+	i := cfg.LibFns["I"]["*liblib.Rec"]
+	if h == nil || i == nil {
+		t.Fatal("functions defined in a group not found")
+	}
+	if h == i {
+		t.Error("functions defined in a group share replacement info")
+	}
+	for _, r := range []*replacementInfo{h, i} {
+		if r.newName != "" || r.argPos != 2 || r.ctxRegExpr != "<?LIB_PREF?>.Copy(ctx)" {
+			t.Errorf("unexpected replacement info %+v", *r)
+		}
+	}
+	if r := cfg.LibFns["J"][""]; r == nil || r.argPos != 1 {
+		t.Error("function defined in a group with default replacement info not found")
+	}
+}
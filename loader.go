@@ -0,0 +1,257 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package propagate
+
+import (
+	"encoding/json"
+	"errors"
+	"go/ast"
+	"go/parser"
+	"go/scanner"
+	"go/token"
+	"go/types"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// listedPackage describes a single package in the output of the "go
+// list -json" command (only fields needed to synthesize
+// packages.Package are included).
+type listedPackage struct {
+	// ImportPath is the import path of the package (for test
+	// variants it is followed by the path of the test in brackets,
+	// e.g. "p [p.test]").
+	ImportPath string
+	// Name is the package name.
+	Name string
+	// Dir is the directory containing package sources.
+	Dir string
+	// GoFiles are Go source files (relative to Dir).
+	GoFiles []string
+	// CompiledGoFiles are Go source files presented to the compiler
+	// (relative to Dir or absolute if generated by cgo) - only
+	// present if "go list" is run with the -compiled flag.
+	CompiledGoFiles []string
+	// Imports are import paths used by the package (after ImportMap
+	// is applied).
+	Imports []string
+	// ImportMap maps import paths in the source code to import paths
+	// listed in Imports (omits identity mappings).
+	ImportMap map[string]string
+	// DepOnly is true if the package is only a dependency of the
+	// packages specified on the command line.
+	DepOnly bool
+	// ForTest is the package under test if this is a test variant
+	// of a package.
+	ForTest string
+	// Error is the error loading the package (if any).
+	Error *struct {
+		Err string
+	}
+}
+
+// jsonListLoader synthesizes packages from the output of "go list
+// -json", type-checking each package from its sources.
+type jsonListLoader struct {
+	fset *token.FileSet
+	// listed are all listed packages (key is import path).
+	listed map[string]*listedPackage
+	// pkgs are packages synthesized so far (key is import path).
+	pkgs map[string]*packages.Package
+	// checking are packages currently being type-checked (used to
+	// detect import cycles).
+	checking map[string]bool
+}
+
+// loadFromJSONList synthesizes packages from a file containing the
+// output of "go list -json" (preferably run with -deps, -test and
+// -compiled flags) instead of loading them via packages.Load, which
+// allows analysis in environments where the go command cannot
+// download modules. Returned packages are those specified on the go
+// list command line, together with their test variants (just like
+// those returned by packages.Load in test mode).
+func loadFromJSONList(path string) ([]*packages.Package, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	l := &jsonListLoader{
+		fset:     token.NewFileSet(),
+		listed:   make(map[string]*listedPackage),
+		pkgs:     make(map[string]*packages.Package),
+		checking: make(map[string]bool),
+	}
+	var roots []string
+	dec := json.NewDecoder(f)
+	for {
+		lp := &listedPackage{}
+		if err := dec.Decode(lp); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, errors.New("error decoding package list " + path + ": " + err.Error())
+		}
+		l.listed[lp.ImportPath] = lp
+		if !lp.DepOnly {
+			roots = append(roots, lp.ImportPath)
+		}
+	}
+
+	var res []*packages.Package
+	for _, r := range roots {
+		p, err := l.load(r)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, p)
+	}
+	return res, nil
+}
+
+// load synthesizes a package with a given import path (and,
+// transitively, all packages it imports).
+func (l *jsonListLoader) load(importPath string) (*packages.Package, error) {
+	if p, exists := l.pkgs[importPath]; exists {
+		return p, nil
+	}
+	if l.checking[importPath] {
+		return nil, errors.New("import cycle involving package " + importPath + " in package list")
+	}
+	lp, exists := l.listed[importPath]
+	if !exists {
+		return nil, errors.New("package " + importPath + " missing from package list (go list must be run with -deps flag)")
+	}
+	l.checking[importPath] = true
+	defer delete(l.checking, importPath)
+
+	p := &packages.Package{
+		ID:      lp.ImportPath,
+		Name:    lp.Name,
+		PkgPath: strings.SplitN(lp.ImportPath, " ", 2)[0],
+		Imports: make(map[string]*packages.Package),
+		Fset:    l.fset,
+		TypesInfo: &types.Info{
+			Types:      make(map[ast.Expr]types.TypeAndValue),
+			Defs:       make(map[*ast.Ident]types.Object),
+			Uses:       make(map[*ast.Ident]types.Object),
+			Implicits:  make(map[ast.Node]types.Object),
+			Instances:  make(map[*ast.Ident]types.Instance),
+			Scopes:     make(map[ast.Node]*types.Scope),
+			Selections: make(map[*ast.SelectorExpr]*types.Selection),
+		},
+		TypesSizes: types.SizesFor("gc", runtime.GOARCH),
+	}
+	if lp.Error != nil {
+		p.Errors = append(p.Errors, packages.Error{Msg: lp.Error.Err, Kind: packages.ListError})
+	}
+	for _, imp := range lp.Imports {
+		if imp == "C" || imp == "unsafe" {
+			continue
+		}
+		dep, err := l.load(imp)
+		if err != nil {
+			return nil, err
+		}
+		p.Imports[imp] = dep
+	}
+
+	files := lp.CompiledGoFiles
+	if len(files) == 0 {
+		files = lp.GoFiles
+	}
+	for _, name := range lp.GoFiles {
+		p.GoFiles = append(p.GoFiles, filepath.Join(lp.Dir, name))
+	}
+	for _, name := range files {
+		if !filepath.IsAbs(name) {
+			name = filepath.Join(lp.Dir, name)
+		}
+		p.CompiledGoFiles = append(p.CompiledGoFiles, name)
+		f, err := parser.ParseFile(l.fset, name, nil, parser.ParseComments)
+		if err != nil {
+			var errList scanner.ErrorList
+			if errors.As(err, &errList) {
+				for _, e := range errList {
+					p.Errors = append(p.Errors, packages.Error{Pos: e.Pos.String(), Msg: e.Msg, Kind: packages.ParseError})
+				}
+			} else {
+				p.Errors = append(p.Errors, packages.Error{Msg: err.Error(), Kind: packages.ParseError})
+			}
+		}
+		if f != nil {
+			p.Syntax = append(p.Syntax, f)
+		}
+	}
+
+	tc := &types.Config{
+		Importer: importerFunc(func(path string) (*types.Package, error) {
+			if path == "unsafe" {
+				return types.Unsafe, nil
+			}
+			if mapped, exists := lp.ImportMap[path]; exists {
+				path = mapped
+			}
+			dep, exists := p.Imports[path]
+			if !exists || dep.Types == nil {
+				return nil, errors.New("package " + path + " not found in package list")
+			}
+			return dep.Types, nil
+		}),
+		Sizes: p.TypesSizes,
+		Error: func(err error) {
+			if te, ok := err.(types.Error); ok {
+				p.Errors = append(p.Errors, packages.Error{Pos: te.Fset.Position(te.Pos).String(), Msg: te.Msg, Kind: packages.TypeError})
+			} else {
+				p.Errors = append(p.Errors, packages.Error{Msg: err.Error(), Kind: packages.TypeError})
+			}
+		},
+	}
+	p.Types, _ = tc.Check(p.PkgPath, l.fset, p.Syntax, p.TypesInfo)
+	p.IllTyped = len(p.Errors) > 0
+	l.pkgs[importPath] = p
+	return p, nil
+}
+
+// importerFunc implements types.Importer using a function.
+type importerFunc func(path string) (*types.Package, error)
+
+// Import implements types.Importer.
+func (f importerFunc) Import(path string) (*types.Package, error) {
+	return f(path)
+}
+
+// appendLibPkg appends the library package to the list of packages if
+// it is only imported by listed packages rather than being one of
+// them (the library package is always loaded when leaf functions are
+// specified via an interface).
+func appendLibPkg(pkgs []*packages.Package, libPkgPath string) []*packages.Package {
+	var libPkg *packages.Package
+	packages.Visit(pkgs, func(p *packages.Package) bool {
+		if p.ID == libPkgPath {
+			libPkg = p
+		}
+		return libPkg == nil
+	}, nil)
+	if libPkg == nil {
+		return pkgs
+	}
+	for _, p := range pkgs {
+		if p == libPkg {
+			return pkgs
+		}
+	}
+	return append(pkgs, libPkg)
+}
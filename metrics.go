@@ -0,0 +1,135 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package propagate
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// metricsPrefix is the prefix of names of all exposed metrics.
+const metricsPrefix = "go_ctx_propagate_"
+
+// metrics contains metrics describing progress of the context
+// propagation process (accumulated over all configs in a chain) that
+// are served in Prometheus text format (see Options.MetricsAddr). All
+// methods can be called on a nil value in which case they do nothing.
+type metrics struct {
+	mu sync.Mutex
+	// pkgsLoaded is the number of packages loaded without errors.
+	pkgsLoaded int
+	// pkgsFailed is the number of packages that failed to load.
+	pkgsFailed int
+	// fnsModified is the number of functions whose signatures have
+	// been modified or that have context variable injected.
+	fnsModified int
+	// callsModified is the number of modified call sites.
+	callsModified int
+	// artificialCtxs is the number of functions initializing
+	// artificial context.
+	artificialCtxs int
+	// analysisDuration is time spent loading and analyzing code
+	// (or reading analysis results from a plan).
+	analysisDuration time.Duration
+	// transformDuration is time spent transforming code.
+	transformDuration time.Duration
+}
+
+// recordPackages records the numbers of packages loaded with and
+// without errors.
+func (m *metrics) recordPackages(loaded int, failed int) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pkgsLoaded += loaded
+	m.pkgsFailed += failed
+}
+
+// recordAnalysis records results of the analysis phase that took a
+// given time.
+func (m *metrics) recordAnalysis(s *stats, d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, n := range s.ArtificialCtxs {
+		m.artificialCtxs += n
+	}
+	m.analysisDuration += d
+}
+
+// recordTransform records results of the transformation phase that
+// took a given time.
+func (m *metrics) recordTransform(s *stats, d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.fnsModified += s.SignaturesModified + s.DefinitionsModified
+	m.callsModified += s.CallsModified
+	m.transformDuration += d
+}
+
+// ServeHTTP writes current values of the metrics in Prometheus text
+// format.
+func (m *metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	writeMetric(w, "packages_loaded", "Number of packages loaded for analysis.", [][2]string{
+		{`{status="ok"}`, strconv.Itoa(m.pkgsLoaded)},
+		{`{status="error"}`, strconv.Itoa(m.pkgsFailed)},
+	})
+	writeMetric(w, "functions_modified", "Number of functions whose signatures have been modified or that have context variable injected.", [][2]string{{"", strconv.Itoa(m.fnsModified)}})
+	writeMetric(w, "call_sites_modified", "Number of modified call sites.", [][2]string{{"", strconv.Itoa(m.callsModified)}})
+	writeMetric(w, "artificial_contexts", "Number of functions initializing artificial context.", [][2]string{{"", strconv.Itoa(m.artificialCtxs)}})
+	writeMetric(w, "analysis_duration_seconds", "Time spent loading and analyzing code.", [][2]string{{"", strconv.FormatFloat(m.analysisDuration.Seconds(), 'g', -1, 64)}})
+	writeMetric(w, "transform_duration_seconds", "Time spent transforming code.", [][2]string{{"", strconv.FormatFloat(m.transformDuration.Seconds(), 'g', -1, 64)}})
+}
+
+// writeMetric writes a gauge with a given name (without the common
+// prefix) and description along with its samples, each consisting of
+// labels (empty string for a gauge without labels) and a value.
+func writeMetric(w http.ResponseWriter, name string, help string, samples [][2]string) {
+	fmt.Fprintf(w, "# HELP %s%s %s\n", metricsPrefix, name, help)
+	fmt.Fprintf(w, "# TYPE %s%s gauge\n", metricsPrefix, name)
+	for _, s := range samples {
+		fmt.Fprintf(w, "%s%s%s %s\n", metricsPrefix, name, s[0], s[1])
+	}
+}
+
+// serveMetrics starts an HTTP server at a given address serving the
+// metrics at /metrics and returns a function shutting the server down
+// gracefully.
+func (m *metrics) serveMetrics(addr string) func() {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatalf("cannot start metrics server: %v", err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m)
+	srv := &http.Server{Handler: mux}
+	go srv.Serve(l)
+	return func() {
+		if err := srv.Shutdown(context.Background()); err != nil {
+			log.Printf("error shutting down metrics server: %v", err)
+		}
+	}
+}
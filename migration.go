@@ -0,0 +1,142 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package propagate
+
+import (
+	"bytes"
+	"go/ast"
+	"go/format"
+	"io/ioutil"
+	"sort"
+	"strconv"
+)
+
+// functionReport describes a function whose signature has been
+// changed (or which has received artificial context) as a result of
+// context propagation.
+type functionReport struct {
+	// pkgPath is the path of the package where the function is
+	// defined.
+	pkgPath string
+	// name is the name of the function (qualified with receiver type
+	// for methods).
+	name string
+	// file is the path of the file where the function is defined.
+	file string
+	// line is the line where the function is defined.
+	line int
+	// before is the function's signature before transformation.
+	before string
+	// after is the function's signature after transformation.
+	after string
+	// exported is true if the function is exported.
+	exported bool
+	// artificialCtx is true if the function's signature has not been
+	// changed and the function received artificial context instead.
+	artificialCtx bool
+	// ctxExpr is the expression initializing artificial context
+	// (empty if the function does not receive artificial context).
+	ctxExpr string
+}
+
+// collectOrigSignatures records signatures of all function
+// declarations in a given AST before it is transformed (needed for
+// the migration guide).
+func (cfg *transformerConfig) collectOrigSignatures(f *ast.File) {
+	cfg.origSigs = make(map[*ast.FuncDecl]string)
+	if cfg.opts.MigrationGuidePath == "" {
+		return
+	}
+	for _, d := range f.Decls {
+		if fd, ok := d.(*ast.FuncDecl); ok {
+			cfg.origSigs[fd] = cfg.fnSignature(fd)
+		}
+	}
+}
+
+// fnSignature returns string representation of a signature of a
+// given function declaration.
+func (cfg *transformerConfig) fnSignature(fd *ast.FuncDecl) string {
+	var buf bytes.Buffer
+	if err := format.Node(&buf, cfg.currentPkg.Fset, &ast.FuncDecl{Recv: fd.Recv, Name: fd.Name, Type: fd.Type}); err != nil {
+		fatal("error formatting signature of function " + fd.Name.Name + ": " + err.Error())
+	}
+	return buf.String()
+}
+
+// addFunctionReport records information about a function declaration
+// that has been modified (to be included in the migration guide).
+func (cfg *transformerConfig) addFunctionReport(fd *ast.FuncDecl, artificialCtx bool, ctxExpr string) {
+	if cfg.opts.MigrationGuidePath == "" {
+		return
+	}
+	position := cfg.currentPkg.Fset.Position(fd.Name.NamePos)
+	cfg.fnReports = append(cfg.fnReports, functionReport{
+		pkgPath:       cfg.currentPkg.PkgPath,
+		name:          cfg.fnDeclName(fd),
+		file:          cfg.relPath(position.Filename),
+		line:          position.Line,
+		before:        cfg.origSigs[fd],
+		after:         cfg.fnSignature(fd),
+		exported:      ast.IsExported(fd.Name.Name),
+		artificialCtx: artificialCtx,
+		ctxExpr:       ctxExpr,
+	})
+}
+
+// writeMigrationGuide writes a Markdown document describing all
+// functions whose signatures have been changed (or which received
+// artificial context), grouped by package.
+func (cfg *transformerConfig) writeMigrationGuide() {
+	if cfg.opts.MigrationGuidePath == "" {
+		return
+	}
+	reports := cfg.fnReports
+	sort.SliceStable(reports, func(i, j int) bool {
+		if reports[i].pkgPath != reports[j].pkgPath {
+			return reports[i].pkgPath < reports[j].pkgPath
+		}
+		if reports[i].file != reports[j].file {
+			return reports[i].file < reports[j].file
+		}
+		return reports[i].line < reports[j].line
+	})
+
+	var doc bytes.Buffer
+	doc.WriteString("# Context propagation migration guide\n\n")
+	doc.WriteString("Functions listed below have been modified to propagate context of type `" + cfg.CtxPkgPath + "." + cfg.CtxParamType + "`.\n")
+	pkgPath := ""
+	for i, r := range reports {
+		if i == 0 || r.pkgPath != pkgPath {
+			pkgPath = r.pkgPath
+			doc.WriteString("\n## Package `" + pkgPath + "`\n")
+		}
+		doc.WriteString("\n### `" + r.name + "`\n\n")
+		doc.WriteString("Defined in `" + r.file + "` (line " + strconv.Itoa(r.line) + ").\n\n")
+		if r.artificialCtx {
+			doc.WriteString("Signature (unchanged):\n\n")
+			doc.WriteString("```go\n" + r.after + "\n```\n\n")
+			doc.WriteString("> **Note:** this function receives artificial context (`" + r.ctxExpr + "`) rather than context propagated from its callers, ")
+			doc.WriteString("as its signature cannot be changed (e.g. it is a program entry point, a test function or its signature is imposed by external code). ")
+			doc.WriteString("To make it a real propagated context, replace the artificial context with context available in this function (e.g. extracted from its receiver or arguments).\n")
+			continue
+		}
+		doc.WriteString("Before:\n\n")
+		doc.WriteString("```go\n" + r.before + "\n```\n\n")
+		doc.WriteString("After:\n\n")
+		doc.WriteString("```go\n" + r.after + "\n```\n")
+		if r.exported {
+			doc.WriteString("\n> **Note:** this function is exported - callers outside of the analyzed code base may also need to be updated to pass context.\n")
+		}
+	}
+	if err := ioutil.WriteFile(cfg.opts.MigrationGuidePath, doc.Bytes(), 0644); err != nil {
+		fatal("error writing migration guide " + cfg.opts.MigrationGuidePath)
+	}
+}
@@ -0,0 +1,173 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package propagate
+
+import (
+	"bytes"
+	"go/format"
+	"go/token"
+	"go/types"
+	"golang.org/x/tools/go/packages"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// mockTypeName is the name of the generated mock context type.
+const mockTypeName = "MockContext"
+
+// generateContextMock generates a file containing mock implementation
+// of the context interface (all methods return zero values) to be
+// used in tests.
+func (cfg *config) generateContextMock() {
+	if cfg.opts.MockFilePath == "" {
+		return
+	}
+	ctxPkg := cfg.findCtxPkg()
+	if ctxPkg == nil {
+		fatal("error finding context package " + cfg.CtxPkgPath + "/" + cfg.CtxPkgName + " among loaded packages")
+	}
+	obj, ok := ctxPkg.Scope().Lookup(cfg.CtxParamType).(*types.TypeName)
+	if !ok {
+		fatal("error finding context type " + cfg.CtxParamType + " in package " + cfg.CtxPkgPath)
+	}
+	iface, ok := obj.Type().Underlying().(*types.Interface)
+	if !ok {
+		fatal("error generating mock for context type " + cfg.CtxParamType + " which is not an interface")
+	}
+
+	// packages imported by the mock file (path -> name)
+	imports := map[string]string{"testing": "testing"}
+	qualifier := func(p *types.Package) string {
+		imports[p.Path()] = p.Name()
+		return p.Name()
+	}
+	ctxTypeName := types.TypeString(obj.Type(), qualifier)
+
+	var methods bytes.Buffer
+	for i := 0; i < iface.NumMethods(); i++ {
+		m := iface.Method(i)
+		if !m.Exported() && m.Pkg() != nil {
+			fatal("error generating mock for context type " + cfg.CtxParamType + " with unexported method " + m.Name())
+		}
+		sig := m.Type().(*types.Signature)
+		methods.WriteString("\n// " + m.Name() + " implements " + ctxTypeName + ".\n")
+		methods.WriteString("func (m *" + mockTypeName + ") " + m.Name() + "(")
+		methods.WriteString(mockParams(sig, qualifier))
+		methods.WriteString(")")
+		if sig.Results().Len() > 0 {
+			methods.WriteString(" (" + mockResults(sig, qualifier) + ")")
+		}
+		methods.WriteString(" {\n\treturn\n}\n")
+	}
+
+	var src bytes.Buffer
+	src.WriteString("// Code generated by go-context-propagate. DO NOT EDIT.\n\n")
+	src.WriteString("package " + mockPkgName(cfg.opts.MockFilePath) + "\n\n")
+	src.WriteString("import (\n")
+	paths := make([]string, 0, len(imports))
+	for p := range imports {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	for _, p := range paths {
+		if imports[p] != path.Base(p) {
+			src.WriteString(imports[p] + " ")
+		}
+		src.WriteString(strconv.Quote(p) + "\n")
+	}
+	src.WriteString(")\n\n")
+	src.WriteString("// " + mockTypeName + " is a mock implementation of " + ctxTypeName + " whose methods return zero values.\n")
+	src.WriteString("type " + mockTypeName + " struct {\n")
+	src.WriteString("\t// T is the test using the mock.\n")
+	src.WriteString("\tT *testing.T\n")
+	src.WriteString("}\n\n")
+	src.WriteString("var _ " + ctxTypeName + " = (*" + mockTypeName + ")(nil)\n\n")
+	src.WriteString("// New" + mockTypeName + " creates a mock context for a given test.\n")
+	src.WriteString("func New" + mockTypeName + "(t *testing.T) *" + mockTypeName + " {\n")
+	src.WriteString("\tt.Helper()\n")
+	src.WriteString("\treturn &" + mockTypeName + "{T: t}\n")
+	src.WriteString("}\n")
+	src.Write(methods.Bytes())
+
+	formatted, err := format.Source(src.Bytes())
+	if err != nil {
+		fatal("error formatting generated context mock: " + err.Error())
+	}
+	if err := os.MkdirAll(filepath.Dir(cfg.opts.MockFilePath), 0755); err != nil {
+		fatal("error creating directory for context mock file " + cfg.opts.MockFilePath)
+	}
+	if err := ioutil.WriteFile(cfg.opts.MockFilePath, formatted, 0644); err != nil {
+		fatal("error writing context mock file " + cfg.opts.MockFilePath)
+	}
+}
+
+// findCtxPkg returns context package (or nil if the context package is
+// not among loaded packages or their dependencies).
+func (cfg *config) findCtxPkg() *types.Package {
+	var ctxPkg *types.Package
+	packages.Visit(cfg.initial, func(p *packages.Package) bool {
+		if p.Types != nil && p.Types.Path() == cfg.CtxPkgPath && p.Types.Name() == cfg.CtxPkgName {
+			ctxPkg = p.Types
+		}
+		return ctxPkg == nil
+	}, nil)
+	return ctxPkg
+}
+
+// mockParams returns parameter list of a mock method (all parameters
+// are unnamed as they are never used).
+func mockParams(sig *types.Signature, qualifier types.Qualifier) string {
+	params := sig.Params()
+	var res []string
+	for i := 0; i < params.Len(); i++ {
+		t := params.At(i).Type()
+		if sig.Variadic() && i == params.Len()-1 {
+			res = append(res, "_ ..."+types.TypeString(t.(*types.Slice).Elem(), qualifier))
+		} else {
+			res = append(res, "_ "+types.TypeString(t, qualifier))
+		}
+	}
+	return strings.Join(res, ", ")
+}
+
+// mockResults returns named result list of a mock method (results are
+// named so that they can be returned as zero values).
+func mockResults(sig *types.Signature, qualifier types.Qualifier) string {
+	results := sig.Results()
+	var res []string
+	for i := 0; i < results.Len(); i++ {
+		res = append(res, "r"+strconv.Itoa(i)+" "+types.TypeString(results.At(i).Type(), qualifier))
+	}
+	return strings.Join(res, ", ")
+}
+
+// mockPkgName returns name of the package for the mock file, derived
+// from the name of the directory where the file is placed.
+func mockPkgName(filePath string) string {
+	absPath, err := filepath.Abs(filePath)
+	if err != nil {
+		fatal("error computing absolute path for context mock file " + filePath)
+	}
+	name := []rune(filepath.Base(filepath.Dir(absPath)))
+	for i, r := range name {
+		if !(r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || i > 0 && r >= '0' && r <= '9') {
+			name[i] = '_'
+		}
+	}
+	if !token.IsIdentifier(string(name)) {
+		return "mock"
+	}
+	return string(name)
+}
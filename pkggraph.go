@@ -0,0 +1,160 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package propagate
+
+import (
+	"bytes"
+	"fmt"
+	"golang.org/x/tools/go/packages"
+	"golang.org/x/tools/go/ssa"
+	"io/ioutil"
+	"sort"
+	"strconv"
+)
+
+// Colors of packages in the package graph depending on the fraction of
+// package's functions modified during context propagation.
+const (
+	pkgGraphColorNone   = "white"
+	pkgGraphColorLow    = "palegreen"
+	pkgGraphColorMedium = "gold"
+	pkgGraphColorHigh   = "tomato"
+)
+
+// pkgGraphNode describes a package in the package graph.
+type pkgGraphNode struct {
+	// fnsModified is the number of package's functions modified
+	// during context propagation.
+	fnsModified int
+	// fnsTotal is the number of all package's functions.
+	fnsTotal int
+}
+
+// writePackageGraph writes a graph (in the DOT format) of packages
+// involved in context propagation. An edge from package A to package
+// B means that package A (importing package B) has call sites modified
+// to pass context to functions of package B modified to take it. Each
+// package is annotated with the number of its functions modified
+// during context propagation and colored according to the fraction of
+// its functions that have been modified.
+func (cfg *analyzerConfig) writePackageGraph() {
+	if cfg.opts.PackageGraphPath == "" {
+		return
+	}
+	imports := make(map[string]map[string]bool)
+	packages.Visit(cfg.initial, nil, func(p *packages.Package) {
+		if imports[p.PkgPath] == nil {
+			imports[p.PkgPath] = make(map[string]bool)
+		}
+		for path := range p.Imports {
+			imports[p.PkgPath][path] = true
+		}
+	})
+
+	nodes := make(map[string]*pkgGraphNode)
+	// the same function may be represented multiple times in
+	// package variants
+	fnsSeen := make(map[uniquePosInfo]bool)
+	edges := make(map[[2]string]map[uniquePosInfo]bool)
+	for f, n := range cfg.graph.Nodes {
+		if f == nil || f.Pkg == nil || f.Synthetic != "" || !f.Pos().IsValid() {
+			continue
+		}
+		pkgPath := getFnPkgPath(f)
+		if cfg.isPkgExternal(pkgPath) {
+			continue
+		}
+		uniquePos := cfg.getUniquePosSSAFn(f, f.Pos())
+		if !fnsSeen[uniquePos] {
+			fnsSeen[uniquePos] = true
+			node, exists := nodes[pkgPath]
+			if !exists {
+				node = &pkgGraphNode{}
+				nodes[pkgPath] = node
+			}
+			node.fnsTotal++
+			if cfg.isFnModified(f) {
+				node.fnsModified++
+			}
+		}
+		for _, out := range n.Out {
+			calleePkgPath := getFnPkgPath(out.Callee.Func)
+			if calleePkgPath == pkgPath || !imports[pkgPath][calleePkgPath] || cfg.isPkgExternal(calleePkgPath) {
+				continue
+			}
+			siteUniquePos := cfg.getUniquePosCallSite(out)
+			if cfg.callSites[siteUniquePos] == nil || !cfg.isFnModified(out.Callee.Func) {
+				continue
+			}
+			e := [2]string{pkgPath, calleePkgPath}
+			if edges[e] == nil {
+				edges[e] = make(map[uniquePosInfo]bool)
+			}
+			edges[e][siteUniquePos] = true
+		}
+	}
+
+	var pkgPaths []string
+	for pkgPath, node := range nodes {
+		if node.fnsModified > 0 {
+			pkgPaths = append(pkgPaths, pkgPath)
+		}
+	}
+	sort.Strings(pkgPaths)
+	var edgeKeys [][2]string
+	for e := range edges {
+		edgeKeys = append(edgeKeys, e)
+	}
+	sort.Slice(edgeKeys, func(i, j int) bool {
+		if edgeKeys[i][0] != edgeKeys[j][0] {
+			return edgeKeys[i][0] < edgeKeys[j][0]
+		}
+		return edgeKeys[i][1] < edgeKeys[j][1]
+	})
+
+	var buf bytes.Buffer
+	fmt.Fprintln(&buf, "digraph pkggraph {")
+	for _, pkgPath := range pkgPaths {
+		node := nodes[pkgPath]
+		label := pkgPath + "\n(" + strconv.Itoa(node.fnsModified) + " of " + strconv.Itoa(node.fnsTotal) + " functions modified)"
+		fmt.Fprintln(&buf, "\t"+strconv.Quote(pkgPath)+" [label="+strconv.Quote(label)+", style=filled, fillcolor="+strconv.Quote(getPkgGraphColor(node))+"];")
+	}
+	for _, e := range edgeKeys {
+		fmt.Fprintln(&buf, "\t"+strconv.Quote(e[0])+" -> "+strconv.Quote(e[1])+" [label="+strconv.Quote(strconv.Itoa(len(edges[e]))+" call sites")+"];")
+	}
+	fmt.Fprintln(&buf, "}")
+	if err := ioutil.WriteFile(cfg.opts.PackageGraphPath, buf.Bytes(), 0644); err != nil {
+		fatal("error writing package graph " + cfg.opts.PackageGraphPath)
+	}
+}
+
+// isFnModified determines if a given function is modified during
+// context propagation (to take context parameter or to initialize
+// context variable).
+func (cfg *analyzerConfig) isFnModified(f *ssa.Function) bool {
+	fnType, exists := cfg.fnVisited[cfg.getUniquePosSSAFn(f, f.Pos())]
+	return exists && (fnType == regularFn || fnType == freshCtxFn)
+}
+
+// getPkgGraphColor returns the color of a given package in the package
+// graph depending on the fraction of package's functions modified
+// during context propagation.
+func getPkgGraphColor(node *pkgGraphNode) string {
+	if node.fnsModified == 0 || node.fnsTotal == 0 {
+		return pkgGraphColorNone
+	}
+	density := float64(node.fnsModified) / float64(node.fnsTotal)
+	if density < 0.25 {
+		return pkgGraphColorLow
+	} else if density < 0.5 {
+		return pkgGraphColorMedium
+	}
+	return pkgGraphColorHigh
+}
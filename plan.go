@@ -0,0 +1,522 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package propagate
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"io/ioutil"
+	"sort"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// Plan contains results of the analysis phase so that the
+// transformation phase can be performed separately (see
+// Options.EmitPlanPath and Options.ApplyPlanPath) and so that custom
+// analyses can check it (see Options.OnPlanReady). Positions are
+// represented by file paths and offsets, which remain valid as long
+// as files do not change (this is verified using file hashes).
+type Plan struct {
+	// Files are files that can be transformed when the plan is
+	// applied.
+	Files []PlanFile
+	// FnVisited are functions that need rewriting (with their kind).
+	FnVisited []PlanIntEntry
+	// CtxParamNames are names of context parameters (or variables)
+	// different from the default one.
+	CtxParamNames []PlanStrEntry
+	// CtxInitExprs are expressions initializing context variables.
+	CtxInitExprs []PlanStrEntry
+	// CtxInitReasons are reasons for initializing artificial context
+	// variables that have their own expressions.
+	CtxInitReasons []PlanStrEntry
+	// CallSites are call sites that need an extra context argument.
+	CallSites []PlanCallSite
+	// CallSitesRenamed are call sites whose function names need to
+	// be renamed (with new names).
+	CallSitesRenamed []PlanStrEntry
+	// FnParamsVisited are parameters of function types that need
+	// context parameter.
+	FnParamsVisited []PlanPos
+	// FnFieldsVisited are struct fields of function types that need
+	// context parameter.
+	FnFieldsVisited []PlanPos
+	// RenameParamsVisited are unnamed (or blank) context parameters
+	// that need to be named.
+	RenameParamsVisited []PlanPos
+	// ClosureArgs are arguments of calls to closure-boundary
+	// functions that need to be wrapped in closures forwarding
+	// context.
+	ClosureArgs []PlanClosureArg
+	// CarrierTypes are context carrier types that need the context
+	// field.
+	CarrierTypes []PlanPos
+	// CarrierCtors are constructors of context carrier types (with
+	// names of context parameters initializing the context field).
+	CarrierCtors []PlanStrEntry
+}
+
+// PlanFile describes a file that can be transformed when the plan is
+// applied.
+type PlanFile struct {
+	// Path is the path of the file (relative to the file prefix if it
+	// is known).
+	Path string
+	// Hash is the SHA-256 hash of the file's content at the time the
+	// plan was made.
+	Hash string
+}
+
+// PlanPos represents position in the plan.
+type PlanPos struct {
+	// File is the path of the file (relative to the file prefix if
+	// it is known).
+	File string
+	// Offset is the offset of the position in the file.
+	Offset int
+	// Line is the line of the position (for informational purposes
+	// only).
+	Line int
+	// Symbol is the name of the top-level symbol (function or type)
+	// containing the position (for informational purposes only).
+	Symbol string `json:",omitempty"`
+}
+
+// PlanIntEntry associates an integer value with a position.
+type PlanIntEntry struct {
+	Pos   PlanPos
+	Value int
+}
+
+// PlanStrEntry associates a string value with a position.
+type PlanStrEntry struct {
+	Pos   PlanPos
+	Value string
+}
+
+// PlanCallSite describes call site that needs an extra context
+// argument.
+type PlanCallSite struct {
+	Pos PlanPos
+	// Invalid is true if call site receives "invalid" context (whose
+	// expression depends on imports of the file containing the call
+	// site).
+	Invalid bool `json:",omitempty"`
+	// InvalidReason is the reason for passing "invalid" context if
+	// it has its own expression (optional).
+	InvalidReason string `json:",omitempty"`
+	// Replacement describes how the call site is to be rewritten
+	// (unless Invalid is true).
+	Replacement *PlanReplacement `json:",omitempty"`
+}
+
+// PlanReplacement is a serializable representation of
+// replacementInfo.
+type PlanReplacement struct {
+	NewName           string            `json:",omitempty"`
+	ArgPos            int               `json:",omitempty"`
+	CtxImports        map[string]string `json:",omitempty"`
+	CtxRegExpr        string            `json:",omitempty"`
+	CtxExpr           string            `json:",omitempty"`
+	IsVar             bool              `json:",omitempty"`
+	ApplyToResultCall bool              `json:",omitempty"`
+	CtxWrapExpr       string            `json:",omitempty"`
+}
+
+// PlanClosureArg describes argument of a call to closure-boundary
+// function that needs to be wrapped in a closure forwarding context.
+type PlanClosureArg struct {
+	Pos      PlanPos
+	ArgIndex int
+	CtxExpr  string
+}
+
+// planFileInfo is information about a loaded file used to translate
+// positions to and from their plan representation.
+type planFileInfo struct {
+	fset *token.FileSet
+	tf   *token.File
+	f    *ast.File
+}
+
+// collectPlanFiles maps (relative) paths of all files of loaded
+// packages and of their dependencies to information about these files.
+func (cfg *config) collectPlanFiles() map[string]planFileInfo {
+	files := make(map[string]planFileInfo)
+	packages.Visit(cfg.initial, nil, func(p *packages.Package) {
+		for _, f := range p.Syntax {
+			tf := p.Fset.File(f.Pos())
+			if tf == nil {
+				continue
+			}
+			files[cfg.relPath(tf.Name())] = planFileInfo{p.Fset, tf, f}
+		}
+	})
+	return files
+}
+
+// hashFile returns SHA-256 hash of a given file's content (taking
+// the overlay into account).
+func hashFile(path string, overlay map[string][]byte) (string, error) {
+	src, exists := overlay[path]
+	if !exists {
+		var err error
+		src, err = ioutil.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+	}
+	sum := sha256.Sum256(src)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// emitPlan writes results of the analysis phase to the plan file.
+func (cfg *analyzerConfig) emitPlan(overlay map[string][]byte) {
+	p := cfg.buildPlan(overlay)
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		fatal("error encoding plan: " + err.Error())
+	}
+	if err := ioutil.WriteFile(cfg.opts.EmitPlanPath, data, 0644); err != nil {
+		fatal("error writing plan " + cfg.opts.EmitPlanPath)
+	}
+}
+
+// buildPlan returns results of the analysis phase represented as a
+// plan.
+func (cfg *analyzerConfig) buildPlan(overlay map[string][]byte) *Plan {
+	files := cfg.collectPlanFiles()
+	p := &Plan{}
+	visitedFiles := make(map[string]bool)
+	for _, pkg := range cfg.initial {
+		if cfg.isPkgExternal(pkg.PkgPath) {
+			continue
+		}
+		for _, path := range pkg.CompiledGoFiles {
+			if visitedFiles[path] {
+				continue
+			}
+			visitedFiles[path] = true
+			hash, err := hashFile(path, overlay)
+			if err != nil {
+				fatal("error reading file " + path + ": " + err.Error())
+			}
+			p.Files = append(p.Files, PlanFile{cfg.relPath(path), hash})
+		}
+	}
+
+	toPlanPos := func(uniquePos uniquePosInfo) (PlanPos, bool) {
+		if uniquePos.syntheticID != 0 || !uniquePos.pos.IsValid() {
+			// function with no position in the source code
+			return PlanPos{}, false
+		}
+		fset := uniquePos.fset
+		if fset == nil {
+			fset = cfg.prog.Fset
+		}
+		position := fset.Position(uniquePos.pos)
+		info, exists := files[cfg.relPath(position.Filename)]
+		if !exists {
+			return PlanPos{}, false
+		}
+		return PlanPos{cfg.relPath(position.Filename), position.Offset, position.Line, declSymbolAt(info.f, uniquePos.pos)}, true
+	}
+	for uniquePos, fnType := range cfg.fnVisited {
+		if pp, ok := toPlanPos(uniquePos); ok {
+			p.FnVisited = append(p.FnVisited, PlanIntEntry{pp, fnType})
+		}
+	}
+	for uniquePos, name := range cfg.ctxParamNames {
+		if pp, ok := toPlanPos(uniquePos); ok {
+			p.CtxParamNames = append(p.CtxParamNames, PlanStrEntry{pp, name})
+		}
+	}
+	for uniquePos, expr := range cfg.ctxInitExprs {
+		if pp, ok := toPlanPos(uniquePos); ok {
+			p.CtxInitExprs = append(p.CtxInitExprs, PlanStrEntry{pp, expr})
+		}
+	}
+	for uniquePos, reason := range cfg.ctxInitReasons {
+		if pp, ok := toPlanPos(uniquePos); ok {
+			p.CtxInitReasons = append(p.CtxInitReasons, PlanStrEntry{pp, reason})
+		}
+	}
+	for uniquePos, r := range cfg.callSites {
+		pp, ok := toPlanPos(uniquePos)
+		if !ok {
+			continue
+		}
+		if r == &cfg.nilCallReplacement {
+			p.CallSites = append(p.CallSites, PlanCallSite{Pos: pp, Invalid: true})
+			continue
+		}
+		if reason := cfg.getNilCallReason(r); reason != "" {
+			p.CallSites = append(p.CallSites, PlanCallSite{Pos: pp, Invalid: true, InvalidReason: reason})
+			continue
+		}
+		p.CallSites = append(p.CallSites, PlanCallSite{Pos: pp, Replacement: &PlanReplacement{r.newName, r.argPos, r.ctxImports, r.ctxRegExpr, r.ctxExpr, r.isVar, r.applyToResultCall, r.ctxWrapExpr}})
+	}
+	for uniquePos, name := range cfg.callSitesRenamed {
+		if pp, ok := toPlanPos(uniquePos); ok {
+			p.CallSitesRenamed = append(p.CallSitesRenamed, PlanStrEntry{pp, name})
+		}
+	}
+	for uniquePos, visited := range cfg.fnParamsVisited {
+		if pp, ok := toPlanPos(uniquePos); ok && visited {
+			p.FnParamsVisited = append(p.FnParamsVisited, pp)
+		}
+	}
+	for uniquePos, visited := range cfg.fnFieldsVisited {
+		if pp, ok := toPlanPos(uniquePos); ok && visited {
+			p.FnFieldsVisited = append(p.FnFieldsVisited, pp)
+		}
+	}
+	for uniquePos, visited := range cfg.renameParamsVisited {
+		if pp, ok := toPlanPos(uniquePos); ok && visited {
+			p.RenameParamsVisited = append(p.RenameParamsVisited, pp)
+		}
+	}
+	for uniquePos, args := range cfg.closureArgs {
+		if pp, ok := toPlanPos(uniquePos); ok {
+			for ind, expr := range args {
+				p.ClosureArgs = append(p.ClosureArgs, PlanClosureArg{pp, ind, expr})
+			}
+		}
+	}
+	for uniquePos, needed := range cfg.carrierTypes {
+		if pp, ok := toPlanPos(uniquePos); ok && needed {
+			p.CarrierTypes = append(p.CarrierTypes, pp)
+		}
+	}
+	for uniquePos, name := range cfg.carrierCtors {
+		if pp, ok := toPlanPos(uniquePos); ok {
+			p.CarrierCtors = append(p.CarrierCtors, PlanStrEntry{pp, name})
+		}
+	}
+	p.sort()
+	return p
+}
+
+// sort sorts plan entries by position so that the plan is
+// deterministic (and easier to review).
+func (p *Plan) sort() {
+	less := func(p1 PlanPos, p2 PlanPos) bool {
+		if p1.File != p2.File {
+			return p1.File < p2.File
+		}
+		return p1.Offset < p2.Offset
+	}
+	sort.Slice(p.Files, func(i, j int) bool { return p.Files[i].Path < p.Files[j].Path })
+	sort.Slice(p.FnVisited, func(i, j int) bool { return less(p.FnVisited[i].Pos, p.FnVisited[j].Pos) })
+	sort.Slice(p.CtxParamNames, func(i, j int) bool { return less(p.CtxParamNames[i].Pos, p.CtxParamNames[j].Pos) })
+	sort.Slice(p.CtxInitExprs, func(i, j int) bool { return less(p.CtxInitExprs[i].Pos, p.CtxInitExprs[j].Pos) })
+	sort.Slice(p.CtxInitReasons, func(i, j int) bool { return less(p.CtxInitReasons[i].Pos, p.CtxInitReasons[j].Pos) })
+	sort.Slice(p.CallSites, func(i, j int) bool { return less(p.CallSites[i].Pos, p.CallSites[j].Pos) })
+	sort.Slice(p.CallSitesRenamed, func(i, j int) bool { return less(p.CallSitesRenamed[i].Pos, p.CallSitesRenamed[j].Pos) })
+	sort.Slice(p.FnParamsVisited, func(i, j int) bool { return less(p.FnParamsVisited[i], p.FnParamsVisited[j]) })
+	sort.Slice(p.FnFieldsVisited, func(i, j int) bool { return less(p.FnFieldsVisited[i], p.FnFieldsVisited[j]) })
+	sort.Slice(p.RenameParamsVisited, func(i, j int) bool { return less(p.RenameParamsVisited[i], p.RenameParamsVisited[j]) })
+	sort.Slice(p.ClosureArgs, func(i, j int) bool {
+		if p.ClosureArgs[i].Pos != p.ClosureArgs[j].Pos {
+			return less(p.ClosureArgs[i].Pos, p.ClosureArgs[j].Pos)
+		}
+		return p.ClosureArgs[i].ArgIndex < p.ClosureArgs[j].ArgIndex
+	})
+	sort.Slice(p.CarrierTypes, func(i, j int) bool { return less(p.CarrierTypes[i], p.CarrierTypes[j]) })
+	sort.Slice(p.CarrierCtors, func(i, j int) bool { return less(p.CarrierCtors[i].Pos, p.CarrierCtors[j].Pos) })
+}
+
+// applyPlan reads results of the analysis phase from the plan file
+// (instead of performing the analysis). Files that have changed since
+// the plan was made are not transformed.
+func (cfg *config) applyPlan(overlay map[string][]byte) {
+	data, err := ioutil.ReadFile(cfg.opts.ApplyPlanPath)
+	if err != nil {
+		fatal("error reading plan " + cfg.opts.ApplyPlanPath)
+	}
+	var p Plan
+	if err := json.Unmarshal(data, &p); err != nil {
+		fatal("error decoding plan " + cfg.opts.ApplyPlanPath + ": " + err.Error())
+	}
+	cfg.onPlanReady(&p)
+	cfg.loadPlan(&p, overlay)
+}
+
+// onPlanReady passes a given plan to the hook specified in the options
+// (if any) before it is applied and aborts the process if the hook
+// returns an error.
+func (cfg *config) onPlanReady(p *Plan) {
+	if cfg.opts.OnPlanReady == nil {
+		return
+	}
+	if err := cfg.opts.OnPlanReady(p); err != nil {
+		fatal("plan rejected: " + err.Error())
+	}
+}
+
+// loadPlan replaces results of the analysis phase with the ones
+// represented by a given plan. Files that have changed since the plan
+// was made are not transformed.
+func (cfg *config) loadPlan(p *Plan, overlay map[string][]byte) {
+	cfg.fnVisited = make(map[uniquePosInfo]int)
+	cfg.ctxParamNames = make(map[uniquePosInfo]string)
+	cfg.ctxInitExprs = make(map[uniquePosInfo]string)
+	cfg.ctxInitReasons = make(map[uniquePosInfo]string)
+	cfg.callSites = make(map[uniquePosInfo]*replacementInfo)
+	cfg.callSitesRenamed = make(map[uniquePosInfo]string)
+	cfg.fnParamsVisited = make(map[uniquePosInfo]bool)
+	cfg.fnFieldsVisited = make(map[uniquePosInfo]bool)
+	cfg.renameParamsVisited = make(map[uniquePosInfo]bool)
+	cfg.closureArgs = make(map[uniquePosInfo]map[int]string)
+	cfg.carrierTypes = make(map[uniquePosInfo]bool)
+	cfg.carrierCtors = make(map[uniquePosInfo]string)
+
+	hashes := make(map[string]string)
+	for _, f := range p.Files {
+		hashes[f.Path] = f.Hash
+	}
+	for _, pkg := range cfg.initial {
+		if cfg.isPkgExternal(pkg.PkgPath) {
+			continue
+		}
+		for _, path := range pkg.CompiledGoFiles {
+			hash, err := hashFile(path, overlay)
+			if err != nil {
+				fatal("error reading file " + path + ": " + err.Error())
+			}
+			if planHash, exists := hashes[cfg.relPath(path)]; !exists || planHash != hash {
+				cfg.staleFiles[path] = true
+			}
+		}
+	}
+
+	files := cfg.collectPlanFiles()
+	fromPlanPos := func(pp PlanPos) (uniquePosInfo, bool) {
+		info, exists := files[pp.File]
+		if !exists || cfg.staleFiles[info.tf.Name()] || pp.Offset > info.tf.Size() {
+			// file not loaded or changed since the plan was made
+			return uniquePosInfo{}, false
+		}
+		uniquePos := uniquePosInfo{pos: info.tf.Pos(pp.Offset)}
+		if cfg.largeCode {
+			uniquePos.fset = info.fset
+		}
+		return uniquePos, true
+	}
+	for _, e := range p.FnVisited {
+		if uniquePos, ok := fromPlanPos(e.Pos); ok {
+			cfg.fnVisited[uniquePos] = e.Value
+		}
+	}
+	for _, e := range p.CtxParamNames {
+		if uniquePos, ok := fromPlanPos(e.Pos); ok {
+			cfg.ctxParamNames[uniquePos] = e.Value
+		}
+	}
+	for _, e := range p.CtxInitExprs {
+		if uniquePos, ok := fromPlanPos(e.Pos); ok {
+			cfg.ctxInitExprs[uniquePos] = e.Value
+		}
+	}
+	for _, e := range p.CtxInitReasons {
+		if uniquePos, ok := fromPlanPos(e.Pos); ok {
+			cfg.ctxInitReasons[uniquePos] = e.Value
+		}
+	}
+	for _, e := range p.CallSites {
+		uniquePos, ok := fromPlanPos(e.Pos)
+		if !ok {
+			continue
+		}
+		if e.Invalid || e.Replacement == nil {
+			cfg.callSites[uniquePos] = cfg.getNilCallReplacement(e.InvalidReason)
+			continue
+		}
+		r := e.Replacement
+		cfg.callSites[uniquePos] = &replacementInfo{r.NewName, r.ArgPos, r.CtxImports, r.CtxRegExpr, r.CtxExpr, r.IsVar, r.ApplyToResultCall, r.CtxWrapExpr}
+	}
+	for _, e := range p.CallSitesRenamed {
+		if uniquePos, ok := fromPlanPos(e.Pos); ok {
+			cfg.callSitesRenamed[uniquePos] = e.Value
+		}
+	}
+	for _, pp := range p.FnParamsVisited {
+		if uniquePos, ok := fromPlanPos(pp); ok {
+			cfg.fnParamsVisited[uniquePos] = true
+		}
+	}
+	for _, pp := range p.FnFieldsVisited {
+		if uniquePos, ok := fromPlanPos(pp); ok {
+			cfg.fnFieldsVisited[uniquePos] = true
+		}
+	}
+	for _, pp := range p.RenameParamsVisited {
+		if uniquePos, ok := fromPlanPos(pp); ok {
+			cfg.renameParamsVisited[uniquePos] = true
+		}
+	}
+	for _, e := range p.ClosureArgs {
+		uniquePos, ok := fromPlanPos(e.Pos)
+		if !ok {
+			continue
+		}
+		if cfg.closureArgs[uniquePos] == nil {
+			cfg.closureArgs[uniquePos] = make(map[int]string)
+		}
+		cfg.closureArgs[uniquePos][e.ArgIndex] = e.CtxExpr
+	}
+	for _, pp := range p.CarrierTypes {
+		if uniquePos, ok := fromPlanPos(pp); ok {
+			cfg.carrierTypes[uniquePos] = true
+		}
+	}
+	for _, e := range p.CarrierCtors {
+		if uniquePos, ok := fromPlanPos(e.Pos); ok {
+			cfg.carrierCtors[uniquePos] = e.Value
+		}
+	}
+}
+
+// declSymbolAt returns name of the top-level symbol (function or
+// type) containing a given position (empty if there is no such
+// symbol).
+func declSymbolAt(f *ast.File, pos token.Pos) string {
+	for _, d := range f.Decls {
+		if pos < d.Pos() || pos >= d.End() {
+			continue
+		}
+		switch d := d.(type) {
+		case *ast.FuncDecl:
+			return fnDeclSymbol(d)
+		case *ast.GenDecl:
+			for _, s := range d.Specs {
+				if ts, ok := s.(*ast.TypeSpec); ok && pos >= ts.Pos() && pos < ts.End() {
+					return ts.Name.Name
+				}
+			}
+		}
+		return ""
+	}
+	return ""
+}
+
+// fnDeclSymbol returns name of a given function declaration
+// (qualified with receiver type for methods).
+func fnDeclSymbol(fd *ast.FuncDecl) string {
+	if fd.Recv != nil && len(fd.Recv.List) > 0 {
+		return "(" + types.ExprString(fd.Recv.List[0].Type) + ")." + fd.Name.Name
+	}
+	return fd.Name.Name
+}
@@ -0,0 +1,482 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package propagate
+
+import (
+	"bytes"
+	"errors"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// sourceEdit represents replacement of a range of the original source
+// code (insertion if the range is empty) with new text.
+type sourceEdit struct {
+	start int
+	end   int
+	text  string
+}
+
+// sourceDiffer computes edits that turn the original source code of a
+// file into the source code of the transformed AST of this file.
+type sourceDiffer struct {
+	// src is the original source code.
+	src []byte
+	// origFile is a token file of the AST parsed from the original
+	// source code.
+	origFile *token.File
+	// fset is a file set of the transformed AST.
+	fset *token.FileSet
+	// modFile is a token file of the transformed AST - positions in
+	// other token files (e.g. borrowed from other ASTs) do not
+	// identify nodes of the original source code.
+	modFile *token.File
+	// origComments are comments of the original AST.
+	origComments []*ast.CommentGroup
+	// comments are comments of the transformed AST.
+	comments []*ast.CommentGroup
+	// edits are edits computed so far.
+	edits []sourceEdit
+}
+
+// errNotEditable signals that differences between the original and
+// the transformed node cannot be expressed as edits of this node's
+// source code (the enclosing node has to be edited instead).
+var errNotEditable = errors.New("node cannot be edited")
+
+// ignoredFields are AST node fields ignored when comparing the
+// original and the transformed AST (they either do not represent
+// source code or are never modified by the transformation).
+var ignoredFields = map[string]bool{
+	"Obj":        true,
+	"Scope":      true,
+	"Imports":    true,
+	"Unresolved": true,
+	"Comments":   true,
+	"Doc":        true,
+	"Comment":    true,
+}
+
+var posType = reflect.TypeOf(token.NoPos)
+var nodeType = reflect.TypeOf((*ast.Node)(nil)).Elem()
+var funcDeclType = reflect.TypeOf(ast.FuncDecl{})
+
+var printerConfig = &printer.Config{Mode: printer.UseSpaces | printer.TabIndent, Tabwidth: 8}
+
+// formatPreserving returns source code of the transformed AST of a
+// file computed by applying minimal edits to the original source code
+// (src) of this file, so that formatting and comments of the code that has
+// not been transformed are preserved.
+func formatPreserving(fset *token.FileSet, f *ast.File, filePath string, src []byte) ([]byte, error) {
+	origFset := token.NewFileSet()
+	orig, err := parser.ParseFile(origFset, filePath, src, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+	d := &sourceDiffer{
+		src:      src,
+		origFile: origFset.File(orig.Pos()),
+		fset:     fset,
+		modFile:  fset.File(f.Pos()),
+		comments: f.Comments,
+
+		origComments: orig.Comments,
+	}
+	if err := d.diffNode(orig, f, false); err != nil {
+		return nil, err
+	}
+	res, err := d.apply()
+	if err != nil {
+		return nil, err
+	}
+	// make sure that edits did not break the code
+	if _, err := parser.ParseFile(token.NewFileSet(), filePath, res, parser.ParseComments); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// apply applies computed edits to the original source code.
+func (d *sourceDiffer) apply() ([]byte, error) {
+	// stable sort to preserve order of insertions at the same offset
+	sort.SliceStable(d.edits, func(i, j int) bool {
+		return d.edits[i].start < d.edits[j].start
+	})
+	var buf bytes.Buffer
+	last := 0
+	for _, e := range d.edits {
+		if e.start < last {
+			return nil, errors.New("overlapping source code edits")
+		}
+		buf.Write(d.src[last:e.start])
+		buf.WriteString(e.text)
+		last = e.end
+	}
+	buf.Write(d.src[last:])
+	return buf.Bytes(), nil
+}
+
+// origOffset returns offset of a position in the original source
+// code.
+func (d *sourceDiffer) origOffset(pos token.Pos) int {
+	return d.origFile.Offset(pos)
+}
+
+// modOffset returns offset in the original source code of a position
+// in the transformed AST or -1 if the position does not come from the
+// original source code.
+func (d *sourceDiffer) modOffset(pos token.Pos) int {
+	if !pos.IsValid() || d.fset.File(pos) != d.modFile {
+		return -1
+	}
+	return d.modFile.Offset(pos)
+}
+
+// indent returns indentation of the line in the original source code
+// where a given node starts.
+func (d *sourceDiffer) indent(n ast.Node) string {
+	start := d.origOffset(n.Pos())
+	for start > 0 && d.src[start-1] != '\n' {
+		start--
+	}
+	end := start
+	for end < len(d.src) && (d.src[end] == ' ' || d.src[end] == '\t') {
+		end++
+	}
+	return string(d.src[start:end])
+}
+
+// diffNode computes edits turning the original node into the
+// transformed one, either by editing the node's children or (if
+// replaceable is true) by replacing the whole node.
+func (d *sourceDiffer) diffNode(o ast.Node, m ast.Node, replaceable bool) error {
+	ov := reflect.ValueOf(o)
+	mv := reflect.ValueOf(m)
+	if equalValues(ov, mv) {
+		return nil
+	}
+	if ov.Type() == mv.Type() && ov.Kind() == reflect.Ptr && ov.Elem().Kind() == reflect.Struct {
+		editsNum := len(d.edits)
+		if err := d.diffChildren(ov.Elem(), mv.Elem()); err == nil {
+			return nil
+		}
+		// discard partial edits
+		d.edits = d.edits[:editsNum]
+	}
+	if !replaceable {
+		return errNotEditable
+	}
+	start := d.origOffset(o.Pos())
+	end := d.origOffset(o.End())
+	// comments inside of the replaced source code range
+	var comments []*ast.CommentGroup
+	for _, c := range d.comments {
+		if offset := d.modOffset(c.Pos()); offset >= start && offset < end {
+			comments = append(comments, c)
+		}
+	}
+	text, err := d.print(m, d.indent(o), comments)
+	if err != nil {
+		return err
+	}
+	d.edits = append(d.edits, sourceEdit{start: start, end: end, text: text})
+	return nil
+}
+
+// diffChildren computes edits turning children of the original node
+// into children of the transformed node.
+func (d *sourceDiffer) diffChildren(o reflect.Value, m reflect.Value) error {
+	for i := 0; i < o.NumField(); i++ {
+		field := o.Type().Field(i)
+		if ignoredFields[field.Name] || field.Type == posType || field.PkgPath != "" {
+			continue
+		}
+		of := o.Field(i)
+		mf := m.Field(i)
+		switch {
+		case field.Type.Implements(nodeType):
+			if of.IsNil() || mf.IsNil() {
+				if of.IsNil() && mf.IsNil() {
+					continue
+				}
+				return errNotEditable
+			}
+			// function type of a function declaration spans
+			// declaration's receiver and name so it cannot be
+			// replaced on its own
+			replaceable := field.Name != "Type" || o.Type() != funcDeclType
+			if err := d.diffNode(of.Interface().(ast.Node), mf.Interface().(ast.Node), replaceable); err != nil {
+				return err
+			}
+		case field.Type.Kind() == reflect.Slice && field.Type.Elem().Implements(nodeType):
+			if err := d.diffList(o, of, mf); err != nil {
+				return err
+			}
+		default:
+			if !equalValues(of, mf) {
+				return errNotEditable
+			}
+		}
+	}
+	return nil
+}
+
+// diffList computes edits turning the original list of nodes into the
+// transformed one. Transformed nodes are paired with the original ones
+// using their positions - unpaired nodes are inserted into the
+// original source code.
+func (d *sourceDiffer) diffList(parent reflect.Value, o reflect.Value, m reflect.Value) error {
+	origIndexes := make(map[int]int) // offset -> index
+	for i := 0; i < o.Len(); i++ {
+		origIndexes[d.origOffset(listNode(o, i).Pos())] = i
+	}
+	paired := make([]bool, m.Len())
+	next := 0
+	for j := 0; j < m.Len(); j++ {
+		i, ok := origIndexes[d.modOffset(listNode(m, j).Pos())]
+		if ok && i == next && reflect.TypeOf(listNode(o, i)) == reflect.TypeOf(listNode(m, j)) {
+			paired[j] = true
+			next++
+		}
+	}
+	if next != o.Len() {
+		if o.Len() != m.Len() {
+			// nodes have been removed or reordered
+			return errNotEditable
+		}
+		// nodes have been replaced - pair them by index
+		for j := range paired {
+			paired[j] = true
+		}
+	}
+	i := 0
+	for j := 0; j < m.Len(); {
+		if paired[j] {
+			if err := d.diffNode(listNode(o, i), listNode(m, j), true); err != nil {
+				return err
+			}
+			i++
+			j++
+			continue
+		}
+		// collect consecutive inserted nodes
+		var texts []string
+		for ; j < m.Len() && !paired[j]; j++ {
+			text, err := d.print(listNode(m, j), "", nil)
+			if err != nil {
+				return err
+			}
+			texts = append(texts, text)
+		}
+		if o.Len() == 0 {
+			return errNotEditable
+		}
+		// insert before the next original node if possible (so that
+		// trailing comments stay where they are) and after the
+		// previous one otherwise
+		anchor := listNode(o, o.Len()-1)
+		if i < o.Len() {
+			anchor = listNode(o, i)
+		}
+		sep, err := d.listSeparator(parent, anchor)
+		if err != nil {
+			return err
+		}
+		for k := range texts {
+			texts[k] = indentText(texts[k], d.indent(anchor))
+		}
+		if i < o.Len() {
+			offset := d.insertOffset(anchor)
+			d.edits = append(d.edits, sourceEdit{start: offset, end: offset, text: strings.Join(texts, sep) + sep})
+		} else {
+			offset := d.origOffset(anchor.End())
+			d.edits = append(d.edits, sourceEdit{start: offset, end: offset, text: sep + strings.Join(texts, sep)})
+		}
+	}
+	return nil
+}
+
+// listSeparator returns a separator between nodes inserted into a list
+// being a child of the parent node.
+func (d *sourceDiffer) listSeparator(parent reflect.Value, anchor ast.Node) (string, error) {
+	switch p := parent.Addr().Interface().(type) {
+	case *ast.File:
+		return "\n\n", nil
+	case *ast.BlockStmt, *ast.CaseClause, *ast.CommClause:
+		return "\n" + d.indent(anchor), nil
+	case *ast.GenDecl:
+		if p.Lparen.IsValid() {
+			return "\n" + d.indent(anchor), nil
+		}
+	case *ast.FieldList:
+		if p.Opening.IsValid() && d.src[d.origOffset(p.Opening)] == '(' {
+			return ", ", nil
+		}
+	case *ast.Field, *ast.ValueSpec, *ast.CallExpr, *ast.AssignStmt, *ast.ReturnStmt:
+		return ", ", nil
+	}
+	return "", errNotEditable
+}
+
+// insertOffset returns offset in the original source code where nodes
+// inserted before a given node are placed (i.e. before comments
+// preceding this node on separate lines).
+func (d *sourceDiffer) insertOffset(n ast.Node) int {
+	offset := d.origOffset(n.Pos())
+	for i := len(d.origComments) - 1; i >= 0; i-- {
+		c := d.origComments[i]
+		end := d.origOffset(c.End())
+		if end > offset {
+			continue
+		}
+		gap := string(d.src[end:offset])
+		if strings.TrimSpace(gap) != "" || strings.Count(gap, "\n") != 1 {
+			break
+		}
+		start := d.origOffset(c.Pos())
+		if lineStart := bytes.LastIndexByte(d.src[:start], '\n') + 1; strings.TrimSpace(string(d.src[lineStart:start])) != "" {
+			// comment does not start the line
+			break
+		}
+		offset = start
+	}
+	return offset
+}
+
+// print returns source code of a node (including given comments) with
+// lines following the first one indented by a given indentation.
+func (d *sourceDiffer) print(n ast.Node, indent string, comments []*ast.CommentGroup) (string, error) {
+	switch n := n.(type) {
+	case *ast.Field:
+		var names []string
+		for _, name := range n.Names {
+			names = append(names, name.Name)
+		}
+		text, err := d.print(n.Type, indent, comments)
+		if err != nil {
+			return "", err
+		}
+		if len(names) > 0 {
+			text = strings.Join(names, ", ") + " " + text
+		}
+		if n.Tag != nil {
+			text += " " + n.Tag.Value
+		}
+		return text, nil
+	case *ast.FieldList:
+		// only parameter and result lists can be printed
+		if n.Opening.IsValid() && d.modOffset(n.Opening) >= 0 && d.src[d.modOffset(n.Opening)] != '(' {
+			return "", errNotEditable
+		}
+		var fields []string
+		for _, f := range n.List {
+			text, err := d.print(f, indent, comments)
+			if err != nil {
+				return "", err
+			}
+			fields = append(fields, text)
+		}
+		text := strings.Join(fields, ", ")
+		if n.Opening.IsValid() || len(n.List) != 1 || len(n.List[0].Names) > 0 {
+			text = "(" + text + ")"
+		}
+		return text, nil
+	}
+	var buf bytes.Buffer
+	if err := printerConfig.Fprint(&buf, d.fset, &printer.CommentedNode{Node: withoutDoc(n), Comments: comments}); err != nil {
+		return "", err
+	}
+	return indentText(buf.String(), indent), nil
+}
+
+// withoutDoc returns a shallow copy of a node with its documentation
+// and line comments removed - they are outside of the node's source
+// code range and are preserved in the original source code.
+func withoutDoc(n ast.Node) ast.Node {
+	v := reflect.ValueOf(n)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return n
+	}
+	c := reflect.New(v.Elem().Type())
+	c.Elem().Set(v.Elem())
+	for _, name := range []string{"Doc", "Comment"} {
+		if f := c.Elem().FieldByName(name); f.IsValid() && f.Type() == reflect.TypeOf((*ast.CommentGroup)(nil)) {
+			f.Set(reflect.Zero(f.Type()))
+		}
+	}
+	return c.Interface().(ast.Node)
+}
+
+// indentText indents all non-empty lines of a text but the first one
+// (raw string literals spanning multiple lines are left intact).
+func indentText(text string, indent string) string {
+	if indent == "" || strings.Contains(text, "`") {
+		return text
+	}
+	lines := strings.Split(text, "\n")
+	for i := 1; i < len(lines); i++ {
+		if lines[i] != "" {
+			lines[i] = indent + lines[i]
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// listNode returns a node at a given index of a list.
+func listNode(l reflect.Value, i int) ast.Node {
+	return l.Index(i).Interface().(ast.Node)
+}
+
+// equalValues checks if values representing (parts of) AST nodes are
+// equal, ignoring positions and fields that do not represent source
+// code.
+func equalValues(a reflect.Value, b reflect.Value) bool {
+	if a.Kind() != b.Kind() {
+		return false
+	}
+	switch a.Kind() {
+	case reflect.Interface, reflect.Ptr:
+		if a.IsNil() || b.IsNil() {
+			return a.IsNil() == b.IsNil()
+		}
+		if a.Elem().Type() != b.Elem().Type() {
+			return false
+		}
+		return equalValues(a.Elem(), b.Elem())
+	case reflect.Struct:
+		for i := 0; i < a.NumField(); i++ {
+			f := a.Type().Field(i)
+			if ignoredFields[f.Name] || f.Type == posType || f.PkgPath != "" {
+				continue
+			}
+			if !equalValues(a.Field(i), b.Field(i)) {
+				return false
+			}
+		}
+		return true
+	case reflect.Slice:
+		if a.Len() != b.Len() {
+			return false
+		}
+		for i := 0; i < a.Len(); i++ {
+			if !equalValues(a.Index(i), b.Index(i)) {
+				return false
+			}
+		}
+		return true
+	default:
+		return a.Interface() == b.Interface()
+	}
+}
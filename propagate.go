@@ -34,11 +34,13 @@ package propagate
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"go/ast"
 	"go/format"
+	"go/parser"
 	"go/token"
 	"go/types"
 	cg "golang.org/x/tools/go/callgraph"
@@ -51,109 +53,292 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
 )
 
 // Run is the main entry point for the whole context propgatation process.
 func Run(configFilePath string, debugFilePath string, srcPaths []string, debugLevel int) {
-
-	results := propagate(configFilePath, debugFilePath, srcPaths, debugLevel)
-
-	// write modified files to the same locations as original files with the added "mod" extension
-	for p, nodes := range results {
-		for n, ind := range nodes {
-			var buf bytes.Buffer
-			err := format.Node(&buf, p.Fset, n)
-			if err != nil {
-				ast.Print(p.Fset, n)
-				log.Fatal(err)
-			}
-			err = ioutil.WriteFile(p.CompiledGoFiles[ind]+".mod", buf.Bytes(), 0644)
-			if err != nil {
-				log.Fatal(err)
-			}
-		}
+	if err := RunContext(context.Background(), configFilePath, debugFilePath, srcPaths, debugLevel); err != nil {
+		log.Fatal(err)
 	}
-
 }
 
-// propagate is the main driver for the whole context propgatation process.
-func propagate(configFilePath string, debugFilePath string, srcPaths []string, debugLevel int) map[*packages.Package]map[*ast.File]int {
+// RunContext is the same as Run but it returns an error instead of
+// terminating the program, in particular if a given context is done
+// before the process completes (the context is checked between phases
+// of the process).
+func RunContext(ctx context.Context, configFilePath string, debugFilePath string, srcPaths []string, debugLevel int) error {
+	return RunWithOptions(ctx, configFilePath, debugFilePath, srcPaths, debugLevel, nil)
+}
 
-	cfg := initialize(configFilePath, debugLevel)
+// RunWithOptions is the same as RunContext but the process is
+// configured with additional options (nil for defaults). In the watch
+// mode (see Options.Watch), it returns when the context is done.
+func RunWithOptions(ctx context.Context, configFilePath string, debugFilePath string, srcPaths []string, debugLevel int, opts *Options) error {
 
-	loadPaths := cfg.LoadPaths
-	if srcPaths != nil && len(srcPaths) > 0 {
-		// if paths passed explicitly - use them
-		loadPaths = srcPaths
+	configFilePaths := []string{configFilePath}
+	if opts != nil && len(opts.ConfigChain) > 0 {
+		configFilePaths = opts.ConfigChain
 	}
-
-	loadConfig := &packages.Config{Mode: packages.LoadAllSyntax, Tests: true}
-	argsSize := 0
-	for _, s := range loadPaths {
-		argsSize += len(s)
+	if opts != nil && opts.MetricsAddr != "" {
+		withMetrics := *opts
+		withMetrics.metrics = &metrics{}
+		opts = &withMetrics
+		shutdown := opts.metrics.serveMetrics(opts.MetricsAddr)
+		defer shutdown()
 	}
-	iter := (argsSize / argBytesLimit) + 1
-	inc := len(loadPaths) / iter
+	if opts != nil && opts.Watch {
+		withWatcher := *opts
+		withWatcher.watcher = newWatcher()
+		withWatcher.watcher.addFiles(configFilePaths)
+		opts = &withWatcher
+	}
+	modified, err := tryPropagateChain(ctx, configFilePaths, debugFilePath, srcPaths, debugLevel, opts)
+	if err != nil {
+		return err
+	}
+	writeModified(modified)
+	if opts != nil && opts.Watch {
+		return watch(ctx, configFilePaths, debugFilePath, srcPaths, debugLevel, opts, modified)
+	}
+	return nil
+}
 
-	var initialLoaded []*packages.Package
-	numPaths := len(loadPaths)
-	if numPaths > inc {
-		cfg.largeCode = true
-		if cfg.debugLevel > 0 {
-			fmt.Println("INCREMENTAL LOADING")
+// writeModified writes modified files to the same locations as
+// original files with the added "mod" extension.
+func writeModified(modified map[string][]byte) {
+	for path, src := range modified {
+		err := ioutil.WriteFile(path+".mod", src, 0644)
+		if err != nil {
+			log.Fatal(err)
 		}
-		cfg.fsets = make(map[*types.Package]*token.FileSet)
-	} else if cfg.debugLevel > 0 {
-		fmt.Println("ONE-TIME LOADING")
 	}
-	for i := 0; i < numPaths; i += inc {
-		end := numPaths
-		if i+inc < numPaths {
-			end = i + inc
-		}
+}
 
-		allLoadPaths := append(loadPaths[i:end], cfg.LibPkgPath)
-		if cfg.LibIface == "" {
-			allLoadPaths = loadPaths[i:end]
-		}
+// propagateChain runs context propagation for each config file
+// sequentially, so that the propagation for a given config file is
+// applied to the code modified by the propagation for the previous
+// one (modified code is kept in memory rather than written to
+// disk). It returns content of modified files.
+func propagateChain(configFilePaths []string, debugFilePath string, srcPaths []string, debugLevel int, opts *Options) map[string][]byte {
+	modified, err := tryPropagateChain(context.Background(), configFilePaths, debugFilePath, srcPaths, debugLevel, opts)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return modified
+}
 
-		loaded, err := packages.Load(loadConfig, allLoadPaths...)
+// tryPropagateChain is the same as propagateChain but it returns an
+// error instead of terminating the program if analysis or
+// transformation for one of the config files fails (or if a given
+// context is done before all of them complete).
+func tryPropagateChain(ctx context.Context, configFilePaths []string, debugFilePath string, srcPaths []string, debugLevel int, opts *Options) (map[string][]byte, error) {
+	overlay := make(map[string][]byte) // file path -> file content
+	for _, configFilePath := range configFilePaths {
+		results, err := tryPropagate(ctx, configFilePath, debugFilePath, srcPaths, debugLevel, opts, overlay)
 		if err != nil {
-			log.Fatal(err)
+			return nil, err
 		}
+		for p, nodes := range results {
+			for n, ind := range nodes {
+				overlay[p.CompiledGoFiles[ind]] = formatResult(p.Fset, n, p.CompiledGoFiles[ind], opts, overlay)
+			}
+		}
+	}
+	return overlay, nil
+}
 
-		if cfg.largeCode && len(loaded) > 0 {
-			for _, l := range loaded {
-				cfg.fsets[l.Types] = l.Fset
+// formatResult returns content of a modified file.
+func formatResult(fset *token.FileSet, n *ast.File, filePath string, opts *Options, overlay map[string][]byte) []byte {
+	if opts != nil && opts.PreserveFormatting {
+		src, exists := overlay[filePath]
+		var err error
+		if !exists {
+			src, err = ioutil.ReadFile(filePath)
+		}
+		if err == nil {
+			res, err := formatPreserving(fset, n, filePath, src)
+			if err == nil {
+				return res
 			}
+			fmt.Println("COULD NOT PRESERVE FORMATTING OF " + filePath + ": " + err.Error())
+		} else if !os.IsNotExist(err) {
+			log.Fatal(err)
 		}
+		// otherwise the file has been generated by the tool and has
+		// no original formatting to preserve
+	}
+	var buf bytes.Buffer
+	err := format.Node(&buf, fset, n)
+	if err != nil {
+		ast.Print(fset, n)
+		log.Fatal(err)
+	}
+	return buf.Bytes()
+}
 
-		initialLoaded = append(initialLoaded, loaded...)
+// propagate is the main driver for the whole context propgatation
+// process. The overlay (optional) maps file paths to file contents
+// replacing the ones stored on disk.
+func propagate(configFilePath string, debugFilePath string, srcPaths []string, debugLevel int, opts *Options, overlay map[string][]byte) map[*packages.Package]map[*ast.File]int {
+	res, err := tryPropagate(context.Background(), configFilePath, debugFilePath, srcPaths, debugLevel, opts, overlay)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return res
+}
+
+// tryPropagate is the same as propagate but it returns an error
+// instead of terminating the program if analysis or transformation
+// fails or if a given context is done before the process completes
+// (the context is checked between phases of the process, which cannot
+// be interrupted themselves). Debug info collected so far is output
+// regardless of whether the process completes or is aborted.
+func tryPropagate(ctx context.Context, configFilePath string, debugFilePath string, srcPaths []string, debugLevel int, opts *Options, overlay map[string][]byte) (res map[*packages.Package]map[*ast.File]int, err error) {
+
+	cfg, err := tryInitialize(configFilePath, debugLevel, opts)
+	if err != nil {
+		return nil, err
+	}
+	cfg.ctx = ctx
+	defer cfg.handleAbort(debugFilePath, &err)
+
+	start := time.Now()
+	if cfg.opts.ApplyPlanPath != "" {
+		// analysis results are read from the plan
+		cfg.loadPackages(srcPaths, overlay)
+		cfg.applyPlan(overlay)
+	} else {
+		analyzer := cfg.analyzeCode(srcPaths, overlay)
+		if cfg.opts.OnPlanReady != nil {
+			// analysis results may be checked (and pruned) before
+			// any code is transformed
+			p := analyzer.buildPlan(overlay)
+			cfg.onPlanReady(p)
+			cfg.loadPlan(p, overlay)
+		}
+		if cfg.opts.EmitPlanPath != "" {
+			// code is transformed when the plan is applied
+			analyzer.emitPlan(overlay)
+			return nil, nil
+		}
+	}
+	cfg.checkDone()
+	cfg.opts.metrics.recordAnalysis(&cfg.debugData.Stats, time.Since(start))
+	start = time.Now()
+	cfg.generateAssertions()
+	cfg.generateInterfaceShadow()
 
+	transformer := transformerConfig{
+		config:           cfg,
+		astIfaceModified: make(map[*ast.InterfaceType]bool),
 	}
+	modified := (&transformer).transform()
+	cfg.addNewFiles(modified)
+	(&transformer).checkIfaceCompleteness(modified, overlay)
+	(&transformer).writeMigrationGuide()
+	(&transformer).writeEditReport()
+	cfg.opts.metrics.recordTransform(&cfg.debugData.Stats, time.Since(start))
+	return modified, nil
+}
 
-	// ignore packages that have not been loaded correctly, but warn the user about it
-	for _, p := range initialLoaded {
-		if len(p.Errors) > 0 {
-			// ignore this package
+// addNewFile records a file generated by the tool in a given package
+// to be output along with transformed files.
+func (cfg *config) addNewFile(p *packages.Package, filePath string, src []byte) {
+	if cfg.newFiles[p] == nil {
+		cfg.newFiles[p] = make(map[string][]byte)
+	}
+	cfg.newFiles[p][filePath] = src
+}
 
-			// if the debug level is high enough, print detailed info
-			if cfg.debugLevel > 1 {
-				fmt.Println("PACKAGE " + p.Name + " (AT " + p.PkgPath + ") BUILD ERRORS: ")
-				for _, e := range p.Errors {
-					fmt.Println(e)
-				}
+// addNewFiles adds files generated by the tool to transformed files of
+// their packages, so that they are output the same way (e.g. written
+// next to the original files with the added "mod" extension) rather
+// than written to the packages' directories.
+func (cfg *config) addNewFiles(modified map[*packages.Package]map[*ast.File]int) {
+	for p, files := range cfg.newFiles {
+		paths := make([]string, 0, len(files))
+		for filePath := range files {
+			paths = append(paths, filePath)
+		}
+		sort.Strings(paths)
+		for _, filePath := range paths {
+			f, err := parser.ParseFile(p.Fset, filePath, files[filePath], parser.ParseComments)
+			if err != nil {
+				fatal("error parsing generated file " + filePath + ": " + err.Error())
 			}
-
-			// if debug is enabled at all, collect names of packages that filed to load
-			if cfg.debugLevel > 0 {
-				cfg.debugData.Excluded = append(cfg.debugData.Excluded, "package "+p.Name+" at "+p.PkgPath)
+			if modified[p] == nil {
+				modified[p] = make(map[*ast.File]int)
 			}
-			continue
+			p.CompiledGoFiles = append(p.CompiledGoFiles, filePath)
+			modified[p][f] = len(p.CompiledGoFiles) - 1
 		}
-		cfg.initial = append(cfg.initial, p)
+	}
+}
+
+// Analyze runs the analysis phase of the context propagation process
+// (without transforming the code) and returns its results.
+func Analyze(configFilePath string, srcPaths []string, opts *Options) *AnalysisResult {
+	res, err := tryAnalyze(configFilePath, srcPaths, opts)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return res
+}
+
+// tryAnalyze is the same as Analyze but it returns an error instead of
+// terminating the program if analysis fails.
+func tryAnalyze(configFilePath string, srcPaths []string, opts *Options) (res *AnalysisResult, err error) {
+	cfg := initialize(configFilePath, 0, opts)
+	defer cfg.handleAbort("", &err)
+
+	analyzer := cfg.analyzeCode(srcPaths, nil)
+	return analyzer.analysisResult(), nil
+}
 
+// handleAbort outputs debug info collected so far and, if the process
+// has been aborted (see fatal), turns the abort into an error. It must
+// be deferred.
+func (cfg *config) handleAbort(debugFilePath string, err *error) {
+	r := recover()
+	outputDebugInfo(debugFilePath, cfg)
+	if r == nil {
+		return
 	}
+	pe, ok := r.(*propagateError)
+	if !ok {
+		panic(r)
+	}
+	pe.debugData = cfg.debugData
+	*err = pe
+}
+
+// checkDone aborts the process if its context is done. It is called
+// between phases of the process as the phases themselves (e.g. package
+// loading or call graph construction) cannot be interrupted.
+func (cfg *config) checkDone() {
+	if err := cfg.ctx.Err(); err != nil {
+		fatal("context propagation interrupted: " + err.Error())
+	}
+}
+
+// analyzeCode loads packages, builds the call graph (see
+// newAnalyzer) and performs the analysis phase.
+func (cfg *config) analyzeCode(srcPaths []string, overlay map[string][]byte) *analyzerConfig {
+	analyzer := cfg.newAnalyzer(srcPaths, overlay)
+	analyzer.analyze()
+	return analyzer
+}
+
+// newAnalyzer loads packages (see loadPackages) and builds the call
+// graph to be analyzed.
+func (cfg *config) newAnalyzer(srcPaths []string, overlay map[string][]byte) *analyzerConfig {
+	cfg.loadPackages(srcPaths, overlay)
+	cfg.checkDone()
 
 	prog, pkgs := ssautil.AllPackages(cfg.initial, ssa.GlobalDebug)
 
@@ -164,6 +349,8 @@ func propagate(configFilePath string, debugFilePath string, srcPaths []string, d
 			p.Build()
 		}
 	}
+	cfg.checkDone()
+	ssaExport := cfg.exportSSA(prog, pkgs)
 
 	var graph *cg.Graph
 	if cfgType == cfgRTA {
@@ -179,7 +366,7 @@ func propagate(configFilePath string, debugFilePath string, srcPaths []string, d
 		}
 		res := rta.Analyze(cgRoots, true)
 		if res == nil {
-			log.Fatalf("error building RTA callgraph")
+			fatal("error building RTA callgraph")
 		}
 		graph = res.CallGraph
 	} else if cfgType == cfgCHA {
@@ -208,75 +395,287 @@ func propagate(configFilePath string, debugFilePath string, srcPaths []string, d
 		ptrConfig.Reflection = true
 		res, err := pointer.Analyze(&ptrConfig)
 		if err != nil {
-			log.Fatalf("error creating call graph using points-to analysis")
+			fatal("error creating call graph using points-to analysis")
 		}
 		graph = res.CallGraph
 
 	}
+	cfg.compareCallGraphs(prog, graph)
 	graph.DeleteSyntheticNodes()
+	cfg.checkDone()
 
-	transformer := transformerConfig{
-		config:           cfg,
-		astIfaceModified: make(map[*ast.InterfaceType]bool),
+	analyzer := analyzerConfig{
+		config:               cfg,
+		prog:                 prog,
+		graph:                graph,
+		mapAndSliceFuncs:     make(map[*ssa.Package]map[*types.Signature]bool),
+		conversionsWarned:    make(map[*ssa.ChangeType]bool),
+		libFnCalls:           make(map[string]map[string]int),
+		leafCallers:          make(map[string]map[string]map[uniquePosInfo]*ssa.Function),
+		assignableCtxWarned:  make(map[*types.Var]bool),
+		closureBoundarySites: make(map[*ssa.Function][]closureBoundarySite),
+		artificialCtxFns:     make(map[uniquePosInfo]int),
+		frozenFns:            make(map[uniquePosInfo]*ssa.Function),
+		linknameTargets:      make(map[uniquePosInfo]string),
+		cgoExports:           make(map[uniquePosInfo]bool),
+		fnDefsCollected:      make(map[*ssa.Function]string),
 	}
+	analyzer.importCallGraph()
+	analyzer.writeSSAExport(ssaExport)
+	return &analyzer
+}
 
-	analyzer := analyzerConfig{
-		config:           cfg,
-		prog:             prog,
-		graph:            graph,
-		mapAndSliceFuncs: make(map[*ssa.Package]map[*types.Signature]bool),
+// loadChunkSize returns the number of load paths that can be loaded
+// at once without exceeding the limit on the arguments length. Load
+// paths containing "..." wildcard are expanded into (possibly many)
+// package paths before being passed to the go command so their
+// length is estimated conservatively.
+func loadChunkSize(loadPaths []string) int {
+	argsSize := 0
+	for _, s := range loadPaths {
+		if strings.Contains(s, "...") {
+			argsSize += len(s) * wildcardExpansionFactor
+		} else {
+			argsSize += len(s)
+		}
+	}
+	iter := (argsSize / argBytesLimit) + 1
+	inc := len(loadPaths) / iter
+	if inc == 0 {
+		// a single path exceeds the limit
+		inc = 1
+	}
+	return inc
+}
+
+// loadPackages loads packages from given source paths (or from paths
+// specified in the config file, or from a package list if one is
+// specified).
+func (cfg *config) loadPackages(srcPaths []string, overlay map[string][]byte) {
+	loadPaths := cfg.LoadPaths
+	if srcPaths != nil && len(srcPaths) > 0 {
+		// if paths passed explicitly - use them
+		loadPaths = srcPaths
 	}
 
-	(&analyzer).analyze()
-	res := (&transformer).transform()
+	loadConfig := &packages.Config{Mode: packages.LoadAllSyntax, Tests: true, Overlay: overlay}
+	inc := loadChunkSize(loadPaths)
 
-	outputDebugInfo(debugFilePath, cfg)
-	return res
+	var initialLoaded []*packages.Package
+	numPaths := len(loadPaths)
+	if cfg.opts.PackageListPath != "" {
+		// packages are synthesized from a package list so
+		// incremental loading is unnecessary
+		var err error
+		initialLoaded, err = loadFromJSONList(cfg.opts.PackageListPath)
+		if err != nil {
+			fatal("error loading packages from package list: " + err.Error())
+		}
+		if cfg.LibIface != "" {
+			initialLoaded = appendLibPkg(initialLoaded, cfg.LibPkgPath)
+		}
+		numPaths = 0
+	}
+	if numPaths > inc {
+		cfg.largeCode = true
+		if cfg.debugLevel > 0 {
+			fmt.Println("INCREMENTAL LOADING")
+		}
+		cfg.fsets = make(map[*types.Package]*token.FileSet)
+	} else if cfg.debugLevel > 0 {
+		fmt.Println("ONE-TIME LOADING")
+	}
+	for i := 0; i < numPaths; i += inc {
+		end := numPaths
+		if i+inc < numPaths {
+			end = i + inc
+		}
+
+		allLoadPaths := append(loadPaths[i:end], cfg.LibPkgPath)
+		if cfg.LibIface == "" {
+			allLoadPaths = loadPaths[i:end]
+		}
+
+		loaded, err := packages.Load(loadConfig, allLoadPaths...)
+		if err != nil {
+			fatal("error loading packages: " + err.Error())
+		}
+
+		if cfg.largeCode && len(loaded) > 0 {
+			for _, l := range loaded {
+				cfg.fsets[l.Types] = l.Fset
+			}
+		}
+
+		initialLoaded = append(initialLoaded, loaded...)
+
+	}
+
+	// ignore packages that have not been loaded correctly, but warn the user about it
+	for _, p := range initialLoaded {
+		if len(p.Errors) > 0 {
+			cfg.opts.metrics.recordPackages(0, 1)
+			// ignore this package
+
+			// if the debug level is high enough, print detailed info
+			if cfg.debugLevel > 1 {
+				fmt.Println("PACKAGE " + p.Name + " (AT " + p.PkgPath + ") BUILD ERRORS: ")
+				for _, e := range p.Errors {
+					fmt.Println(e)
+				}
+			}
+
+			// if debug is enabled at all, collect names of packages that filed to load
+			if cfg.debugLevel > 0 {
+				cfg.debugData.Excluded = append(cfg.debugData.Excluded, "package "+p.Name+" at "+p.PkgPath)
+			}
+			continue
+		}
+		cfg.opts.metrics.recordPackages(1, 0)
+		cfg.initial = append(cfg.initial, p)
+
+	}
+
+	cfg.opts.watcher.addPackages(loadPaths, initialLoaded)
+	cfg.initFilePrefix()
+	cfg.collectSkippedFiles()
+	cfg.collectGeneratedFiles()
+	cfg.generateContextMock()
 }
 
 // initialize performs tool initialization.
-func initialize(configFilePath string, debugLevel int) *config {
+func initialize(configFilePath string, debugLevel int, opts *Options) *config {
+	cfg, err := tryInitialize(configFilePath, debugLevel, opts)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return cfg
+}
+
+// tryInitialize is the same as initialize but it returns an error
+// instead of terminating the program if the config file is invalid.
+func tryInitialize(configFilePath string, debugLevel int, opts *Options) (_ *config, err error) {
+	defer handleInitAbort(&err)
 	if configFilePath == "" {
 		fmt.Fprintln(os.Stderr, "USAGE:")
 		flag.PrintDefaults()
 		os.Exit(1)
 	}
 
-	buf, ok := ioutil.ReadFile(configFilePath)
-	if ok != nil {
-		log.Fatalf("error reading config file " + configFilePath)
+	jsonCfg := jsonConfig{
+		ExtEmbedTypes:       make(typeInfo),
+		TestSuiteTypes:      make(typeInfo),
+		CtxCarrierTypes:     make(typeInfo),
+		CtxParamInvalid:     make(ctxExprInfo),
+		LibFns:              make(fnReplacementInfo),
+		LibFnGroups:         make(fnGroupReplacementInfo),
+		CtxWrapCallSites:    make(ctxWrapInfo),
+		PropagationStops:    make(fnInfo),
+		ContextFlowBarriers: make(fnInfo),
+		ClosureBoundaryFns:  defaultClosureBoundaryFns(),
 	}
 
-	jsonCfg := jsonConfig{
-		ExtEmbedTypes:    make(typeInfo),
-		LibFns:           make(fnReplacementInfo),
-		PropagationStops: make(fnInfo),
+	readConfig(configFilePath, &jsonCfg, make(map[string]bool))
+
+	for name, recvs := range jsonCfg.LibFnGroups {
+		for recv, callReplacement := range recvs {
+			mapFnToReplacementInfo(jsonCfg.LibFns, name, recv, callReplacement)
+		}
 	}
 
-	err := json.Unmarshal(buf, &jsonCfg)
-	if err != nil {
-		log.Fatalf("error unmarshalling file " + configFilePath + ":\n" + err.Error())
+	for name, wrapExpr := range jsonCfg.CtxWrapCallSites {
+		recvs, exists := jsonCfg.LibFns[name]
+		if !exists {
+			fatal("context wrapping expression specified for function " + name + " which is not a leaf function in the config file")
+		}
+		for _, callReplacement := range recvs {
+			callReplacement.ctxWrapExpr = wrapExpr
+		}
+	}
+
+	if opts == nil {
+		opts = &Options{}
 	}
 
 	cfg := config{
 		jsonConfig:          &jsonCfg,
+		ctx:                 context.Background(),
 		debugLevel:          debugLevel,
+		opts:                opts,
 		largeCode:           false,
+		skippedFiles:        make(map[string]bool),
+		newFiles:            make(map[*packages.Package]map[string][]byte),
+		generatedFiles:      make(map[string]bool),
+		staleFiles:          make(map[string]bool),
+		syntheticIDs:        make(map[*ssa.Function]int),
 		fnVisited:           make(map[uniquePosInfo]int),
+		ctxParamNames:       make(map[uniquePosInfo]string),
+		ctxInitExprs:        make(map[uniquePosInfo]string),
 		callSites:           make(map[uniquePosInfo]*replacementInfo),
 		callSitesRenamed:    make(map[uniquePosInfo]string),
 		ifaceModified:       make(map[*types.Interface]map[string]bool),
 		fnParamsVisited:     make(map[uniquePosInfo]bool),
+		fnFieldsVisited:     make(map[uniquePosInfo]bool),
 		renameParamsVisited: make(map[uniquePosInfo]bool),
+		closureArgs:         make(map[uniquePosInfo]map[int]string),
+		carrierTypes:        make(map[uniquePosInfo]bool),
+		carrierCtors:        make(map[uniquePosInfo]string),
+		nilCallReplacements: make(map[string]*replacementInfo),
+		ctxInitReasons:      make(map[uniquePosInfo]string),
 	}
 
-	if cfg.CtxParamInvalid == "" {
-		log.Fatalf("artificial context expression (CtxParamInvalid) must be specified in the config file")
+	if cfg.CtxParamInvalid[defaultCtxReason] == "" {
+		fatal("artificial context expression (CtxParamInvalid) must be specified in the config file")
+	}
+	for reason := range cfg.CtxParamInvalid {
+		if !isArtificialCtxReason(reason) {
+			fatal("unknown reason " + reason + " for artificial context expression (CtxParamInvalid) in the config file")
+		}
+		if reason != defaultCtxReason {
+			cfg.nilCallReplacements[reason] = &replacementInfo{}
+		}
 	}
 
 	if !(len(cfg.CtxCustomPkgPath) == 0 && len(cfg.CtxCustomPkgName) == 0 && len(cfg.CtxCustomParamType) == 0 && len(cfg.CtxCustomExprExtract) == 0) &&
 		!(len(cfg.CtxCustomPkgPath) > 0 && len(cfg.CtxCustomPkgName) > 0 && len(cfg.CtxCustomParamType) > 0 && len(cfg.CtxCustomExprExtract) > 0) {
-		log.Fatalf("either all or none of the custom context options should be specified in the config file")
+		fatal("either all or none of the custom context options should be specified in the config file")
+	}
+
+	for _, pattern := range cfg.CommandContextPatterns {
+		if pattern.PkgPath == "" || pattern.OldFunc == "" || pattern.NewFunc == "" {
+			fatal("package path, old function name and new function name must be specified for each entry of CommandContextPatterns in the config file")
+		}
+	}
+
+	for _, foreign := range cfg.ForeignCtxTypes {
+		if foreign.PkgPath == "" || foreign.TypeName == "" {
+			fatal("package path and type name must be specified for each entry of ForeignCtxTypes in the config file")
+		}
+	}
+
+	for _, field := range cfg.CtxStructFields {
+		if field.PkgPath == "" || field.TypeName == "" || field.FieldName == "" {
+			fatal("package path, type name and field name must be specified for each entry of CtxStructFields in the config file")
+		}
+	}
+
+	if cfg.PropagatedCallExpr != "" && !strings.Contains(cfg.PropagatedCallExpr, ctxWildcard) {
+		fatal("propagated call site expression " + cfg.PropagatedCallExpr + " in the config file does not contain context wildcard " + ctxWildcard)
+	}
+	if len(cfg.PropagatedCallImports) > 1 {
+		fatal("at most one import can be specified for propagated call site expression in the config file")
+	}
+
+	if cfg.InterfaceShadowPkg != "" && cfg.LibIface == "" {
+		fatal("library interface (LibIface) must be specified in the config file to generate its copy in " + cfg.InterfaceShadowPkg)
+	}
+
+	if opts.ApplyPlanPath != "" && (opts.EmitPlanPath != "" || opts.GenerateAssertions || cfg.InterfaceShadowPkg != "" || opts.AffectedTestsPath != "" || opts.PackageGraphPath != "" || opts.ExplainFunc != "" || len(opts.ConfigChain) > 0) {
+		// these require analysis results not included in the plan
+		fatal("plan " + opts.ApplyPlanPath + " cannot be applied when emitting a plan, generating assertions, generating interface copy, listing affected tests, writing package graph, explaining a function or applying a config chain")
+	}
+	if opts.EmitPlanPath != "" && len(opts.ConfigChain) > 0 {
+		fatal("plan " + opts.EmitPlanPath + " cannot be emitted when applying a config chain")
 	}
 
 	// context param type qualified with both path and name
@@ -285,9 +684,134 @@ func initialize(configFilePath string, debugLevel int) *config {
 		cfg.ctxCustomParamTypeWithPkgPathName = getQualifiedType(cfg.CtxCustomParamType, cfg.CtxCustomPkgPath, cfg.CtxCustomPkgName)
 	}
 
-	cfg.commonCallReplacement = replacementInfo{"", 1, nil, "", cfg.CtxParamName}
+	var propagatedImports map[string]string
+	for _, imp := range cfg.PropagatedCallImports {
+		if propagatedImports == nil {
+			propagatedImports = make(map[string]string)
+		}
+		propagatedImports[imp.Import] = imp.Alias
+	}
+	cfg.commonCallReplacement = replacementInfo{"", cfg.getInjectedCtxArgPos(), propagatedImports, cfg.PropagatedCallExpr, replaceCtxExprWildcard(ctxWildcard, cfg.PropagatedCallExpr, cfg.CtxParamName), false, false, ""}
+
+	return &cfg, nil
+}
+
+// handleInitAbort turns the abort of the initialization (see fatal)
+// into an error. It must be deferred.
+func handleInitAbort(err *error) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	pe, ok := r.(*propagateError)
+	if !ok {
+		panic(r)
+	}
+	*err = pe
+}
+
+// readConfig reads a config file into a given JSON config. If the
+// config file extends a base config file, the base config file is read
+// first and the config file is merged on top of it - scalar fields
+// override those of the base config file and arrays and maps are
+// merged.
+func readConfig(configFilePath string, jsonCfg *jsonConfig, extending map[string]bool) {
+	buf, ok := ioutil.ReadFile(configFilePath)
+	if ok != nil {
+		fatal("error reading config file " + configFilePath)
+	}
+
+	var ext struct{ Extends string }
+	err := json.Unmarshal(buf, &ext)
+	if err != nil {
+		fatal("error unmarshalling file " + configFilePath + ":\n" + err.Error())
+	}
+	if ext.Extends != "" {
+		basePath := ext.Extends
+		if !filepath.IsAbs(basePath) {
+			basePath = filepath.Join(filepath.Dir(configFilePath), basePath)
+		}
+		extending[filepath.Clean(configFilePath)] = true
+		if extending[filepath.Clean(basePath)] {
+			fatal("config file " + configFilePath + " extends config file " + basePath + " which (directly or indirectly) extends it")
+		}
+		readConfig(basePath, jsonCfg, extending)
+	}
+
+	// slices are replaced (or overwritten in place) when unmarshalled
+	// so they have to be merged explicitly
+	cfgValue := reflect.ValueOf(jsonCfg).Elem()
+	baseValue := reflect.ValueOf(*jsonCfg)
+	for i := 0; i < cfgValue.NumField(); i++ {
+		if f := cfgValue.Field(i); f.Kind() == reflect.Slice {
+			f.Set(reflect.Zero(f.Type()))
+		}
+	}
+	err = json.Unmarshal(buf, jsonCfg)
+	if err != nil {
+		fatal("error unmarshalling file " + configFilePath + ":\n" + err.Error())
+	}
+	for i := 0; i < cfgValue.NumField(); i++ {
+		if f := cfgValue.Field(i); f.Kind() == reflect.Slice {
+			f.Set(mergeSlices(baseValue.Field(i), f))
+		}
+	}
+}
+
+// mergeSlices returns union of two slices of the same type (preserving
+// order of their elements).
+func mergeSlices(a reflect.Value, b reflect.Value) reflect.Value {
+	res := reflect.Zero(a.Type())
+	for _, s := range []reflect.Value{a, b} {
+		for i := 0; i < s.Len(); i++ {
+			exists := false
+			for j := 0; j < res.Len(); j++ {
+				exists = exists || reflect.DeepEqual(res.Index(j).Interface(), s.Index(i).Interface())
+			}
+			if !exists {
+				res = reflect.Append(res, s.Index(i))
+			}
+		}
+	}
+	return res
+}
+
+// collectSkippedFiles collects files exceeding maximum file size
+// (these files will not be transformed).
+func (cfg *config) collectSkippedFiles() {
+	if cfg.opts.MaxFileSize <= 0 {
+		return
+	}
+	for _, p := range cfg.initial {
+		for _, f := range p.CompiledGoFiles {
+			info, err := os.Stat(f)
+			if err != nil {
+				fatal("error reading info for file " + f)
+			}
+			if info.Size() > cfg.opts.MaxFileSize {
+				cfg.skippedFiles[f] = true
+			}
+		}
+	}
+}
 
-	return &cfg
+// collectGeneratedFiles collects files matching patterns of generated
+// files specified in the config file (only call sites will be
+// transformed in these files).
+func (cfg *config) collectGeneratedFiles() {
+	if len(cfg.GeneratedCodePatterns) == 0 {
+		return
+	}
+	for _, p := range cfg.initial {
+		for _, f := range p.CompiledGoFiles {
+			for _, pattern := range cfg.GeneratedCodePatterns {
+				if matchesGlob(pattern, f) {
+					cfg.generatedFiles[f] = true
+					break
+				}
+			}
+		}
+	}
 }
 
 // outputDebugInfo outputs debug info either to standard output or to
@@ -9,84 +9,1226 @@
 
 package propagate
 
-import "testing"
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"go/format"
+	"golang.org/x/tools/go/packages"
+	"io/ioutil"
+	"math/rand"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/quick"
+	"time"
+)
 
-func TestAnon(t *testing.T) {
-	loadPath := "test-anon"
+func TestAbortDebug(t *testing.T) {
+	loadPath := "test-conversion"
+	srcPaths := []string{loadPath}
+	debugFilePath := filepath.Join(t.TempDir(), "debug.json")
+	// context argument position in the config file is invalid which
+	// aborts transformation after the analysis has been completed
+	results, err := tryPropagate(context.Background(), "testdata/config/test_abort.json", debugFilePath, srcPaths, 1, nil, nil)
+	if err == nil || results != nil {
+		t.Log("expected transformation to be aborted")
+		t.FailNow()
+	}
+	if pe, ok := err.(*propagateError); !ok || len(pe.debugData.Warnings) == 0 {
+		t.Log("expected error to carry debug info collected before abort")
+		t.FailNow()
+	}
+	validateWarning(t, debugFilePath, "WARNING: function Foo defined in an external package is converted to type ParamFn modified to take context parameter")
+}
+
+func TestAffectedTests(t *testing.T) {
+	loadPath := "test-affected-tests"
+	srcPaths := []string{loadPath}
+	reportPath := filepath.Join(t.TempDir(), "tests.json")
+	propagate("testdata/config/test.json", "", srcPaths, 0, &Options{AffectedTestsPath: reportPath}, nil)
+	reportBuf, err := ioutil.ReadFile(reportPath)
+	if err != nil {
+		t.Fatal("could not read affected tests report: " + reportPath)
+	}
+	var report affectedTests
+	if err := json.Unmarshal(reportBuf, &report); err != nil {
+		t.Fatal("could not parse affected tests report: " + reportPath)
+	}
+	var tests []string
+	for _, test := range report.Tests {
+		tests = append(tests, test.PkgPath+"."+test.Name+":"+strconv.Itoa(test.Line))
+	}
+	expected := "test-affected-tests.TestFoo:15,test-affected-tests.TestRun:20"
+	if strings.Join(tests, ",") != expected {
+		t.Errorf("expected affected tests %s but found %s", expected, strings.Join(tests, ","))
+	}
+}
+
+func TestOutput(t *testing.T) {
+	tests := []struct {
+		loadPath       string
+		configFilePath string
+	}{
+		{"test-anon", "testdata/config/test.json"},
+		{"test-anon-iface", "testdata/config/test.json"},
+		{"test-any-position-ctx", "testdata/config/test_any_position_ctx.json"},
+		{"test-blank-import", "testdata/config/test.json"},
+		{"test-call-site-artificial", "testdata/config/test_call_site_artificial.json"},
+		{"test-carrier", "testdata/config/test_carrier.json"},
+		{"test-cgo", "testdata/config/test.json"},
+		{"test-chain-call", "testdata/config/test_chain_call.json"},
+		{"test-closure-boundary", "testdata/config/test_closure_boundary.json"},
+		{"test-cmd-ctx", "testdata/config/test_cmd_ctx.json"},
+		{"test-collection", "testdata/config/test.json"},
+		{"test-composite", "testdata/config/test.json"},
+		{"test-flow-barrier", "testdata/config/test_flow_barrier.json"},
+		{"test-ctx-first", "testdata/config/test.json"},
+		{"test-ctx-last", "testdata/config/test_ctx_last.json"},
+		{"test-ctx-name", "testdata/config/test.json"},
+		{"test-ctx-pkg", "testdata/config/test_ctx_pkg.json"},
+		{"test-ctx-struct-field", "testdata/config/test_ctx_struct_field.json"},
+		{"test-ctx-wrap", "testdata/config/test_ctx_wrap.json"},
+		{"test-curried", "testdata/config/test_curried.json"},
+		{"test-external", "testdata/config/test_external.json"},
+		{"test-existing", "testdata/config/test_existing.json"},
+		{"test-existing-same-type", "testdata/config/test_existing_same_type.json"},
+		{"test-fn-param", "testdata/config/test.json"},
+		{"test-fn-pointer", "testdata/config/test.json"},
+		{"test-fn-type", "testdata/config/test.json"},
+		{"test-foreign-ctx", "testdata/config/test_foreign_ctx.json"},
+		{"test-generated", "testdata/config/test_generated.json"},
+		{"test-go-defer", "testdata/config/test.json"},
+		{"test-import", "testdata/config/test_import.json"},
+		{"test-init-comment", "testdata/config/test.json"},
+		{"test-insert", "testdata/config/test.json"},
+		{"test-inter", "testdata/config/test.json"},
+		{"test-lib-test", "testdata/config/test_lib_test.json"},
+		{"test-lib-var", "testdata/config/test_lib_var.json"},
+		{"test-named-lit", "testdata/config/test.json"},
+		{"test-nested-closure", "testdata/config/test.json"},
+		{"test-nil-fn", "testdata/config/test.json"},
+		{"test-propagated-expr", "testdata/config/test_propagated_expr.json"},
+		{"test-qualified", "testdata/config/test_qualified.json"},
+		{"test-recv-ctx", "testdata/config/test_recv_ctx.json"},
+		{"test-rename", "testdata/config/test_existing_same_type.json"},
+		{"test-select-func-lit", "testdata/config/test.json"},
+		{"test-stop", "testdata/config/test_stop.json"},
+		{"test-stream-ctx", "testdata/config/test_stream_ctx.json"},
+		{"test-suite", "testdata/config/test_suite.json"},
+		{"test-inter-spec", "testdata/config/test_inter_spec.json"},
+		{"test-type-assert", "testdata/config/test.json"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.loadPath, func(t *testing.T) {
+			srcPaths := []string{tc.loadPath}
+			results := propagate(tc.configFilePath, "", srcPaths, 0, nil, nil)
+			validateOutput(t, results, tc.loadPath, true)
+		})
+	}
+}
+
+func TestArtificialCtxAllowed(t *testing.T) {
+	loadPath := "test-artificial-allowed"
 	srcPaths := []string{loadPath}
-	results := propagate("testdata/config/test.json", "", srcPaths, 0)
+	debugFilePath := filepath.Join(t.TempDir(), "debug.json")
+	results := propagate("testdata/config/test_artificial_allowed.json", debugFilePath, srcPaths, 1, nil, nil)
 	validateOutput(t, results, loadPath, true)
+	validateArtificialCtx(t, debugFilePath, []string{"test_test.go:15:init"}, []string{"test.go:19:init"})
+	// artificial context in a disallowed file fails the run in
+	// strict mode
+	_, err := tryPropagate(context.Background(), "testdata/config/test_artificial_allowed.json", debugFilePath, srcPaths, 1, &Options{Strict: true}, nil)
+	if err == nil {
+		t.Log("expected strict mode run to fail")
+		t.FailNow()
+	}
 }
 
-func TestCollection(t *testing.T) {
-	loadPath := "test-collection"
+func TestAssignableCtx(t *testing.T) {
+	loadPath := "test-assignable-ctx"
 	srcPaths := []string{loadPath}
-	results := propagate("testdata/config/test.json", "", srcPaths, 0)
+	debugFilePath := filepath.Join(t.TempDir(), "debug.json")
+	results := propagate("testdata/config/test_assignable.json", debugFilePath, srcPaths, 1, nil, nil)
 	validateOutput(t, results, loadPath, true)
+	validateWarning(t, debugFilePath, "WARNING: parameter rc of type ReqContext assignable to Context is used as context parameter")
 }
 
-func TestExternal(t *testing.T) {
-	loadPath := "test-external"
+func TestBlankCtx(t *testing.T) {
+	loadPath := "test-blank-ctx"
 	srcPaths := []string{loadPath}
-	results := propagate("testdata/config/test_external.json", "", srcPaths, 0)
+	results := propagate("testdata/config/test.json", "", srcPaths, 0, &Options{RewriteBlankIdentifier: true}, nil)
 	validateOutput(t, results, loadPath, true)
 }
 
-func TestExisting(t *testing.T) {
-	loadPath := "test-existing"
+func TestCallGraphCompare(t *testing.T) {
+	loadPath := "test-type-assert"
 	srcPaths := []string{loadPath}
-	results := propagate("testdata/config/test_existing.json", "", srcPaths, 0)
+	reportPath := filepath.Join(t.TempDir(), "report.json")
+	propagate("testdata/config/test.json", "", srcPaths, 0, &Options{CallGraphComparePath: reportPath}, nil)
+	reportBuf, err := ioutil.ReadFile(reportPath)
+	if err != nil {
+		t.Fatal("could not read call graph comparison report: " + reportPath)
+	}
+	var report callGraphComparison
+	if err := json.Unmarshal(reportBuf, &report); err != nil {
+		t.Fatal("could not parse call graph comparison report: " + reportPath)
+	}
+	if report.RTAEdges == 0 || report.CHAEdges == 0 {
+		t.Errorf("empty call graph (RTA edges: %d, CHA edges: %d)", report.RTAEdges, report.CHAEdges)
+	}
+	if len(report.RTAOnly) > 0 {
+		t.Errorf("edges present in RTA call graph only: %v", report.RTAOnly)
+	}
+	if len(report.CHAOnly) == 0 {
+		t.Error("expected edges present in CHA call graph only")
+	}
+	if len(report.CHAOnly) != report.CHAEdges-report.RTAEdges {
+		t.Errorf("expected %d edges present in CHA call graph only but found %d", report.CHAEdges-report.RTAEdges, len(report.CHAOnly))
+	}
+}
+
+func TestCallGraphImport(t *testing.T) {
+	loadPath := "test-callgraph-import"
+	srcPaths := []string{loadPath}
+	// file paths are absolute (with symbolic links resolved) as
+	// sources are not in a module
+	srcRoot, err := filepath.Abs(testRoots.src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srcRoot, err = filepath.EvalSymlinks(srcRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(srcRoot, loadPath, "test.go")
+	graph := importedCallGraph{
+		Nodes: []importedNode{
+			{1, loadPath, "call", importedPos{file, 23, 6}},
+			{2, loadPath, "bar", importedPos{file, 18, 6}},
+			// function from different code - not matched
+			{3, loadPath, "baz", importedPos{file, 14, 6}},
+		},
+		Edges: []importedEdge{
+			{1, 2, importedPos{file, 24, 10}},
+			{1, 3, importedPos{file, 24, 10}},
+		},
+	}
+	graphBuf, err := json.Marshal(graph)
+	if err != nil {
+		t.Fatal(err)
+	}
+	graphPath := filepath.Join(t.TempDir(), "callgraph.json")
+	if err := ioutil.WriteFile(graphPath, graphBuf, 0644); err != nil {
+		t.Fatal(err)
+	}
+	results := propagate("testdata/config/test.json", "", srcPaths, 0, &Options{CallGraphPath: graphPath}, nil)
 	validateOutput(t, results, loadPath, true)
 }
 
-func TestExistingSameType(t *testing.T) {
+func TestCgoExport(t *testing.T) {
+	loadPath := "test-cgo-export"
+	srcPaths := []string{loadPath}
+	debugFilePath := filepath.Join(t.TempDir(), "debug.json")
+	results := propagate("testdata/config/test.json", debugFilePath, srcPaths, 1, nil, nil)
+	validateWarning(t, debugFilePath, "WARNING: function goCallback is exported to C code via //export directive and its signature is determined by the C interface (injecting ARTIFICIAL context)")
+	// files using cgo are transformed in the form presented to the
+	// compiler so they are not compared with expected output
+	transformed := false
+	for p, nodes := range results {
+		for n := range nodes {
+			var buf bytes.Buffer
+			if err := format.Node(&buf, p.Fset, n); err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(buf.String(), "func goCallback(n ") || !strings.Contains(buf.String(), "ctx := lib.Background()") {
+				t.Errorf("expected unmodified signature and artificial context in function goCallback:\n%s", buf.String())
+			}
+			transformed = true
+		}
+	}
+	if !transformed {
+		t.Error("expected function goCallback to be transformed")
+	}
+}
+
+func TestCheck(t *testing.T) {
+	loadPath := "test-check"
+	srcPaths := []string{loadPath}
+	findings := Check("testdata/config/test_check.json", srcPaths, nil)
+	expected := []string{
+		"test.go:18: call to A that does not take context (CtxA should be called instead)",
+		"test.go:23: function bar takes context parameter ctx but uses artificial context lib.Background()",
+		"test.go:30: function baz takes context parameter ctx but uses artificial context lib.Background()",
+		"test.go:37: artificial context lib.Background() used outside of allowed files",
+	}
+	var actual []string
+	for _, f := range findings {
+		actual = append(actual, filepath.Base(f.Pos.Filename)+":"+strconv.Itoa(f.Pos.Line)+": "+f.Msg)
+	}
+	if strings.Join(actual, "\n") != strings.Join(expected, "\n") {
+		t.Log("unexpected findings:\n" + strings.Join(actual, "\n"))
+		t.FailNow()
+	}
+}
+
+func TestCompareTransformations(t *testing.T) {
+	srcPaths := []string{"test-stop"}
+	diff, err := CompareTransformations("testdata/config/test.json", "testdata/config/test_stop.json", srcPaths)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedOnlyInFirst := []string{
+		"(test-stop.StopTestStruct).FooMethod: regular function (receives context parameter)",
+		"test-stop.FooFn: regular function (receives context parameter)",
+	}
+	if !reflect.DeepEqual(diff.OnlyInFirst.Functions, expectedOnlyInFirst) {
+		t.Errorf("functions only in first run: %v (expected %v)", diff.OnlyInFirst.Functions, expectedOnlyInFirst)
+	}
+	if len(diff.OnlyInSecond.Functions) != 0 {
+		t.Errorf("unexpected functions only in second run: %v", diff.OnlyInSecond.Functions)
+	}
+	found := false
+	for _, fn := range diff.Both.Functions {
+		found = found || fn == "test-stop.bar: regular function (receives context parameter)"
+	}
+	if !found {
+		t.Errorf("function bar missing from functions in both runs: %v", diff.Both.Functions)
+	}
+	// the file is modified differently by each run
+	if len(diff.OnlyInFirst.Files) != 1 || len(diff.OnlyInSecond.Files) != 1 || len(diff.Both.Files) != 0 {
+		t.Errorf("unexpected file differences: %v / %v / %v", diff.OnlyInFirst.Files, diff.OnlyInSecond.Files, diff.Both.Files)
+	}
+
+	diff, err = CompareTransformations("testdata/config/test_stop.json", "testdata/config/test_stop.json", srcPaths)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(diff.OnlyInFirst, DiffEntries{}) || !reflect.DeepEqual(diff.OnlyInSecond, DiffEntries{}) {
+		t.Errorf("unexpected differences between identical runs: %v / %v", diff.OnlyInFirst, diff.OnlyInSecond)
+	}
+	if len(diff.Both.Functions) == 0 || len(diff.Both.CallSites) == 0 || len(diff.Both.Files) != 1 {
+		t.Errorf("unexpected results of identical runs: %v", diff.Both)
+	}
+}
+
+func TestConfigChain(t *testing.T) {
+	loadPath := "test-chain"
+	srcPaths := []string{loadPath}
+	configFilePaths := []string{"testdata/config/test.json", "testdata/config/test_chain.json"}
+	modified := propagateChain(configFilePaths, "", srcPaths, 0, nil)
+	validateModified(t, modified, loadPath, true)
+}
+
+func TestContextFlowGraph(t *testing.T) {
+	res, err := tryAnalyze("testdata/config/test.json", []string{"test-flow"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := BuildContextFlowGraph(res, CallGraph(res))
+	var nodes []string
+	for _, n := range g.Nodes {
+		nodes = append(nodes, n.Name+":"+n.Kind)
+	}
+	expectedNodes := "lib.A:leaf,test-flow.bar:injected,test-flow.bar$1:closure,test-flow.existing:existing,test-flow.foo:injected,test-flow.main:artificial"
+	if strings.Join(nodes, ",") != expectedNodes {
+		t.Errorf("unexpected context flow graph nodes: %s", strings.Join(nodes, ","))
+	}
+	var edges []string
+	for _, e := range g.Edges {
+		edges = append(edges, e.Caller+"->"+e.Callee+":"+e.Flow)
+	}
+	expectedEdges := "test-flow.bar->test-flow.bar$1:closure-captured,test-flow.bar->test-flow.existing:already-present,test-flow.bar$1->test-flow.foo:propagated,test-flow.existing->test-flow.foo:propagated,test-flow.foo->lib.A:propagated,test-flow.main->test-flow.bar:propagated"
+	if strings.Join(edges, ",") != expectedEdges {
+		t.Errorf("unexpected context flow graph edges: %s", strings.Join(edges, ","))
+	}
+
+	var buf bytes.Buffer
+	if err := g.WriteDOT(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "\t\"test-flow.main\" -> \"test-flow.bar\" [label=\"propagated\"];\n") {
+		t.Errorf("unexpected DOT output:\n%s", buf.String())
+	}
+	buf.Reset()
+	if err := g.WriteJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var decoded ContextFlowGraph
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil || len(decoded.Nodes) != len(g.Nodes) || len(decoded.Edges) != len(g.Edges) {
+		t.Errorf("unexpected JSON output:\n%s", buf.String())
+	}
+}
+
+func TestContextSensitivityReport(t *testing.T) {
+	res, err := tryAnalyze("testdata/config/test.json", []string{"test-flow", "test-call-site-artificial"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := BuildContextSensitivityReport(res)
+	if got := strings.Join(r.FullyPropagated, ","); got != "test-flow.bar,test-flow.foo" {
+		t.Errorf("unexpected fully propagated functions: %s", got)
+	}
+	// foo is also called from the package initializer
+	if got := strings.Join(r.PartiallyPropagated, ","); got != "test-call-site-artificial.foo" {
+		t.Errorf("unexpected partially propagated functions: %s", got)
+	}
+	if got := strings.Join(r.ArtificialOnly, ","); got != "test-call-site-artificial.main,test-flow.main" {
+		t.Errorf("unexpected functions with artificial context only: %s", got)
+	}
+}
+
+func TestConversion(t *testing.T) {
+	loadPath := "test-conversion"
+	srcPaths := []string{loadPath}
+	debugFilePath := filepath.Join(t.TempDir(), "debug.json")
+	results := propagate("testdata/config/test_external.json", debugFilePath, srcPaths, 1, nil, nil)
+	// external function converted to modified named type cannot be
+	// modified so the result will not compile
+	validateOutput(t, results, loadPath, false)
+	validateWarning(t, debugFilePath, "WARNING: function Foo defined in an external package is converted to type ParamFn modified to take context parameter")
+}
+
+func TestContextAlreadyPropagated(t *testing.T) {
 	loadPath := "test-existing-same-type"
 	srcPaths := []string{loadPath}
-	results := propagate("testdata/config/test_existing_same_type.json", "", srcPaths, 0)
+	res, err := tryAnalyze("testdata/config/test_existing_same_type.json", srcPaths, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fn := range []struct {
+		pkgPath  string
+		name     string
+		recvType string
+		expected bool
+	}{
+		{loadPath, "FooA", "", true},
+		{loadPath, "FooB", "", true},
+		// context parameter is not the first one
+		{loadPath, "FooC", "", false},
+		{"lib", "CtxF", "*Rec", true},
+		{"lib", "F", "*Rec", false},
+		{"lib", "A", "", false},
+		// unknown function
+		{loadPath, "Unknown", "", false},
+	} {
+		if ContextAlreadyPropagated(res, fn.pkgPath, fn.name, fn.recvType) != fn.expected {
+			t.Errorf("unexpected result for function %s (receiver %s) in package %s", fn.name, fn.recvType, fn.pkgPath)
+		}
+	}
+}
+
+func TestCtxEscape(t *testing.T) {
+	loadPath := "test-ctx-escape"
+	srcPaths := []string{loadPath}
+	debugFilePath := filepath.Join(t.TempDir(), "debug.json")
+	results := propagate("testdata/config/test.json", debugFilePath, srcPaths, 1, &Options{ContextEscapeAnalysis: true}, nil)
 	validateOutput(t, results, loadPath, true)
+	validateCtxEscapes(t, debugFilePath, []string{
+		"test-ctx-escape.foo:ctx:17",
+		"test-ctx-escape.foo:ctx:21",
+		"test-ctx-escape.bar:ctx:34",
+	})
 }
 
-func TestFnParam(t *testing.T) {
-	loadPath := "test-fn-param"
+func TestCtxReasons(t *testing.T) {
+	loadPath := "test-ctx-reasons"
 	srcPaths := []string{loadPath}
-	results := propagate("testdata/config/test.json", "", srcPaths, 0)
+	results := propagate("testdata/config/test_ctx_reasons.json", "", srcPaths, 0, nil, nil)
+	validateOutput(t, results, loadPath, true)
+
+	// reasons must be preserved in the plan
+	planPath := filepath.Join(t.TempDir(), "plan.json")
+	propagate("testdata/config/test_ctx_reasons.json", "", srcPaths, 0, &Options{EmitPlanPath: planPath}, nil)
+	results = propagate("testdata/config/test_ctx_reasons.json", "", srcPaths, 0, &Options{ApplyPlanPath: planPath}, nil)
 	validateOutput(t, results, loadPath, true)
 }
 
-func TestImport(t *testing.T) {
-	loadPath := "test-import"
+func TestDeadCode(t *testing.T) {
+	loadPath := "test-dead-code"
 	srcPaths := []string{loadPath}
-	results := propagate("testdata/config/test_import.json", "", srcPaths, 0)
+	results := propagate("testdata/config/test.json", "", srcPaths, 0, &Options{EliminateDeadCode: true}, nil)
 	validateOutput(t, results, loadPath, true)
 }
 
-func TestInsert(t *testing.T) {
-	loadPath := "test-insert"
+func TestDupIface(t *testing.T) {
+	loadPath := "test-dup-iface"
+	srcPaths := []string{loadPath}
+	cfg := initialize("testdata/config/test_dup_iface.json", 0, nil)
+	cfg.analyzeCode(srcPaths, nil)
+	if len(cfg.libIfaces) != 1 || len(cfg.ifaceCopies[cfg.libIfaces[0]]) != 2 {
+		t.Fatalf("expected a single library interface with two copies")
+	}
+	// results must not depend on which copy of the interface is
+	// encountered first
+	for i := 0; i < 3; i++ {
+		results := propagate("testdata/config/test_dup_iface.json", "", srcPaths, 0, nil, nil)
+		// do not recompile transformed code as the library
+		// interface is not context-aware
+		validateOutput(t, results, loadPath, false)
+	}
+}
+
+func TestEditLimits(t *testing.T) {
+	loadPath := "test-edit-limits"
 	srcPaths := []string{loadPath}
-	results := propagate("testdata/config/test.json", "", srcPaths, 0)
+	debugFilePath := filepath.Join(t.TempDir(), "debug.json")
+	results := propagate("testdata/config/test_edit_limits.json", debugFilePath, srcPaths, 1, nil, nil)
 	validateOutput(t, results, loadPath, true)
+	validateWarning(t, debugFilePath, "WARNING: file has 8 edits (arg: 5, init: 1, param: 2) exceeding the limit of 4 edits per file")
+	validateEditLimitsExceeded(t, debugFilePath, []string{"busy.go:8:false"})
+	// file exceeding the error limit is not transformed and the run
+	// fails
+	_, err := tryPropagate(context.Background(), "testdata/config/test_edit_limits_error.json", debugFilePath, srcPaths, 1, nil, nil)
+	if err == nil {
+		t.Log("expected run exceeding edit limit to fail")
+		t.FailNow()
+	}
+	validateWarning(t, debugFilePath, "WARNING: file has 8 edits (arg: 5, init: 1, param: 2) exceeding the limit of 4 edits per file and will not be transformed")
+	validateEditLimitsExceeded(t, debugFilePath, []string{"busy.go:8:true"})
 }
 
-func TestInter(t *testing.T) {
+func TestExplainFunc(t *testing.T) {
+	loadPath := "test-stop"
+	srcPaths := []string{loadPath}
+	for fnName, expectedLines := range map[string][]string{
+		"test-stop.bar": {
+			"function test-stop.bar defined at ",
+			"categorized as regular function (receives context parameter)",
+			"  test-stop.bar: calls \"leaf\" function lib.A at ",
+		},
+		"test-stop.(StopTestStruct).FooMethod": {
+			"function (test-stop.StopTestStruct).FooMethod defined at ",
+			"not categorized (its signature is not modified - see analysis decisions)",
+			"  propagation stops (listed in PropagationStops)",
+			"  (test-stop.StopTestStruct).FooMethod: reached via call to test-stop.bar at ",
+			"  test-stop.bar: calls \"leaf\" function lib.A at ",
+		},
+		"test-stop.missing": {
+			"function test-stop.missing not found in the call graph",
+		},
+	} {
+		debugFilePath := filepath.Join(t.TempDir(), "debug.json")
+		propagate("testdata/config/test_stop.json", debugFilePath, srcPaths, 1, &Options{ExplainFunc: fnName}, nil)
+		debugData := readDebugInfo(t, debugFilePath)
+		for _, expected := range expectedLines {
+			found := false
+			for _, line := range debugData.Explanation {
+				found = found || strings.HasPrefix(line, expected)
+			}
+			if !found {
+				t.Errorf("explanation of %s does not contain line starting with %q", fnName, expected)
+			}
+		}
+	}
+}
+
+func TestExportSSA(t *testing.T) {
+	loadPath := "test-recv-ctx"
+	srcPaths := []string{loadPath}
+	exportPath := filepath.Join(t.TempDir(), "ssa.txt")
+	propagate("testdata/config/test_recv_ctx.json", "", srcPaths, 0, &Options{ExportSSAPath: exportPath}, nil)
+	exportBuf, err := ioutil.ReadFile(exportPath)
+	if err != nil {
+		t.Fatal("could not read SSA export: " + exportPath)
+	}
+	export := string(exportBuf)
+	for _, s := range []string{
+		"package test-recv-ctx:",
+		"# Name: (*test-recv-ctx.Request).handle",
+		"CALL GRAPH EDGES:",
+		"test-recv-ctx.serve -> (*test-recv-ctx.Request).handle at ",
+	} {
+		if !strings.Contains(export, s) {
+			t.Errorf("SSA export does not contain %q", s)
+		}
+	}
+	// dependencies are not exported
+	if strings.Contains(export, "package lib:") {
+		t.Error("SSA export contains dependency lib")
+	}
+}
+
+func TestExtends(t *testing.T) {
+	cfg := initialize("testdata/config/test_extends.json", 0, nil)
+	if cfg.CtxParamInvalid[defaultCtxReason] != "Background()" {
+		t.Errorf("invalid context expression %v not overridden", cfg.CtxParamInvalid[defaultCtxReason])
+	}
+	if cfg.CtxPkgPath != "lib" {
+		t.Errorf("context package path %v not inherited", cfg.CtxPkgPath)
+	}
+	if !reflect.DeepEqual(cfg.ExtPkgPaths, []string{"lib_helper", "lib_trace"}) {
+		t.Errorf("unexpected external package paths %v", cfg.ExtPkgPaths)
+	}
+	if len(cfg.LibFns) != 2 {
+		t.Errorf("unexpected leaf functions %v", cfg.LibFns)
+	}
+
+	loadPath := "test-external"
+	srcPaths := []string{loadPath}
+	results := propagate("testdata/config/test_extends.json", "", srcPaths, 0, nil, nil)
+	validateOutput(t, results, loadPath, true)
+}
+
+func TestExtendsSlices(t *testing.T) {
+	// every slice in the config is set to a different value in the
+	// config file and in the base config file it extends
+	baseCfg := make(map[string]interface{})
+	extendingCfg := map[string]interface{}{"Extends": "base.json"}
+	rnd := rand.New(rand.NewSource(0))
+	cfgType := reflect.TypeOf(jsonConfig{})
+	for i := 0; i < cfgType.NumField(); i++ {
+		f := cfgType.Field(i)
+		if f.Type.Kind() != reflect.Slice {
+			continue
+		}
+		for _, m := range []map[string]interface{}{baseCfg, extendingCfg} {
+			v, ok := quick.Value(f.Type.Elem(), rnd)
+			if !ok {
+				t.Fatalf("could not generate value of %s", f.Type.Elem())
+			}
+			m[f.Name] = []interface{}{v.Interface()}
+		}
+	}
+	dir := t.TempDir()
+	for name, m := range map[string]map[string]interface{}{"base.json": baseCfg, "config.json": extendingCfg} {
+		buf, err := json.Marshal(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, name), buf, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var jsonCfg jsonConfig
+	readConfig(filepath.Join(dir, "config.json"), &jsonCfg, make(map[string]bool))
+	cfgValue := reflect.ValueOf(jsonCfg)
+	for name := range baseCfg {
+		if n := cfgValue.FieldByName(name).Len(); n != 2 {
+			t.Errorf("%s of base config file not merged (%d elements instead of 2)", name, n)
+		}
+	}
+}
+
+func TestFrozen(t *testing.T) {
+	loadPath := "test-frozen"
+	srcPaths := []string{loadPath, loadPath + "/api"}
+	debugFilePath := filepath.Join(t.TempDir(), "debug.json")
+	results := propagate("testdata/config/test_frozen.json", debugFilePath, srcPaths, 1, nil, nil)
+	// exported signatures of the package with frozen API remain
+	// unchanged so the transformed code compiles against the original
+	// package
+	validateOutput(t, results, loadPath, true)
+	validateFrozenFns(t, debugFilePath, []string{
+		"(test-frozen.getter).Get:21",
+		"test-frozen/api.Foo:21",
+	})
+	validateWarning(t, debugFilePath, "WARNING: function Foo is exported by or implements an exported interface of a package with frozen API (injecting ARTIFICIAL context)")
+}
+
+func TestIfaceAssert(t *testing.T) {
+	loadPath := "test-iface-assert"
+	srcPaths := []string{loadPath}
+	debugFilePath := filepath.Join(t.TempDir(), "debug.json")
+	results := propagate("testdata/config/test_external.json", debugFilePath, srcPaths, 1, nil, nil)
+	// implementation of the asserted interface in external package
+	// has not been modified so the result will not compile
+	validateOutput(t, results, loadPath, false)
+	validateWarning(t, debugFilePath, "WARNING: method Do of type *lib_helper.ExtDoer has not been modified to take context parameter but interface test-iface-assert.Doer asserted to be implemented by this type has")
+}
+
+func TestIfaceCompleteness(t *testing.T) {
+	// method of PassedDoer is used by an external package and keeps
+	// its signature so PassedDoer no longer implements Doer
+	debugFilePath := filepath.Join(t.TempDir(), "debug.json")
+	_, err := tryPropagate(context.Background(), "testdata/config/test_external.json", debugFilePath, []string{"test-iface-complete"}, 1, &Options{CheckIfaceCompleteness: true}, nil)
+	if err == nil {
+		t.Log("expected interface completeness check to fail")
+		t.FailNow()
+	}
+	validateIncompleteIfaceImpls(t, debugFilePath, []string{"test-iface-complete.PassedDoer:test-iface-complete.Doer:Do"})
+
+	// all implementations have been updated
 	loadPath := "test-inter"
+	results, err := tryPropagate(context.Background(), "testdata/config/test.json", debugFilePath, []string{loadPath}, 1, &Options{CheckIfaceCompleteness: true}, nil)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	validateOutput(t, results, loadPath, true)
+	validateIncompleteIfaceImpls(t, debugFilePath, nil)
+}
+
+func TestFilePrefix(t *testing.T) {
+	loadPath := "test-max-size"
 	srcPaths := []string{loadPath}
-	results := propagate("testdata/config/test.json", "", srcPaths, 0)
+	// config file with explicitly specified absolute file prefix
+	configBuf, err := ioutil.ReadFile("testdata/config/test.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var config map[string]interface{}
+	if err := json.Unmarshal(configBuf, &config); err != nil {
+		t.Fatal(err)
+	}
+	filePrefix, err := filepath.Abs(testRoots.src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// loaded file paths have symbolic links resolved
+	filePrefix, err = filepath.EvalSymlinks(filePrefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config["FilePrefix"] = filePrefix
+	configBuf, err = json.Marshal(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	configFilePath := filepath.Join(t.TempDir(), "config.json")
+	if err := ioutil.WriteFile(configFilePath, configBuf, 0644); err != nil {
+		t.Fatal(err)
+	}
+	debugFilePath := filepath.Join(t.TempDir(), "debug.json")
+	propagate(configFilePath, debugFilePath, srcPaths, 1, &Options{MaxFileSize: 1024}, nil)
+	debugData := readDebugInfo(t, debugFilePath)
+	if len(debugData.Warnings) == 0 {
+		t.Fatal("no warnings reported")
+	}
+	for _, w := range debugData.Warnings {
+		if !strings.HasPrefix(w["file"], loadPath+"/") {
+			t.Errorf("unexpected file path %s in warning: %s", w["file"], w["msg"])
+		}
+	}
+}
+
+func TestGenerateAssertions(t *testing.T) {
+	loadPath := "test-inter"
+	srcPaths := []string{loadPath}
+	results := propagate("testdata/config/test.json", "", srcPaths, 0, &Options{GenerateAssertions: true}, nil)
+	// generated file is among the results (compared with expected
+	// output along with transformed files)
 	validateOutput(t, results, loadPath, true)
+	if _, err := os.Stat(filepath.Join(testRoots.src, loadPath, assertionsFileName)); !os.IsNotExist(err) {
+		t.Log("interface assertions written to the source directory")
+		t.FailNow()
+	}
 }
 
-func TestStop(t *testing.T) {
-	loadPath := "test-stop"
+func TestGenerateMock(t *testing.T) {
+	loadPath := "test-anon"
 	srcPaths := []string{loadPath}
-	results := propagate("testdata/config/test_stop.json", "", srcPaths, 0)
+	mockFilePath := filepath.Join(t.TempDir(), "mock", "mock.go")
+	propagate("testdata/config/test.json", "", srcPaths, 0, &Options{MockFilePath: mockFilePath}, nil)
+	validateFile(t, mockFilePath, filepath.Join(testRoots.expected, "mock", "mock.go"))
+	validateCompile(t, "expected/mock")
+}
+
+func TestInvalidConfig(t *testing.T) {
+	configFilePath := filepath.Join(t.TempDir(), "config.json")
+	if err := ioutil.WriteFile(configFilePath, []byte(`{"CtxParamInvalid": ""}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// invalid config file fails the run instead of terminating the
+	// program (e.g. in watch mode)
+	_, err := tryPropagate(context.Background(), configFilePath, "", []string{"test-fn-param"}, 0, nil, nil)
+	if err == nil || !strings.Contains(err.Error(), "CtxParamInvalid") {
+		t.Logf("unexpected error: %v", err)
+		t.FailNow()
+	}
+}
+
+func TestLeafStats(t *testing.T) {
+	debugFilePath := filepath.Join(t.TempDir(), "debug.json")
+	// function Bar is modified because of both "leaf" functions
+	propagate("testdata/config/test.json", debugFilePath, []string{"test-ctx-name"}, 1, nil, nil)
+	validateLeafStats(t, debugFilePath, []string{"A:2/3/1/0/0", "B:2/3/1/0/0"})
+	// propagation ends at test and main functions (with artificial
+	// context) and stops at the explicitly specified functions
+	propagate("testdata/config/test_stop.json", debugFilePath, []string{"test-stop"}, 1, nil, nil)
+	validateLeafStats(t, debugFilePath, []string{"A:4/1/0/3/0"})
+}
+
+func TestLinkname(t *testing.T) {
+	loadPath := "test-linkname"
+	srcPaths := []string{loadPath}
+	debugFilePath := filepath.Join(t.TempDir(), "debug.json")
+	results := propagate("testdata/config/test_linkname.json", debugFilePath, srcPaths, 1, nil, nil)
+	validateOutput(t, results, loadPath, true)
+	validateWarning(t, debugFilePath, "WARNING: function hook is linked to runtime.hook via //go:linkname directive (injecting ARTIFICIAL context)")
+}
+
+func TestLoadChunkSize(t *testing.T) {
+	path := strings.Repeat("p", 1000)
+	var paths []string
+	for i := 0; i < 100; i++ {
+		paths = append(paths, path)
+	}
+	// paths fit within the limit
+	if inc := loadChunkSize(paths); inc != 100 {
+		t.Errorf("unexpected chunk size %d", inc)
+	}
+	// expansion of wildcard paths is accounted for
+	for i := range paths {
+		paths[i] = path + "/..."
+	}
+	if inc := loadChunkSize(paths); inc != 33 {
+		t.Errorf("unexpected chunk size %d", inc)
+	}
+	// a single path exceeding the limit is still loaded
+	if inc := loadChunkSize([]string{strings.Repeat("p", argBytesLimit) + "/..."}); inc != 1 {
+		t.Errorf("unexpected chunk size %d", inc)
+	}
+}
+
+func TestMaxFileSize(t *testing.T) {
+	loadPath := "test-max-size"
+	srcPaths := []string{loadPath}
+	results := propagate("testdata/config/test.json", "", srcPaths, 0, &Options{MaxFileSize: 1024}, nil)
+	validateOutput(t, results, loadPath, true)
+}
+
+func TestMetrics(t *testing.T) {
+	m := &metrics{}
+	propagate("testdata/config/test_stop.json", "", []string{"test-stop"}, 0, &Options{metrics: m}, nil)
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	out := rec.Body.String()
+	for _, line := range []string{
+		"# TYPE go_ctx_propagate_functions_modified gauge",
+		`go_ctx_propagate_packages_loaded{status="ok"} 1`,
+		`go_ctx_propagate_packages_loaded{status="error"} 0`,
+		"go_ctx_propagate_functions_modified 4",
+		"go_ctx_propagate_call_sites_modified 6",
+		"go_ctx_propagate_artificial_contexts 3",
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("metrics do not contain %q:\n%s", line, out)
+		}
+	}
+	for _, name := range []string{"analysis_duration_seconds", "transform_duration_seconds"} {
+		if !strings.Contains(out, "\ngo_ctx_propagate_"+name+" ") {
+			t.Errorf("metrics do not contain %s:\n%s", name, out)
+		}
+	}
+}
+
+func TestMigrationGuide(t *testing.T) {
+	loadPath := "test-migration"
+	srcPaths := []string{loadPath}
+	guidePath := filepath.Join(t.TempDir(), "MIGRATION.md")
+	results := propagate("testdata/config/test.json", "", srcPaths, 0, &Options{MigrationGuidePath: guidePath}, nil)
+	validateOutput(t, results, loadPath, true)
+	guideBuf, err := ioutil.ReadFile(guidePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// file paths in the guide are absolute (with symbolic links
+	// resolved) as sources are not in a module
+	srcRoot, err := filepath.Abs(testRoots.src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srcRoot, err = filepath.EvalSymlinks(srcRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	guide := strings.ReplaceAll(string(guideBuf), srcRoot+"/", "")
+	expectedBuf, err := ioutil.ReadFile(filepath.Join(testRoots.expected, loadPath, "MIGRATION.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if guide != string(expectedBuf) {
+		t.Errorf("unexpected migration guide:\n%s", guide)
+	}
+}
+
+func TestNoInterface(t *testing.T) {
+	loadPath := "test-nointerface"
+	srcPaths := []string{loadPath}
+	debugFilePath := filepath.Join(t.TempDir(), "debug.json")
+	results := propagate("testdata/config/test.json", debugFilePath, srcPaths, 1, nil, nil)
+	validateOutput(t, results, loadPath, true)
+	validateWarning(t, debugFilePath, "WARNING: method Do is marked with //go:nointerface pragma and is treated as not implementing any interface")
+}
+
+func TestPackageList(t *testing.T) {
+	loadPath := "test-inter"
+	listPath := filepath.Join(t.TempDir(), "packages.json")
+	out, err := exec.Command("go", "list", "-deps", "-test", "-compiled", "-json", loadPath).Output()
+	if err != nil {
+		t.Log("could not list packages")
+		t.Log(err)
+		t.FailNow()
+	}
+	if err := ioutil.WriteFile(listPath, out, 0644); err != nil {
+		t.Log("could not write package list")
+		t.FailNow()
+	}
+	// source paths are ignored as packages are synthesized from the
+	// package list
+	results := propagate("testdata/config/test.json", "", nil, 0, &Options{PackageListPath: listPath}, nil)
+	validateOutput(t, results, loadPath, true)
+}
+
+func TestOnPlanReady(t *testing.T) {
+	loadPath := "test-closure-boundary"
+	srcPaths := []string{loadPath}
+	var planned *Plan
+	opts := &Options{OnPlanReady: func(p *Plan) error {
+		planned = p
+		return nil
+	}}
+	results := propagate("testdata/config/test_closure_boundary.json", "", srcPaths, 0, opts, nil)
+	validateOutput(t, results, loadPath, true)
+	if planned == nil || len(planned.FnVisited) == 0 || len(planned.CallSites) == 0 {
+		t.Fatal("expected plan with functions and call sites to be passed to the hook")
+	}
+
+	// entries removed from the plan are not applied
+	opts.OnPlanReady = func(p *Plan) error {
+		*p = Plan{Files: p.Files}
+		return nil
+	}
+	results = propagate("testdata/config/test_closure_boundary.json", "", srcPaths, 0, opts, nil)
+	if len(results) != 0 {
+		t.Error("unexpected transformation after all plan entries have been removed")
+	}
+
+	// returning an error aborts the process
+	opts.OnPlanReady = func(p *Plan) error {
+		return errors.New("interface modified")
+	}
+	if _, err := tryPropagate(context.Background(), "testdata/config/test_closure_boundary.json", "", srcPaths, 0, opts, nil); err == nil || !strings.Contains(err.Error(), "interface modified") {
+		t.Errorf("expected run to be aborted by the hook but got: %v", err)
+	}
+}
+
+func TestPackageGraph(t *testing.T) {
+	srcPaths := []string{"test-pkg-graph", "test-pkg-graph/svc", "test-pkg-graph/store"}
+	graphPath := filepath.Join(t.TempDir(), "pkggraph.dot")
+	propagate("testdata/config/test.json", "", srcPaths, 0, &Options{PackageGraphPath: graphPath}, nil)
+	graphBuf, err := ioutil.ReadFile(graphPath)
+	if err != nil {
+		t.Fatal("could not read package graph: " + graphPath)
+	}
+	expected := []string{
+		"\t\"test-pkg-graph/store\" [label=\"test-pkg-graph/store\\n(1 of 3 functions modified)\", style=filled, fillcolor=\"gold\"];\n",
+		"\t\"test-pkg-graph/svc\" [label=\"test-pkg-graph/svc\\n(1 of 1 functions modified)\", style=filled, fillcolor=\"tomato\"];\n",
+		"\t\"test-pkg-graph\" -> \"test-pkg-graph/store\" [label=\"1 call sites\"];\n",
+		"\t\"test-pkg-graph\" -> \"test-pkg-graph/svc\" [label=\"1 call sites\"];\n",
+		"\t\"test-pkg-graph/svc\" -> \"test-pkg-graph/store\" [label=\"1 call sites\"];\n",
+	}
+	for _, e := range expected {
+		if !strings.Contains(string(graphBuf), e) {
+			t.Errorf("expected %q in package graph:\n%s", e, string(graphBuf))
+		}
+	}
+	if strings.Contains(string(graphBuf), "\"lib\"") {
+		t.Errorf("unexpected external package in package graph:\n%s", string(graphBuf))
+	}
+}
+
+func TestPlan(t *testing.T) {
+	loadPath := "test-closure-boundary"
+	srcPaths := []string{loadPath}
+	planPath := filepath.Join(t.TempDir(), "plan.json")
+	results := propagate("testdata/config/test_closure_boundary.json", "", srcPaths, 0, &Options{EmitPlanPath: planPath}, nil)
+	if len(results) != 0 {
+		t.Fatal("unexpected transformation when emitting plan")
+	}
+	results = propagate("testdata/config/test_closure_boundary.json", "", srcPaths, 0, &Options{ApplyPlanPath: planPath}, nil)
 	validateOutput(t, results, loadPath, true)
+
+	// simulate file change after the plan was made
+	planBuf, err := ioutil.ReadFile(planPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var p Plan
+	if err := json.Unmarshal(planBuf, &p); err != nil {
+		t.Fatal(err)
+	}
+	for i := range p.Files {
+		p.Files[i].Hash = "changed"
+	}
+	if planBuf, err = json.Marshal(p); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(planPath, planBuf, 0644); err != nil {
+		t.Fatal(err)
+	}
+	debugFilePath := filepath.Join(t.TempDir(), "debug.json")
+	results = propagate("testdata/config/test_closure_boundary.json", debugFilePath, srcPaths, 1, &Options{ApplyPlanPath: planPath}, nil)
+	if len(results) != 0 {
+		t.Error("unexpected transformation of changed file")
+	}
+	validateWarning(t, debugFilePath, "WARNING: file has changed since the applied plan was made and will not be transformed")
+}
+
+func TestPreserveFormatting(t *testing.T) {
+	loadPath := "test-preserve"
+	srcPaths := []string{loadPath}
+	results := propagate("testdata/config/test.json", "", srcPaths, 0, nil, nil)
+	validatePreserved(t, results, loadPath, true)
+}
+
+func TestPropagationGraph(t *testing.T) {
+	res, err := tryAnalyze("testdata/config/test.json", []string{"test-flow"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := BuildPropagationGraph(res, CallGraph(res))
+	if got := strings.Join(g.ShortestPath("test-flow.main", ""), ","); got != "test-flow.main,test-flow.bar,test-flow.bar$1,test-flow.foo,lib.A" {
+		t.Errorf("unexpected path to leaf function: %s", got)
+	}
+	if got := strings.Join(g.ShortestPath("test-flow.main", "test-flow.existing"), ","); got != "test-flow.main,test-flow.bar,test-flow.existing" {
+		t.Errorf("unexpected path to target function: %s", got)
+	}
+	if got := strings.Join(g.ShortestPath("lib.A", ""), ","); got != "lib.A" {
+		t.Errorf("unexpected path from leaf function: %s", got)
+	}
+	if path := g.ShortestPath("test-flow.foo", "test-flow.main"); path != nil {
+		t.Errorf("unexpected path against call direction: %v", path)
+	}
+}
+
+func TestRecursionStress(t *testing.T) {
+	// generate a cluster of mutually recursive functions, each calling
+	// all of them (including itself) directly and via a closure
+	const numFns = 40
+	root := t.TempDir()
+	dir := filepath.Join(root, "src", "test-recursion-stress")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	var src strings.Builder
+	src.WriteString("package test\n\nimport \"lib\"\n\n")
+	for i := 0; i < numFns; i++ {
+		src.WriteString("func f" + strconv.Itoa(i) + "(i int) bool {\n\tif i <= 0 {\n\t\treturn lib.A()\n\t}\n")
+		for j := 0; j < numFns; j++ {
+			src.WriteString("\tf" + strconv.Itoa(j) + "(i - 1)\n")
+		}
+		src.WriteString("\treturn func() bool {\n\t\treturn f" + strconv.Itoa((i+1)%numFns) + "(i - 1)\n\t}()\n}\n\n")
+	}
+	src.WriteString("func main() {\n\tf0(1)\n}\n")
+	if err := ioutil.WriteFile(filepath.Join(dir, "test.go"), []byte(src.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GOPATH", os.Getenv("GOPATH")+string(os.PathListSeparator)+root)
+
+	cfg := initialize("testdata/config/test.json", 0, nil)
+	analyzer := cfg.analyzeCode([]string{"test-recursion-stress"}, nil)
+	if analyzer.debugData.Stats.FnsVisited != numFns {
+		t.Errorf("unexpected number of functions receiving context parameter: %d", analyzer.debugData.Stats.FnsVisited)
+	}
+	// each function (and closure) is examined once regardless of the
+	// number of its call sites
+	if analyzer.fnDefsExamined > 2*numFns+1 {
+		t.Errorf("function definitions examined %d times", analyzer.fnDefsExamined)
+	}
+}
+
+func TestRevert(t *testing.T) {
+	loadPath := "test-migration"
+	srcPaths := []string{loadPath}
+	tmpDir := t.TempDir()
+	reportPath := filepath.Join(tmpDir, "report.json")
+	results := propagate("testdata/config/test.json", "", srcPaths, 0, &Options{EditReportPath: reportPath}, nil)
+	validateOutput(t, results, loadPath, true)
+
+	// simulate unrelated changes made after propagation by adding a
+	// function to the transformed file
+	drift := "type T struct{}\n\nfunc baz() int {\n\treturn 42\n}\n"
+	transformed, err := ioutil.ReadFile(filepath.Join(testRoots.expected, loadPath, "test.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	filePath := filepath.Join(tmpDir, "test.go")
+	if err := ioutil.WriteFile(filePath, []byte(strings.Replace(string(transformed), "type T struct{}\n", drift, 1)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// point the report to the drifted file
+	reportBuf, err := ioutil.ReadFile(reportPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var report editReport
+	if err := json.Unmarshal(reportBuf, &report); err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Files) != 1 {
+		t.Fatalf("unexpected number of files in edit report: %d", len(report.Files))
+	}
+	report.Files[0].Path = filePath
+	if reportBuf, err = json.Marshal(report); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(reportPath, reportBuf, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if res := Revert(reportPath, []string{"other/..."}); len(res.Files) != 0 || len(res.Manual) != 0 {
+		t.Errorf("unexpected edits reverted in unselected package")
+	}
+	res := Revert(reportPath, []string{loadPath})
+	if len(res.Manual) != 0 {
+		t.Errorf("unexpected edits to be reverted manually: %v", res.Manual)
+	}
+	orig, err := ioutil.ReadFile(filepath.Join(testRoots.src, loadPath, "test.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := strings.Replace(string(orig), "type T struct{}\n", drift, 1)
+	if string(res.Files[filePath]) != expected {
+		t.Errorf("unexpected reverted file:\n%s", res.Files[filePath])
+	}
+}
+
+func TestStats(t *testing.T) {
+	debugFilePath := filepath.Join(t.TempDir(), "debug.json")
+	// main, TestA and TestMain initialize artificial context while
+	// bar receives context parameter
+	propagate("testdata/config/test_stop.json", debugFilePath, []string{"test-stop"}, 1, nil, nil)
+	validateStats(t, debugFilePath, stats{
+		LeafCalls:           4,
+		FnsVisited:          1,
+		ArtificialCtxs:      map[string]int{"harness": 3},
+		CallsModified:       6,
+		SignaturesModified:  1,
+		DefinitionsModified: 3,
+	})
 }
 
-func TestInterSpec(t *testing.T) {
-	loadPath := "test-inter-spec"
+func TestStructFieldFn(t *testing.T) {
+	loadPath := "test-struct-field-fn"
 	srcPaths := []string{loadPath}
-	results := propagate("testdata/config/test_inter_spec.json", "", srcPaths, 0)
-	// do not recompile transformed code as it would require manual
-	// change of import to point to a new (context aware) interface
-	// instead of the old (not-context aware one)
+	debugFilePath := filepath.Join(t.TempDir(), "debug.json")
+	results := propagate("testdata/config/test_struct_field_fn.json", debugFilePath, srcPaths, 1, nil, nil)
+	validateOutput(t, results, loadPath, true)
+	validateWarning(t, debugFilePath, "WARNING: field handle is not assigned in function Qux - functions assigned to it elsewhere may not receive context parameter")
+}
+
+func TestTimeout(t *testing.T) {
+	loadPath := "test-anon"
+	srcPaths := []string{loadPath}
+	debugFilePath := filepath.Join(t.TempDir(), "debug.json")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	// the context is checked for the first time after packages have
+	// been loaded
+	results, err := tryPropagate(ctx, "testdata/config/test.json", debugFilePath, srcPaths, 1, nil, nil)
+	if err == nil || results != nil || !strings.Contains(err.Error(), context.Canceled.Error()) {
+		t.Fatal("expected propagation to be interrupted")
+	}
+	if _, err := os.Stat(debugFilePath); err != nil {
+		t.Fatal("expected debug info to be output when propagation is interrupted: " + err.Error())
+	}
+}
+
+func TestTraceFunc(t *testing.T) {
+	loadPath := "test-stop"
+	srcPaths := []string{loadPath}
+	debugFilePath := filepath.Join(t.TempDir(), "debug.json")
+	propagate("testdata/config/test_stop.json", debugFilePath, srcPaths, 1, &Options{TraceFunc: "test-stop.bar"}, nil)
+	debugData := readDebugInfo(t, debugFilePath)
+	for _, expected := range []string{
+		"first parameter is not context",
+		"entered work list",
+		"categorized as regular function (receives context parameter)",
+		"calls \"leaf\" function lib.A at ",
+		"call site in test-stop.FooFn at ",
+		"call site in (test-stop.StopTestStruct).FooMethod at ",
+	} {
+		found := false
+		for _, msg := range debugData.Trace {
+			found = found || strings.HasPrefix(msg, expected)
+		}
+		if !found {
+			t.Errorf("trace does not contain message starting with %q", expected)
+		}
+	}
+}
+
+func TestUnsafeCast(t *testing.T) {
+	loadPath := "test-unsafe-cast"
+	srcPaths := []string{loadPath}
+	results := propagate("testdata/config/test_unsafe_cast.json", "", srcPaths, 0, nil, nil)
+	// calls through function values cast from unsafe.Pointer are not
+	// updated to match the modified signature
 	validateOutput(t, results, loadPath, false)
 }
+
+func TestUnusedLibFns(t *testing.T) {
+	loadPath := "test-conversion"
+	srcPaths := []string{loadPath}
+	debugFilePath := filepath.Join(t.TempDir(), "debug.json")
+	results := propagate("testdata/config/test_unused.json", debugFilePath, srcPaths, 1, nil, nil)
+	validateOutput(t, results, loadPath, false)
+	validateUnusedLibFn(t, debugFilePath, "A", "no calls", "")
+	validateUnusedLibFn(t, debugFilePath, "F", "no definition", "(*lib.Rec).F")
+	// leaf function with no matching definition fails the run in
+	// strict mode
+	_, err := tryPropagate(context.Background(), "testdata/config/test_unused.json", debugFilePath, srcPaths, 1, &Options{Strict: true}, nil)
+	if err == nil {
+		t.Log("expected strict mode run to fail")
+		t.FailNow()
+	}
+}
+
+func TestWatcher(t *testing.T) {
+	w := newWatcher()
+	propagate("testdata/config/test.json", "", []string{"test-fn-param"}, 0, &Options{watcher: w}, nil)
+	if len(w.modTimes) != 1 {
+		t.Logf("expected files of one loaded package to be watched: %v", w.modTimes)
+		t.FailNow()
+	}
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "existing.go")
+	added := filepath.Join(dir, "added.go")
+	if err := ioutil.WriteFile(existing, []byte("package test\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	w.addFiles([]string{existing, added})
+	if changed := w.changed(); len(changed) != 0 {
+		t.Logf("unexpected changes: %v", changed)
+		t.FailNow()
+	}
+	// modifications of both files are reported together
+	if err := os.Chtimes(existing, time.Now(), time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(added, []byte("package test\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	changed := w.wait(context.Background(), time.Millisecond, 10*time.Millisecond)
+	if strings.Join(changed, ",") != added+","+existing {
+		t.Logf("unexpected changes: %v", changed)
+		t.FailNow()
+	}
+	// new files and packages under a load path's root are reported
+	// as well
+	pkgFile := filepath.Join(dir, "root", "a", "a.go")
+	newPkgFile := filepath.Join(dir, "root", "b", "b.go")
+	for _, f := range []string{pkgFile, newPkgFile} {
+		if err := os.MkdirAll(filepath.Dir(f), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(pkgFile, []byte("package a\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	w.addPackages([]string{"root/..."}, []*packages.Package{{PkgPath: "root/a", GoFiles: []string{pkgFile}, CompiledGoFiles: []string{pkgFile}}})
+	if err := ioutil.WriteFile(newPkgFile, []byte("package b\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if changed := w.changed(); strings.Join(changed, ",") != newPkgFile {
+		t.Logf("unexpected changes: %v", changed)
+		t.FailNow()
+	}
+}
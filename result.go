@@ -0,0 +1,73 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package propagate
+
+import (
+	"go/types"
+	"golang.org/x/tools/go/ssa"
+)
+
+// ContextAlreadyPropagated returns true if a function with a given
+// name (and, for methods, a given receiver type, e.g. "*Rec") defined
+// in a package at a given path has been found during analysis to
+// already take context as its first parameter.
+func ContextAlreadyPropagated(result *AnalysisResult, pkgPath string, funcName string, recvType string) bool {
+	if result == nil {
+		return false
+	}
+	return result.ctxParams[getFnKey(pkgPath, funcName, recvType)]
+}
+
+// analysisResult computes results of the analysis phase for all
+// declared functions and methods.
+func (cfg *analyzerConfig) analysisResult() *AnalysisResult {
+	res := &AnalysisResult{ctxParams: make(map[string]bool)}
+	allFns, _ := cfg.getDeclaredFnsAndVars()
+	for _, f := range allFns {
+		if f.Pkg == nil {
+			// no package information (e.g. a synthetic function)
+			continue
+		}
+		isParamContext, _, _, _, _ := cfg.isFirstParamContext(f.Signature)
+		res.ctxParams[getFnKey(f.Pkg.Pkg.Path(), f.Name(), getRecvTypeName(f))] = isParamContext
+	}
+	cfg.collectContextFlow(res)
+	cfg.collectContextSensitivity(res)
+	return res
+}
+
+// getFnKey returns a key identifying a function in the analysis
+// results.
+func getFnKey(pkgPath string, funcName string, recvType string) string {
+	if recvType == "" {
+		return pkgPath + "." + funcName
+	}
+	return pkgPath + ".(" + recvType + ")." + funcName
+}
+
+// getRecvTypeName returns a name of the receiver type of a given
+// method (with "*" prefix if the receiver is a pointer) or an empty
+// string if the function is not a method.
+func getRecvTypeName(f *ssa.Function) string {
+	recv := f.Signature.Recv()
+	if recv == nil {
+		return ""
+	}
+	t := recv.Type()
+	prefix := ""
+	if ptr, ok := t.(*types.Pointer); ok {
+		t = ptr.Elem()
+		prefix = "*"
+	}
+	if named, ok := t.(*types.Named); ok {
+		return prefix + named.Obj().Name()
+	}
+	return prefix + t.String()
+}
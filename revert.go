@@ -0,0 +1,378 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package propagate
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/tools/go/ast/astutil"
+)
+
+// RevertResult contains results of reverting edits described in an
+// edit report (see Revert).
+type RevertResult struct {
+	// Files maps paths of reverted files to their new content.
+	Files map[string][]byte
+	// Manual describes edits that could not be reverted automatically
+	// and have to be reverted manually.
+	Manual []string
+}
+
+// Revert reverts edits described in an edit report (see
+// Options.EditReportPath) in files of packages matching given
+// patterns (a pattern ending with "/..." matches a package and all
+// its subpackages). Edits are located using names of symbols they
+// have been made in so that they can be reverted even if files have
+// changed since. Edits that cannot be located unambiguously are not
+// reverted but returned in the result to be handled manually.
+func Revert(reportPath string, pkgPatterns []string) *RevertResult {
+	res, err := tryRevert(reportPath, pkgPatterns)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return res
+}
+
+// tryRevert is the same as Revert but it returns an error instead of
+// terminating the program if reverting fails.
+func tryRevert(reportPath string, pkgPatterns []string) (*RevertResult, error) {
+	data, err := ioutil.ReadFile(reportPath)
+	if err != nil {
+		return nil, err
+	}
+	var report editReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, errors.New("error decoding edit report " + reportPath + ": " + err.Error())
+	}
+	res := &RevertResult{Files: make(map[string][]byte)}
+	for _, fe := range report.Files {
+		if !matchesPkgPatterns(fe.PkgPath, pkgPatterns) {
+			continue
+		}
+		path := filepath.FromSlash(fe.Path)
+		if report.FilePrefix != "" && !filepath.IsAbs(path) {
+			path = filepath.Join(report.FilePrefix, path)
+		}
+		src, manual, err := revertFile(path, fe)
+		if err != nil {
+			return nil, err
+		}
+		if src != nil {
+			res.Files[path] = src
+		}
+		res.Manual = append(res.Manual, manual...)
+	}
+	return res, nil
+}
+
+// matchesPkgPatterns determines if a given package path matches any of
+// the given patterns.
+func matchesPkgPatterns(pkgPath string, pkgPatterns []string) bool {
+	for _, pattern := range pkgPatterns {
+		if pattern == "..." {
+			return true
+		}
+		if prefix := strings.TrimSuffix(pattern, "/..."); prefix != pattern {
+			if pkgPath == prefix || strings.HasPrefix(pkgPath, prefix+"/") {
+				return true
+			}
+		} else if pkgPath == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// argEditKey identifies a group of context arguments that are
+// indistinguishable when located by symbol name (e.g. the same
+// function called multiple times within the same function).
+type argEditKey struct {
+	fn         string
+	callee     string
+	origCallee string
+	argIndex   int
+	expr       string
+}
+
+// fileReverter is data used when reverting edits in a single file.
+type fileReverter struct {
+	fset *token.FileSet
+	f    *ast.File
+	// fe describes edits to be reverted.
+	fe *fileEdits
+	// decls maps names of top-level symbols to their declarations
+	// (function declarations or type specs).
+	decls map[string][]ast.Node
+	// modified is true if the file has been modified.
+	modified bool
+	// manual describes edits that have to be reverted manually.
+	manual []string
+}
+
+// revertFile reverts edits in a given file and returns the file's new
+// content (nil if no edits have been reverted) along with
+// descriptions of edits that have to be reverted manually.
+func revertFile(path string, fe *fileEdits) ([]byte, []string, error) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, path, nil, parser.ParseComments)
+	if err != nil {
+		return nil, nil, err
+	}
+	r := &fileReverter{fset: fset, f: f, fe: fe, decls: make(map[string][]ast.Node)}
+	for _, d := range f.Decls {
+		switch d := d.(type) {
+		case *ast.FuncDecl:
+			name := fnDeclSymbol(d)
+			r.decls[name] = append(r.decls[name], d)
+		case *ast.GenDecl:
+			for _, s := range d.Specs {
+				if ts, ok := s.(*ast.TypeSpec); ok {
+					r.decls[ts.Name.Name] = append(r.decls[ts.Name.Name], ts)
+				}
+			}
+		}
+	}
+
+	argEdits := make(map[argEditKey][]edit)
+	var argKeys []argEditKey
+	for _, e := range fe.Edits {
+		switch e.Kind {
+		case paramEdit:
+			r.revertParam(e)
+		case initEdit:
+			r.revertInit(e)
+		case ifaceParamEdit:
+			r.revertIfaceParam(e)
+		case argEdit:
+			key := argEditKey{e.Func, e.Callee, e.OrigCallee, e.ArgIndex, e.Expr}
+			if _, exists := argEdits[key]; !exists {
+				argKeys = append(argKeys, key)
+			}
+			argEdits[key] = append(argEdits[key], e)
+		default:
+			r.addManual(e, "")
+		}
+	}
+	for _, key := range argKeys {
+		r.revertArgs(key, argEdits[key])
+	}
+	r.revertImports()
+
+	if !r.modified {
+		return nil, r.manual, nil
+	}
+	var buf bytes.Buffer
+	if err := format.Node(&buf, fset, f); err != nil {
+		return nil, nil, err
+	}
+	return buf.Bytes(), r.manual, nil
+}
+
+// addManual records an edit that has to be reverted manually, with an
+// optional reason.
+func (r *fileReverter) addManual(e edit, reason string) {
+	msg := r.fe.Path + ":" + strconv.Itoa(e.Line) + ": " + e.Kind
+	if e.Func != "" {
+		msg += " edit in " + e.Func
+	} else {
+		msg += " edit"
+	}
+	if reason != "" {
+		msg += " (" + reason + ")"
+	}
+	r.manual = append(r.manual, msg)
+}
+
+// findFuncDecl returns the only function declaration with a given
+// name, if any.
+func (r *fileReverter) findFuncDecl(name string) *ast.FuncDecl {
+	if len(r.decls[name]) != 1 {
+		return nil
+	}
+	fd, _ := r.decls[name][0].(*ast.FuncDecl)
+	return fd
+}
+
+// isCtxParam determines if a given parameter has a given name and
+// type.
+func isCtxParam(fld *ast.Field, name string, typ string) bool {
+	if types.ExprString(fld.Type) != typ || len(fld.Names) > 1 {
+		return false
+	}
+	return paramName(fld) == name
+}
+
+// revertParam removes context parameter from a function declaration.
+func (r *fileReverter) revertParam(e edit) {
+	fd := r.findFuncDecl(e.Func)
+	if fd == nil {
+		r.addManual(e, "function not found")
+		return
+	}
+	params := fd.Type.Params.List
+	i := e.ArgIndex
+	if len(params) <= i || !isCtxParam(params[i], e.Name, e.Type) {
+		r.addManual(e, "context parameter not found")
+		return
+	}
+	fd.Type.Params.List = append(params[:i:i], params[i+1:]...)
+	r.modified = true
+}
+
+// revertInit removes artificial context variable initialization from
+// a function declaration.
+func (r *fileReverter) revertInit(e edit) {
+	fd := r.findFuncDecl(e.Func)
+	if fd == nil || fd.Body == nil {
+		r.addManual(e, "function not found")
+		return
+	}
+	if len(fd.Body.List) == 0 {
+		r.addManual(e, "context variable not found")
+		return
+	}
+	as, ok := fd.Body.List[0].(*ast.AssignStmt)
+	if !ok || as.Tok != token.DEFINE || len(as.Lhs) != 1 || len(as.Rhs) != 1 {
+		r.addManual(e, "context variable not found")
+		return
+	}
+	if id, ok := as.Lhs[0].(*ast.Ident); !ok || id.Name != e.Name || types.ExprString(as.Rhs[0]) != e.Expr {
+		r.addManual(e, "context variable not found")
+		return
+	}
+	// move the opening brace to the removed statement's line so that
+	// no empty line is left in its place
+	fd.Body.Lbrace = as.Pos()
+	fd.Body.List = fd.Body.List[1:]
+	r.modified = true
+}
+
+// revertIfaceParam removes context parameter from an interface
+// method.
+func (r *fileReverter) revertIfaceParam(e edit) {
+	var it *ast.InterfaceType
+	if len(r.decls[e.Func]) == 1 {
+		if ts, ok := r.decls[e.Func][0].(*ast.TypeSpec); ok {
+			it, _ = ts.Type.(*ast.InterfaceType)
+		}
+	}
+	if it == nil {
+		r.addManual(e, "interface not found")
+		return
+	}
+	for _, m := range it.Methods.List {
+		if len(m.Names) != 1 || m.Names[0].Name != e.Name {
+			continue
+		}
+		ft, ok := m.Type.(*ast.FuncType)
+		if !ok {
+			break
+		}
+		params := ft.Params.List
+		i := e.ArgIndex
+		if len(params) <= i || types.ExprString(params[i].Type) != e.Type || len(params[i].Names) > 1 {
+			break
+		}
+		ft.Params.List = append(params[:i:i], params[i+1:]...)
+		r.modified = true
+		return
+	}
+	r.addManual(e, "context parameter of method "+e.Name+" not found")
+}
+
+// revertArgs removes context arguments (and restores original names
+// of called functions) at call sites within a given function. Call
+// sites are only reverted if their number is the same as the number
+// of recorded edits, as otherwise it cannot be determined which of
+// them have been edited.
+func (r *fileReverter) revertArgs(key argEditKey, edits []edit) {
+	if key.fn == "" || len(r.decls[key.fn]) != 1 {
+		for _, e := range edits {
+			r.addManual(e, "enclosing symbol not found")
+		}
+		return
+	}
+	var calls []*ast.CallExpr
+	// calls are collected in pre-order to match callee expressions
+	// recorded after nested calls have been edited
+	ast.Inspect(r.decls[key.fn][0], func(n ast.Node) bool {
+		e, ok := n.(*ast.CallExpr)
+		if !ok || len(e.Args) <= key.argIndex {
+			return true
+		}
+		if types.ExprString(e.Fun) == key.callee && types.ExprString(e.Args[key.argIndex]) == key.expr {
+			calls = append(calls, e)
+		}
+		return true
+	})
+	if len(calls) != len(edits) {
+		for _, e := range edits {
+			r.addManual(e, "found "+strconv.Itoa(len(calls))+" matching call sites instead of "+strconv.Itoa(len(edits)))
+		}
+		return
+	}
+	for _, e := range calls {
+		var newArgs []ast.Expr
+		newArgs = append(newArgs, e.Args[:key.argIndex]...)
+		newArgs = append(newArgs, e.Args[key.argIndex+1:]...)
+		e.Args = newArgs
+		if key.origCallee != "" {
+			e.Fun = restoreCallee(e.Fun, key.origCallee)
+		}
+	}
+	r.modified = true
+}
+
+// restoreCallee returns called function expression restored to its
+// original form (preserving the current expression's position).
+func restoreCallee(fun ast.Expr, origCallee string) ast.Expr {
+	orig, err := parser.ParseExpr(origCallee)
+	if err != nil {
+		return &ast.Ident{NamePos: fun.Pos(), Name: origCallee}
+	}
+	switch orig := orig.(type) {
+	case *ast.Ident:
+		return &ast.Ident{NamePos: fun.Pos(), Name: orig.Name}
+	case *ast.SelectorExpr:
+		if sel, ok := fun.(*ast.SelectorExpr); ok && types.ExprString(sel.X) == types.ExprString(orig.X) {
+			// only the selected name has changed
+			return &ast.SelectorExpr{X: sel.X, Sel: &ast.Ident{NamePos: sel.Sel.NamePos, Name: orig.Sel.Name}}
+		}
+		// package qualifiers must be identifiers for unused imports
+		// to be detected
+		return &ast.SelectorExpr{X: &ast.Ident{NamePos: fun.Pos(), Name: types.ExprString(orig.X)}, Sel: ast.NewIdent(orig.Sel.Name)}
+	}
+	return &ast.Ident{NamePos: fun.Pos(), Name: origCallee}
+}
+
+// revertImports removes added imports that are no longer used.
+func (r *fileReverter) revertImports() {
+	for _, imp := range r.fe.ImportsAdded {
+		if astutil.UsesImport(r.f, imp) {
+			r.manual = append(r.manual, r.fe.Path+": import "+strconv.Quote(imp)+" is still used")
+			continue
+		}
+		if astutil.DeleteImport(r.fset, r.f, imp) {
+			r.modified = true
+		}
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package propagate
+
+import "sort"
+
+// Categories of functions in the context sensitivity report.
+const (
+	// function receiving propagated context at all its call sites
+	// (or extracting context from its receiver or parameter)
+	sensitivityFull = iota
+	// function receiving propagated context at some of its call
+	// sites and artificial context at others
+	sensitivityPartial
+	// function initializing artificial context
+	sensitivityArtificial
+)
+
+// ContextSensitivityReport groups functions that have become
+// context-sensitive as a result of context propagation by how their
+// context-sensitivity has been achieved (e.g. to track completeness
+// of a migration). Functions are identified by their names qualified
+// with package path (and receiver type for methods).
+type ContextSensitivityReport struct {
+	// FullyPropagated are functions receiving injected context
+	// parameter at whose call sites context is always propagated
+	// from their callers, as well as functions initializing context
+	// using their receiver (or parameter).
+	FullyPropagated []string
+	// PartiallyPropagated are functions receiving injected context
+	// parameter at some of whose call sites artificial context is
+	// passed instead (e.g. when called from a package initializer).
+	PartiallyPropagated []string
+	// ArtificialOnly are functions initializing artificial context
+	// (no context is propagated to them at all).
+	ArtificialOnly []string
+}
+
+// BuildContextSensitivityReport builds the context sensitivity report
+// from the analysis results.
+func BuildContextSensitivityReport(result *AnalysisResult) *ContextSensitivityReport {
+	r := &ContextSensitivityReport{FullyPropagated: []string{}, PartiallyPropagated: []string{}, ArtificialOnly: []string{}}
+	if result == nil {
+		return r
+	}
+	for name, category := range result.sensitivity {
+		switch category {
+		case sensitivityFull:
+			r.FullyPropagated = append(r.FullyPropagated, name)
+		case sensitivityPartial:
+			r.PartiallyPropagated = append(r.PartiallyPropagated, name)
+		case sensitivityArtificial:
+			r.ArtificialOnly = append(r.ArtificialOnly, name)
+		}
+	}
+	sort.Strings(r.FullyPropagated)
+	sort.Strings(r.PartiallyPropagated)
+	sort.Strings(r.ArtificialOnly)
+	return r
+}
+
+// collectContextSensitivity records categories of functions in the
+// context sensitivity report in the analysis results.
+func (cfg *analyzerConfig) collectContextSensitivity(res *AnalysisResult) {
+	res.sensitivity = make(map[string]int)
+	for f, n := range cfg.graph.Nodes {
+		if f == nil {
+			continue
+		}
+		uniquePos := cfg.getUniquePosSSAFn(f, f.Pos())
+		fnType, exists := cfg.fnVisited[uniquePos]
+		if !exists {
+			continue
+		}
+		category := sensitivityFull
+		if fnType == freshCtxFn {
+			if _, extracted := cfg.ctxInitExprs[uniquePos]; !extracted {
+				category = sensitivityArtificial
+			}
+		} else if fnType != regularFn {
+			continue
+		} else {
+			for _, in := range n.In {
+				if cfg.isNilCallReplacement(cfg.callSites[cfg.getUniquePosCallSite(in)]) {
+					category = sensitivityPartial
+					break
+				}
+			}
+		}
+		// the same function may be represented multiple times in
+		// package variants - partial propagation in any of them
+		// takes precedence
+		if prev, exists := res.sensitivity[f.String()]; !exists || prev != sensitivityPartial {
+			res.sensitivity[f.String()] = category
+		}
+	}
+}
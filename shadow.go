@@ -0,0 +1,186 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package propagate
+
+import (
+	"bytes"
+	"go/ast"
+	"go/format"
+	"go/token"
+	"go/types"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/tools/go/ast/astutil"
+	"golang.org/x/tools/go/packages"
+)
+
+// shadowFileName is the name of the generated file containing a
+// context-aware copy of the library interface.
+const shadowFileName = "context_shadow.go"
+
+// generateInterfaceShadow generates a file in the package specified
+// by the InterfaceShadowPkg config option containing a copy of the
+// library interface (LibIface) whose "leaf" methods take context
+// parameter. Internal references to the library interface are
+// rewritten to refer to this copy during transformation so that the
+// transformed code compiles without modifying the library itself.
+func (cfg *config) generateInterfaceShadow() {
+	if cfg.InterfaceShadowPkg == "" {
+		return
+	}
+	libIface := cfg.findLibIface()
+	if libIface == nil {
+		fatal("error finding library interface " + cfg.LibIface + " in package " + cfg.LibPkgPath)
+	}
+	iface := libIface.Type().Underlying().(*types.Interface)
+	var shadowPkg *packages.Package
+	for _, p := range cfg.initial {
+		if p.PkgPath == cfg.InterfaceShadowPkg && len(p.GoFiles) > 0 && !strings.HasSuffix(p.Name, "_test") {
+			shadowPkg = p
+			break
+		}
+	}
+	if shadowPkg == nil {
+		fatal("error finding package " + cfg.InterfaceShadowPkg + " for library interface copy among loaded packages")
+	}
+	if obj := shadowPkg.Types.Scope().Lookup(cfg.LibIface); obj != nil && filepath.Base(shadowPkg.Fset.Position(obj.Pos()).Filename) != shadowFileName {
+		fatal("error generating library interface copy in package " + cfg.InterfaceShadowPkg + " which already defines " + cfg.LibIface)
+	}
+	ctxPkg := cfg.findCtxPkg()
+	if ctxPkg == nil {
+		fatal("error finding context package " + cfg.CtxPkgPath + "/" + cfg.CtxPkgName + " among loaded packages")
+	}
+	ctxObj, ok := ctxPkg.Scope().Lookup(strings.TrimPrefix(cfg.CtxParamType, "*")).(*types.TypeName)
+	if !ok {
+		fatal("error finding context type " + cfg.CtxParamType + " in package " + cfg.CtxPkgPath)
+	}
+	ctxType := ctxObj.Type()
+	if strings.HasPrefix(cfg.CtxParamType, "*") {
+		ctxType = types.NewPointer(ctxType)
+	}
+
+	// packages imported by the shadow file (path -> name)
+	imports := make(map[string]string)
+	qualifier := func(p *types.Package) string {
+		if p.Path() == shadowPkg.PkgPath {
+			return ""
+		}
+		imports[p.Path()] = p.Name()
+		return p.Name()
+	}
+
+	var methods bytes.Buffer
+	for i := 0; i < iface.NumMethods(); i++ {
+		m := iface.Method(i)
+		if !m.Exported() {
+			fatal("error generating copy of library interface " + cfg.LibIface + " with unexported method " + m.Name())
+		}
+		sig := m.Type().(*types.Signature)
+		if _, isLeaf := cfg.LibFns[m.Name()]; isLeaf && (sig.Params().Len() == 0 || getTypeWithPkgFromVar(sig.Params().At(0)) != cfg.ctxParamTypeWithPkgPathName) {
+			params := []*types.Var{types.NewParam(token.NoPos, nil, cfg.CtxParamName, ctxType)}
+			for j := 0; j < sig.Params().Len(); j++ {
+				params = append(params, sig.Params().At(j))
+			}
+			sig = types.NewSignatureType(nil, nil, nil, types.NewTuple(params...), sig.Results(), sig.Variadic())
+		}
+		methods.WriteString("\t" + m.Name() + strings.TrimPrefix(types.TypeString(sig, qualifier), "func") + "\n")
+	}
+
+	var src bytes.Buffer
+	src.WriteString("// Code generated by go-context-propagate. DO NOT EDIT.\n\n")
+	src.WriteString("package " + shadowPkg.Name + "\n\n")
+	if len(imports) > 0 {
+		src.WriteString("import (\n")
+		paths := make([]string, 0, len(imports))
+		for p := range imports {
+			paths = append(paths, p)
+		}
+		sort.Strings(paths)
+		for _, p := range paths {
+			if imports[p] != path.Base(p) {
+				src.WriteString(imports[p] + " ")
+			}
+			src.WriteString(strconv.Quote(p) + "\n")
+		}
+		src.WriteString(")\n\n")
+	}
+	src.WriteString("// " + cfg.LibIface + " is a context-aware copy of " + cfg.LibPkgName + "." + cfg.LibIface + ".\n")
+	src.WriteString("type " + cfg.LibIface + " interface {\n")
+	src.Write(methods.Bytes())
+	src.WriteString("}\n")
+
+	formatted, err := format.Source(src.Bytes())
+	if err != nil {
+		fatal("error formatting generated library interface copy: " + err.Error())
+	}
+	cfg.addNewFile(shadowPkg, filepath.Join(filepath.Dir(shadowPkg.GoFiles[0]), shadowFileName), formatted)
+	cfg.shadowPkgName = shadowPkg.Name
+}
+
+// findLibIface returns type name of the library interface (or nil if
+// the library package is not among loaded packages or the interface
+// is not defined there).
+func (cfg *config) findLibIface() *types.TypeName {
+	for _, p := range cfg.initial {
+		if p.Types == nil || !cfg.isLibPkg(p.PkgPath, p.Name) {
+			continue
+		}
+		if obj, ok := p.Types.Scope().Lookup(cfg.LibIface).(*types.TypeName); ok && types.IsInterface(obj.Type()) {
+			return obj
+		}
+	}
+	return nil
+}
+
+// isLibIfaceRef checks if a selector expression refers to the library
+// interface whose context-aware copy has been generated.
+func (cfg *transformerConfig) isLibIfaceRef(sel *ast.SelectorExpr) bool {
+	if cfg.shadowPkgName == "" {
+		return false
+	}
+	obj, ok := cfg.currentPkg.TypesInfo.Uses[sel.Sel].(*types.TypeName)
+	return ok && obj.Name() == cfg.LibIface && obj.Pkg() != nil && cfg.isLibPkg(obj.Pkg().Path(), obj.Pkg().Name())
+}
+
+// replaceLibIfaceRef replaces a reference to the library interface
+// with a reference to its context-aware copy.
+func (cfg *transformerConfig) replaceLibIfaceRef(c *astutil.Cursor, sel *ast.SelectorExpr) {
+	// positions are preserved so that the code is laid out the same
+	// way by the printer
+	if cfg.currentPkg.PkgPath == cfg.InterfaceShadowPkg {
+		c.Replace(&ast.Ident{NamePos: sel.Pos(), Name: cfg.LibIface})
+	} else {
+		qualifier, found := cfg.existingImports[cfg.InterfaceShadowPkg]
+		if !found || qualifier == "" {
+			qualifier = cfg.shadowPkgName
+			cfg.newImports[cfg.InterfaceShadowPkg] = ""
+		}
+		c.Replace(&ast.SelectorExpr{X: &ast.Ident{NamePos: sel.Pos(), Name: qualifier}, Sel: &ast.Ident{NamePos: sel.Sel.Pos(), Name: cfg.LibIface}})
+	}
+	cfg.modified = true
+	cfg.addEdit(sel.Pos(), edit{Kind: shadowRefEdit, Name: cfg.LibIface})
+}
+
+// removeUnusedLibImport removes import of the library package if all
+// its uses have been replaced with references to the context-aware
+// copy of the library interface.
+func (cfg *transformerConfig) removeUnusedLibImport(f *ast.File) {
+	if cfg.shadowPkgName == "" {
+		return
+	}
+	if _, found := cfg.existingImports[cfg.LibPkgPath]; found && !astutil.UsesImport(f, cfg.LibPkgPath) {
+		astutil.DeleteImport(cfg.currentPkg.Fset, f, cfg.LibPkgPath)
+		cfg.addEdit(f.Package, edit{Kind: importRemovedEdit, Name: cfg.LibPkgPath})
+	}
+}
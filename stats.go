@@ -0,0 +1,69 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package propagate
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// artificialCtxReasons are names of reasons for initializing
+// artificial context (function kinds in fnVisited map) used in
+// statistics - functions with no prior kind are called by the test
+// harness or the runtime.
+var artificialCtxReasons = map[int]string{
+	regularFn:     "harness",
+	containerSig:  "containerSig",
+	extFn:         "extFn",
+	extPkg:        "extPkg",
+	extRecv:       "extRecv",
+	testSuiteRecv: "testSuiteRecv",
+	unsafeCastFn:  "unsafeCast",
+	generatedFn:   "generated",
+	frozenFn:      "frozen",
+}
+
+// collectStats records analysis-side statistics in debug data.
+func (cfg *analyzerConfig) collectStats() {
+	s := &cfg.debugData.Stats
+	s.FnsVisited = 0
+	for _, fnType := range cfg.fnVisited {
+		if fnType == regularFn {
+			s.FnsVisited++
+		}
+	}
+	s.ArtificialCtxs = make(map[string]int)
+	for _, fnType := range cfg.artificialCtxFns {
+		s.ArtificialCtxs[artificialCtxReasons[fnType]]++
+	}
+	if cfg.debugLevel > 0 {
+		fmt.Println("FUNCTIONS VISITED: " + strconv.Itoa(s.FnsVisited))
+		reasons := make([]string, 0, len(s.ArtificialCtxs))
+		for reason := range s.ArtificialCtxs {
+			reasons = append(reasons, reason)
+		}
+		sort.Strings(reasons)
+		for _, reason := range reasons {
+			fmt.Println("ARTIFICIAL CONTEXTS (" + reason + "): " + strconv.Itoa(s.ArtificialCtxs[reason]))
+		}
+	}
+}
+
+// printTransformStats prints transformation-side statistics.
+func (s *stats) printTransformStats() {
+	fmt.Println("IFACES MODIFIED: " + strconv.Itoa(s.IfacesModified) + " METHODS: " + strconv.Itoa(s.IfaceMethodsModified))
+	fmt.Println("NAMED MODIFIED: " + strconv.Itoa(s.NamedModified))
+	fmt.Println("PARAMS MODIFIED: " + strconv.Itoa(s.ParamsModified))
+	fmt.Println("CALLS MODIFIED: " + strconv.Itoa(s.CallsModified))
+	fmt.Println("SIGNATURES MODIFIED: " + strconv.Itoa(s.SignaturesModified))
+	fmt.Println("DEFINITIONS MODIFIED: " + strconv.Itoa(s.DefinitionsModified))
+	fmt.Println("IMPORTS ADDED: " + strconv.Itoa(s.ImportsAdded))
+}
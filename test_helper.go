@@ -11,64 +11,299 @@ package propagate
 
 import (
 	"bytes"
+	"encoding/json"
 	"go/ast"
 	"go/format"
 	"golang.org/x/tools/go/packages"
 	"io/ioutil"
+	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
 )
 
+// rootPair describes root directory of test source files and root
+// directory of files containing expected output for these source files
+// (located at the same relative paths).
+type rootPair struct {
+	src      string
+	expected string
+}
+
+// testRoots are root directories used by tests.
+var testRoots = rootPair{
+	src:      filepath.Join("testdata", "src"),
+	expected: filepath.Join("testdata", "src", "expected"),
+}
+
+// expectedPath returns path of a file containing expected output for
+// a given source file.
+func (r rootPair) expectedPath(filePath string) string {
+	return r.mapPath(filePath, filepath.Separator)
+}
+
+// mapPath maps path of a file located under the source root to the
+// path of the file at the same relative path under the expected root,
+// for paths using a given separator (empty string is returned if the
+// file is not located under the source root).
+func (r rootPair) mapPath(filePath string, sep byte) string {
+	s := string(sep)
+	src := s + strings.Trim(r.src, s) + s
+	// relative paths may start with the source root
+	filePath = s + filePath
+	ind := strings.LastIndex(filePath, src)
+	if ind < 0 {
+		return ""
+	}
+	return (filePath[:ind] + s + strings.Trim(r.expected, s) + s + filePath[ind+len(src):])[1:]
+}
+
 // validateOutput compares generated output with expected output and
 // type-checks expected output.
 func validateOutput(t *testing.T, results map[*packages.Package]map[*ast.File]int, loadPath string, recompile bool) {
-	fail := false
-	if len(results) == 0 {
-		t.Log("no files have been refactored")
-		t.FailNow()
+	modified := make(map[string][]byte)
+	for p, nodes := range results {
+		for n, ind := range nodes {
+			var buf bytes.Buffer
+			if err := format.Node(&buf, p.Fset, n); err != nil {
+				t.Log("could not format refactored AST")
+				t.FailNow()
+			}
+			modified[p.CompiledGoFiles[ind]] = buf.Bytes()
+		}
 	}
+	validateModified(t, modified, loadPath, recompile)
+}
+
+// validatePreserved compares output generated with formatting of the
+// original source code preserved with expected output and type-checks
+// expected output.
+func validatePreserved(t *testing.T, results map[*packages.Package]map[*ast.File]int, loadPath string, recompile bool) {
+	modified := make(map[string][]byte)
 	for p, nodes := range results {
 		for n, ind := range nodes {
-			expectedPath := strings.ReplaceAll(p.CompiledGoFiles[ind], "testdata/src", "testdata/src/expected")
-			refactoredBuf, err := ioutil.ReadFile(expectedPath)
+			srcBuf, err := ioutil.ReadFile(p.CompiledGoFiles[ind])
 			if err != nil {
-				t.Log("could not read file containing expected refactored output: " + expectedPath)
+				t.Log("could not read file containing original source code: " + p.CompiledGoFiles[ind])
 				t.FailNow()
 			}
-			var buf bytes.Buffer
-			err = format.Node(&buf, p.Fset, n)
+			buf, err := formatPreserving(p.Fset, n, p.CompiledGoFiles[ind], srcBuf)
 			if err != nil {
-				t.Log("could not format refactored AST")
+				t.Log("could not preserve formatting of refactored AST")
+				t.Log(err)
 				t.FailNow()
 			}
-			if !bytes.Equal(refactoredBuf, buf.Bytes()) {
-				t.Log("refactored file and expected refactored output have different content")
-				t.Log("REFACTORED\n" + string(buf.Bytes()))
-				t.Log("EXPECTED\n" + string(refactoredBuf))
-				// set flag instead of failing so that we can observe more than one mismatch
-				fail = true
-			}
+			modified[p.CompiledGoFiles[ind]] = buf
 		}
 	}
+	validateModified(t, modified, loadPath, recompile)
+}
+
+// validateModified compares content of modified files (indexed by
+// their original paths) with expected output and type-checks expected
+// output.
+func validateModified(t *testing.T, modified map[string][]byte, loadPath string, recompile bool) {
+	if len(modified) == 0 {
+		t.Log("no files have been refactored")
+		t.FailNow()
+	}
+	fail := false
+	for path, buf := range modified {
+		// keep comparing instead of failing so that we can observe more than one mismatch
+		fail = !compareRefactored(t, path, buf) || fail
+	}
 	if fail {
 		t.FailNow()
 	}
 	if recompile {
-		cfg := &packages.Config{Mode: packages.LoadAllSyntax, Tests: true}
-		loaded, err := packages.Load(cfg, "expected/"+loadPath)
-		if err != nil {
-			t.Log("could not load refactored packages")
-			t.Log(err)
+		validateCompile(t, "expected/"+loadPath)
+	}
+}
+
+// compareRefactored compares refactored content of a file at a given
+// path with expected output and returns false (after logging both) if
+// they differ.
+func compareRefactored(t *testing.T, path string, buf []byte) bool {
+	expectedPath := testRoots.expectedPath(path)
+	refactoredBuf, err := ioutil.ReadFile(expectedPath)
+	if err != nil {
+		t.Log("could not read file containing expected refactored output: " + expectedPath)
+		t.FailNow()
+	}
+	if !bytes.Equal(refactoredBuf, buf) {
+		t.Log("refactored file and expected refactored output have different content: " + path)
+		t.Log("REFACTORED\n" + string(buf))
+		t.Log("EXPECTED\n" + string(refactoredBuf))
+		return false
+	}
+	return true
+}
+
+// validateCompile type-checks packages at a given load path.
+func validateCompile(t *testing.T, loadPath string) {
+	cfg := &packages.Config{Mode: packages.LoadAllSyntax, Tests: true}
+	loaded, err := packages.Load(cfg, loadPath)
+	if err != nil {
+		t.Log("could not load refactored packages")
+		t.Log(err)
+		t.FailNow()
+	}
+	for _, p := range loaded {
+		if len(p.Errors) > 0 {
+			t.Log("refactored package loading errors")
+			for _, e := range p.Errors {
+				t.Log(e)
+			}
 			t.FailNow()
 		}
-		for _, p := range loaded {
-			if len(p.Errors) > 0 {
-				t.Log("refactored package loading errors")
-				for _, e := range p.Errors {
-					t.Log(e)
-				}
-				t.FailNow()
-			}
+	}
+}
+
+// validateFile compares content of a generated file with expected
+// content.
+func validateFile(t *testing.T, generatedPath string, expectedPath string) {
+	generatedBuf, err := ioutil.ReadFile(generatedPath)
+	if err != nil {
+		t.Log("could not read generated file: " + generatedPath)
+		t.FailNow()
+	}
+	expectedBuf, err := ioutil.ReadFile(expectedPath)
+	if err != nil {
+		t.Log("could not read file containing expected output: " + expectedPath)
+		t.FailNow()
+	}
+	if !bytes.Equal(generatedBuf, expectedBuf) {
+		t.Log("generated file and expected output have different content")
+		t.Log("GENERATED\n" + string(generatedBuf))
+		t.Log("EXPECTED\n" + string(expectedBuf))
+		t.FailNow()
+	}
+}
+
+// readDebugInfo reads and parses the debug file.
+func readDebugInfo(t *testing.T, debugFilePath string) debugInfo {
+	debugBuf, err := ioutil.ReadFile(debugFilePath)
+	if err != nil {
+		t.Log("could not read debug file: " + debugFilePath)
+		t.FailNow()
+	}
+	var debugData debugInfo
+	if err := json.Unmarshal(debugBuf, &debugData); err != nil {
+		t.Log("could not parse debug file: " + debugFilePath)
+		t.FailNow()
+	}
+	return debugData
+}
+
+// findEntry returns the first debug file entry whose value for a
+// given key is equal to a given value, or nil if there is none.
+func findEntry(entries []map[string]string, key string, value string) map[string]string {
+	for _, e := range entries {
+		if e[key] == value {
+			return e
 		}
 	}
+	return nil
+}
+
+// validateEntries checks if debug file entries, each described by a
+// given function, match the expected descriptions (including their
+// order).
+func validateEntries(t *testing.T, desc string, entries []map[string]string, describe func(map[string]string) string, expected []string) {
+	var actual []string
+	for _, e := range entries {
+		actual = append(actual, describe(e))
+	}
+	if strings.Join(actual, ",") != strings.Join(expected, ",") {
+		t.Log("unexpected " + desc + ": " + strings.Join(actual, ","))
+		t.FailNow()
+	}
+}
+
+// validateWarning checks if a given warning has been written to the
+// debug file.
+func validateWarning(t *testing.T, debugFilePath string, msg string) {
+	if findEntry(readDebugInfo(t, debugFilePath).Warnings, "msg", msg) == nil {
+		t.Log("expected warning not found: " + msg)
+		t.FailNow()
+	}
+}
+
+// validateUnusedLibFn validates that a debug file lists a given "leaf"
+// function as unused for a given reason and with given candidates.
+func validateUnusedLibFn(t *testing.T, debugFilePath string, fn string, reason string, candidates string) {
+	u := findEntry(readDebugInfo(t, debugFilePath).UnusedLibFns, "fn", fn)
+	if u == nil || u["reason"] != reason || u["candidates"] != candidates {
+		t.Log("expected unused leaf function not found: " + fn + ": " + reason + " (candidates: " + candidates + ")")
+		t.FailNow()
+	}
+}
+
+// validateCtxEscapes validates that a debug file lists given context
+// escapes (each described as "fn:param:line") and no other ones.
+func validateCtxEscapes(t *testing.T, debugFilePath string, expected []string) {
+	validateEntries(t, "context escapes", readDebugInfo(t, debugFilePath).CtxEscapes, func(e map[string]string) string {
+		return e["fn"] + ":" + e["param"] + ":" + e["line"]
+	}, expected)
+}
+
+// validateLeafStats validates that a debug file lists given "leaf"
+// function statistics (each described as
+// "fn:callSites/fnsModified/shared/artificialEnds/realEnds") and no
+// other ones.
+func validateLeafStats(t *testing.T, debugFilePath string, expected []string) {
+	validateEntries(t, "leaf function statistics", readDebugInfo(t, debugFilePath).LeafStats, func(s map[string]string) string {
+		return s["fn"] + ":" + s["callSites"] + "/" + s["fnsModified"] + "/" + s["shared"] + "/" + s["artificialEnds"] + "/" + s["realEnds"]
+	}, expected)
+}
+
+// validateStats checks if statistics of the analysis and
+// transformation recorded in the debug file match the expected ones.
+func validateStats(t *testing.T, debugFilePath string, expected stats) {
+	if actual := readDebugInfo(t, debugFilePath).Stats; !reflect.DeepEqual(actual, expected) {
+		actualBuf, _ := json.Marshal(actual)
+		t.Log("unexpected statistics: " + string(actualBuf))
+		t.FailNow()
+	}
+}
+
+// describeArtificialCtx describes an artificial context injection
+// recorded in the debug file as "file:line:kind" (with file base name).
+func describeArtificialCtx(m map[string]string) string {
+	return filepath.Base(m["file"]) + ":" + m["line"] + ":" + m["kind"]
+}
+
+// validateArtificialCtx checks if artificial context injections
+// recorded in the debug file as allowed and disallowed match the
+// expected ones (described as "file:line:kind" with file base names).
+func validateArtificialCtx(t *testing.T, debugFilePath string, allowed []string, disallowed []string) {
+	debugData := readDebugInfo(t, debugFilePath)
+	validateEntries(t, "allowed artificial context injections", debugData.ArtificialCtxAllowed, describeArtificialCtx, allowed)
+	validateEntries(t, "disallowed artificial context injections", debugData.ArtificialCtxDisallowed, describeArtificialCtx, disallowed)
+}
+
+// validateIncompleteIfaceImpls checks if types reported as no longer
+// implementing modified interfaces (in the "type:iface:method" format)
+// are as expected.
+func validateIncompleteIfaceImpls(t *testing.T, debugFilePath string, expected []string) {
+	validateEntries(t, "incomplete interface implementations", readDebugInfo(t, debugFilePath).IncompleteIfaceImpls, func(m map[string]string) string {
+		return m["type"] + ":" + m["iface"] + ":" + m["method"]
+	}, expected)
+}
+
+// validateFrozenFns validates that a debug file lists given frozen
+// exported functions (each described as "fn:line") and no other ones.
+func validateFrozenFns(t *testing.T, debugFilePath string, expected []string) {
+	validateEntries(t, "frozen exported functions", readDebugInfo(t, debugFilePath).FrozenFns, func(f map[string]string) string {
+		return f["fn"] + ":" + f["line"]
+	}, expected)
+}
+
+// validateEditLimitsExceeded validates that a debug file lists given
+// files exceeding limits of edits per file (each described as
+// "file:edits:skipped") and no other ones.
+func validateEditLimitsExceeded(t *testing.T, debugFilePath string, expected []string) {
+	validateEntries(t, "files exceeding edit limits", readDebugInfo(t, debugFilePath).EditLimitsExceeded, func(f map[string]string) string {
+		return filepath.Base(f["file"]) + ":" + f["edits"] + ":" + f["skipped"]
+	}, expected)
 }
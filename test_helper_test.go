@@ -0,0 +1,35 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package propagate
+
+import "testing"
+
+func TestMapPath(t *testing.T) {
+	tests := []struct {
+		roots    rootPair
+		sep      byte
+		filePath string
+		expected string
+	}{
+		{rootPair{"testdata/src", "testdata/src/expected"}, '/', "/go/src/testdata/src/test-anon/test.go", "/go/src/testdata/src/expected/test-anon/test.go"},
+		{rootPair{"testdata/src", "testdata/src/expected"}, '/', "testdata/src/test-anon/test.go", "testdata/src/expected/test-anon/test.go"},
+		{rootPair{"testdata/src", "testdata/src/expected"}, '/', "/go/src/test-anon/test.go", ""},
+		// directory whose name starts with the source root name
+		// is not the source root
+		{rootPair{"testdata/src", "testdata/src/expected"}, '/', "/go/src/testdata/srcs/test-anon/test.go", ""},
+		{rootPair{`testdata\src`, `testdata\src\expected`}, '\\', `C:\go\src\testdata\src\test-anon\test.go`, `C:\go\src\testdata\src\expected\test-anon\test.go`},
+		{rootPair{`testdata\src`, `testdata\expected`}, '\\', `testdata\src\test-anon\test.go`, `testdata\expected\test-anon\test.go`},
+	}
+	for _, test := range tests {
+		if p := test.roots.mapPath(test.filePath, test.sep); p != test.expected {
+			t.Errorf("path %s mapped to %s instead of %s", test.filePath, p, test.expected)
+		}
+	}
+}
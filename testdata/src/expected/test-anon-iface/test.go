@@ -0,0 +1,37 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import "lib"
+
+type handler struct{}
+
+func (handler) Handle(ctx lib.Context) error {
+	lib.CtxA(ctx)
+	return nil
+}
+
+type noopHandler struct{}
+
+func (noopHandler) Handle(ctx lib.Context) error {
+	return nil
+}
+
+// parameter of anonymous interface type - the interface literal must
+// be updated along with its implementations
+func Do(ctx lib.Context, h interface{ Handle(ctx lib.Context) error }) error {
+	return h.Handle(ctx)
+}
+
+func main() {
+	ctx := lib.Background()
+	Do(ctx, handler{})
+	Do(ctx, noopHandler{})
+}
@@ -0,0 +1,22 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import "lib"
+
+func foo(ctx lib.Context) bool {
+	return lib.CtxA(ctx)
+}
+
+// artificial context is not allowed in this file
+func main() {
+	ctx := lib.Background()
+	foo(ctx)
+}
@@ -0,0 +1,66 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import "lib"
+
+type Store struct {
+	name string
+	ctx  lib.Context
+}
+
+type Cache struct {
+	size int
+	ctx  lib.Context
+}
+
+// never calls "leaf" functions - context field should not be added
+type Unused struct {
+	n int
+}
+
+// constructor (returning pointer) should receive context parameter
+// initializing the context field
+func NewStore(ctx lib.Context, name string) *Store {
+	return &Store{name: name, ctx: ctx}
+}
+
+// constructor using unkeyed literal
+func newCache(ctx lib.Context, size int) Cache {
+	c := Cache{size, ctx}
+	return c
+}
+
+func NewUnused() *Unused {
+	return &Unused{1}
+}
+
+// context should be extracted from the context field rather than
+// injected as a parameter
+func (s *Store) get(p bool) bool {
+	ctx := s.ctx
+	return lib.CtxB(ctx, p)
+}
+
+// context should be extracted from the context field (receiver
+// passed by value)
+func (c Cache) lookup() bool {
+	ctx := c.ctx
+	return lib.CtxA(ctx)
+}
+
+// callers of carrier methods should remain unchanged while callers
+// of constructors should receive context parameter
+func serve(ctx lib.Context) bool {
+	s := NewStore(ctx, "foo")
+	c := newCache(ctx, 42)
+	u := NewUnused()
+	return s.get(true) || c.lookup() || u.n > 0
+}
@@ -0,0 +1,24 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"lib"
+	"test-cgo/asm"
+)
+
+func foo(ctx lib.Context) bool {
+	return lib.CtxA(ctx) && asm.True() && cTrue()
+}
+
+func main() {
+	ctx := lib.Background()
+	foo(ctx)
+}
@@ -0,0 +1,31 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"lib"
+	"lib_trace"
+)
+
+// two contexts propagated one after another
+
+func Foo(tctx lib_trace.TraceContext, ctx lib.Context) bool {
+	return lib.CtxA(ctx) && lib_trace.CtxT(tctx)
+}
+
+func Bar(tctx lib_trace.TraceContext, ctx lib.Context) bool {
+	return Foo(tctx, ctx)
+}
+
+func main() {
+	tctx := lib_trace.Background()
+	ctx := lib.Background()
+	Bar(tctx, ctx)
+}
@@ -0,0 +1,50 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"context"
+	"lib_cmd"
+	"os/exec"
+)
+
+// context parameter exists - command receives it
+func run(ctx context.Context) error {
+	return exec.CommandContext(ctx, "true").Run()
+}
+
+// context parameter is injected
+func build(ctx context.Context, dir string) error {
+	lib_cmd.CtxLog(ctx)
+	cmd := exec.CommandContext(ctx, "make", "-C", dir)
+	return cmd.Run()
+}
+
+// context is available to the closure as a free variable
+func runAll(ctx context.Context, names []string) {
+	for _, n := range names {
+		func() {
+			exec.CommandContext(ctx, n).Run()
+		}()
+	}
+}
+
+// context is unavailable - command is left intact
+func version() ([]byte, error) {
+	return exec.Command("go", "version").Output()
+}
+
+func main() {
+	ctx := context.Background()
+	run(context.TODO())
+	build(ctx, ".")
+	runAll(context.TODO(), []string{"true"})
+	version()
+}
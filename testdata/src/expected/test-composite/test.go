@@ -0,0 +1,63 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import "lib"
+
+type Config struct {
+	Enabled bool
+	Flags   []bool
+}
+
+// call as a value of a struct field initializer
+func structField(ctx lib.Context) Config {
+	return Config{Enabled: lib.CtxA(ctx)}
+}
+
+// call as an element of a composite literal without keys
+func compositeElt(ctx lib.Context) Config {
+	return Config{lib.CtxA(ctx), nil}
+}
+
+// call as an element of a slice literal nested in a struct literal
+func sliceElt(ctx lib.Context) Config {
+	return Config{Flags: []bool{lib.CtxA(ctx), true}}
+}
+
+// calls as a key and as a value of a map literal
+func mapKeyValue(ctx lib.Context) map[bool]bool {
+	return map[bool]bool{lib.CtxA(ctx): lib.CtxA(ctx)}
+}
+
+// call as a returned value
+func returned(ctx lib.Context) (bool, error) {
+	return lib.CtxA(ctx), nil
+}
+
+// call as an index expression
+func index(ctx lib.Context, m map[bool]int, s []int) int {
+	return m[lib.CtxA(ctx)] + s[len(s)-1]
+}
+
+// call as an argument of another call
+func argument(ctx lib.Context) bool {
+	return lib.CtxB(ctx, lib.CtxA(ctx))
+}
+
+func main() {
+	ctx := lib.Background()
+	structField(ctx)
+	compositeElt(ctx)
+	sliceElt(ctx)
+	mapKeyValue(ctx)
+	returned(ctx)
+	index(ctx, nil, nil)
+	argument(ctx)
+}
@@ -0,0 +1,46 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"lib"
+	"lib_helper"
+)
+
+type ParamFn func(ctx lib.Context, p bool) bool
+
+type holder struct {
+	fn ParamFn
+}
+
+// modified function converted to named function type in a composite literal
+func helper(ctx lib.Context, p bool) bool {
+	return lib.CtxB(ctx, p)
+}
+
+// unmodified function converted to the same named function type in an assignment
+func other(ctx lib.Context, p bool) bool {
+	return p
+}
+
+func call(ctx lib.Context, h holder, f ParamFn) bool {
+	return h.fn(ctx, true) || f(ctx, false)
+}
+
+func main() {
+	ctx := lib.Background()
+	h := holder{fn: ParamFn(helper)}
+	var f ParamFn
+	f = ParamFn(other)
+	call(ctx, h, f)
+	// external function cannot be modified
+	f = ParamFn(lib_helper.Foo)
+	f(ctx, true)
+}
@@ -0,0 +1,63 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"lib"
+)
+
+type Handler func(lib.Context, int) bool
+
+// function literal of named function type
+var pkgHandler Handler = func(ctx lib.Context, n int) bool {
+	return n > 1
+}
+
+type Doer interface {
+	Do(ctx lib.Context, n int) bool
+}
+
+type doer struct{}
+
+// method implementing interface method
+func (doer) Do(ctx lib.Context, n int) bool {
+	return n > 0 && lib.CtxA(ctx)
+}
+
+// named function type
+func handle(ctx lib.Context, n int) bool {
+	return n > 0 && lib.CtxA(ctx)
+}
+
+// variadic function
+func sum(ctx lib.Context, prefix string, nums ...int) bool {
+	return len(prefix)+len(nums) > 0 && lib.CtxA(ctx)
+}
+
+// function type parameter
+func apply(ctx lib.Context, f func(lib.Context, int, string) bool) bool {
+	return f(ctx, 42, "")
+}
+
+func applied(ctx lib.Context, n int, s string) bool {
+	return lib.CtxA(ctx)
+}
+
+func Foo(ctx lib.Context, d Doer, nums []int) bool {
+	var h Handler = handle
+	lit := func(s string) bool {
+		return sum(ctx, s, 1, 2) && sum(ctx, s) && sum(ctx, s, nums...)
+	}
+	return d.Do(ctx, 1) && h(ctx, 1) && lit("") && apply(ctx, applied)
+}
+
+func Bar(ctx lib.Context) bool {
+	return Foo(ctx, doer{}, nil) && pkgHandler(ctx, 2)
+}
@@ -0,0 +1,63 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"lib"
+)
+
+type Handler func(int, lib.Context) bool
+
+// function literal of named function type
+var pkgHandler Handler = func(n int, ctx lib.Context) bool {
+	return n > 1
+}
+
+type Doer interface {
+	Do(n int, ctx lib.Context) bool
+}
+
+type doer struct{}
+
+// method implementing interface method
+func (doer) Do(n int, ctx lib.Context) bool {
+	return n > 0 && lib.CtxA(ctx)
+}
+
+// named function type
+func handle(n int, ctx lib.Context) bool {
+	return n > 0 && lib.CtxA(ctx)
+}
+
+// variadic function
+func sum(prefix string, ctx lib.Context, nums ...int) bool {
+	return len(prefix)+len(nums) > 0 && lib.CtxA(ctx)
+}
+
+// function type parameter
+func apply(f func(int, string, lib.Context) bool, ctx lib.Context) bool {
+	return f(42, "", ctx)
+}
+
+func applied(n int, s string, ctx lib.Context) bool {
+	return lib.CtxA(ctx)
+}
+
+func Foo(d Doer, nums []int, ctx lib.Context) bool {
+	var h Handler = handle
+	lit := func(s string) bool {
+		return sum(s, ctx, 1, 2) && sum(s, ctx) && sum(s, ctx, nums...)
+	}
+	return d.Do(1, ctx) && h(1, ctx) && lit("") && apply(applied, ctx)
+}
+
+func Bar(ctx lib.Context) bool {
+	return Foo(doer{}, nil, ctx) && pkgHandler(2, ctx)
+}
@@ -0,0 +1,54 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import "lib"
+
+type Rec struct {
+}
+
+// injected context parameter must not conflict with the named result
+func FooA(ctx1 lib.Context) (ctx lib.Context, ok bool) {
+	ok = lib.CtxA(ctx1)
+	return
+}
+
+// injected context parameter must not conflict with the receiver
+func (ctx *Rec) FooB(ctx1 lib.Context, p bool) bool {
+	return lib.CtxB(ctx1, p)
+}
+
+// injected context parameter must not conflict with another parameter
+// or with the first alternative name
+func FooC(ctx2 lib.Context, ctx bool, ctx1 bool) bool {
+	return lib.CtxB(ctx2, ctx || ctx1)
+}
+
+// injected context parameter must not conflict with the named result
+// of an interface method
+type Inter interface {
+	Baz(ctx1 lib.Context) (ctx bool)
+}
+
+func (r *Rec) Baz(ctx1 lib.Context) (ctx bool) {
+	ctx = lib.CtxA(ctx1)
+	return
+}
+
+// calls should use injected context parameter with alternative name
+func Bar(ctx1 lib.Context) (ctx lib.Context) {
+	r := Rec{}
+	FooA(ctx1)
+	r.FooB(ctx1, true)
+	FooC(ctx1, true, false)
+	var i Inter = &r
+	i.Baz(ctx1)
+	return
+}
@@ -0,0 +1,28 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import "lib"
+
+// helper file in the package defining context - injected context
+// type must not be qualified and context package must not be
+// imported
+func helper(ctx Context) bool {
+	return lib.CtxA(ctx)
+}
+
+func foo(ctx Context) bool {
+	return helper(ctx)
+}
+
+func main() {
+	ctx := Background()
+	foo(ctx)
+}
@@ -0,0 +1,37 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"lib"
+	"lib_helper"
+)
+
+func foo(ctx lib.Context) bool {
+	return lib.CtxA(ctx)
+}
+
+// called from the package initializer - artificial context specific
+// to synthetic package initializer callers is passed at the call site
+var initialized = foo(lib.TODO())
+
+// function to be passed as parameter to external function -
+// artificial context specific to such functions is injected
+func bar() bool {
+	ctx := lib.TODO()
+	return lib.CtxA(ctx)
+}
+
+// default artificial context is injected
+func main() {
+	ctx := lib.Background()
+	foo(ctx)
+	lib_helper.Register(bar)
+}
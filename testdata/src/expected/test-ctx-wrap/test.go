@@ -0,0 +1,48 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import "lib"
+
+// tests wrapping existing context parameters at leaf call sites
+
+// function with existing context parameter - context is wrapped at
+// the call site of A
+func foo(ctx lib.Context) bool {
+	return lib.CtxA(lib.Copy(ctx))
+}
+
+// function with existing context parameter - context is not wrapped
+// at the call site of B
+func bar(ctx lib.Context) bool {
+	return lib.CtxB(ctx, true)
+}
+
+// function with existing context parameter of a different name -
+// context is wrapped at the call site of A in a nested function
+func baz(c lib.Context) bool {
+	f := func() bool {
+		return lib.CtxA(lib.Copy(c))
+	}
+	return f()
+}
+
+// function receiving context parameter - context is not wrapped
+func qux(ctx lib.Context) bool {
+	return lib.CtxA(ctx)
+}
+
+func main() {
+	ctx := lib.Background()
+	foo(lib.Background())
+	bar(lib.Background())
+	baz(lib.Background())
+	qux(ctx)
+}
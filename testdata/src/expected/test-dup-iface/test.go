@@ -0,0 +1,34 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"lib"
+	"lib_dup"
+)
+
+// tests "leaf" functions specified in an interface defined in a
+// package that is loaded twice
+
+type DupRec struct {
+}
+
+func FooZ(ctx lib.Context, rec lib_dup.DupInter) bool {
+	return rec.Z(ctx, nil)
+}
+
+func (r DupRec) Z(ctx lib.Context, a *lib_dup.Arg) bool {
+	return true
+}
+
+func main() {
+	ctx := lib.Background()
+	FooZ(ctx, DupRec{})
+}
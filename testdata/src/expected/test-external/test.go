@@ -24,6 +24,9 @@ type ReceiverStructExt struct {
 type ReceiverStructReturn struct {
 }
 
+type ReceiverStructValue struct {
+}
+
 // method implementing an external interface - no context parameter injection
 func (*ReceiverStructExt) Foo() bool {
 	ctx := lib.Background()
@@ -49,6 +52,12 @@ func (*OuterStruct) baz() bool {
 	return lib.CtxA(ctx)
 }
 
+// method passed as a method value to external function - no context parameter injection
+func (*ReceiverStructValue) qux() bool {
+	ctx := lib.Background()
+	return lib.CtxA(ctx)
+}
+
 // interface method Foo has to be actually called via interface
 // (passed as parameter) to be prevented from having context parameter
 // injected (otherwise Foo's definition will get a context parameter)
@@ -71,4 +80,6 @@ func main() {
 	lib_helper.Register(bar)
 	o := OuterStruct{}
 	o.baz()
+	v := ReceiverStructValue{}
+	lib_helper.Register(v.qux)
 }
@@ -0,0 +1,43 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"lib"
+)
+
+type BarrierTestStruct struct {
+}
+
+// helper function to add additional call to the chain
+func bar(ctx lib.Context) bool {
+	return lib.CtxA(ctx)
+}
+
+// test context flow barrier for explicitly specified function
+func FooFn(ctx lib.Context) bool {
+	return bar(ctx)
+}
+
+// test context flow barrier for explicitly specified method
+func (BarrierTestStruct) FooMethod(ctx lib.Context) bool {
+	return bar(ctx)
+}
+
+// callers of context flow barriers are not traversed
+func Baz() bool {
+	var s BarrierTestStruct
+	return FooFn(lib.Background()) || s.FooMethod(lib.Background())
+}
+
+// callers of functions other than context flow barriers are still traversed
+func Qux(ctx lib.Context) bool {
+	return bar(ctx)
+}
@@ -0,0 +1,29 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import "lib"
+
+// function to be called via pointer
+func Foo(ctx lib.Context) bool {
+	return lib.CtxA(ctx)
+}
+
+// function whose parameter type (pointer to function) is meant to
+// change to accomodate context
+func Bar(ctx lib.Context, fn *func(ctx lib.Context) bool) bool {
+	return (*fn)(ctx)
+}
+
+func main() {
+	ctx := lib.Background()
+	f := Foo
+	Bar(ctx, &f)
+}
@@ -0,0 +1,58 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import "lib"
+
+// named function type used as parameter type in the same package
+type Handler func(ctx lib.Context) bool
+
+// alias of function type used as parameter type in the same package
+// (alias definition rather than parameter declaration should be
+// modified)
+type Getter = func(lib.Context, int) bool
+
+func foo(ctx lib.Context) bool {
+	return lib.CtxA(ctx)
+}
+
+func get(ctx lib.Context, i int) bool {
+	return lib.CtxA(ctx)
+}
+
+// parameter of named type called with a function literal
+func run(ctx lib.Context, h Handler) bool {
+	return h(ctx)
+}
+
+// pointer to named type
+func runPtr(ctx lib.Context, h *Handler) bool {
+	return (*h)(ctx)
+}
+
+// parameter of alias type
+func runGetter(ctx lib.Context, g Getter) bool {
+	return g(ctx, 1)
+}
+
+// pointer to alias type
+func runGetterPtr(ctx lib.Context, g *Getter) bool {
+	return (*g)(ctx, 2)
+}
+
+func main() {
+	ctx := lib.Background()
+	run(ctx, func(ctx lib.Context) bool { return lib.CtxA(ctx) })
+	var h Handler = foo
+	runPtr(ctx, &h)
+	var g Getter = get
+	runGetter(ctx, g)
+	runGetterPtr(ctx, &g)
+}
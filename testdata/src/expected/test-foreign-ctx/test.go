@@ -0,0 +1,55 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"lib"
+	"lib_foreign"
+)
+
+// context is extracted from the foreign context parameter
+func Handle(c *lib_foreign.Context) bool {
+	ctx := c.Request.Context()
+	return lib.CtxA(ctx)
+}
+
+// context parameter is injected after the foreign context parameter
+func Serve(c lib_foreign.Ctx, ctx lib.Context, name string) bool {
+	return lib.CtxA(ctx)
+}
+
+// parameters declared together with the foreign context parameter
+// are split off
+func ServeBoth(c lib_foreign.Ctx, ctx lib.Context, d lib_foreign.Ctx) bool {
+	return lib.CtxA(ctx)
+}
+
+type Server interface {
+	Serve(c lib_foreign.Ctx, ctx lib.Context) bool
+}
+
+type server struct{}
+
+func (server) Serve(c lib_foreign.Ctx, ctx lib.Context) bool {
+	return lib.CtxA(ctx)
+}
+
+func callServer(ctx lib.Context, s Server, c lib_foreign.Ctx) bool {
+	return s.Serve(c, ctx)
+}
+
+func main() {
+	ctx := lib.Background()
+	Handle(&lib_foreign.Context{})
+	var c lib_foreign.Ctx
+	Serve(c, ctx, "name")
+	ServeBoth(c, ctx, c)
+	callServer(ctx, server{}, c)
+}
@@ -0,0 +1,36 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import "lib"
+
+// Getter is an exported interface of a package with frozen API
+type Getter interface {
+	Get() bool
+}
+
+// exported function of a package with frozen API - keeps its
+// signature and receives artificial context
+func Foo() bool {
+	ctx := lib.TODO()
+	return bar(ctx)
+}
+
+// unexported function of a package with frozen API - receives context
+// parameter
+func bar(ctx lib.Context) bool {
+	return lib.CtxA(ctx)
+}
+
+// exported function calling a method of an exported interface whose
+// implementations keep their signatures - remains unchanged
+func Use(g Getter) bool {
+	return g.Get()
+}
@@ -0,0 +1,35 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"lib"
+	"test-frozen/api"
+)
+
+type getter struct{}
+
+// method implementing exported interface of a package with frozen API
+// - keeps its signature and receives artificial context
+func (getter) Get() bool {
+	ctx := lib.TODO()
+	return lib.CtxA(ctx)
+}
+
+// function receiving context parameter
+func baz(ctx lib.Context) bool {
+	return lib.CtxA(ctx) && api.Foo()
+}
+
+func main() {
+	ctx := lib.Background()
+	api.Use(getter{})
+	baz(ctx)
+}
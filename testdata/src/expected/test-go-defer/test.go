@@ -0,0 +1,40 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import "lib"
+
+func multi(p1 bool, p2 bool) bool {
+	return p1 || p2
+}
+
+func foo(ctx lib.Context) {
+	// calls nested inside go and defer statements should each get
+	// their own context argument
+	defer multi(lib.CtxA(ctx), lib.CtxB(ctx, true))
+	go multi(lib.CtxC(ctx, true), lib.CtxA(ctx))
+	defer lib.CtxB(ctx, lib.CtxA(ctx))
+	go lib.CtxD(lib.CtxA(ctx), ctx, lib.CtxC(ctx, true))
+	go lib.CtxA(ctx)
+	defer func(p bool) {
+		lib.CtxB(ctx, p)
+	}(lib.CtxA(ctx))
+	defer func() {
+		lib.CtxA(ctx)
+	}()
+	go func() {
+		lib.CtxB(ctx, true)
+	}()
+}
+
+func main() {
+	ctx := lib.Background()
+	foo(ctx)
+}
@@ -0,0 +1,41 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"lib"
+	"lib_helper"
+)
+
+type Doer interface {
+	Do(ctx lib.Context) bool
+}
+
+type client struct {
+}
+
+func (*client) Do(ctx lib.Context) bool {
+	return lib.CtxA(ctx)
+}
+
+// implementation modified along with the interface - no warning
+var _ Doer = (*client)(nil)
+
+// implementation in an external package cannot be modified - warning
+var _ Doer = (*lib_helper.ExtDoer)(nil)
+
+func use(ctx lib.Context, d Doer) bool {
+	return d.Do(ctx)
+}
+
+func main() {
+	ctx := lib.Background()
+	use(ctx, &client{})
+}
@@ -0,0 +1,46 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"lib"
+	"strings"
+)
+
+func foo(ctx lib.Context) bool {
+	return lib.CtxA(ctx)
+}
+
+func main() {
+	ctx := lib.Background()
+	// Doc-style comment block describing the first statement of the
+	// function body - it must stay attached to this statement.
+	//
+	// It spans multiple paragraphs.
+	r := foo(ctx)
+
+	// comment separated by a blank line
+	if r {
+		return
+	}
+}
+
+func init() { // trailing comment on the signature
+	ctx := lib.Background()
+	/* block comment */ foo(ctx)
+}
+
+func mapAll(ctx lib.Context, s string) string {
+	return strings.Map(func(r rune) rune { // trailing comment on the literal
+		// comment in a function literal
+		foo(ctx)
+		return r
+	}, s)
+}
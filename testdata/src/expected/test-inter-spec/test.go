@@ -9,17 +9,14 @@
 
 package test
 
-import (
-	"lib"
-	"lib_helper"
-)
+import "lib"
 
 // tests "leaf" functions specified in an external interface
 
 type InterSpecRec struct {
 }
 
-func FooZ(ctx lib.Context, rec lib_helper.SpecInter) bool {
+func FooZ(ctx lib.Context, rec SpecInter) bool {
 	return rec.Z(ctx)
 }
 
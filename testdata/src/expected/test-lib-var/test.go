@@ -0,0 +1,28 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import "lib"
+
+// function calling library function via a package-level variable
+func Foo(ctx lib.Context, b bool) bool {
+	return lib.CtxSend(ctx, b)
+}
+
+// function calling library function via a package-level variable
+// indirectly
+func Bar(ctx lib.Context) bool {
+	return Foo(ctx, true) && lib.CtxA(ctx)
+}
+
+func main() {
+	ctx := lib.Background()
+	Bar(ctx)
+}
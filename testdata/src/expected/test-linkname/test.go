@@ -0,0 +1,43 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"lib"
+	_ "unsafe"
+)
+
+// function linked to a runtime function - keeps its signature and
+// receives artificial context
+//
+//go:linkname hook runtime.hook
+func hook() bool {
+	ctx := lib.Background()
+	return lib.CtxA(ctx)
+}
+
+// function linked to a function of an external package - keeps its
+// signature and receives artificial context
+//
+//go:linkname helperHook lib_helper.hook
+func helperHook() bool {
+	ctx := lib.Background()
+	return lib.CtxA(ctx)
+}
+
+// function receiving context parameter
+func Foo(ctx lib.Context) bool {
+	return hook() && helperHook() && lib.CtxA(ctx)
+}
+
+func main() {
+	ctx := lib.Background()
+	Foo(ctx)
+}
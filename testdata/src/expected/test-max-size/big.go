@@ -0,0 +1,49 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import "lib"
+
+// big is defined in a file exceeding maximum file size - no context
+// parameter injection and no context propagation to its callers
+//
+// padding to make this file exceed maximum file size used in the test
+// padding to make this file exceed maximum file size used in the test
+// padding to make this file exceed maximum file size used in the test
+// padding to make this file exceed maximum file size used in the test
+// padding to make this file exceed maximum file size used in the test
+// padding to make this file exceed maximum file size used in the test
+// padding to make this file exceed maximum file size used in the test
+// padding to make this file exceed maximum file size used in the test
+// padding to make this file exceed maximum file size used in the test
+// padding to make this file exceed maximum file size used in the test
+// padding to make this file exceed maximum file size used in the test
+// padding to make this file exceed maximum file size used in the test
+// padding to make this file exceed maximum file size used in the test
+// padding to make this file exceed maximum file size used in the test
+// padding to make this file exceed maximum file size used in the test
+// padding to make this file exceed maximum file size used in the test
+// padding to make this file exceed maximum file size used in the test
+// padding to make this file exceed maximum file size used in the test
+// padding to make this file exceed maximum file size used in the test
+// padding to make this file exceed maximum file size used in the test
+// padding to make this file exceed maximum file size used in the test
+// padding to make this file exceed maximum file size used in the test
+// padding to make this file exceed maximum file size used in the test
+// padding to make this file exceed maximum file size used in the test
+// padding to make this file exceed maximum file size used in the test
+// padding to make this file exceed maximum file size used in the test
+// padding to make this file exceed maximum file size used in the test
+// padding to make this file exceed maximum file size used in the test
+// padding to make this file exceed maximum file size used in the test
+// padding to make this file exceed maximum file size used in the test
+func big() bool {
+	return lib.A()
+}
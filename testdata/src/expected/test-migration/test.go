@@ -0,0 +1,29 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import "lib"
+
+type T struct{}
+
+// exported method
+func (t *T) Foo(ctx lib.Context) bool {
+	return lib.CtxA(ctx)
+}
+
+// unexported function
+func bar(ctx lib.Context, t *T, f func() bool) bool {
+	return t.Foo(ctx) && f()
+}
+
+func main() {
+	ctx := lib.Background()
+	bar(ctx, &T{}, func() bool { return lib.CtxB(ctx, true) })
+}
@@ -0,0 +1,50 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import "lib"
+
+type HandlerFunc func(ctx lib.Context, r int) bool
+
+// typed package-level variable - the literal receives context
+// parameter along with the named type
+var DefaultHandler HandlerFunc = func(ctx lib.Context, r int) bool {
+	return r > 0
+}
+
+// conversion to the named type - the literal receives context
+// parameter along with the named type
+var ConvHandler = HandlerFunc(func(ctx lib.Context, r int) bool {
+	return r < 0
+})
+
+// literal calling leaf function - it is nested in the synthetic
+// package initializer and context comes from its own parameter
+var LeafHandler HandlerFunc = func(ctx lib.Context, r int) bool {
+	return lib.CtxA(ctx)
+}
+
+// named function calling leaf function - HandlerFunc receives context
+// parameter as values of this type are called with it
+func leafHandler(ctx lib.Context, r int) bool {
+	return lib.CtxA(ctx)
+}
+
+func serve(ctx lib.Context, h HandlerFunc) bool {
+	return h(ctx, 42)
+}
+
+func main() {
+	ctx := lib.Background()
+	serve(ctx, DefaultHandler)
+	serve(ctx, ConvHandler)
+	serve(ctx, LeafHandler)
+	serve(ctx, leafHandler)
+}
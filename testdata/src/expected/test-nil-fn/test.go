@@ -0,0 +1,63 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import "lib"
+
+// function to be passed as parameter
+func Foo(ctx lib.Context) bool {
+	return lib.CtxA(ctx)
+}
+
+// function calling its parameter of function type only if it is not
+// nil
+func Bar(ctx lib.Context, f func(ctx lib.Context) bool) bool {
+	if f != nil {
+		return f(ctx)
+	}
+	return false
+}
+
+// function setting its parameter of function type to nil before
+// calling it
+func Baz(ctx lib.Context, f func(ctx lib.Context) bool, disabled bool) bool {
+	if disabled {
+		f = nil
+	}
+	if f == nil {
+		return false
+	}
+	return f(ctx)
+}
+
+// function replacing nil value of its parameter of function type with
+// a default one
+func Qux(ctx lib.Context, f func(ctx lib.Context) bool) bool {
+	if f == nil {
+		f = Foo
+	}
+	return f(ctx)
+}
+
+// function calling a nil function value - there is no function to
+// propagate context into
+func Quux() {
+	var f func() bool
+	f()
+}
+
+func main() {
+	ctx := lib.Background()
+	Bar(ctx, nil)
+	Bar(ctx, Foo)
+	Baz(ctx, Foo, false)
+	Qux(ctx, nil)
+	Quux()
+}
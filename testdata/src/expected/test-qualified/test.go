@@ -0,0 +1,28 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"lib"
+	ctxlib "lib_ctx"
+)
+
+// "leaf" function renamed to a function defined in a different
+// package imported with an alias
+
+func Foo(ctx lib.Context) bool {
+	return ctxlib.J(ctx)
+}
+
+func main() {
+	ctx := lib.Background()
+	Foo(ctx)
+	Bar(ctx)
+}
@@ -0,0 +1,44 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import "lib"
+
+type Request struct {
+	ctx lib.Context
+}
+
+type Other struct {
+}
+
+// context should be extracted from the receiver rather than
+// injected as a parameter
+func (r *Request) handle(p bool) bool {
+	ctx := r.ctx
+	return lib.CtxB(ctx, p)
+}
+
+// context should be extracted from the receiver (passed by value)
+func (r Request) get() bool {
+	ctx := r.ctx
+	return lib.CtxA(ctx)
+}
+
+// receiver type not specified in the config file - context
+// parameter should be injected
+func (o *Other) handle(ctx lib.Context, p bool) bool {
+	return lib.CtxB(ctx, p)
+}
+
+// callers of methods extracting context from the receiver should
+// remain unchanged
+func serve(ctx lib.Context, r *Request, o *Other) bool {
+	return r.handle(true) || r.get() || o.handle(ctx, false)
+}
@@ -0,0 +1,45 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import "lib"
+
+// only the first context parameter should be named (the second one
+// becomes blank so that parameter names are not duplicated)
+func FooA(ctx lib.Context, _ lib.Context) bool {
+	return lib.CtxA(ctx)
+}
+
+// same as above but with "_" names
+func FooB(ctx, _ lib.Context) bool {
+	return lib.CtxA(ctx)
+}
+
+// remaining unnamed parameters should become blank
+func FooC(ctx lib.Context, _ bool, _ int) bool {
+	return lib.CtxA(ctx)
+}
+
+func Bar() bool {
+	// "_" in function literal should be replaced with the actual
+	// context parameter and used in call to lib.A
+	f := func(ctx lib.Context) bool {
+		return lib.CtxA(ctx)
+	}
+	// same as above but for immediately invoked function literal
+	g := func(ctx lib.Context, p bool) bool {
+		return lib.CtxB(ctx, p)
+	}(lib.Background(), true)
+	// unnamed parameters in function literal
+	h := func(ctx lib.Context, _ bool) bool {
+		return lib.CtxA(ctx)
+	}
+	return f(lib.Background()) || g || h(lib.Background(), true)
+}
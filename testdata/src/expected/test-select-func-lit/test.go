@@ -0,0 +1,46 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import "lib"
+
+// function literal defined in a select case body
+func recv(ctx lib.Context, ch chan bool, done chan bool) bool {
+	select {
+	case <-ch:
+		f := func() bool {
+			return lib.CtxA(ctx)
+		}
+		return f()
+	case <-done:
+		return false
+	}
+}
+
+// function literal called in a select case body and nested in
+// another function literal
+func send(ctx lib.Context, ch chan bool) {
+	go func() {
+		select {
+		case ch <- func() bool { return lib.CtxA(ctx) }():
+		default:
+			func() {
+				lib.CtxA(ctx)
+			}()
+		}
+	}()
+}
+
+func main() {
+	ctx := lib.Background()
+	ch := make(chan bool, 1)
+	send(ctx, ch)
+	recv(ctx, ch, make(chan bool))
+}
@@ -0,0 +1,73 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"lib"
+)
+
+type ops struct {
+	run func(ctx lib.Context) bool
+}
+
+// function stored in a field of an anonymous struct
+func helper(ctx lib.Context) bool {
+	return lib.CtxA(ctx)
+}
+
+// function stored in a field of a named struct
+func namedHelper(ctx lib.Context) bool {
+	return lib.CtxA(ctx)
+}
+
+// call through a field of an anonymous struct
+func Foo(ctx lib.Context) bool {
+	o := struct{ run func(ctx lib.Context) bool }{run: helper}
+	return o.run(ctx)
+}
+
+// call through a field of a named struct
+func Bar(ctx lib.Context) bool {
+	o := &ops{}
+	o.run = namedHelper
+	return o.run(ctx)
+}
+
+// function stored in a field without calling a "leaf" function
+func other(ctx lib.Context) bool {
+	return false
+}
+
+// call through a field of an anonymous struct assigned different functions
+func Baz(ctx lib.Context, b bool) bool {
+	o := struct{ run func(ctx lib.Context) bool }{run: helper}
+	if b {
+		o.run = other
+	}
+	return o.run(ctx)
+}
+
+type handlers struct {
+	handle func(lib.Context, int) bool
+}
+
+func handle(ctx lib.Context, i int) bool {
+	return i > 0 && lib.CtxA(ctx)
+}
+
+func newHandlers() *handlers {
+	return &handlers{handle: handle}
+}
+
+// call through a field assigned in a different function
+func Qux(ctx lib.Context) bool {
+	h := newHandlers()
+	return h.handle(ctx, 42)
+}
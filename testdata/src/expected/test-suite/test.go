@@ -0,0 +1,35 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"lib"
+	"lib_helper"
+)
+
+type MySuite struct {
+	lib_helper.TestSuite
+}
+
+func bar(ctx lib.Context) bool {
+	return lib.CtxA(ctx)
+}
+
+// method run by the test suite harness - no context parameter injection
+func (s *MySuite) TestFoo() {
+	ctx := lib.Background()
+	bar(ctx)
+}
+
+// any other method of the test suite - no context parameter injection
+func (s *MySuite) helper(p bool) bool {
+	ctx := lib.Background()
+	return bar(ctx) || p
+}
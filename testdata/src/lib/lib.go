@@ -30,6 +30,10 @@ func Background() Context {
 	return ContextStruct{P: true}
 }
 
+func TODO() Context {
+	return ContextStruct{P: false}
+}
+
 func Copy(ctx Context) Context {
 	return ContextStruct{P: ctx.Val()}
 }
@@ -113,3 +117,52 @@ func J() bool {
 func CtxJ(ctx Context) bool {
 	return ctx.Val()
 }
+
+func defaultSend(b bool) bool {
+	return b
+}
+
+func defaultCtxSend(ctx Context, b bool) bool {
+	return ctx.Val() || b
+}
+
+var Send = defaultSend
+
+var CtxSend = defaultCtxSend
+
+func Counter(name string) func(int) bool {
+	return func(i int) bool {
+		return i > 0
+	}
+}
+
+func CtxCounter(name string) func(Context, int) bool {
+	return func(ctx Context, i int) bool {
+		return ctx.Val() || i > 0
+	}
+}
+
+// Client has a fluent API
+type Client struct {
+	Retries int
+}
+
+func (c *Client) WithRetry(n int) *Client {
+	return &Client{Retries: n}
+}
+
+func (c *Client) WithDeadline(d int) *Client {
+	return c
+}
+
+func (c *Client) CtxWithDeadline(ctx Context, d int) *Client {
+	return c
+}
+
+func (c *Client) Do(p bool) bool {
+	return p
+}
+
+func (c *Client) CtxDo(ctx Context, p bool) bool {
+	return ctx.Val() || p
+}
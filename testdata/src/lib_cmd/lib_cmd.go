@@ -0,0 +1,20 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lib_cmd
+
+import "context"
+
+// "leaf" functions taking standard library context
+
+func Log() {
+}
+
+func CtxLog(ctx context.Context) {
+}
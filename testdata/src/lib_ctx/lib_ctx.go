@@ -0,0 +1,23 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lib_ctx
+
+import "lib"
+
+// context-aware versions of "leaf" functions defined in a different
+// package than the original ones
+
+func I(ctx lib.Context) bool {
+	return ctx.Val()
+}
+
+func J(ctx lib.Context) bool {
+	return ctx.Val()
+}
@@ -0,0 +1,20 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lib_dup
+
+// library whose package has in-package tests and is thus loaded twice
+// (along with its test variant) when loaded with tests
+
+type Arg struct {
+}
+
+type DupInter interface {
+	Z(a *Arg) bool
+}
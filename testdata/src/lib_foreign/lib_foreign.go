@@ -0,0 +1,35 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lib_foreign
+
+import "lib"
+
+// Request is a request carrying context.
+type Request struct {
+	ctx lib.Context
+}
+
+// Context returns context carried by the request.
+func (r *Request) Context() lib.Context {
+	if r.ctx == nil {
+		return lib.Background()
+	}
+	return r.ctx
+}
+
+// Context is a web framework's context wrapping a request.
+type Context struct {
+	Request *Request
+}
+
+// Ctx is a homegrown request context.
+type Ctx interface {
+	Value(key string) string
+}
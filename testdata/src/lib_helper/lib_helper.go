@@ -19,6 +19,17 @@ type EmbedStruct struct {
 	P bool
 }
 
+type TestSuite struct {
+	T bool
+}
+
+type ExtDoer struct {
+}
+
+func (*ExtDoer) Do() bool {
+	return true
+}
+
 type LibCallInter interface {
 	Foo() bool
 }
@@ -50,3 +61,26 @@ func Register(f func() bool) bool {
 func Foo(p bool) bool {
 	return p
 }
+
+// Group runs functions on behalf of its caller (similarly to
+// errgroup.Group)
+type Group struct {
+	err error
+}
+
+func (g *Group) Go(f func() error) {
+	if err := f(); err != nil {
+		g.err = err
+	}
+}
+
+// ServerStream provides context of a streaming call (similarly to
+// grpc.ServerStream)
+type ServerStream interface {
+	Context() lib.Context
+	Send(m string) error
+}
+
+func RegisterStream(h func(ServerStream) error) error {
+	return nil
+}
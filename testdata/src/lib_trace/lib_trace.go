@@ -0,0 +1,27 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lib_trace
+
+// tracing context to be propagated in addition to the "regular" one
+
+type TraceContext interface {
+}
+
+func Background() TraceContext {
+	return nil
+}
+
+func T() bool {
+	return true
+}
+
+func CtxT(tctx TraceContext) bool {
+	return true
+}
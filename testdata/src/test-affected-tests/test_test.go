@@ -0,0 +1,31 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import "testing"
+
+// calls modified function directly
+func TestFoo(t *testing.T) {
+	foo()
+}
+
+// calls modified function in a subtest
+func TestRun(t *testing.T) {
+	t.Run("foo", func(t *testing.T) {
+		foo()
+	})
+}
+
+// does not call modified functions
+func TestBar(t *testing.T) {
+	t.Run("bar", func(t *testing.T) {
+		bar()
+	})
+}
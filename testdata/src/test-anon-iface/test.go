@@ -0,0 +1,36 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import "lib"
+
+type handler struct{}
+
+func (handler) Handle() error {
+	lib.A()
+	return nil
+}
+
+type noopHandler struct{}
+
+func (noopHandler) Handle() error {
+	return nil
+}
+
+// parameter of anonymous interface type - the interface literal must
+// be updated along with its implementations
+func Do(h interface{ Handle() error }) error {
+	return h.Handle()
+}
+
+func main() {
+	Do(handler{})
+	Do(noopHandler{})
+}
@@ -0,0 +1,36 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import "lib"
+
+type Req struct{}
+
+// function taking context in a non-first position - its signature is
+// not modified and its context parameter is used for calls
+func Do(req Req, c lib.Context) bool {
+	return lib.A()
+}
+
+// function taking context in a non-first position and calling a
+// function receiving context parameter
+func Handle(req Req, c lib.Context, retry bool) bool {
+	return Foo(req)
+}
+
+// function receiving context parameter
+func Foo(req Req) bool {
+	return lib.A()
+}
+
+func main() {
+	Do(Req{}, lib.Background())
+	Handle(Req{}, lib.Background(), false)
+}
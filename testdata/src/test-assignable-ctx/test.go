@@ -0,0 +1,38 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import "lib"
+
+// interface that can be used as context
+type ReqContext interface {
+	lib.Context
+	Deadline() bool
+}
+
+// function already taking context of a wider interface type
+func Foo(rc ReqContext) bool {
+	return lib.A()
+}
+
+// function calling function already taking context of a wider
+// interface type
+func Bar(rc ReqContext) bool {
+	return Foo(rc) && Baz()
+}
+
+// function not taking context
+func Baz() bool {
+	return lib.A()
+}
+
+func main() {
+	Bar(nil)
+}
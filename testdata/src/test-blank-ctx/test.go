@@ -0,0 +1,49 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import "lib"
+
+// not on the propagation path - blank context parameter should be
+// named
+func stop(_ lib.Context) bool {
+	return true
+}
+
+// not on the propagation path - unnamed context parameters should be
+// named (remaining unnamed parameters become blank)
+func other(lib.Context, int) bool {
+	return false
+}
+
+// the name is already used in the function - blank context parameter
+// should remain unchanged
+func conflict(_ lib.Context) bool {
+	ctx := lib.Background()
+	return ctx.Val()
+}
+
+// on the propagation path - blank context parameter should be named
+// and passed to the "leaf" function
+func foo(_ lib.Context) bool {
+	return lib.A()
+}
+
+func main() {
+	ctx := lib.Background()
+	f := func(_ lib.Context) bool {
+		return true
+	}
+	stop(ctx)
+	other(ctx, 42)
+	conflict(ctx)
+	foo(ctx)
+	f(ctx)
+}
@@ -0,0 +1,22 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+// context package imported both for side effects and with an alias
+// - the alias should be used to qualify context type
+
+import (
+	_ "lib"
+	l "lib"
+)
+
+func Baz(r *l.Rec) bool {
+	return Foo()
+}
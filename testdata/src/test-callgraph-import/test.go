@@ -0,0 +1,31 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import "lib"
+
+func foo() bool {
+	return lib.A()
+}
+
+func bar() bool {
+	return true
+}
+
+// imported call graph determines that only bar is called here
+func call(f func() bool) bool {
+	return f()
+}
+
+func main() {
+	call(bar)
+	var v interface{} = foo
+	_ = v
+}
@@ -0,0 +1,26 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import "C"
+
+import (
+	"lib"
+)
+
+// goCallback is called from C code.
+//
+//export goCallback
+func goCallback(n C.int) C.int {
+	if n > 0 && lib.A() {
+		return 1
+	}
+	return 0
+}
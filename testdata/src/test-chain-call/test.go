@@ -0,0 +1,55 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import "lib"
+
+// only the last call in a two-deep chain is a "leaf" call
+func twoDeep(c *lib.Client) bool {
+	return c.WithRetry(3).Do(true)
+}
+
+// only the last call in a three-deep chain is a "leaf" call
+func threeDeep(c *lib.Client) bool {
+	return c.WithRetry(3).WithRetry(2).Do(false)
+}
+
+// both calls in a chain are "leaf" calls
+func bothLeaves(c *lib.Client) bool {
+	return c.WithDeadline(1).Do(true)
+}
+
+// both calls in a three-deep chain are "leaf" calls
+func bothLeavesThreeDeep(c *lib.Client) bool {
+	return c.WithDeadline(1).WithRetry(2).Do(true)
+}
+
+// only the last call in a deferred chain is a "leaf" call
+func deferredChain(c *lib.Client) {
+	defer c.WithRetry(3).Do(true)
+}
+
+// both calls in a deferred chain are "leaf" calls
+func deferredBothLeaves(c *lib.Client) {
+	defer c.WithDeadline(1).Do(true)
+}
+
+// only the last call in a chain started in a goroutine is a "leaf"
+// call
+func goChain(c *lib.Client) {
+	go c.WithRetry(3).Do(false)
+}
+
+// "leaf" call in a chain inside a deferred function literal
+func deferredLiteral(c *lib.Client) {
+	defer func() {
+		c.WithRetry(3).Do(true)
+	}()
+}
@@ -0,0 +1,44 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import "lib"
+
+// tests checking code whose context propagation has been completed
+
+// call to leaf function that does not take context
+func foo() bool {
+	return lib.A()
+}
+
+// function taking context but using artificial context
+func bar(ctx lib.Context) bool {
+	return lib.CtxA(lib.Background())
+}
+
+// function literal in function taking context using artificial
+// context
+func baz(ctx lib.Context) bool {
+	f := func() bool {
+		return lib.CtxA(lib.Background())
+	}
+	return f()
+}
+
+// function using artificial context outside of allowed files
+func qux() bool {
+	ctx := lib.Background()
+	return lib.CtxA(ctx)
+}
+
+// function propagating context
+func quux(ctx lib.Context) bool {
+	return lib.CtxA(ctx)
+}
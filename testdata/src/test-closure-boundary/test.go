@@ -0,0 +1,54 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"lib"
+	"lib_helper"
+)
+
+// tests named functions passed to closure-boundary functions
+
+type Server struct {
+}
+
+// method passed as a method value to closure-boundary function -
+// receives context of the caller
+func (s *Server) work() error {
+	lib.A()
+	return nil
+}
+
+// function passed to closure-boundary function - receives context of
+// the caller
+func process() error {
+	lib.A()
+	return nil
+}
+
+// function passed to another external function - no context parameter
+// injection
+func bar() bool {
+	return lib.A()
+}
+
+func run(s *Server) {
+	g := lib_helper.Group{}
+	g.Go(s.work)
+	g.Go(process)
+	g.Go(func() error {
+		return s.work()
+	})
+	lib_helper.Register(bar)
+}
+
+func main() {
+	run(&Server{})
+}
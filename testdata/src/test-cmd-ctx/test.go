@@ -0,0 +1,49 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"context"
+	"lib_cmd"
+	"os/exec"
+)
+
+// context parameter exists - command receives it
+func run(ctx context.Context) error {
+	return exec.Command("true").Run()
+}
+
+// context parameter is injected
+func build(dir string) error {
+	lib_cmd.Log()
+	cmd := exec.Command("make", "-C", dir)
+	return cmd.Run()
+}
+
+// context is available to the closure as a free variable
+func runAll(ctx context.Context, names []string) {
+	for _, n := range names {
+		func() {
+			exec.Command(n).Run()
+		}()
+	}
+}
+
+// context is unavailable - command is left intact
+func version() ([]byte, error) {
+	return exec.Command("go", "version").Output()
+}
+
+func main() {
+	run(context.TODO())
+	build(".")
+	runAll(context.TODO(), []string{"true"})
+	version()
+}
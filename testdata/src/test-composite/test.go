@@ -0,0 +1,62 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import "lib"
+
+type Config struct {
+	Enabled bool
+	Flags   []bool
+}
+
+// call as a value of a struct field initializer
+func structField() Config {
+	return Config{Enabled: lib.A()}
+}
+
+// call as an element of a composite literal without keys
+func compositeElt() Config {
+	return Config{lib.A(), nil}
+}
+
+// call as an element of a slice literal nested in a struct literal
+func sliceElt() Config {
+	return Config{Flags: []bool{lib.A(), true}}
+}
+
+// calls as a key and as a value of a map literal
+func mapKeyValue() map[bool]bool {
+	return map[bool]bool{lib.A(): lib.A()}
+}
+
+// call as a returned value
+func returned() (bool, error) {
+	return lib.A(), nil
+}
+
+// call as an index expression
+func index(m map[bool]int, s []int) int {
+	return m[lib.A()] + s[len(s)-1]
+}
+
+// call as an argument of another call
+func argument() bool {
+	return lib.B(lib.A())
+}
+
+func main() {
+	structField()
+	compositeElt()
+	sliceElt()
+	mapKeyValue()
+	returned()
+	index(nil, nil)
+	argument()
+}
@@ -0,0 +1,45 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"lib"
+	"lib_helper"
+)
+
+type ParamFn func(p bool) bool
+
+type holder struct {
+	fn ParamFn
+}
+
+// modified function converted to named function type in a composite literal
+func helper(p bool) bool {
+	return lib.B(p)
+}
+
+// unmodified function converted to the same named function type in an assignment
+func other(p bool) bool {
+	return p
+}
+
+func call(h holder, f ParamFn) bool {
+	return h.fn(true) || f(false)
+}
+
+func main() {
+	h := holder{fn: ParamFn(helper)}
+	var f ParamFn
+	f = ParamFn(other)
+	call(h, f)
+	// external function cannot be modified
+	f = ParamFn(lib_helper.Foo)
+	f(true)
+}
@@ -0,0 +1,45 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import "lib"
+
+// existing context parameter
+func foo(ctx lib.Context) {
+	// context captured by goroutine
+	go func() {
+		lib.CtxA(ctx)
+	}()
+	// context passed to goroutine
+	go func(c lib.Context) {
+		lib.CtxB(c, true)
+	}(ctx)
+	// context not referenced by goroutine
+	go func() {
+		println()
+	}()
+}
+
+// context parameter to be injected
+func bar() {
+	lib.A()
+	// context will be captured by goroutine
+	go func() {
+		lib.B(true)
+	}()
+	go func() {
+		println()
+	}()
+}
+
+func main() {
+	foo(lib.Background())
+	bar()
+}
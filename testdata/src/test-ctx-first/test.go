@@ -0,0 +1,63 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"lib"
+)
+
+type Handler func(int) bool
+
+// function literal of named function type
+var pkgHandler Handler = func(n int) bool {
+	return n > 1
+}
+
+type Doer interface {
+	Do(n int) bool
+}
+
+type doer struct{}
+
+// method implementing interface method
+func (doer) Do(n int) bool {
+	return n > 0 && lib.A()
+}
+
+// named function type
+func handle(n int) bool {
+	return n > 0 && lib.A()
+}
+
+// variadic function
+func sum(prefix string, nums ...int) bool {
+	return len(prefix)+len(nums) > 0 && lib.A()
+}
+
+// function type parameter
+func apply(f func(int, string) bool) bool {
+	return f(42, "")
+}
+
+func applied(n int, s string) bool {
+	return lib.A()
+}
+
+func Foo(d Doer, nums []int) bool {
+	var h Handler = handle
+	lit := func(s string) bool {
+		return sum(s, 1, 2) && sum(s) && sum(s, nums...)
+	}
+	return d.Do(1) && h(1) && lit("") && apply(applied)
+}
+
+func Bar() bool {
+	return Foo(doer{}, nil) && pkgHandler(2)
+}
@@ -0,0 +1,35 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"lib"
+	"lib_helper"
+)
+
+func foo() bool {
+	return lib.A()
+}
+
+// called from the package initializer - artificial context specific
+// to synthetic package initializer callers is passed at the call site
+var initialized = foo()
+
+// function to be passed as parameter to external function -
+// artificial context specific to such functions is injected
+func bar() bool {
+	return lib.A()
+}
+
+// default artificial context is injected
+func main() {
+	foo()
+	lib_helper.Register(bar)
+}
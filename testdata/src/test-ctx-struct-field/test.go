@@ -0,0 +1,46 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import "lib"
+
+// stores context when constructed
+type Service struct {
+	ctx  lib.Context
+	name string
+}
+
+func NewService(ctx lib.Context) *Service {
+	return &Service{ctx: ctx}
+}
+
+// receives context parameter
+func helper() bool {
+	return lib.A()
+}
+
+// passes context stored in the receiver's field
+func (s *Service) Handle() bool {
+	return helper() || lib.A()
+}
+
+// passes context stored in the receiver's field (value receiver)
+func (s Service) Value() bool {
+	f := func() bool {
+		return lib.A()
+	}
+	return f()
+}
+
+func main() {
+	s := NewService(lib.Background())
+	s.Handle()
+	s.Value()
+}
@@ -0,0 +1,47 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import "lib"
+
+// tests wrapping existing context parameters at leaf call sites
+
+// function with existing context parameter - context is wrapped at
+// the call site of A
+func foo(ctx lib.Context) bool {
+	return lib.A()
+}
+
+// function with existing context parameter - context is not wrapped
+// at the call site of B
+func bar(ctx lib.Context) bool {
+	return lib.B(true)
+}
+
+// function with existing context parameter of a different name -
+// context is wrapped at the call site of A in a nested function
+func baz(c lib.Context) bool {
+	f := func() bool {
+		return lib.A()
+	}
+	return f()
+}
+
+// function receiving context parameter - context is not wrapped
+func qux() bool {
+	return lib.A()
+}
+
+func main() {
+	foo(lib.Background())
+	bar(lib.Background())
+	baz(lib.Background())
+	qux()
+}
@@ -0,0 +1,28 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import "lib"
+
+// function whose result is called immediately
+func Foo() bool {
+	return lib.Counter("foo")(1)
+}
+
+// function whose result is stored in a variable before being called
+func Bar() bool {
+	c := lib.Counter("bar")
+	return c(1) && c(2)
+}
+
+func main() {
+	Foo()
+	Bar()
+}
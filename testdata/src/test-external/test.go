@@ -24,6 +24,9 @@ type ReceiverStructExt struct {
 type ReceiverStructReturn struct {
 }
 
+type ReceiverStructValue struct {
+}
+
 // method implementing an external interface - no context parameter injection
 func (*ReceiverStructExt) Foo() bool {
 	return lib.A()
@@ -45,6 +48,11 @@ func (*OuterStruct) baz() bool {
 	return lib.A()
 }
 
+// method passed as a method value to external function - no context parameter injection
+func (*ReceiverStructValue) qux() bool {
+	return lib.A()
+}
+
 // interface method Foo has to be actually called via interface
 // (passed as parameter) to be prevented from having context parameter
 // injected (otherwise Foo's definition will get a context parameter)
@@ -67,4 +75,6 @@ func main() {
 	lib_helper.Register(bar)
 	o := OuterStruct{}
 	o.baz()
+	v := ReceiverStructValue{}
+	lib_helper.Register(v.qux)
 }
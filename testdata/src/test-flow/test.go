@@ -0,0 +1,35 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import "lib"
+
+// receives context parameter and passes it to "leaf" function
+func foo() bool {
+	return lib.A()
+}
+
+// already takes context parameter
+func existing(ctx lib.Context) bool {
+	return foo()
+}
+
+// receives context parameter, captured by the anonymous function
+func bar() bool {
+	f := func() bool {
+		return foo()
+	}
+	return f() || existing(lib.Background())
+}
+
+// initializes artificial context
+func main() {
+	bar()
+}
@@ -0,0 +1,57 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import "lib"
+
+// named function type used as parameter type in the same package
+type Handler func() bool
+
+// alias of function type used as parameter type in the same package
+// (alias definition rather than parameter declaration should be
+// modified)
+type Getter = func(int) bool
+
+func foo() bool {
+	return lib.A()
+}
+
+func get(i int) bool {
+	return lib.A()
+}
+
+// parameter of named type called with a function literal
+func run(h Handler) bool {
+	return h()
+}
+
+// pointer to named type
+func runPtr(h *Handler) bool {
+	return (*h)()
+}
+
+// parameter of alias type
+func runGetter(g Getter) bool {
+	return g(1)
+}
+
+// pointer to alias type
+func runGetterPtr(g *Getter) bool {
+	return (*g)(2)
+}
+
+func main() {
+	run(func() bool { return lib.A() })
+	var h Handler = foo
+	runPtr(&h)
+	var g Getter = get
+	runGetter(g)
+	runGetterPtr(&g)
+}
@@ -0,0 +1,53 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"lib"
+	"lib_foreign"
+)
+
+// context is extracted from the foreign context parameter
+func Handle(c *lib_foreign.Context) bool {
+	return lib.A()
+}
+
+// context parameter is injected after the foreign context parameter
+func Serve(c lib_foreign.Ctx, name string) bool {
+	return lib.A()
+}
+
+// parameters declared together with the foreign context parameter
+// are split off
+func ServeBoth(c, d lib_foreign.Ctx) bool {
+	return lib.A()
+}
+
+type Server interface {
+	Serve(c lib_foreign.Ctx) bool
+}
+
+type server struct{}
+
+func (server) Serve(c lib_foreign.Ctx) bool {
+	return lib.A()
+}
+
+func callServer(s Server, c lib_foreign.Ctx) bool {
+	return s.Serve(c)
+}
+
+func main() {
+	Handle(&lib_foreign.Context{})
+	var c lib_foreign.Ctx
+	Serve(c, "name")
+	ServeBoth(c, c)
+	callServer(server{}, c)
+}
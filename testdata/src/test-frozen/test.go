@@ -0,0 +1,33 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"lib"
+	"test-frozen/api"
+)
+
+type getter struct{}
+
+// method implementing exported interface of a package with frozen API
+// - keeps its signature and receives artificial context
+func (getter) Get() bool {
+	return lib.A()
+}
+
+// function receiving context parameter
+func baz() bool {
+	return lib.A() && api.Foo()
+}
+
+func main() {
+	api.Use(getter{})
+	baz()
+}
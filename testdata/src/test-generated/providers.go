@@ -0,0 +1,26 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import "lib"
+
+type Service struct {
+	ok bool
+}
+
+// provider whose signature is modified to receive context
+func NewService() *Service {
+	return &Service{ok: lib.A()}
+}
+
+func main() {
+	s := InitializeService()
+	println(s.ok)
+}
@@ -0,0 +1,39 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import "lib"
+
+func multi(p1 bool, p2 bool) bool {
+	return p1 || p2
+}
+
+func foo() {
+	// calls nested inside go and defer statements should each get
+	// their own context argument
+	defer multi(lib.A(), lib.B(true))
+	go multi(lib.C(true), lib.A())
+	defer lib.B(lib.A())
+	go lib.D(lib.A(), lib.C(true))
+	go lib.A()
+	defer func(p bool) {
+		lib.B(p)
+	}(lib.A())
+	defer func() {
+		lib.A()
+	}()
+	go func() {
+		lib.B(true)
+	}()
+}
+
+func main() {
+	foo()
+}
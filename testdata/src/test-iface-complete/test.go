@@ -0,0 +1,48 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"lib"
+	"lib_helper"
+)
+
+type Doer interface {
+	Do() bool
+}
+
+type ModifiedDoer struct {
+}
+
+// method calling "leaf" function - Doer's method receives context
+// parameter
+func (*ModifiedDoer) Do() bool {
+	return lib.A()
+}
+
+type PassedDoer struct {
+}
+
+// method passed as a method value to external function - no context
+// parameter injection even though it implements Doer
+func (*PassedDoer) Do() bool {
+	return true
+}
+
+func do(d Doer) bool {
+	return d.Do()
+}
+
+func main() {
+	do(&ModifiedDoer{})
+	p := &PassedDoer{}
+	do(p)
+	lib_helper.Register(p.Do)
+}
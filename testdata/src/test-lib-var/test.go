@@ -0,0 +1,27 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import "lib"
+
+// function calling library function via a package-level variable
+func Foo(b bool) bool {
+	return lib.Send(b)
+}
+
+// function calling library function via a package-level variable
+// indirectly
+func Bar() bool {
+	return Foo(true) && lib.A()
+}
+
+func main() {
+	Bar()
+}
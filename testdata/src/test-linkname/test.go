@@ -0,0 +1,40 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"lib"
+	_ "unsafe"
+)
+
+// function linked to a runtime function - keeps its signature and
+// receives artificial context
+//
+//go:linkname hook runtime.hook
+func hook() bool {
+	return lib.A()
+}
+
+// function linked to a function of an external package - keeps its
+// signature and receives artificial context
+//
+//go:linkname helperHook lib_helper.hook
+func helperHook() bool {
+	return lib.A()
+}
+
+// function receiving context parameter
+func Foo() bool {
+	return hook() && helperHook() && lib.A()
+}
+
+func main() {
+	Foo()
+}
@@ -0,0 +1,28 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import "lib"
+
+type T struct{}
+
+// exported method
+func (t *T) Foo() bool {
+	return lib.A()
+}
+
+// unexported function
+func bar(t *T, f func() bool) bool {
+	return t.Foo() && f()
+}
+
+func main() {
+	bar(&T{}, func() bool { return lib.B(true) })
+}
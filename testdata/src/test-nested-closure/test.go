@@ -0,0 +1,75 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import "lib"
+
+type S struct {
+}
+
+// closure inside a closure inside a method with the "leaf" function
+// call at the innermost level
+func (s *S) Foo() bool {
+	outer := func() bool {
+		inner := func() bool {
+			return lib.A()
+		}
+		return inner()
+	}
+	return outer()
+}
+
+// innermost closure returned by the intermediate one and called in
+// the method
+func (s *S) Bar() bool {
+	outer := func() func() bool {
+		return func() bool {
+			return lib.A()
+		}
+	}
+	return outer()()
+}
+
+// innermost closure called by the intermediate one that is only
+// passed to another function
+func (s *S) Baz() bool {
+	outer := func() {
+		inner := func() bool {
+			return lib.A()
+		}
+		inner()
+	}
+	run(outer)
+	return true
+}
+
+// innermost closure using context of the intermediate one rather than
+// having it propagated to the method
+func (s *S) Qux() bool {
+	outer := func(c lib.Context) bool {
+		inner := func() bool {
+			return lib.A()
+		}
+		return inner()
+	}
+	return outer(lib.Background())
+}
+
+func run(f func()) {
+	f()
+}
+
+func main() {
+	s := &S{}
+	s.Foo()
+	s.Bar()
+	s.Baz()
+	s.Qux()
+}
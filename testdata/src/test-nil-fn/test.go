@@ -0,0 +1,62 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import "lib"
+
+// function to be passed as parameter
+func Foo() bool {
+	return lib.A()
+}
+
+// function calling its parameter of function type only if it is not
+// nil
+func Bar(f func() bool) bool {
+	if f != nil {
+		return f()
+	}
+	return false
+}
+
+// function setting its parameter of function type to nil before
+// calling it
+func Baz(f func() bool, disabled bool) bool {
+	if disabled {
+		f = nil
+	}
+	if f == nil {
+		return false
+	}
+	return f()
+}
+
+// function replacing nil value of its parameter of function type with
+// a default one
+func Qux(f func() bool) bool {
+	if f == nil {
+		f = Foo
+	}
+	return f()
+}
+
+// function calling a nil function value - there is no function to
+// propagate context into
+func Quux() {
+	var f func() bool
+	f()
+}
+
+func main() {
+	Bar(nil)
+	Bar(Foo)
+	Baz(Foo, false)
+	Qux(nil)
+	Quux()
+}
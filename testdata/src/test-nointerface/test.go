@@ -0,0 +1,42 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import "lib"
+
+type Doer interface {
+	Do() bool
+}
+
+type RealDoer struct{}
+
+// method implementing an interface
+func (d *RealDoer) Do() bool {
+	return lib.A()
+}
+
+type FakeDoer struct{}
+
+// test double not meant to implement an interface
+//
+//go:nointerface
+func (d *FakeDoer) Do() bool {
+	return lib.A()
+}
+
+func use(d Doer) bool {
+	return d.Do()
+}
+
+func main() {
+	use(&RealDoer{})
+	f := &FakeDoer{}
+	f.Do()
+}
@@ -0,0 +1,29 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"lib"
+)
+
+// Get is modified to take context
+func Get() bool {
+	return lib.A()
+}
+
+// Put is not modified
+func Put() bool {
+	return true
+}
+
+// Delete is not modified
+func Delete() bool {
+	return false
+}
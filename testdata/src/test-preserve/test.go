@@ -0,0 +1,40 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import "lib"
+
+// formatting of the code that is not transformed (including
+// formatting that gofmt would change) should be preserved
+
+type Rec struct {
+	a    int    // field comment
+	bb   string
+}
+
+func  Foo( x int ) bool {
+	// comment inside of a transformed function
+	return lib.A( )
+}
+
+func Bar(  ) bool {
+	y := Foo( 1 )   // trailing comment
+	return y
+}
+
+func Baz(r Rec)  int {
+	return r.a+len( r.bb )
+}
+
+func main() {
+	// comment preceding the first statement
+	Bar(  )
+	Baz(Rec{a:1})
+}
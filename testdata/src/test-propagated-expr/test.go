@@ -0,0 +1,32 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import "lib"
+
+// receives context parameter and passes it to "leaf" function
+func foo() bool {
+	return lib.A()
+}
+
+// receives context parameter and passes it wrapped to foo
+func bar() bool {
+	return foo()
+}
+
+// already takes context parameter with a different name
+func existing(c lib.Context) bool {
+	return foo()
+}
+
+func main() {
+	bar()
+	existing(lib.Background())
+}
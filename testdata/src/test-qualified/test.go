@@ -0,0 +1,24 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import "lib"
+
+// "leaf" function renamed to a function defined in a different
+// package imported with an alias
+
+func Foo() bool {
+	return lib.J()
+}
+
+func main() {
+	Foo()
+	Bar()
+}
@@ -0,0 +1,42 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import "lib"
+
+type Request struct {
+	ctx lib.Context
+}
+
+type Other struct {
+}
+
+// context should be extracted from the receiver rather than
+// injected as a parameter
+func (r *Request) handle(p bool) bool {
+	return lib.B(p)
+}
+
+// context should be extracted from the receiver (passed by value)
+func (r Request) get() bool {
+	return lib.A()
+}
+
+// receiver type not specified in the config file - context
+// parameter should be injected
+func (o *Other) handle(p bool) bool {
+	return lib.B(p)
+}
+
+// callers of methods extracting context from the receiver should
+// remain unchanged
+func serve(r *Request, o *Other) bool {
+	return r.handle(true) || r.get() || o.handle(false)
+}
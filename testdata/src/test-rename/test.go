@@ -0,0 +1,45 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import "lib"
+
+// only the first context parameter should be named (the second one
+// becomes blank so that parameter names are not duplicated)
+func FooA(lib.Context, lib.Context) bool {
+	return lib.A()
+}
+
+// same as above but with "_" names
+func FooB(_, _ lib.Context) bool {
+	return lib.A()
+}
+
+// remaining unnamed parameters should become blank
+func FooC(lib.Context, bool, int) bool {
+	return lib.A()
+}
+
+func Bar() bool {
+	// "_" in function literal should be replaced with the actual
+	// context parameter and used in call to lib.A
+	f := func(_ lib.Context) bool {
+		return lib.A()
+	}
+	// same as above but for immediately invoked function literal
+	g := func(_ lib.Context, p bool) bool {
+		return lib.B(p)
+	}(lib.Background(), true)
+	// unnamed parameters in function literal
+	h := func(lib.Context, bool) bool {
+		return lib.A()
+	}
+	return f(lib.Background()) || g || h(lib.Background(), true)
+}
@@ -0,0 +1,36 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"lib"
+	"lib_helper"
+)
+
+// handler registered with an external package - context should be
+// extracted from the stream parameter rather than being artificial
+func handle(stream lib_helper.ServerStream) error {
+	if lib.A() {
+		return stream.Send("a")
+	}
+	return nil
+}
+
+// stream parameter has no name - artificial context should be
+// injected
+func handleUnnamed(_ lib_helper.ServerStream) error {
+	lib.A()
+	return nil
+}
+
+func main() {
+	lib_helper.RegisterStream(handle)
+	lib_helper.RegisterStream(handleUnnamed)
+}
@@ -0,0 +1,73 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"lib"
+)
+
+type ops struct {
+	run func() bool
+}
+
+// function stored in a field of an anonymous struct
+func helper() bool {
+	return lib.A()
+}
+
+// function stored in a field of a named struct
+func namedHelper() bool {
+	return lib.A()
+}
+
+// call through a field of an anonymous struct
+func Foo() bool {
+	o := struct{ run func() bool }{run: helper}
+	return o.run()
+}
+
+// call through a field of a named struct
+func Bar() bool {
+	o := &ops{}
+	o.run = namedHelper
+	return o.run()
+}
+
+// function stored in a field without calling a "leaf" function
+func other() bool {
+	return false
+}
+
+// call through a field of an anonymous struct assigned different functions
+func Baz(b bool) bool {
+	o := struct{ run func() bool }{run: helper}
+	if b {
+		o.run = other
+	}
+	return o.run()
+}
+
+type handlers struct {
+	handle func(int) bool
+}
+
+func handle(i int) bool {
+	return i > 0 && lib.A()
+}
+
+func newHandlers() *handlers {
+	return &handlers{handle: handle}
+}
+
+// call through a field assigned in a different function
+func Qux() bool {
+	h := newHandlers()
+	return h.handle(42)
+}
@@ -0,0 +1,57 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import "lib"
+
+type HandlerFunc func(p bool) bool
+
+// handlers are stored as interface{} values so calls through them
+// are not visible as calls to HandlerFunc values
+var registry = map[string]interface{}{}
+
+func register(name string, h HandlerFunc) {
+	registry[name] = h
+}
+
+func hello(p bool) bool {
+	return lib.B(p)
+}
+
+type server struct {
+}
+
+func (*server) handle(p bool) bool {
+	return lib.B(p)
+}
+
+func dispatchAssert(name string) bool {
+	if h, ok := registry[name].(HandlerFunc); ok {
+		return h(true)
+	}
+	return false
+}
+
+func dispatchSwitch(name string) bool {
+	switch h := registry[name].(type) {
+	case HandlerFunc:
+		return h(false)
+	default:
+		return false
+	}
+}
+
+func main() {
+	register("hello", hello)
+	s := &server{}
+	register("method", s.handle)
+	dispatchAssert("method")
+	dispatchSwitch("method")
+}
@@ -0,0 +1,43 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"lib"
+	"unsafe"
+)
+
+// function to be called via a function value cast from unsafe.Pointer
+func Foo() bool {
+	return lib.A()
+}
+
+var fooFn = Foo
+
+// function whose call cannot be updated to pass context as the
+// function value is cast from unsafe.Pointer - receives artificial
+// context
+func Bar() bool {
+	return (*(*func() bool)(unsafe.Pointer(&fooFn)))()
+}
+
+// function calling a function value cast from unsafe.Pointer in a
+// closure - receives artificial context
+func Baz() bool {
+	f := func() bool {
+		return (*(*func() bool)(unsafe.Pointer(&fooFn)))()
+	}
+	return f()
+}
+
+func main() {
+	Bar()
+	Baz()
+}
@@ -0,0 +1,204 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package propagate
+
+import (
+	"fmt"
+	"go/token"
+	"os"
+	"sort"
+	"strconv"
+
+	"golang.org/x/tools/go/ssa"
+)
+
+// fnTypeNames are human-readable names of function types in fnVisited
+// map.
+var fnTypeNames = map[int]string{
+	regularFn:     "regular function (receives context parameter)",
+	freshCtxFn:    "function initializing its own context",
+	containerSig:  "signature used in map or slice construction",
+	extFn:         "function used by an external package",
+	extPkg:        "function implementing an external interface",
+	extRecv:       "method on a type embedding an external type",
+	testSuiteRecv: "method on a type embedding a test suite type",
+	skippedFileFn: "function defined in a skipped file",
+	unsafeCastFn:  "function calling a function value cast from unsafe.Pointer",
+	generatedFn:   "function defined in a generated file",
+	frozenFn:      "exported function of a package with frozen API",
+}
+
+// isTraced determines if analysis decisions concerning a given
+// function are to be traced (see Options.TraceFunc).
+func (cfg *analyzerConfig) isTraced(fn *ssa.Function) bool {
+	return cfg.opts.TraceFunc != "" && fn != nil && fn.String() == cfg.opts.TraceFunc
+}
+
+// trace records an analysis decision concerning a given function if
+// the function is traced.
+func (cfg *analyzerConfig) trace(fn *ssa.Function, msg string) {
+	if cfg.isExplained(fn) {
+		cfg.explainNotes = append(cfg.explainNotes, msg)
+	}
+	if !cfg.isTraced(fn) {
+		return
+	}
+	fmt.Fprintln(os.Stderr, "TRACE "+fn.String()+": "+msg)
+	cfg.debugData.Trace = append(cfg.debugData.Trace, msg)
+}
+
+// tracePos returns a human-readable representation of a given
+// position in a given function to be included in trace messages.
+func (cfg *analyzerConfig) tracePos(fn *ssa.Function, pos token.Pos) string {
+	p := cfg.getFset(fn).Position(pos)
+	return cfg.relPath(p.Filename) + ":" + strconv.Itoa(p.Line)
+}
+
+// traceResults records the final analysis results concerning the
+// traced function: the type it has been categorized as and the
+// replacements recorded at its call sites.
+func (cfg *analyzerConfig) traceResults() {
+	if cfg.opts.TraceFunc == "" {
+		return
+	}
+	var traced *ssa.Function
+	// the same function (and call site) may be represented by multiple
+	// nodes (and edges) in package variants including tests
+	sitesTraced := make(map[string]bool)
+	var sites []string
+	for fn, node := range cfg.graph.Nodes {
+		if !cfg.isTraced(fn) {
+			continue
+		}
+		traced = fn
+		for _, in := range node.In {
+			if !in.Site.Common().Pos().IsValid() {
+				continue
+			}
+			replacement, exists := cfg.callSites[cfg.getUniquePosCallSite(in)]
+			if !exists || replacement == nil {
+				continue
+			}
+			site := "call site in " + in.Caller.Func.String() + " at " + cfg.tracePos(in.Caller.Func, in.Site.Common().Pos())
+			if cfg.isNilCallReplacement(replacement) {
+				site += " receives artificial context"
+			} else {
+				site += " receives context argument " + replacement.ctxExpr
+			}
+			if !sitesTraced[site] {
+				sitesTraced[site] = true
+				sites = append(sites, site)
+			}
+		}
+	}
+	if traced == nil {
+		fmt.Fprintln(os.Stderr, "TRACE: function "+cfg.opts.TraceFunc+" not found in the call graph")
+		return
+	}
+	if fnType, exists := cfg.fnVisited[cfg.getUniquePosSSAFn(traced, traced.Pos())]; exists {
+		cfg.trace(traced, "categorized as "+fnTypeNames[fnType])
+	} else {
+		cfg.trace(traced, "not visited")
+	}
+	sort.Strings(sites)
+	for _, site := range sites {
+		cfg.trace(traced, site)
+	}
+}
+
+// isExplained determines if a given function is the one whose
+// categorization is to be explained (see Options.ExplainFunc) - the
+// function can be specified either using its SSA representation
+// (e.g. "(*pkg/path.Type).Method") or qualified with package path
+// (e.g. "pkg/path.(*Type).Method").
+func (cfg *analyzerConfig) isExplained(fn *ssa.Function) bool {
+	if cfg.opts.ExplainFunc == "" || fn == nil {
+		return false
+	}
+	if fn.String() == cfg.opts.ExplainFunc {
+		return true
+	}
+	return fn.Pkg != nil && fn.Pkg.Pkg.Path()+"."+fn.RelString(fn.Pkg.Pkg) == cfg.opts.ExplainFunc
+}
+
+// traceReach records an analysis decision concerning a given
+// function that is reached by the analysis (along with the function
+// from which it has been reached, if any) - only the first such
+// decision is used when explaining the analysis results.
+func (cfg *analyzerConfig) traceReach(fn *ssa.Function, from *ssa.Function, msg string) {
+	cfg.trace(fn, msg)
+	if cfg.opts.ExplainFunc == "" {
+		return
+	}
+	if cfg.reaches == nil {
+		cfg.reaches = make(map[*ssa.Function]reachInfo)
+	}
+	if _, exists := cfg.reaches[fn]; !exists {
+		cfg.reaches[fn] = reachInfo{from, msg}
+	}
+}
+
+// explainResults prints a human-readable explanation of how the
+// function specified via Options.ExplainFunc has been categorized
+// by the analysis: the resulting category, the analysis decisions
+// concerning the function and the chain of functions through which
+// it has been reached.
+func (cfg *analyzerConfig) explainResults() {
+	if cfg.opts.ExplainFunc == "" {
+		return
+	}
+	var explained *ssa.Function
+	for fn := range cfg.graph.Nodes {
+		if cfg.isExplained(fn) {
+			if _, exists := cfg.reaches[fn]; exists || explained == nil {
+				// prefer a package variant that has been reached
+				explained = fn
+			}
+		}
+	}
+	var lines []string
+	if explained == nil {
+		lines = append(lines, "function "+cfg.opts.ExplainFunc+" not found in the call graph")
+	} else {
+		lines = append(lines, "function "+explained.String()+" defined at "+cfg.tracePos(explained, explained.Pos()))
+		if fnType, exists := cfg.fnVisited[cfg.getUniquePosSSAFn(explained, explained.Pos())]; exists {
+			lines = append(lines, "categorized as "+fnTypeNames[fnType])
+		} else if _, exists := cfg.reaches[explained]; exists {
+			lines = append(lines, "not categorized (its signature is not modified - see analysis decisions)")
+		} else {
+			lines = append(lines, "not reached by the analysis (no call chain leads from it to a \"leaf\" function)")
+		}
+		if len(cfg.explainNotes) > 0 {
+			lines = append(lines, "analysis decisions:")
+			// the same decisions may concern multiple package
+			// variants including tests
+			notesSeen := make(map[string]bool)
+			for _, note := range cfg.explainNotes {
+				if !notesSeen[note] {
+					notesSeen[note] = true
+					lines = append(lines, "  "+note)
+				}
+			}
+		}
+		if _, exists := cfg.reaches[explained]; exists {
+			lines = append(lines, "propagation chain:")
+			visited := make(map[*ssa.Function]bool)
+			for fn := explained; fn != nil && !visited[fn]; fn = cfg.reaches[fn].from {
+				visited[fn] = true
+				lines = append(lines, "  "+fn.String()+": "+cfg.reaches[fn].reason)
+			}
+		}
+	}
+	fmt.Println("EXPLANATION:")
+	for _, line := range lines {
+		fmt.Println(line)
+	}
+	cfg.debugData.Explanation = lines
+}
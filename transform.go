@@ -13,9 +13,9 @@ import (
 	"fmt"
 	"go/ast"
 	"go/token"
+	"go/types"
 	"golang.org/x/tools/go/ast/astutil"
 	"golang.org/x/tools/go/packages"
-	"log"
 	"strconv"
 	"strings"
 )
@@ -45,10 +45,24 @@ func (cfg *transformerConfig) transform() map[*packages.Package]map[*ast.File]in
 			}
 			visitedFiles[p.CompiledGoFiles[ind]] = true
 
+			if cfg.skippedFiles[p.CompiledGoFiles[ind]] {
+				cfg.writeWarning(p.Fset, f.Package, "WARNING: file exceeds maximum file size of "+strconv.FormatInt(cfg.opts.MaxFileSize, 10)+" bytes and will not be transformed")
+				continue
+			}
+			if cfg.staleFiles[p.CompiledGoFiles[ind]] {
+				cfg.writeWarning(p.Fset, f.Package, "WARNING: file has changed since the applied plan was made and will not be transformed")
+				continue
+			}
+
+			cfg.currentFile = f
+			cfg.currentFileGenerated = cfg.generatedFiles[p.CompiledGoFiles[ind]]
 			cfg.computeExistingImports(f)
+			cfg.collectOrigSignatures(f)
+			cfg.collectDeclSymbols(f, p.CompiledGoFiles[ind])
 			// init context-related expressions that depend on the
 			// current file's import statements
 			cfg.initContextExpressions()
+			cfg.collectNamedTypeLits(f)
 			// perform AST transformation
 			cfg.newImports = make(map[string]string)
 
@@ -58,24 +72,34 @@ func (cfg *transformerConfig) transform() map[*packages.Package]map[*ast.File]in
 			res := astutil.Apply(f, nil, cfg.astRewrite)
 
 			if res != f {
-				log.Fatalf("root note of rewritten AST unexpectedly changed")
+				fatal("root note of rewritten AST unexpectedly changed")
 			}
 			if cfg.modified {
+				cfg.removeUnusedLibImport(f)
+				if cfg.checkEditLimits(p.Fset, f) {
+					continue
+				}
 				addResult(results, p, f, ind)
 				if cfg.addImports(f) {
 					importsAdded++
 				}
+				cfg.addFileEdits()
 			}
 		}
 	}
+	cfg.reportDisallowedArtificialCtx()
+	cfg.reportEditLimitsExceeded()
+	s := &cfg.debugData.Stats
+	s.IfacesModified = len(cfg.astIfaceModified)
+	s.IfaceMethodsModified = cfg.ifaceMethodModifiedNum
+	s.NamedModified = cfg.astNamedModifiedNum
+	s.ParamsModified = cfg.astParamsModifiedNum
+	s.CallsModified = cfg.astCallsModifiedNum
+	s.SignaturesModified = cfg.astSigsModifiedNum
+	s.DefinitionsModified = cfg.astDefsModifiedNum
+	s.ImportsAdded = importsAdded
 	if cfg.debugLevel > 0 {
-		fmt.Println("IFACES MODIFIED: " + strconv.Itoa(len(cfg.astIfaceModified)) + " METHODS: " + strconv.Itoa(cfg.ifaceMethodModifiedNum))
-		fmt.Println("NAMED MODIFIED: " + strconv.Itoa(cfg.astNamedModifiedNum))
-		fmt.Println("PARAMS MODIFIED: " + strconv.Itoa(cfg.astParamsModifiedNum))
-		fmt.Println("CALLS MODIFIED: " + strconv.Itoa(cfg.astCallsModifiedNum))
-		fmt.Println("SIGNATURES MODIFIED: " + strconv.Itoa(cfg.astSigsModifiedNum))
-		fmt.Println("DEFINITIONS MODIFIED: " + strconv.Itoa(cfg.astDefsModifiedNum))
-		fmt.Println("IMPORTS ADDED: " + strconv.Itoa(importsAdded))
+		s.printTransformStats()
 	}
 
 	return results
@@ -93,7 +117,9 @@ func (cfg *transformerConfig) computeExistingImports(f *ast.File) {
 			importPath = importPath[1 : len-1]
 			if imp.Name == nil {
 				cfg.existingImports[importPath] = ""
-			} else {
+			} else if imp.Name.Name != "_" {
+				// blank imports cannot be used to qualify
+				// identifiers so they are not taken into account
 				cfg.existingImports[importPath] = imp.Name.Name
 			}
 		}
@@ -104,60 +130,109 @@ func (cfg *transformerConfig) computeExistingImports(f *ast.File) {
 // the code whose final shape depends on the imports already defined
 // in the analyzed AST.
 func (cfg *transformerConfig) initContextExpressions() {
-	pkgAlias, importFound := cfg.existingImports[cfg.CtxPkgPath]
-	if importFound {
-		if pkgAlias != "" {
-			cfg.CtxParamInvalid = pkgAlias + "." + cfg.CtxParamInvalid
-			cfg.ctxParamTypeWithPkgAlias = pkgAlias + "." + cfg.CtxParamType
-		} else {
-			cfg.CtxParamInvalid = cfg.CtxPkgName + "." + cfg.CtxParamInvalid
-			cfg.ctxParamTypeWithPkgAlias = cfg.CtxPkgName + "." + cfg.CtxParamType
+	qualify := func(expr string) string {
+		if cfg.isCtxPkgCurrent() {
+			// no qualifier when referencing context package's
+			// identifiers from within this package
+			return expr
 		}
-	} else {
-		if cfg.CtxPkgAlias == "" {
-			cfg.CtxParamInvalid = cfg.CtxPkgName + "." + cfg.CtxParamInvalid
-			cfg.ctxParamTypeWithPkgAlias = cfg.CtxPkgName + "." + cfg.CtxParamType
-		} else {
-			cfg.CtxParamInvalid = cfg.CtxPkgAlias + "." + cfg.CtxParamInvalid
-			cfg.ctxParamTypeWithPkgAlias = cfg.CtxPkgAlias + "." + cfg.CtxParamType
+		qualifier := cfg.CtxPkgName
+		if pkgAlias, importFound := cfg.existingImports[cfg.CtxPkgPath]; importFound {
+			if pkgAlias != "" {
+				qualifier = pkgAlias
+			}
+		} else if cfg.CtxPkgAlias != "" {
+			qualifier = cfg.CtxPkgAlias
 		}
+		return qualifier + "." + expr
+	}
+	cfg.ctxParamInvalidWithPkgAlias = qualify(cfg.CtxParamInvalid[defaultCtxReason])
+	cfg.ctxParamTypeWithPkgAlias = qualify(cfg.CtxParamType)
+	cfg.ctxCallSiteArtificialWithPkgAlias = cfg.ctxParamInvalidWithPkgAlias
+	if cfg.CtxCallSiteArtificial != "" {
+		cfg.ctxCallSiteArtificialWithPkgAlias = qualify(cfg.CtxCallSiteArtificial)
+	}
+	cfg.nilCallReplacement = replacementInfo{"", cfg.getInjectedCtxArgPos(), nil, "", cfg.ctxCallSiteArtificialWithPkgAlias, false, false, ""}
+	cfg.ctxParamInvalidReasonsWithPkgAlias = make(map[string]string)
+	for reason, callReplacement := range cfg.nilCallReplacements {
+		expr := qualify(cfg.CtxParamInvalid[reason])
+		cfg.ctxParamInvalidReasonsWithPkgAlias[reason] = expr
+		*callReplacement = replacementInfo{"", cfg.getInjectedCtxArgPos(), nil, "", expr, false, false, ""}
 	}
-	cfg.nilCallReplacement = replacementInfo{"", 1, nil, "", cfg.CtxParamInvalid}
+}
+
+// isCtxPkgCurrent determines if the package currently being
+// transformed is the one where context is defined (in which case
+// context package's identifiers must not be qualified and the
+// context package must not be imported).
+func (cfg *transformerConfig) isCtxPkgCurrent() bool {
+	return cfg.currentPkg != nil && cfg.currentPkg.PkgPath == cfg.CtxPkgPath
 }
 
 // astRewrite implements the main AST rewriting logic.
 func (cfg *transformerConfig) astRewrite(c *astutil.Cursor) bool {
+	if cfg.currentFileGenerated && isDefinitionRewrite(c) {
+		// only call sites are transformed in generated files (see
+		// GeneratedCodePatterns)
+		return true
+	}
 	if e, ok := c.Node().(*ast.CallExpr); ok {
-		pos := cfg.renameCallSite(c, e)
-		cfg.rewriteCallSite(c, e, pos)
+		pos := cfg.renameCallSite(e)
+		cfg.rewriteCallSite(e, pos)
+		cfg.rewriteClosureArgs(e, pos)
 
+	} else if sel, ok := c.Node().(*ast.SelectorExpr); ok && cfg.isLibIfaceRef(sel) {
+		// refer to context-aware copy of the library interface
+		cfg.replaceLibIfaceRef(c, sel)
 	} else if fd, ok := c.Parent().(*ast.FuncDecl); ok && c.Name() == "Type" {
 		uniquePos := cfg.getUniquePosPkg(cfg.currentPkg.Types, fd.Name.NamePos)
 		if fnType, exists := cfg.fnVisited[uniquePos]; exists && fnType == regularFn {
 			// modify "regular" (named) function definition to inject context parameter
 			ft := c.Node().(*ast.FuncType)
 			cfg.addContextParam(ft.Params)
+			cfg.renameContextParam(ft.Params, cfg.getCtxParamName(uniquePos))
 			cfg.modified = true
 			cfg.astSigsModifiedNum++
+			cfg.addFunctionReport(fd, false, "")
+			i := cfg.getCtxParamIndex(ft.Params)
+			cfg.addEdit(fd.Name.NamePos, edit{Kind: paramEdit, Name: paramName(ft.Params.List[i]), Type: cfg.ctxParamTypeWithPkgAlias, ArgIndex: i})
 		}
 	} else if fl, ok := c.Parent().(*ast.FuncLit); ok && c.Name() == "Type" {
 		uniquePos := cfg.getUniquePosPkg(cfg.currentPkg.Types, fl.Type.Func)
-		if fnType, exists := cfg.fnVisited[uniquePos]; exists && fnType == regularFn {
+		if fnType, exists := cfg.fnVisited[uniquePos]; (exists && fnType == regularFn) || cfg.namedTypeLits[fl] {
 			// modify function literal (e.g. anonymous function definition) to inject context parameter
 			ft := c.Node().(*ast.FuncType)
 			cfg.addContextParam(ft.Params)
+			cfg.renameContextParam(ft.Params, cfg.getCtxParamName(uniquePos))
 			cfg.modified = true
 			cfg.astSigsModifiedNum++
+			i := cfg.getCtxParamIndex(ft.Params)
+			cfg.addEdit(fl.Type.Func, edit{Kind: literalParamEdit, Name: paramName(ft.Params.List[i]), Type: cfg.ctxParamTypeWithPkgAlias, ArgIndex: i})
 		}
 	} else if fl, ok := c.Node().(*ast.FieldList); ok && c.Name() == "Params" {
 		// modify function type definition representing some other function's parameter to inject context parameter
 		if fl.List != nil {
 			for _, fld := range fl.List {
 				uniquePos := cfg.getUniquePosPkg(cfg.currentPkg.Types, fld.Pos())
-				if cfg.fnParamsVisited[uniquePos] {
+				if cfg.fnParamsVisited[uniquePos] && !cfg.checkParamTypeDef(fld) {
 					astutil.Apply(fld.Type, cfg.addContextParamApply, nil)
 					cfg.modified = true
 					cfg.astParamsModifiedNum++
+					cfg.addEdit(fld.Pos(), edit{Kind: fnTypeParamEdit, Type: cfg.ctxParamTypeWithPkgAlias})
+				}
+			}
+		}
+	} else if _, ok := c.Parent().(*ast.StructType); ok && c.Name() == "Fields" {
+		// modify function type definition of a struct field to inject context parameter
+		fl := c.Node().(*ast.FieldList)
+		for _, fld := range fl.List {
+			for _, name := range fld.Names {
+				if cfg.fnFieldsVisited[cfg.getUniquePosPkg(cfg.currentPkg.Types, name.NamePos)] {
+					astutil.Apply(fld.Type, cfg.addContextParamApply, nil)
+					cfg.modified = true
+					cfg.astParamsModifiedNum++
+					cfg.addEdit(fld.Pos(), edit{Kind: fnTypeFieldEdit, Type: cfg.ctxParamTypeWithPkgAlias})
+					break
 				}
 			}
 		}
@@ -172,30 +247,41 @@ func (cfg *transformerConfig) astRewrite(c *astutil.Cursor) bool {
 					astutil.Apply(fld.Type, cfg.addContextParamApply, nil)
 					cfg.modified = true
 					cfg.ifaceMethodModifiedNum++
+					cfg.addIfaceParamEdit(fld)
 				}
 			}
 		}
 	} else if fd, ok := c.Parent().(*ast.FuncDecl); ok && c.Name() == "Body" {
 		uniquePos := cfg.getUniquePosPkg(cfg.currentPkg.Types, fd.Name.NamePos)
+		if ctxName, exists := cfg.carrierCtors[uniquePos]; exists && fd.Body != nil {
+			// initialize context field of context carrier type
+			// constructed by the function
+			cfg.initCarrierCtxFields(fd, ctxName)
+		}
 		if fnType, exists := cfg.fnVisited[uniquePos]; exists && fnType == freshCtxFn {
 			// modify "regular" (named) function definition to inject context variable declaration
 			if fd.Body == nil {
-				log.Fatalf("adding artificial context to function declaration with no body")
+				fatal("adding artificial context to function declaration with no body")
 			}
-			fd.Body.List = cfg.addContextInitStmt(fd.Body.List, fd.Name.NamePos)
+			fd.Body.List = cfg.addContextInitStmt(fd.Body, cfg.getCtxParamName(uniquePos), cfg.getCtxInitExpr(uniquePos))
+			cfg.recordArtificialCtx(uniquePos, fd.Name.NamePos, initEdit)
 			cfg.modified = true
 			cfg.astDefsModifiedNum++
+			cfg.addFunctionReport(fd, true, cfg.getCtxInitExpr(uniquePos))
+			cfg.addEdit(fd.Name.NamePos, edit{Kind: initEdit, Name: cfg.getCtxParamName(uniquePos), Expr: normalizeExpr(cfg.getCtxInitExpr(uniquePos))})
 		}
 	} else if fl, ok := c.Parent().(*ast.FuncLit); ok && c.Name() == "Body" {
 		uniquePos := cfg.getUniquePosPkg(cfg.currentPkg.Types, fl.Type.Func)
-		if fnType, exists := cfg.fnVisited[uniquePos]; exists && fnType == freshCtxFn {
+		if fnType, exists := cfg.fnVisited[uniquePos]; exists && fnType == freshCtxFn && !cfg.namedTypeLits[fl] {
 			// modify function literal (e.g. anonymous function definition) to inject context variable declaration
 			if fl.Body == nil {
-				log.Fatalf("adding artificial context to function literal with no body")
+				fatal("adding artificial context to function literal with no body")
 			}
-			fl.Body.List = cfg.addContextInitStmt(fl.Body.List, fl.Type.Func)
+			fl.Body.List = cfg.addContextInitStmt(fl.Body, cfg.getCtxParamName(uniquePos), cfg.getCtxInitExpr(uniquePos))
+			cfg.recordArtificialCtx(uniquePos, fl.Type.Func, initEdit)
 			cfg.modified = true
 			cfg.astDefsModifiedNum++
+			cfg.addEdit(fl.Type.Func, edit{Kind: literalInitEdit, Name: cfg.getCtxParamName(uniquePos), Expr: normalizeExpr(cfg.getCtxInitExpr(uniquePos))})
 		}
 	} else if ft, ok := c.Parent().(*ast.TypeSpec); ok && c.Name() == "Type" {
 		uniquePos := cfg.getUniquePosPkg(cfg.currentPkg.Types, ft.Name.NamePos)
@@ -204,21 +290,243 @@ func (cfg *transformerConfig) astRewrite(c *astutil.Cursor) bool {
 			astutil.Apply(c.Node(), cfg.addContextParamApply, nil)
 			cfg.modified = true
 			cfg.astNamedModifiedNum++
+			cfg.addEdit(ft.Name.NamePos, edit{Kind: namedTypeEdit, Type: cfg.ctxParamTypeWithPkgAlias})
+		} else if st, ok := ft.Type.(*ast.StructType); ok && cfg.carrierTypes[uniquePos] {
+			// modify context carrier type to add context field
+			names := []*ast.Ident{ast.NewIdent(cfg.CtxParamName)}
+			st.Fields.List = append(st.Fields.List, &ast.Field{Doc: nil, Names: names, Type: ast.NewIdent(cfg.ctxParamTypeWithPkgAlias), Tag: nil, Comment: nil})
+			cfg.modified = true
+			cfg.addEdit(ft.Name.NamePos, edit{Kind: carrierFieldEdit, Name: cfg.CtxParamName, Type: cfg.ctxParamTypeWithPkgAlias})
 		}
+	} else if vs, ok := c.Node().(*ast.ValueSpec); ok {
+		cfg.checkIfaceAssertion(vs)
 	} else if fld, ok := c.Node().(*ast.Field); ok && fld.Names == nil {
 		uniquePos := cfg.getUniquePosPkg(cfg.currentPkg.Types, fld.Pos())
 		if cfg.renameParamsVisited[uniquePos] {
 			fld.Names = []*ast.Ident{ast.NewIdent(cfg.CtxParamName)}
+			cfg.modified = true
+			cfg.addEdit(fld.Pos(), edit{Kind: paramRenameEdit, Name: cfg.CtxParamName})
+			if fl, ok := c.Parent().(*ast.FieldList); ok {
+				// parameters must be either all named or all
+				// unnamed - remaining ones become blank
+				for _, f := range fl.List {
+					if f.Names == nil {
+						f.Names = []*ast.Ident{ast.NewIdent("_")}
+					}
+				}
+			}
 		}
 	} else if fld, ok := c.Parent().(*ast.Field); ok && c.Name() == "Names" && c.Index() == 0 {
 		uniquePos := cfg.getUniquePosPkg(cfg.currentPkg.Types, fld.Pos())
 		if cfg.renameParamsVisited[uniquePos] {
 			c.Replace(ast.NewIdent(cfg.CtxParamName))
+			cfg.modified = true
+			cfg.addEdit(fld.Pos(), edit{Kind: paramRenameEdit, Name: cfg.CtxParamName})
 		}
 	}
 	return true
 }
 
+// isDefinitionRewrite determines if a node visited during AST
+// traversal is a part of a function signature or of a type definition
+// that may be modified by astRewrite (as opposed to call sites and
+// function bodies).
+func isDefinitionRewrite(c *astutil.Cursor) bool {
+	switch c.Parent().(type) {
+	case *ast.FuncDecl, *ast.FuncLit, *ast.TypeSpec:
+		return c.Name() == "Type"
+	case *ast.InterfaceType:
+		return c.Name() == "Methods"
+	case *ast.StructType:
+		return c.Name() == "Fields"
+	case *ast.Field:
+		return c.Name() == "Names"
+	}
+	if _, ok := c.Node().(*ast.FieldList); ok && c.Name() == "Params" {
+		return true
+	}
+	fld, ok := c.Node().(*ast.Field)
+	return ok && fld.Names == nil
+}
+
+// collectNamedTypeLits collects function literals assigned to
+// package-level variables declared with (or converted to) named
+// function types receiving context parameter. Package-level
+// variables are initialized in the synthetic package initializer
+// which does not have context available, and such literals may not
+// be reached during analysis at all, yet they have to match the
+// modified type.
+func (cfg *transformerConfig) collectNamedTypeLits(f *ast.File) {
+	cfg.namedTypeLits = make(map[*ast.FuncLit]bool)
+	for _, decl := range f.Decls {
+		gd, ok := decl.(*ast.GenDecl)
+		if !ok || gd.Tok != token.VAR {
+			continue
+		}
+		for _, spec := range gd.Specs {
+			vs := spec.(*ast.ValueSpec)
+			declModified := vs.Type != nil && cfg.isModifiedFnType(cfg.currentPkg.TypesInfo.TypeOf(vs.Type))
+			for _, v := range vs.Values {
+				v = astutil.Unparen(v)
+				if conv, ok := v.(*ast.CallExpr); ok && len(conv.Args) == 1 {
+					// conversion to a named function type
+					if tv, ok := cfg.currentPkg.TypesInfo.Types[conv.Fun]; ok && tv.IsType() && cfg.isModifiedFnType(tv.Type) {
+						if fl, ok := astutil.Unparen(conv.Args[0]).(*ast.FuncLit); ok {
+							cfg.namedTypeLits[fl] = true
+						}
+					}
+				} else if fl, ok := v.(*ast.FuncLit); ok && declModified {
+					cfg.namedTypeLits[fl] = true
+				}
+			}
+		}
+	}
+}
+
+// isModifiedFnType checks if a given type is a named function type
+// (or an alias of a function type) whose definition receives context
+// parameter.
+func (cfg *transformerConfig) isModifiedFnType(t types.Type) bool {
+	var objs []*types.TypeName
+	if alias, ok := t.(*types.Alias); ok {
+		objs = append(objs, alias.Obj())
+	}
+	if named, ok := types.Unalias(t).(*types.Named); ok {
+		objs = append(objs, named.Obj())
+	}
+	for _, obj := range objs {
+		if obj.Pkg() == nil {
+			continue
+		}
+		if fnType, exists := cfg.fnVisited[cfg.getUniquePosPkg(obj.Pkg(), obj.Pos())]; exists && fnType == regularFn {
+			return true
+		}
+	}
+	return false
+}
+
+// checkParamTypeDef checks if the function type of a given parameter
+// that needs context parameter is referenced by name (e.g. via an
+// alias) rather than spelled out in the parameter declaration, in
+// which case it is the type definition that receives context
+// parameter and it returns true. It warns if the type definition has
+// not been modified (the parameter declaration and the definition
+// must be updated together).
+func (cfg *transformerConfig) checkParamTypeDef(fld *ast.Field) bool {
+	typ := fld.Type
+	if star, ok := typ.(*ast.StarExpr); ok {
+		typ = star.X
+	}
+	if sel, ok := typ.(*ast.SelectorExpr); ok {
+		typ = sel.Sel
+	}
+	ident, ok := typ.(*ast.Ident)
+	if !ok {
+		// function type spelled out
+		return false
+	}
+	obj, ok := cfg.currentPkg.TypesInfo.Uses[ident].(*types.TypeName)
+	if !ok {
+		return false
+	}
+	if fnType, exists := cfg.fnVisited[cfg.getUniquePosPkg(obj.Pkg(), obj.Pos())]; !exists || fnType != regularFn {
+		msg := "WARNING: definition of type " + obj.Name() + " of parameter " + paramName(fld) + " has not been modified to take context parameter"
+		cfg.writeWarning(cfg.currentPkg.Fset, fld.Pos(), msg)
+	}
+	return true
+}
+
+// initCarrierCtxFields initializes context field in all composite
+// literals of context carrier types in the body of a given
+// constructor using context parameter (or variable) with a given
+// name.
+func (cfg *transformerConfig) initCarrierCtxFields(fd *ast.FuncDecl, ctxName string) {
+	found := false
+	ast.Inspect(fd.Body, func(n ast.Node) bool {
+		cl, ok := n.(*ast.CompositeLit)
+		if !ok {
+			return true
+		}
+		t := cfg.currentPkg.TypesInfo.TypeOf(cl)
+		if t == nil {
+			return true
+		}
+		if p, ok := t.(*types.Pointer); ok {
+			// literal with elided type of a pointer element
+			t = p.Elem()
+		}
+		named, ok := t.(*types.Named)
+		if !ok || !cfg.carrierTypes[cfg.getUniquePosPkg(named.Obj().Pkg(), named.Obj().Pos())] {
+			return true
+		}
+		found = true
+		var elt ast.Expr = &ast.KeyValueExpr{Key: ast.NewIdent(cfg.CtxParamName), Value: ast.NewIdent(ctxName)}
+		if len(cl.Elts) > 0 {
+			if _, keyed := cl.Elts[0].(*ast.KeyValueExpr); !keyed {
+				// unkeyed literal - context field is the last one
+				elt = ast.NewIdent(ctxName)
+			}
+		}
+		cl.Elts = append(cl.Elts, elt)
+		cfg.addEdit(cl.Lbrace, edit{Kind: carrierInitEdit, Name: cfg.CtxParamName, Expr: ctxName})
+		return true
+	})
+	if found {
+		cfg.modified = true
+	} else {
+		// the struct may be constructed elsewhere (e.g. by another
+		// constructor)
+		cfg.writeWarning(cfg.currentPkg.Fset, fd.Name.NamePos, "WARNING: constructor "+fd.Name.Name+" of context carrier type does not contain literal of this type (context field not initialized)")
+	}
+}
+
+// checkIfaceAssertion checks if a compile-time interface assertion
+// (e.g. var _ Iface = (*Impl)(nil)) refers to an interface whose
+// methods have been modified while the asserted implementation's
+// methods have not (e.g. because they are defined in an external
+// package), and warns about it as the assertion will be the first
+// place where a build fails.
+func (cfg *transformerConfig) checkIfaceAssertion(vs *ast.ValueSpec) {
+	if vs.Type == nil || len(vs.Names) != len(vs.Values) {
+		// not an interface assertion
+		return
+	}
+	iface, ok := cfg.currentPkg.TypesInfo.TypeOf(vs.Type).Underlying().(*types.Interface)
+	if !ok {
+		// not an interface type
+		return
+	}
+	for ind, name := range vs.Names {
+		if name.Name != "_" {
+			// not an assertion
+			continue
+		}
+		implType := cfg.currentPkg.TypesInfo.TypeOf(vs.Values[ind])
+		if implType == nil {
+			continue
+		}
+		for i := 0; i < iface.NumMethods(); i++ {
+			m := iface.Method(i)
+			if fnType, exists := cfg.fnVisited[cfg.getUniquePosPkg(m.Pkg(), m.Pos())]; !exists || fnType != regularFn {
+				// interface method has not been modified
+				continue
+			}
+			obj, _, _ := types.LookupFieldOrMethod(implType, true, m.Pkg(), m.Name())
+			implMethod, ok := obj.(*types.Func)
+			if !ok {
+				continue
+			}
+			fnType, exists := cfg.fnVisited[cfg.getUniquePosPkg(implMethod.Pkg(), implMethod.Pos())]
+			if exists && fnType == regularFn && !cfg.isPkgExternal(implMethod.Pkg().Path()) {
+				// implementation has been modified as well
+				continue
+			}
+			msg := "WARNING: method " + m.Name() + " of type " + types.TypeString(implType, nil) + " has not been modified to take context parameter but interface " + types.TypeString(cfg.currentPkg.TypesInfo.TypeOf(vs.Type), nil) + " asserted to be implemented by this type has"
+			cfg.writeWarning(cfg.currentPkg.Fset, vs.Pos(), msg)
+		}
+	}
+}
+
 // addResult records a file modified during AST traversal.
 func addResult(results map[*packages.Package]map[*ast.File]int, pkg *packages.Package, f *ast.File, ind int) {
 	var exists bool
@@ -237,115 +545,222 @@ func (cfg *transformerConfig) addImports(f *ast.File) bool {
 	// to cover both named and unnamed import
 	added := false
 	_, importFound := cfg.existingImports[cfg.CtxPkgPath]
-	if !importFound {
-		if cfg.CtxPkgAlias == "" {
-			added = astutil.AddImport(cfg.currentPkg.Fset, f, cfg.CtxPkgPath) || added
-		} else {
-			added = astutil.AddNamedImport(cfg.currentPkg.Fset, f, cfg.CtxPkgAlias, cfg.CtxPkgPath) || added
-		}
+	if !importFound && !cfg.isCtxPkgCurrent() {
+		added = cfg.addImport(f, cfg.CtxPkgAlias, cfg.CtxPkgPath) || added
 	}
 	for imp, alias := range cfg.newImports {
-		if alias == "" {
-			added = astutil.AddImport(cfg.currentPkg.Fset, f, imp) || added
-		} else {
-			added = astutil.AddNamedImport(cfg.currentPkg.Fset, f, alias, imp) || added
-		}
+		added = cfg.addImport(f, alias, imp) || added
+	}
+	return added
+}
+
+// addImport adds import with a given path (and optional alias) if it
+// does not exist yet and returns true if it has been added.
+func (cfg *transformerConfig) addImport(f *ast.File, alias string, path string) bool {
+	var added bool
+	if alias == "" {
+		added = astutil.AddImport(cfg.currentPkg.Fset, f, path)
+	} else {
+		added = astutil.AddNamedImport(cfg.currentPkg.Fset, f, alias, path)
+	}
+	if added && cfg.currentEdits != nil {
+		cfg.currentEdits.ImportsAdded = append(cfg.currentEdits.ImportsAdded, path)
 	}
 	return added
 }
 
 // renameCallSite renames function/method at a given call site and
-// returns position of the call site expression.
-func (cfg *transformerConfig) renameCallSite(c *astutil.Cursor, e *ast.CallExpr) token.Pos {
+// returns position of the call site expression. The position is
+// always that of the opening parenthesis, also for calls in go and
+// defer statements, to match call site positions computed during
+// analysis.
+func (cfg *transformerConfig) renameCallSite(e *ast.CallExpr) token.Pos {
 	pos := e.Lparen
-	if g, ok := c.Parent().(*ast.GoStmt); ok {
-		pos = g.Go
-	} else if d, ok := c.Parent().(*ast.DeferStmt); ok {
-		pos = d.Defer
-	}
 	// rename functions at call sites
 	uniquePos := cfg.getUniquePosPkg(cfg.currentPkg.Types, pos)
 	if newName, exists := cfg.callSitesRenamed[uniquePos]; exists {
-		newIdent := ast.NewIdent(newName)
-		if n, ok := e.Fun.(*ast.SelectorExpr); ok {
+		cfg.recordOrigCallee(uniquePos, e.Fun)
+		qualifier, name := splitQualifiedName(newName)
+		newIdent := ast.NewIdent(name)
+		if qualifier != "" {
+			// new function is defined in a different package so the
+			// whole function expression must be replaced
+			e.Fun = &ast.SelectorExpr{X: ast.NewIdent(cfg.resolveQualifier(qualifier, uniquePos)), Sel: newIdent}
+		} else if n, ok := e.Fun.(*ast.SelectorExpr); ok {
 			// must replace the whole selector expression
 			e.Fun = &ast.SelectorExpr{X: n.X, Sel: newIdent}
 		} else if _, ok := e.Fun.(*ast.Ident); ok {
 			e.Fun = newIdent
 		} else {
-			log.Fatalf("unrecognized call expression when rewriting AST")
+			fatal("unrecognized call expression when rewriting AST")
 		}
 		cfg.modified = true
+		if _, exists := cfg.callSites[uniquePos]; !exists {
+			cfg.addEdit(pos, edit{Kind: renameEdit, Callee: types.ExprString(e.Fun), OrigCallee: cfg.origCallees[uniquePos]})
+		}
 	}
 	return pos
 
 }
 
-// rewriteCallSite adds context arguments to a given call site.
-func (cfg *transformerConfig) rewriteCallSite(c *astutil.Cursor, e *ast.CallExpr, pos token.Pos) {
+// resolveQualifier returns package qualifier to be used in a given
+// file for the new name of the function called at a given call
+// site. The import defining the qualifier is added when the call site
+// is rewritten (see getCtxExprAndAddImports) unless the file already
+// imports the package under a different name.
+func (cfg *transformerConfig) resolveQualifier(qualifier string, uniquePos uniquePosInfo) string {
+	callReplacement, exists := cfg.callSites[uniquePos]
+	if !exists {
+		return qualifier
+	}
+	imp := getQualifierImport(qualifier, callReplacement.ctxImports)
+	if callReplacement.ctxImports[imp] != "" {
+		// import with explicit alias will be added
+		return qualifier
+	}
+	if alias := cfg.existingImports[imp]; alias != "" && alias != "_" && alias != "." {
+		// package is already imported under a different name
+		return alias
+	}
+	return qualifier
+}
+
+// rewriteCallSite adds context arguments to a given call site. Call
+// expressions are updated in place so that call sites are handled the
+// same way regardless of where they appear (e.g. as arguments of
+// other calls or as elements of composite literals).
+func (cfg *transformerConfig) rewriteCallSite(e *ast.CallExpr, pos token.Pos) {
 	uniquePos := cfg.getUniquePosPkg(cfg.currentPkg.Types, pos)
-	if _, ok := c.Parent().(*ast.CallExpr); !ok && e.Args == nil {
-		// handle no-argument case for a call site only if this
-		// call site is not an argument to another call;
-		// this case is handled below due to some problem with AST
-		// traversal when we need to replace CallExpr stored in an array
-		// (of another function's arguments)
-		if callReplacement, exists := cfg.callSites[uniquePos]; exists {
-			if callReplacement.argPos != 1 {
-				cfg.writeWarning(cfg.currentPkg.Fset, pos, "WARNING: requesting to put a context argument in a position other then the first one for parameter-less function - defaulting to first position")
-			}
-			ctxExpr := cfg.getCtxExprAndAddImports(cfg.existingImports, cfg.newImports, callReplacement)
-			args := []ast.Expr{ast.Expr(ast.NewIdent(cfg.resolveCtxExprPackageWildcard(ctxExpr)))}
-			ce := ast.CallExpr{Fun: e.Fun, Lparen: e.Lparen, Args: args, Ellipsis: e.Ellipsis, Rparen: e.Rparen}
-			c.Replace(&ce)
-			cfg.modified = true
-			cfg.astCallsModifiedNum++
+	callReplacement, exists := cfg.callSites[uniquePos]
+	if !exists {
+		return
+	}
+	var argPos int
+	if callReplacement.argPos < 1 {
+		// inject at the last position if negative argPos value
+		argPos = cfg.getLastCtxArgPos(e)
+	} else if len(e.Args) == 0 {
+		if callReplacement.argPos != 1 {
+			cfg.writeWarning(cfg.currentPkg.Fset, pos, "WARNING: requesting to put a context argument in a position other then the first one for parameter-less function - defaulting to first position")
+		}
+	} else {
+		argPos = callReplacement.argPos - 1
+		if callReplacement.argPos == 1 && cfg.isCallCtxAfterForeign(e) {
+			// context argument follows the argument of foreign
+			// context type
+			argPos = 1
+		}
+		if argPos > len(e.Args) {
+			fatal("error requesting to put a context argument in a position beyond the last function parameter" + cfg.currentPkg.Fset.Position(pos).String())
+		}
+	}
+	ctxExpr := cfg.getCtxExprAndAddImports(cfg.existingImports, cfg.newImports, callReplacement)
+	ctxExpr = replaceCtxExprWildcard(ctxWildcard, callReplacement.ctxWrapExpr, ctxExpr)
+	var newArgs []ast.Expr
+	newArgs = append(newArgs, e.Args[:argPos]...)
+	ctxExpr = cfg.resolveCtxExprLibWildcard(cfg.resolveCtxExprPackageWildcard(ctxExpr))
+	newArgs = append(newArgs, ast.NewIdent(ctxExpr))
+	newArgs = append(newArgs, e.Args[argPos:]...)
+	e.Args = newArgs
+	if cfg.isNilCallReplacement(callReplacement) {
+		cfg.recordArtificialCtx(uniquePos, pos, argEdit)
+	}
+	cfg.modified = true
+	cfg.astCallsModifiedNum++
+	cfg.addArgEdit(e, uniquePos, argPos)
+}
 
+// getLastCtxArgPos returns the last position of the context argument
+// at a given call site - it precedes variadic arguments (or a slice
+// passed as variadic arguments) if the called function is variadic.
+func (cfg *transformerConfig) getLastCtxArgPos(e *ast.CallExpr) int {
+	t := cfg.currentPkg.TypesInfo.TypeOf(e.Fun)
+	if t == nil {
+		return len(e.Args)
+	}
+	sig, ok := t.Underlying().(*types.Signature)
+	if !ok || !sig.Variadic() || sig.Params().Len()-1 > len(e.Args) {
+		return len(e.Args)
+	}
+	return sig.Params().Len() - 1
+}
+
+// rewriteClosureArgs replaces named functions passed as arguments to
+// a closure-boundary function with closures forwarding context to
+// these functions.
+func (cfg *transformerConfig) rewriteClosureArgs(e *ast.CallExpr, pos token.Pos) {
+	uniquePos := cfg.getUniquePosPkg(cfg.currentPkg.Types, pos)
+	for i, ctxExpr := range cfg.closureArgs[uniquePos] {
+		if i >= len(e.Args) {
+			continue
 		}
-	} else if e.Args != nil {
-		for _, a := range e.Args {
-			c, ok := a.(*ast.CallExpr)
-			if !ok {
-				continue
-			}
-			if c.Args != nil {
-				continue
-			}
-			uniqueCallPos := cfg.getUniquePosPkg(cfg.currentPkg.Types, c.Lparen)
-			callReplacement, exists := cfg.callSites[uniqueCallPos]
-			if !exists {
-				continue
-			}
-			if callReplacement.argPos != 1 {
-				cfg.writeWarning(cfg.currentPkg.Fset, pos, "WARNING: requesting to put a context argument in a position other then the first one for parameter-less function - defaulting to first position")
-			}
-			ctxExpr := cfg.getCtxExprAndAddImports(cfg.existingImports, cfg.newImports, callReplacement)
-			args := []ast.Expr{ast.Expr(ast.NewIdent(cfg.resolveCtxExprPackageWildcard(ctxExpr)))}
-			c.Args = args
-			cfg.modified = true
-			cfg.astCallsModifiedNum++
+		t := cfg.currentPkg.TypesInfo.TypeOf(e.Args[i])
+		if t == nil {
+			continue
 		}
-		if callReplacement, exists := cfg.callSites[uniquePos]; exists {
-			var argPos int
-			if callReplacement.argPos < 1 {
-				// inject at the last position if negative argPos value
-				argPos = len(e.Args)
-			} else {
-				argPos = callReplacement.argPos - 1
-				if argPos > len(e.Args) {
-					log.Fatalf("error requesting to put a context argument in a position beyond the last function parameter" + cfg.currentPkg.Fset.Position(pos).String())
-				}
+		sig, ok := t.Underlying().(*types.Signature)
+		if !ok {
+			continue
+		}
+		cfg.addEdit(e.Args[i].Pos(), edit{Kind: closureEdit, Callee: types.ExprString(e.Args[i]), Expr: normalizeExpr(ctxExpr), ArgIndex: i})
+		e.Args[i] = cfg.newForwardingClosure(e.Args[i], sig, ctxExpr)
+		cfg.modified = true
+		cfg.astCallsModifiedNum++
+	}
+}
+
+// newForwardingClosure creates a closure (with a given signature)
+// calling a given function with context expression as the first (or
+// the last, see CtxLastEverywhere) argument along with the closure's
+// parameters.
+func (cfg *transformerConfig) newForwardingClosure(fn ast.Expr, sig *types.Signature, ctxExpr string) *ast.FuncLit {
+	qualifier := func(p *types.Package) string {
+		if p.Path() == cfg.currentPkg.PkgPath {
+			return ""
+		}
+		if alias, exists := cfg.existingImports[p.Path()]; exists {
+			if alias != "" {
+				return alias
 			}
-			ctxExpr := cfg.getCtxExprAndAddImports(cfg.existingImports, cfg.newImports, callReplacement)
-			var newArgs []ast.Expr
-			newArgs = append(newArgs, e.Args[:argPos]...)
-			newArgs = append(newArgs, ast.NewIdent(cfg.resolveCtxExprPackageWildcard(ctxExpr)))
-			newArgs = append(newArgs, e.Args[argPos:]...)
-			e.Args = newArgs
-			cfg.modified = true
-			cfg.astCallsModifiedNum++
+			return p.Name()
+		}
+		cfg.newImports[p.Path()] = ""
+		return p.Name()
+	}
+	ft := &ast.FuncType{Func: fn.Pos(), Params: &ast.FieldList{}}
+	call := &ast.CallExpr{Fun: fn}
+	if !cfg.CtxLastEverywhere {
+		call.Args = append(call.Args, ast.NewIdent(ctxExpr))
+	}
+	params := sig.Params()
+	for i := 0; i < params.Len(); i++ {
+		name := "p" + strconv.Itoa(i)
+		typ := types.TypeString(params.At(i).Type(), qualifier)
+		if sig.Variadic() && i == params.Len()-1 {
+			typ = "..." + types.TypeString(params.At(i).Type().(*types.Slice).Elem(), qualifier)
+			call.Ellipsis = fn.End()
 		}
+		ft.Params.List = append(ft.Params.List, &ast.Field{Names: []*ast.Ident{ast.NewIdent(name)}, Type: ast.NewIdent(typ)})
+		if cfg.CtxLastEverywhere && sig.Variadic() && i == params.Len()-1 {
+			call.Args = append(call.Args, ast.NewIdent(ctxExpr))
+		}
+		call.Args = append(call.Args, ast.NewIdent(name))
+	}
+	if cfg.CtxLastEverywhere && !sig.Variadic() {
+		call.Args = append(call.Args, ast.NewIdent(ctxExpr))
+	}
+	results := sig.Results()
+	if results.Len() > 0 {
+		ft.Results = &ast.FieldList{}
+		for i := 0; i < results.Len(); i++ {
+			ft.Results.List = append(ft.Results.List, &ast.Field{Type: ast.NewIdent(types.TypeString(results.At(i).Type(), qualifier))})
+		}
+	}
+	var stmt ast.Stmt = &ast.ExprStmt{X: call}
+	if results.Len() > 0 {
+		stmt = &ast.ReturnStmt{Results: []ast.Expr{call}}
 	}
+	return &ast.FuncLit{Type: ft, Body: &ast.BlockStmt{List: []ast.Stmt{stmt}}}
 }
 
 // addContextParam adds additional context parameter.
@@ -358,6 +773,18 @@ func (cfg *transformerConfig) addContextParam(fl *ast.FieldList) {
 		params := []*ast.Field{&ast.Field{Doc: nil, Names: names, Type: &typ, Tag: nil, Comment: nil}}
 		fl.List = params
 		// don't traverse list or parameters again
+	} else if cfg.CtxLastEverywhere {
+		// context parameter is the last one (preceding the variadic
+		// one, if any) and it is named only if other parameters are
+		ctxParam := &ast.Field{Doc: nil, Names: nil, Type: ast.NewIdent(cfg.ctxParamTypeWithPkgAlias), Tag: nil, Comment: nil}
+		if fl.List[0].Names != nil {
+			ctxParam.Names = []*ast.Ident{ast.NewIdent(cfg.CtxParamName)}
+		}
+		i := len(fl.List)
+		if _, ok := fl.List[i-1].Type.(*ast.Ellipsis); ok {
+			i--
+		}
+		fl.List = append(fl.List[:i:i], append([]*ast.Field{ctxParam}, fl.List[i:]...)...)
 	} else {
 		// we only want to process parameters (return types are represented by the same ast node type)
 		// so we do recursive application on the parameters firgsPeld only
@@ -365,6 +792,55 @@ func (cfg *transformerConfig) addContextParam(fl *ast.FieldList) {
 	}
 }
 
+// renameContextParam changes name of the injected context parameter
+// (see getCtxParamIndex) if it has to be different from the default
+// one.
+func (cfg *transformerConfig) renameContextParam(fl *ast.FieldList, name string) {
+	i := cfg.getCtxParamIndex(fl)
+	if name == cfg.CtxParamName || len(fl.List) <= i || len(fl.List[i].Names) == 0 {
+		return
+	}
+	fl.List[i].Names[0].Name = name
+}
+
+// getCtxParamIndex returns index of the (injected) context parameter
+// in a given parameter list - it is the first one unless the first
+// parameter is of a foreign context type after which context
+// parameter is placed (see ForeignCtxTypes) or unless context
+// parameter is placed last (see CtxLastEverywhere).
+func (cfg *transformerConfig) getCtxParamIndex(fl *ast.FieldList) int {
+	if cfg.CtxLastEverywhere && len(fl.List) > 0 {
+		i := len(fl.List) - 1
+		if _, ok := fl.List[i].Type.(*ast.Ellipsis); ok && i > 0 {
+			i--
+		}
+		return i
+	}
+	if len(fl.List) > 1 && cfg.isFieldCtxAfterForeign(fl.List[0]) {
+		return 1
+	}
+	return 0
+}
+
+// isFieldCtxAfterForeign determines if a given parameter is of a
+// foreign context type after which context parameter is placed.
+func (cfg *transformerConfig) isFieldCtxAfterForeign(fld *ast.Field) bool {
+	foreign := cfg.getForeignCtx(cfg.currentPkg.TypesInfo.TypeOf(fld.Type))
+	return foreign != nil && foreign.CtxExtract == ""
+}
+
+// isCallCtxAfterForeign determines if the function called at a given
+// call site takes the first parameter of a foreign context type after
+// which context parameter is placed.
+func (cfg *transformerConfig) isCallCtxAfterForeign(e *ast.CallExpr) bool {
+	t := cfg.currentPkg.TypesInfo.TypeOf(e.Fun)
+	if t == nil {
+		return false
+	}
+	sig, ok := t.Underlying().(*types.Signature)
+	return ok && cfg.isCtxAfterForeign(sig.Params())
+}
+
 // addContextParamNonEmptyListApply adds additional context parameter
 // to the existing list of declared function parameters (to be used
 // with astutil.Apply function).
@@ -375,11 +851,21 @@ func (cfg *transformerConfig) addContextParamNonEmptyListApply(c *astutil.Cursor
 			// also to function type representing type of a parameter;
 			// in the latter case, both using param name and omitting
 			// it is valid syntax but these two forms cannot be mixed
-			if fl.List[0].Names == nil {
-				c.InsertBefore(&ast.Field{Doc: nil, Names: nil, Type: ast.NewIdent(cfg.ctxParamTypeWithPkgAlias), Tag: nil, Comment: nil})
+			ctxParam := &ast.Field{Doc: nil, Names: nil, Type: ast.NewIdent(cfg.ctxParamTypeWithPkgAlias), Tag: nil, Comment: nil}
+			if fl.List[0].Names != nil {
+				ctxParam.Names = []*ast.Ident{ast.NewIdent(cfg.CtxParamName)}
+			}
+			if fld := fl.List[0]; cfg.isFieldCtxAfterForeign(fld) {
+				// context parameter follows the parameter of
+				// foreign context type - other parameters declared
+				// together with it must be split off
+				if len(fld.Names) > 1 {
+					c.InsertAfter(&ast.Field{Doc: nil, Names: fld.Names[1:], Type: fld.Type, Tag: nil, Comment: nil})
+					fld.Names = fld.Names[:1]
+				}
+				c.InsertAfter(ctxParam)
 			} else {
-				names := []*ast.Ident{ast.NewIdent(cfg.CtxParamName)}
-				c.InsertBefore(&ast.Field{Doc: nil, Names: names, Type: ast.NewIdent(cfg.ctxParamTypeWithPkgAlias), Tag: nil, Comment: nil})
+				c.InsertBefore(ctxParam)
 			}
 		}
 		// don't traverse any children to avoid spurious updates
@@ -392,23 +878,133 @@ func (cfg *transformerConfig) addContextParamNonEmptyListApply(c *astutil.Cursor
 // traversal (to be used with astutil.Apply function).
 func (cfg *transformerConfig) addContextParamApply(c *astutil.Cursor) bool {
 	if fl, ok := c.Node().(*ast.FieldList); ok && c.Name() == "Params" {
+		name := cfg.CtxParamName
+		if ft, ok := c.Parent().(*ast.FuncType); ok {
+			name = cfg.getFuncTypeCtxParamName(ft)
+		}
 		cfg.addContextParam(fl)
+		cfg.renameContextParam(fl, name)
 		return false
 	}
 	return true
 }
 
-// addContextInitStmt adds context variable definition at the
-// beginning of the function's statement list.
-func (cfg *transformerConfig) addContextInitStmt(stmtsList []ast.Stmt, sigPos token.Pos) []ast.Stmt {
+// getFuncTypeCtxParamName returns name of the context parameter to be
+// injected into a given function type without a body (e.g. an
+// interface method) that does not conflict with names of its
+// parameters or named results. Unlike for function declarations (see
+// recordCtxParamName), there is no scope the name could conflict in
+// and the conflict is purely syntactic.
+func (cfg *transformerConfig) getFuncTypeCtxParamName(ft *ast.FuncType) string {
+	names := make(map[string]bool)
+	for _, fl := range []*ast.FieldList{ft.Params, ft.Results} {
+		if fl == nil {
+			continue
+		}
+		for _, fld := range fl.List {
+			for _, n := range fld.Names {
+				names[n.Name] = true
+			}
+		}
+	}
+	name := cfg.CtxParamName
+	for i := 1; names[name]; i++ {
+		name = cfg.CtxParamName + strconv.Itoa(i)
+	}
+	return name
+}
+
+// getCtxInitExpr returns expression initializing context variable in
+// a given function (by default an "invalid" context specific to the
+// reason for initializing it, if any).
+func (cfg *transformerConfig) getCtxInitExpr(uniquePos uniquePosInfo) string {
+	if ctxExpr, exists := cfg.ctxInitExprs[uniquePos]; exists {
+		return ctxExpr
+	}
+	if reason, exists := cfg.ctxInitReasons[uniquePos]; exists {
+		if ctxExpr, exists := cfg.ctxParamInvalidReasonsWithPkgAlias[reason]; exists {
+			return ctxExpr
+		}
+	}
+	return cfg.ctxParamInvalidWithPkgAlias
+}
+
+// recordArtificialCtx records artificial context injected at a given
+// position (either as a context variable initialization or as a call
+// site argument) as allowed or disallowed depending on whether the
+// file matches globs specified in the config file (see
+// isArtificialCtxAllowed). Context
+// initialized with an expression specified in the config file (e.g.
+// extracted from the receiver) is not artificial.
+func (cfg *transformerConfig) recordArtificialCtx(uniquePos uniquePosInfo, pos token.Pos, kind string) {
+	if len(cfg.ArtificialCtxAllowedFiles) == 0 {
+		return
+	}
+	if _, exists := cfg.ctxInitExprs[uniquePos]; exists && kind == initEdit {
+		return
+	}
+	p := cfg.currentPkg.Fset.Position(pos)
+	m := map[string]string{"file": cfg.relPath(p.Filename), "line": strconv.Itoa(p.Line), "kind": kind}
+	if cfg.isArtificialCtxAllowed(p.Filename) {
+		cfg.debugData.ArtificialCtxAllowed = append(cfg.debugData.ArtificialCtxAllowed, m)
+		return
+	}
+	cfg.debugData.ArtificialCtxDisallowed = append(cfg.debugData.ArtificialCtxDisallowed, m)
+}
+
+// reportDisallowedArtificialCtx reports artificial context injected in
+// files not matching globs specified in the config file (and fails in
+// strict mode).
+func (cfg *transformerConfig) reportDisallowedArtificialCtx() {
+	disallowed := cfg.debugData.ArtificialCtxDisallowed
+	if len(disallowed) == 0 {
+		return
+	}
+	var positions []string
+	for _, m := range disallowed {
+		positions = append(positions, m["file"]+" (line "+m["line"]+")")
+	}
+	if cfg.debugLevel > 0 {
+		fmt.Println("ERROR: ARTIFICIAL CONTEXT INJECTED IN DISALLOWED FILES:")
+		for _, p := range positions {
+			fmt.Println(p)
+		}
+	}
+	if cfg.opts.Strict {
+		fatal("artificial context injected in files not allowed by the config file: " + strings.Join(positions, ", "))
+	}
+}
+
+// addContextInitStmt adds context variable definition (with a given
+// name and initialized with a given expression) at the beginning of
+// the function body's statement list. The definition is positioned
+// right after the opening brace of the body (or after the comment
+// following the brace on the same line) so that comments preceding the
+// first statement stay attached to this statement.
+func (cfg *transformerConfig) addContextInitStmt(body *ast.BlockStmt, ctxName string, ctxExpr string) []ast.Stmt {
+	pos := body.Lbrace + 1
+	if cfg.currentFile != nil {
+		fset := cfg.currentPkg.Fset
+		for _, c := range cfg.currentFile.Comments {
+			if c.Pos() <= body.Lbrace {
+				continue
+			}
+			if fset.Position(c.Pos()).Line == fset.Position(body.Lbrace).Line && (len(body.List) == 0 || c.End() <= body.List[0].Pos()) {
+				pos = c.End()
+			}
+			break
+		}
+	}
+	// all parts of the definition share the same concrete position to
+	// avoid being split by a comment leading to syntax error
 	newStmt := ast.AssignStmt{
-		Lhs:    []ast.Expr{ast.NewIdent(cfg.CtxParamName)},
-		TokPos: sigPos, // use concrete position to avoid being split by a comment leading to syntax error
+		Lhs:    []ast.Expr{&ast.Ident{Name: ctxName, NamePos: pos}},
+		TokPos: pos,
 		Tok:    token.DEFINE,
-		Rhs:    []ast.Expr{ast.NewIdent(cfg.CtxParamInvalid)}}
+		Rhs:    []ast.Expr{&ast.Ident{Name: ctxExpr, NamePos: pos}}}
 	var newStmtsList []ast.Stmt
 	newStmtsList = append(newStmtsList, &newStmt)
-	newStmtsList = append(newStmtsList, stmtsList...)
+	newStmtsList = append(newStmtsList, body.List...)
 	return newStmtsList
 }
 
@@ -418,7 +1014,7 @@ func (cfg *transformerConfig) addContextInitStmt(stmtsList []ast.Stmt, sigPos to
 // (potentxially custom) parameter name filled with correct values.
 func (cfg *transformerConfig) getCtxExprAndAddImports(existingImports map[string]string, newImports map[string]string, callReplacement *replacementInfo) string {
 	if len(callReplacement.ctxImports) > 1 {
-		log.Fatalf("currently only supporting one custom import per library call in the config file")
+		fatal("currently only supporting one custom import per library call in the config file")
 	}
 	ctxExpr := callReplacement.ctxExpr
 	if ctxExpr == "" {
@@ -435,7 +1031,7 @@ func (cfg *transformerConfig) getCtxExprAndAddImports(existingImports map[string
 				if alias == "" {
 					// existing import does not have an alias
 					if newAlias == "" {
-						log.Fatalf("alias placeholder for library call in the config file exists withou alias itself being defined")
+						fatal("alias placeholder for library call in the config file exists withou alias itself being defined")
 					} else {
 						newImports[newImp] = newAlias
 						return replaceCtxExprWildcard(aliasWildCard, ctxExpr, newAlias)
@@ -457,7 +1053,7 @@ func (cfg *transformerConfig) getCtxExprAndAddImports(existingImports map[string
 			// no existing import with a given path
 			if strings.Contains(ctxExpr, aliasWildCard) {
 				if newAlias == "" {
-					log.Fatalf("alias placeholder for library call in the config file exists withou alias itself being defined")
+					fatal("alias placeholder for library call in the config file exists withou alias itself being defined")
 				} else {
 					newImports[newImp] = newAlias
 					return replaceCtxExprWildcard(aliasWildCard, ctxExpr, newAlias)
@@ -493,5 +1089,33 @@ func (cfg *transformerConfig) resolveCtxExprPackageWildcard(expr string) string
 	} else {
 		replacementName = cfg.CtxPkgName
 	}
+	if cfg.isCtxPkgCurrent() {
+		return replaceCtxExprWildcard(ctxPrefWildcard+".", expr, "")
+	}
 	return replaceCtxExprWildcard(ctxPrefWildcard, expr, replacementName)
 }
+
+// resolveCtxExprLibWildcard resolves the wildcard representing the
+// library package qualifier in the context expression based on the
+// existing import of the library package in a given file (the import
+// is added if the file does not import the library package).
+func (cfg *transformerConfig) resolveCtxExprLibWildcard(expr string) string {
+	if !strings.Contains(expr, libPrefWildcard) {
+		return expr
+	}
+	if cfg.currentPkg.PkgPath == cfg.LibPkgPath {
+		return replaceCtxExprWildcard(libPrefWildcard+".", expr, "")
+	}
+	pkgAlias, importFound := cfg.existingImports[cfg.LibPkgPath]
+	if !importFound {
+		cfg.newImports[cfg.LibPkgPath] = ""
+		return replaceCtxExprWildcard(libPrefWildcard, expr, cfg.LibPkgName)
+	}
+	if pkgAlias == "." {
+		return replaceCtxExprWildcard(libPrefWildcard+".", expr, "")
+	}
+	if pkgAlias == "" {
+		pkgAlias = cfg.LibPkgName
+	}
+	return replaceCtxExprWildcard(libPrefWildcard, expr, pkgAlias)
+}
@@ -10,6 +10,7 @@
 package propagate
 
 import (
+	"context"
 	"go/ast"
 	"go/token"
 	"go/types"
@@ -31,11 +32,27 @@ type replacementInfo struct {
 	ctxImports map[string]string // import path -> alias (optionally empty string)
 	// ctxRegExpr is the string defining context expresion (with
 	// wildcards) to be used as argument for the call (optional -
-	// defaults to the context variable itself).
+	// defaults to the context variable itself). The <?LIB_PREF?>
+	// wildcard is resolved to the library package's qualifier used
+	// in a given file.
 	ctxRegExpr string
 	// ctxExpr is the same as ctxRegExpr but with wildcards resolved
 	// (expression ready for injection).
 	ctxExpr string
+	// isVar is true if the "leaf" function is a package-level
+	// variable of function type rather than a declared function
+	// (optional - defaults to false).
+	isVar bool
+	// applyToResultCall is true if the context argument is to be
+	// passed to the call applied to the result of the function call
+	// (e.g. Counter("name")(ctx, 1)) rather than to the function call
+	// itself (optional - defaults to false).
+	applyToResultCall bool
+	// ctxWrapExpr is the expression (with a wildcard for the context
+	// parameter) wrapping context argument of the call in functions
+	// that already take context parameter (optional - context
+	// parameter is passed as is if not specified).
+	ctxWrapExpr string
 }
 
 // pkgInfo maps package paths to package names defined on these paths.
@@ -49,8 +66,99 @@ type fnInfo map[string]map[string]pkgInfo // func/method -> receiver -> pkgInfo
 // and then to the information for replacing this function call with
 // its context-aware version.
 type fnReplacementInfo map[string]map[string]*replacementInfo // func/method -> receiver -> replacementInfo
+// fnGroupReplacementInfo is the same as fnReplacementInfo but it is
+// specified in the config file as groups of functions sharing the
+// same replacement info.
+type fnGroupReplacementInfo fnReplacementInfo
+
+// streamCtxInfo describes an interface (e.g. a gRPC server stream)
+// whose implementations provide context via one of their methods.
+type streamCtxInfo struct {
+	// PkgPath is path of the package where the interface is defined.
+	PkgPath string
+	// InterfaceName is the name of the interface.
+	InterfaceName string
+	// MethodName is the name of the interface method returning
+	// context.
+	MethodName string
+}
+
+// cmdCtxPatternInfo describes a function (e.g. exec.Command) whose
+// calls are to be replaced with calls to its context-aware variant
+// (e.g. exec.CommandContext) in functions where context is available.
+type cmdCtxPatternInfo struct {
+	// PkgPath is path of the package where both functions are
+	// defined.
+	PkgPath string
+	// OldFunc is the name of the function whose calls are replaced.
+	OldFunc string
+	// NewFunc is the name of the context-aware variant of the
+	// function.
+	NewFunc string
+	// CtxArgPos is position of the context argument of the
+	// context-aware variant (optional - defaults to first position,
+	// negative value denotes last position preceding variadic
+	// arguments, if any).
+	CtxArgPos int
+}
+
+// ctxStructFieldInfo describes a struct field holding context.
+type ctxStructFieldInfo struct {
+	// PkgPath is path of the package where the struct type is
+	// defined.
+	PkgPath string
+	// TypeName is the name of the struct type (methods with both
+	// this type and the pointer to it as receivers use the field).
+	TypeName string
+	// FieldName is the name of the field of the context type.
+	FieldName string
+}
+
+// importInfo describes an import needed by an expression specified in
+// the config file.
+type importInfo struct {
+	// Import is the import path.
+	Import string
+	// Alias is the import alias, also filling in the alias wildcard
+	// in the expression (optional).
+	Alias string
+}
+
+// foreignCtxInfo describes a context-like type (e.g. a web framework's
+// request context) that does not match the configured context type
+// but that functions take as their first parameter.
+type foreignCtxInfo struct {
+	// PkgPath is path of the package where the type is defined.
+	PkgPath string
+	// TypeName is the name of the type (parameters of both this type
+	// and the pointer to it match).
+	TypeName string
+	// CtxExtract is an expression extracting context from a parameter
+	// of this type (e.g. ".Request.Context()") - functions whose first
+	// parameter is of this type initialize context using it rather
+	// than having context parameter injected (optional - if empty,
+	// context parameter is injected after the parameter of this type
+	// instead of at the first position).
+	CtxExtract string
+}
+
+// ctxWrapInfo maps "leaf" function names to expressions wrapping
+// context parameter at their call sites.
+type ctxWrapInfo map[string]string // func/method -> wrap expression
+
+// ctxExprInfo maps reasons for using artificial context to
+// expressions defining it (specified in the config file either as a
+// single expression used for all reasons or as a map with a "default"
+// entry used for reasons without their own entries).
+type ctxExprInfo map[string]string // reason -> expression
 
 type jsonConfig struct {
+	// Extends is path of a base config file (relative to the directory
+	// of this config file unless absolute) that this config file
+	// extends - fields specified in this config file override those
+	// of the base config file, with arrays and maps being merged
+	// (optional).
+	Extends string
 	// CtxPkgPath is package path for the context type.
 	CtxPkgPath string
 	// CtxPkgName is package name for the context type.
@@ -63,8 +171,21 @@ type jsonConfig struct {
 	// CtxParamType is context type.
 	CtxParamType string
 	// CtxParamInvalid is an expression defining "invalid" context (to
-	// be used when propagated context is unavailable).
-	CtxParamInvalid string
+	// be used when propagated context is unavailable) - it can also
+	// be specified as a map from the reason for using artificial
+	// context (reasons for initializing it in function bodies, such
+	// as "extPkg" or "harness", "init-caller" for calls from
+	// synthetic package initializers, or "flow-barrier-caller" for
+	// calls to ContextFlowBarriers) to the expression, with the
+	// "default" entry used for all other reasons.
+	CtxParamInvalid ctxExprInfo
+	// CtxCallSiteArtificial is an expression defining artificial
+	// context passed as argument at call sites where propagated
+	// context is unavailable, as opposed to CtxParamInvalid
+	// initializing context variables injected into function bodies
+	// (optional - defaults to the default CtxParamInvalid entry;
+	// reason-specific CtxParamInvalid entries take precedence).
+	CtxCallSiteArtificial string
 	// LibPkgPath is path to library where "leaf" functions are
 	// defined.
 	LibPkgPath string
@@ -74,6 +195,13 @@ type jsonConfig struct {
 	// interface defining "leaf" functions (optional - not used if
 	// "leaf" functions specified by describing their definitions).
 	LibIface string
+	// InterfaceShadowPkg is path of an internal package where a
+	// context-aware copy of LibIface is generated (it is output the
+	// same way as transformed files) - internal
+	// references to LibIface are rewritten to refer to the copy
+	// (optional - LibIface references are left intact if not
+	// specified).
+	InterfaceShadowPkg string
 
 	// The following describe custom context 0 the one from which a
 	// "regular" context can be extracted via ctxCustomExprExtract.
@@ -89,17 +217,314 @@ type jsonConfig struct {
 
 	// ExtPkgPaths are paths where external packages reside.
 	ExtPkgPaths []string
+	// FreezeExportedFuncsPkgPaths are paths of packages whose
+	// exported functions (and methods implementing their exported
+	// interfaces) keep their signatures as they may be used outside
+	// of the analyzed code - these functions initialize artificial
+	// context instead (optional).
+	FreezeExportedFuncsPkgPaths []string
+	// TransformCtxPkg is true if the package where the context type
+	// is defined is to be analyzed and transformed like any other
+	// application package rather than treated as external (optional -
+	// defaults to false).
+	TransformCtxPkg bool
 	// ExtEmbedTypes are external types (currently meant for structs
 	// only) that are embedded in user types (methods on these user
 	// types should not have their signatures changed).
 	ExtEmbedTypes typeInfo
+	// TestSuiteTypes are test suite types (e.g. testify's
+	// suite.Suite) that are embedded in user types - methods on these
+	// user types are run by the test harness and should receive
+	// artificial context instead of having their signatures changed.
+	TestSuiteTypes typeInfo
+	// CtxFromReceiver maps receiver types qualified with package path
+	// (e.g. "myorg/http.Request") to expressions extracting context
+	// from the receiver (e.g. ".ctx") - methods on these receiver
+	// types initialize context using the receiver rather than having
+	// context parameter injected.
+	CtxFromReceiver map[string]string
+	// StreamContextExtract are interfaces (e.g. gRPC server streams)
+	// providing context - functions whose first parameter implements
+	// one of them initialize context by calling the interface's
+	// method on the parameter rather than using artificial context
+	// (optional).
+	StreamContextExtract []streamCtxInfo
+	// CommandContextPatterns are functions (e.g. exec.Command) whose
+	// calls are to be replaced with calls to their context-aware
+	// variants (e.g. exec.CommandContext) taking context argument in
+	// functions to which context is propagated (optional).
+	CommandContextPatterns []cmdCtxPatternInfo
+	// ForeignCtxTypes are context-like types that should never
+	// receive a second context - functions whose first parameter is
+	// of one of these types either extract context from this
+	// parameter or receive context parameter as their second
+	// parameter (optional).
+	ForeignCtxTypes []foreignCtxInfo
+	// CtxStructFields are struct fields holding context (e.g. set
+	// when the struct is constructed) - methods of these struct types
+	// are treated as already taking context and pass the field
+	// (accessed via the receiver) as context argument at their call
+	// sites instead of receiving context parameter (optional).
+	CtxStructFields []ctxStructFieldInfo
+	// PropagatedCallExpr is an expression (with a wildcard for the
+	// context parameter, e.g. "ctxutil.Ensure(<?CTX?>)") passed as
+	// context argument at call sites of functions receiving
+	// propagated context (optional - defaults to the context
+	// parameter itself).
+	PropagatedCallExpr string
+	// PropagatedCallImports are imports needed by PropagatedCallExpr,
+	// the same as CtxImports of "leaf" functions (optional - at most
+	// one import can be specified).
+	PropagatedCallImports []importInfo
+	// CtxCarrierTypes are struct types carrying context in a field
+	// set when the struct is constructed - the field is added to the
+	// struct, functions constructing the struct (returning it or a
+	// pointer to it) receive context parameter to initialize the
+	// field and methods of the struct initialize context using the
+	// field rather than having context parameter injected (optional).
+	CtxCarrierTypes typeInfo
+	// AcceptAssignableContext is true if a function's first parameter
+	// of a named interface type whose values can be used as context
+	// (e.g. an interface embedding the context type) is to be treated
+	// as an existing context parameter (optional - defaults to false).
+	AcceptAssignableContext bool
+	// UseAnyPositionCtxAsStop is true if a function taking a (named)
+	// parameter of the context type in a position other than the
+	// first one is to be treated as already taking context - calls
+	// inside it use this parameter and its signature is not modified
+	// (optional - defaults to false).
+	UseAnyPositionCtxAsStop bool
+	// CtxLastEverywhere is true if context parameter is injected as
+	// the last parameter (preceding the variadic one, if any) of
+	// function declarations, function literals, function types and
+	// interface methods, and context argument is injected at their
+	// call sites accordingly - functions taking a (named) parameter of
+	// the context type in any position are then treated as already
+	// taking context (optional - defaults to false, that is to
+	// injecting context as the first parameter).
+	CtxLastEverywhere bool
 	// LibFns are "leaf" functions definitions.
 	LibFns fnReplacementInfo
+	// LibFnGroups are "leaf" functions definitions specified in
+	// groups of function names sharing the same replacement info
+	// (merged into LibFns).
+	LibFnGroups fnGroupReplacementInfo
+	// CtxWrapCallSites are expressions wrapping existing context
+	// parameter (e.g. "context.WithValue(<?CTX?>, key, val)") at
+	// call sites of given "leaf" functions, in functions that
+	// already take context parameter (optional).
+	CtxWrapCallSites ctxWrapInfo
 	// PropagationStops are functions where upward propagating context
 	// should stop.
 	PropagationStops fnInfo
+	// ContextFlowBarriers are functions that receive context
+	// parameter but whose callers pass artificial context instead of
+	// having context propagated further up (optional).
+	ContextFlowBarriers fnInfo
+	// ClosureBoundaryFns are external functions (e.g. errgroup's
+	// Group.Go) that run functions passed to them on behalf of their
+	// callers - named functions passed to them are wrapped in
+	// closures forwarding the caller's context instead of receiving
+	// artificial context (added to the default ones, see
+	// defaultClosureBoundaryFns).
+	ClosureBoundaryFns fnInfo
+	// ArtificialCtxAllowedFiles are glob patterns (where "**"
+	// matches any number of path segments, e.g. "*_test.go" or
+	// "cmd/**") describing files in which artificial context
+	// (CtxParamInvalid) may be used - uses in other files are
+	// reported by Check, and injections in other files are reported
+	// as errors, failing the run in strict mode (optional - if not
+	// specified, injections are not checked). Patterns are matched
+	// against trailing segments of file paths relative to FilePrefix.
+	ArtificialCtxAllowedFiles []string
+	// GeneratedCodePatterns are glob patterns (see
+	// ArtificialCtxAllowedFiles, e.g. "wire_gen.go") describing
+	// generated files in which only call sites are transformed -
+	// functions defined in these files keep their signatures and
+	// initialize artificial context instead (optional).
+	GeneratedCodePatterns []string
+	// MaxEditsPerFileWarn is the number of edits (see the edit
+	// report) in a single file above which a warning describing the
+	// edits is issued (optional - defaults to 0, that is to no
+	// warning).
+	MaxEditsPerFileWarn int
+	// MaxEditsPerFileError is the number of edits in a single file
+	// above which the file is not transformed and the run fails
+	// (optional - defaults to 0, that is to no limit).
+	MaxEditsPerFileError int
 	// LoadPaths are source code paths.
 	LoadPaths []string
+	// FilePrefix is a prefix of the source files path - file paths
+	// reported to the user are relative to this prefix (optional -
+	// defaults to the root directory of the module containing
+	// loaded packages).
+	FilePrefix string
+}
+
+// Options are optional settings of the context propagation process
+// (typically specified via command line flags rather than in the
+// config file).
+type Options struct {
+	// MaxFileSize is the maximum size (in bytes) of a file to be
+	// transformed (0 means no limit). Functions defined in larger
+	// files keep their signatures and context propagation stops at
+	// these functions.
+	MaxFileSize int64
+	// MockFilePath is the path of a file where mock implementation
+	// of the context interface is to be generated (empty string
+	// means that no mock is generated).
+	MockFilePath string
+	// CallGraphComparePath is the path of a file where a report
+	// comparing call graphs constructed using RTA and CHA algorithms
+	// is written (empty string means that no report is written).
+	CallGraphComparePath string
+	// PreserveFormatting is true if transformed files are to be
+	// written by applying minimal edits to the original source code
+	// rather than by formatting whole transformed ASTs.
+	PreserveFormatting bool
+	// ConfigChain is a list of paths of config files to be applied
+	// sequentially, each to the code transformed using the previous
+	// one (if specified, it replaces a single config file path).
+	ConfigChain []string
+	// GenerateAssertions is true if a file with compile-time
+	// assertions that types with modified methods still implement
+	// modified interfaces is to be generated in each package defining
+	// such types (it is output the same way as transformed files).
+	GenerateAssertions bool
+	// Strict is true if the run is to fail when no matching
+	// definition has been found for a "leaf" function specified in
+	// the config file or when artificial context has been injected
+	// in a file not allowed by the config file.
+	Strict bool
+	// MigrationGuidePath is the path of a file where a Markdown
+	// document describing all functions whose signatures have been
+	// changed is written (empty string means that no document is
+	// written).
+	MigrationGuidePath string
+	// EliminateDeadCode is true if functions that have no callers
+	// (other than program entry points, package initializers and test
+	// functions) are to be excluded from the analysis.
+	EliminateDeadCode bool
+	// PackageListPath is the path of a file containing the output of
+	// "go list -json" from which packages are synthesized instead of
+	// being loaded by the go command (empty string means that
+	// packages are loaded by the go command).
+	PackageListPath string
+	// EditReportPath is the path of a file where a JSON document
+	// describing all edits made to the code is written, so that they
+	// can be reverted later (empty string means that no document is
+	// written).
+	EditReportPath string
+	// ContextEscapeAnalysis is true if goroutines started by
+	// anonymous functions referencing context parameter of the
+	// enclosing function are to be reported in the debug output.
+	ContextEscapeAnalysis bool
+	// EmitPlanPath is the path of a file where results of the
+	// analysis phase are written instead of transforming the code
+	// (empty string means that the code is transformed).
+	EmitPlanPath string
+	// ApplyPlanPath is the path of a file containing results of the
+	// analysis phase (see EmitPlanPath) to be used to transform the
+	// code instead of performing the analysis (empty string means
+	// that the analysis is performed). Files that have changed since
+	// the plan was made are not transformed.
+	ApplyPlanPath string
+	// CallGraphPath is the path of a JSON file containing an
+	// externally computed call graph whose edges replace those of the
+	// locally built call graph wherever imported functions and call
+	// sites can be matched with the analyzed code (empty string means
+	// that only the locally built call graph is used).
+	CallGraphPath string
+	// ExportSSAPath is the path of a text file where the SSA
+	// representation of all non-external packages is written along
+	// with edges of the call graph used in the analysis (empty string
+	// means that nothing is written).
+	ExportSSAPath string
+	// TraceFunc is the name of a function (e.g. "pkg/path.FuncName"
+	// or "(*pkg/path.Type).Method") whose analysis decisions are
+	// logged to stderr and included in the debug data (empty string
+	// means that no function is traced).
+	TraceFunc string
+	// ExplainFunc is the name of a function (e.g. "pkg/path.FuncName"
+	// or "pkg/path.(*Type).Method") for which a human-readable
+	// explanation of how the analysis has categorized it (including
+	// the chain of calls leading to a "leaf" function) is printed
+	// after the analysis and included in the debug data (empty string
+	// means that no function is explained).
+	ExplainFunc string
+	// AffectedTestsPath is the path of a JSON file where test
+	// functions (transitively) calling functions whose call sites
+	// have been modified are listed (empty string means that no
+	// list is written).
+	AffectedTestsPath string
+	// PackageGraphPath is the path of a file where a graph (in the
+	// DOT format) of packages involved in context propagation is
+	// written, with edges leading from packages to packages they
+	// import whose modifications they have to follow (empty string
+	// means that no graph is written).
+	PackageGraphPath string
+	// RewriteBlankIdentifier is true if blank (or unnamed) context
+	// parameters of all functions in non-external packages are to be
+	// named using the context parameter name specified in the config
+	// file, regardless of whether the functions are on the
+	// propagation path or not.
+	RewriteBlankIdentifier bool
+	// CheckIfaceCompleteness is true if, after transformation, the
+	// transformed code is to be type-checked to verify that all types
+	// implementing modified interfaces before transformation still
+	// implement them (the run fails otherwise, as the transformed
+	// code would not compile).
+	CheckIfaceCompleteness bool
+	// MetricsAddr is the address (e.g. "localhost:9090") of an HTTP
+	// server serving Prometheus metrics describing progress of the
+	// process at /metrics for the duration of Run (empty string means
+	// that no server is started).
+	MetricsAddr string
+	// Watch is true if, after the initial run, config files and files
+	// of the loaded packages (including new files created in
+	// directories matching load paths) are watched for modifications
+	// and context propagation is re-run each time they change, with
+	// failed runs reported rather than terminating the program
+	// (RunWithOptions returns only when its context is done in this
+	// mode).
+	Watch bool
+	// OnPlanReady is a function invoked with results of the analysis
+	// phase (see Plan) before the code is transformed (or before the
+	// plan is written to EmitPlanPath) - entries removed from the
+	// plan are not applied and returning an error aborts the process
+	// (nil means that no function is invoked). Positions in the plan
+	// refer to files of the loaded packages and entries added to it
+	// must refer to existing positions.
+	OnPlanReady func(plan *Plan) error
+
+	// metrics are metrics served at MetricsAddr (nil if no server is
+	// started).
+	metrics *metrics
+	// watcher tracks modifications of files of the loaded packages
+	// (nil unless Watch is set).
+	watcher *watcher
+}
+
+// AnalysisResult contains results of the analysis phase of the
+// context propagation process that can be queried by the tool's users
+// (see ContextAlreadyPropagated, BuildContextFlowGraph and
+// BuildContextSensitivityReport).
+type AnalysisResult struct {
+	// ctxParams tells whether a given function already takes context
+	// as its first parameter (keys are computed by getFnKey).
+	ctxParams map[string]bool
+	// graph is the call graph constructed during analysis.
+	graph *cg.Graph
+	// flowFns are kinds of functions involved in context propagation
+	// (keys are function names qualified with package path).
+	flowFns map[string]flowInfo
+	// flowEdges describe context flowing along the edges of the call
+	// graph.
+	flowEdges map[flowEdgeKey]flowInfo
+	// sensitivity are categories of functions in the context
+	// sensitivity report (keys are function names qualified with
+	// package path).
+	sensitivity map[string]int
 }
 
 // uniquePosInfo represents position info across different file
@@ -108,6 +533,10 @@ type jsonConfig struct {
 type uniquePosInfo struct {
 	pos  token.Pos
 	fset *token.FileSet
+	// syntheticID distinguishes functions with no position in the
+	// source code (e.g. synthetic package initializers) - it is 0
+	// for all other positions.
+	syntheticID int
 }
 
 // debugInfo represents debugging information collected during
@@ -116,8 +545,117 @@ type debugInfo struct {
 	// Excluded is a list of packages excluded from the analysis
 	// (e.g. due to build problems).
 	Excluded []string
-	// Warnings is a list of warnings to be reported to the tool user.
+	// Warnings is a list of warnings to be reported to the tool user
+	// (each with "msg", "file" and "line" keys, where "file" is a path
+	// relative to the module root or to FilePrefix specified in the
+	// config file).
 	Warnings []map[string]string
+	// UnusedLibFns is a list of "leaf" functions specified in the
+	// config file for which no call sites have been found (each with
+	// "fn", "reason" and "candidates" keys, where "candidates" are
+	// functions with the same name but defined in a different package
+	// or on a different receiver).
+	UnusedLibFns []map[string]string
+	// CtxEscapes is a list of goroutines started by anonymous
+	// functions referencing context parameter of the enclosing
+	// function (each with "file" and "line" keys describing position
+	// of the go statement, "fn" key describing the enclosing function
+	// and "param" key describing the context parameter).
+	CtxEscapes []map[string]string
+	// Trace is a list of analysis decisions concerning the function
+	// specified via Options.TraceFunc.
+	Trace []string
+	// Explanation is a human-readable explanation of how the function
+	// specified via Options.ExplainFunc has been categorized by the
+	// analysis.
+	Explanation []string
+	// LeafStats is a list of statistics of propagation fan-out of
+	// "leaf" functions (each with "fn", "callSites", "fnsModified",
+	// "shared", "artificialEnds" and "realEnds" keys, where "shared"
+	// is the number of modified functions also modified because of
+	// another "leaf" function).
+	LeafStats []map[string]string
+	// Stats are statistics of the analysis and transformation.
+	Stats stats
+	// ArtificialCtxAllowed is a list of artificial context
+	// injections in files matching globs specified in the config
+	// file (each with "file", "line" and "kind" keys, where "kind"
+	// is "init" for context variable initialization and "arg" for
+	// call site argument).
+	ArtificialCtxAllowed []map[string]string
+	// ArtificialCtxDisallowed is a list of artificial context
+	// injections in files not matching globs specified in the config
+	// file (with the same keys as ArtificialCtxAllowed).
+	ArtificialCtxDisallowed []map[string]string
+	// IncompleteIfaceImpls is a list of types implementing modified
+	// interfaces before transformation but not after it (each with
+	// "iface", "type", "method", "file" and "line" keys, where
+	// "method" is the interface method whose implementation has not
+	// been updated), only collected if
+	// Options.CheckIfaceCompleteness is set.
+	IncompleteIfaceImpls []map[string]string
+	// FrozenFns is a list of exported functions of packages
+	// specified in FreezeExportedFuncsPkgPaths (and methods
+	// implementing their exported interfaces) that initialize
+	// artificial context instead of receiving context parameter
+	// (each with "fn", "file" and "line" keys).
+	FrozenFns []map[string]string
+	// EditLimitsExceeded is a list of files whose number of edits
+	// exceeds MaxEditsPerFileWarn or MaxEditsPerFileError specified
+	// in the config file (each with "file", "edits", "kinds" and
+	// "skipped" keys, where "kinds" is the number of edits of each
+	// kind and "skipped" is "true" if the file has not been
+	// transformed).
+	EditLimitsExceeded []map[string]string
+}
+
+// stats are statistics of the analysis and transformation that can be
+// tracked over time (e.g. during migration of a large code base).
+type stats struct {
+	// LeafCalls is the number of "leaf" function call sites found.
+	LeafCalls int
+	// FnsVisited is the number of functions (and function types)
+	// that receive context parameter.
+	FnsVisited int
+	// ArtificialCtxs maps reasons for initializing artificial
+	// context (e.g. "harness" or "extPkg") to numbers of functions
+	// initializing it.
+	ArtificialCtxs map[string]int
+	// IfacesModified is the number of modified interfaces.
+	IfacesModified int
+	// IfaceMethodsModified is the number of modified interface
+	// methods.
+	IfaceMethodsModified int
+	// NamedModified is the number of modified named function types.
+	NamedModified int
+	// ParamsModified is the number of modified parameters (and
+	// struct fields) of function type.
+	ParamsModified int
+	// CallsModified is the number of modified call sites.
+	CallsModified int
+	// SignaturesModified is the number of modified function
+	// signatures.
+	SignaturesModified int
+	// DefinitionsModified is the number of function definitions
+	// with injected context variable.
+	DefinitionsModified int
+	// ImportsAdded is the number of files with added imports.
+	ImportsAdded int
+}
+
+// propagateError represents an error aborting the analysis or
+// transformation process, along with the debugging information
+// collected up to the point of failure.
+type propagateError struct {
+	// msg is the error message.
+	msg string
+	// debugData is debug data collected before the process was
+	// aborted.
+	debugData debugInfo
+}
+
+func (e *propagateError) Error() string {
+	return e.msg
 }
 
 // config is data shared by both the analysis and transformation
@@ -125,16 +663,33 @@ type debugInfo struct {
 type config struct {
 	*jsonConfig
 
+	// ctx is the context of the whole process, checked between its
+	// phases (see checkDone).
+	ctx context.Context
+
 	// debugLevel is debugging level (0 - no debugging info at all).
 	debugLevel int
 
+	// opts are optional settings of the propagation process.
+	opts *Options
+
 	// debugData is debug data collected during analysis to either be
 	// printed or stored into a file.
 	debugData debugInfo
 
-	// filePrefix is a prefix of the source files path.
+	// filePrefix is a prefix of the source files path (see
+	// FilePrefix config field) - empty if unknown.
 	filePrefix string
 
+	// warningsWritten are messages of warnings already written at a
+	// given position (so that identical warnings are written once).
+	warningsWritten map[uniquePosInfo]map[string]bool
+	// fileWarningsWritten are messages of warnings already written
+	// for a given file - used when packages are loaded
+	// incrementally, as the same position may then be represented
+	// in different file sets (and even map to different lines).
+	fileWarningsWritten map[string]map[string]bool
+
 	// commonCallReplacement represents call replacement info for
 	// majority of functions taking context as the first argument
 	// (unless overridden due to how surrounding code looks like).
@@ -143,16 +698,24 @@ type config struct {
 	// nilCallReplacement represents call replacement info for all
 	// functins taking "nil" (invalid) context as the first argument.
 	nilCallReplacement replacementInfo
+	// nilCallReplacements represent call replacement info for
+	// functions taking "nil" (invalid) context as the first argument
+	// for reasons that have their own expressions specified in the
+	// config file.
+	nilCallReplacements map[string]*replacementInfo // reason -> replacementInfo
+	// ctxInitReasons are reasons for initializing artificial context
+	// in functions, recorded for reasons that have their own
+	// expressions specified in the config file.
+	ctxInitReasons map[uniquePosInfo]string
 
 	// libIfaces contains interface definitions specifying methods
 	// that need their signatures changed (describes by "libIface"
-	// field in the JSON config file). The reason it is an array is
-	// that sometimes (I think it's a bug), despite providing one load
-	// path, the packages.Load function loads two packages (with two
-	// interface definitions) from the same sources. If we are
-	// unlucky, when checking for methods implementing the interface,
-	// we will hit the "wrong" one for which types.Implements fill
-	// fail
+	// field in the JSON config file). Sometimes, despite providing
+	// one load path, the packages.Load function loads two packages
+	// (with two interface definitions) from the same sources - only
+	// the canonical instance is included here (see addIface) and
+	// implementations are checked against all copies (see
+	// implementsIface).
 	libIfaces []*types.Interface
 
 	// The following represent context param type qualified with pkg
@@ -160,17 +723,49 @@ type config struct {
 	ctxParamTypeWithPkgAlias    string
 	ctxParamTypeWithPkgPathName string
 
+	// ctxParamInvalidWithPkgAlias is "invalid" context expression
+	// qualified with pkg name (it depends on imports of a given
+	// file).
+	ctxParamInvalidWithPkgAlias string
+	// ctxParamInvalidReasonsWithPkgAlias are reason-specific
+	// "invalid" context expressions qualified with pkg name.
+	ctxParamInvalidReasonsWithPkgAlias map[string]string
+
+	// ctxCallSiteArtificialWithPkgAlias is artificial context
+	// expression passed at call sites qualified with pkg name (it
+	// depends on imports of a given file).
+	ctxCallSiteArtificialWithPkgAlias string
+
 	// ctxCustomParamTypeWithPkgPathName is custom context param type
 	// qualified with both path and name.
 	ctxCustomParamTypeWithPkgPathName string
 
-	// ifaces is a list of all interfaces found in the source code.
+	// ifaces is a list of all interfaces found in the source code
+	// (only canonical instances of interfaces defined in packages
+	// loaded more than once are included - see addIface).
 	ifaces map[*types.Interface]*types.Package
+	// ifaceCopies are all copies of (canonical) interfaces in ifaces.
+	ifaceCopies map[*types.Interface][]*types.Interface
+	// canonicalIfaces map copies of interfaces to their canonical
+	// instances.
+	canonicalIfaces map[*types.Interface]*types.Interface
 
 	// extRecvTypes contains receiver types that contain one of the
 	// embedded external types specified in the config file.
 	extRecvTypes map[*types.Struct]bool
 
+	// testSuiteRecvTypes contains receiver types that contain one of
+	// the embedded test suite types specified in the config file.
+	testSuiteRecvTypes map[*types.Struct]bool
+
+	// frozenIfaces contains exported interfaces defined in packages
+	// specified in FreezeExportedFuncsPkgPaths.
+	frozenIfaces map[*types.Interface]bool
+
+	// streamIfaces maps interfaces specified in the config file as
+	// providing context to names of methods returning it.
+	streamIfaces map[*types.Interface]string
+
 	// fsets is a mapping from packages to fsets for the case when we
 	// have single program but multiple fset-s due to inremental
 	// package loading for large code.
@@ -179,14 +774,51 @@ type config struct {
 	// largeCode is true if incremental package loading was used.
 	largeCode bool
 
+	// skippedFiles are files that exceed maximum file size and will
+	// not be transformed.
+	skippedFiles map[string]bool
+
+	// newFiles are files generated by the tool (e.g. interface
+	// assertions) in a given package (file path -> file content) that
+	// are output along with transformed files.
+	newFiles map[*packages.Package]map[string][]byte
+
+	// generatedFiles are files matching GeneratedCodePatterns in
+	// which only call sites are transformed.
+	generatedFiles map[string]bool
+
+	// staleFiles are files that have changed since the applied plan
+	// was made and will not be transformed.
+	staleFiles map[string]bool
+
+	// syntheticIDs are identifiers assigned to functions with no
+	// position in the source code so that they can be distinguished
+	// from one another (see uniquePosInfo definition).
+	syntheticIDs map[*ssa.Function]int
+	// syntheticCounter is the last identifier assigned to a function
+	// with no position in the source code.
+	syntheticCounter int
+
 	// initial is a list of packages loaded by the tool.
 	initial []*packages.Package
 
+	// shadowPkgName is the name of the package where a context-aware
+	// copy of LibIface is generated (empty if no copy is generated).
+	shadowPkgName string
+
 	// The following are computed during analysis phase and used in
 	// the transformation phase for AST rewriting.
 
 	// fnVisited are functions that need rewriting.
 	fnVisited map[uniquePosInfo]int
+	// ctxParamNames are names of context parameters (or variables)
+	// injected into functions where the default name would conflict
+	// with another identifier (e.g. a named result).
+	ctxParamNames map[uniquePosInfo]string
+	// ctxInitExprs are expressions initializing context variable in
+	// functions that do not use the artificial context (by default
+	// the context variable is initialized to an "invalid" context).
+	ctxInitExprs map[uniquePosInfo]string
 	// callSites are call sites that need an extra context argument.
 	callSites map[uniquePosInfo]*replacementInfo
 	// callSitesRenamed are call sites whose function names need to be
@@ -200,10 +832,30 @@ type config struct {
 	// is a function that needs a context injection in its definition.
 	fnParamsVisited map[uniquePosInfo]bool
 
+	// fnFieldsVisited identifies positions of struct fields whose
+	// type is a function that needs a context injection in its
+	// definition.
+	fnFieldsVisited map[uniquePosInfo]bool
+
 	// renameParamsVisited identifies of context parameters with no
 	// name or with "_" name that need to be turned into named
 	// parameters.
 	renameParamsVisited map[uniquePosInfo]bool
+
+	// closureArgs identifies named function arguments of calls to
+	// closure-boundary functions that need to be wrapped in closures
+	// forwarding context (given by context expression).
+	closureArgs map[uniquePosInfo]map[int]string // call site -> argument index -> context expression
+
+	// carrierTypes identifies context carrier types (by positions of
+	// their names) whose methods use context and that need the
+	// context field.
+	carrierTypes map[uniquePosInfo]bool
+
+	// carrierCtors are constructors of context carrier types mapped
+	// to names of context parameters (or variables) initializing
+	// the context field.
+	carrierCtors map[uniquePosInfo]string
 }
 
 // transformerConfig is data used in the transformation stage.
@@ -215,6 +867,11 @@ type transformerConfig struct {
 
 	// currentPkg is packege a code in a given AST belongs to.
 	currentPkg *packages.Package
+	// currentFile is the file represented by a given AST.
+	currentFile *ast.File
+	// currentFileGenerated is true if the file represented by a given
+	// AST is a generated file (see GeneratedCodePatterns).
+	currentFileGenerated bool
 	// existingImports contains information about existing import
 	// statements (the key is import path, and the value is an
 	// optional alias - otherwise empty string).
@@ -227,11 +884,40 @@ type transformerConfig struct {
 	// modified keeps track of whether a given AST has been modified
 	// at all during transformation.
 	modified bool
+	// namedTypeLits are function literals assigned to package-level
+	// variables of (or converted to) named function types receiving
+	// context parameter - the literals receive context parameter as
+	// well, whether or not they have been reached during analysis.
+	namedTypeLits map[*ast.FuncLit]bool
 
 	// astIfaceModified collects information about interfaces modified
 	// across traversing all AST traversals.
 	astIfaceModified map[*ast.InterfaceType]bool
 
+	// origSigs are signatures of function declarations in the
+	// currently transformed AST before transformation (only collected
+	// if migration guide is to be written).
+	origSigs map[*ast.FuncDecl]string
+	// fnReports describe modified functions across all AST
+	// traversals (only collected if migration guide is to be
+	// written).
+	fnReports []functionReport
+
+	// declSymbols are ranges of top-level symbols in the currently
+	// transformed AST (only collected if edit report is to be
+	// written).
+	declSymbols []declSymbol
+	// currentEdits describe edits of the currently transformed AST
+	// (nil if edit report is not to be written).
+	currentEdits *fileEdits
+	// origCallees are called function expressions at call sites
+	// before they have been renamed (only collected if edit report is
+	// to be written).
+	origCallees map[uniquePosInfo]string
+	// fileEdits describe edits across all AST traversals (only
+	// collected if edit report is to be written).
+	fileEdits []*fileEdits
+
 	// The following count different types of transformations that
 	// actually take place when transforming all ASTs.
 	ifaceMethodModifiedNum int
@@ -256,4 +942,79 @@ type analyzerConfig struct {
 	// and slice construction so that we can avoid modifying functions
 	// with these signatures.
 	mapAndSliceFuncs map[*ssa.Package]map[*types.Signature]bool
+
+	// conversionsWarned are conversions between functions and named
+	// function types that cannot be reconciled and have already been
+	// reported to the user.
+	conversionsWarned map[*ssa.ChangeType]bool
+
+	// libFnCalls are numbers of call sites found for "leaf" functions
+	// specified in the config file.
+	libFnCalls map[string]map[string]int // func/method -> receiver -> number of calls
+
+	// leafCallers are functions calling "leaf" functions specified in
+	// the config file at their call sites.
+	leafCallers map[string]map[string]map[uniquePosInfo]*ssa.Function // func/method -> receiver -> call site -> calling function
+
+	// assignableCtxWarned are parameters of types assignable to the
+	// context type that have been used as context parameters and
+	// already reported to the user.
+	assignableCtxWarned map[*types.Var]bool
+
+	// closureBoundarySites are calls to closure-boundary functions
+	// taking a given named function as an argument.
+	closureBoundarySites map[*ssa.Function][]closureBoundarySite
+
+	// artificialCtxFns are functions initializing artificial context
+	// mapped to the reasons for initializing it (function kinds
+	// in fnVisited map).
+	artificialCtxFns map[uniquePosInfo]int
+	// frozenFns are exported functions of packages specified in
+	// FreezeExportedFuncsPkgPaths (and methods implementing their
+	// exported interfaces) whose signatures are not modified.
+	frozenFns map[uniquePosInfo]*ssa.Function
+	// linknameTargets are external functions (see markLinknameFns)
+	// that functions linked to them via //go:linkname directives are
+	// mapped to.
+	linknameTargets map[uniquePosInfo]string
+	// cgoExports are functions made callable from C code via
+	// //export directives (see markCgoExportFns).
+	cgoExports map[uniquePosInfo]bool
+
+	// fnDefsCollected are outcomes of collecting function definitions
+	// (names of context parameters or variables in these functions)
+	// - keyed by functions rather than by their positions as
+	// functions of different variants of the same package (e.g. test
+	// variants) share positions but not callers.
+	fnDefsCollected map[*ssa.Function]string
+	// fnDefsExamined is the number of times function definitions
+	// have actually been examined (rather than their memoized
+	// outcomes used).
+	fnDefsExamined int
+	// reaches describe how functions have been reached by the
+	// analysis (only collected if Options.ExplainFunc is specified).
+	reaches map[*ssa.Function]reachInfo
+	// explainNotes are analysis decisions concerning the function
+	// specified via Options.ExplainFunc.
+	explainNotes []string
+}
+
+// reachInfo describes how a function has been reached by the analysis.
+type reachInfo struct {
+	// from is the function from which the function has been reached
+	// (nil if the function has been reached directly, e.g. because it
+	// calls a "leaf" function).
+	from *ssa.Function
+	// reason is a human-readable reason for which the function has
+	// been reached.
+	reason string
+}
+
+// closureBoundarySite describes a named function passed as an
+// argument to a closure-boundary function.
+type closureBoundarySite struct {
+	// edge represents the call to the closure-boundary function.
+	edge *cg.Edge
+	// argIndex is the index of the argument at the call site.
+	argIndex int
 }
@@ -12,24 +12,144 @@ package propagate
 import (
 	"go/token"
 	"go/types"
+	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 )
 
+// fatal aborts the initialization, analysis or transformation process
+// with a given error message (see tryInitialize and tryPropagate for
+// how it is handled).
+func fatal(msg string) {
+	panic(&propagateError{msg: msg})
+}
+
 // getUniquePosPkg returns unique position within a given package.
 func (cfg *config) getUniquePosPkg(pkg *types.Package, pos token.Pos) uniquePosInfo {
 	if cfg.largeCode {
-		return uniquePosInfo{pos, cfg.fsets[pkg]}
+		return uniquePosInfo{pos, cfg.fsets[pkg], 0}
+	}
+	return uniquePosInfo{pos, nil, 0}
+}
+
+// getCtxParamName returns name of the context parameter (or variable)
+// injected into a function at a given position.
+func (cfg *config) getCtxParamName(uniquePos uniquePosInfo) string {
+	if name, exists := cfg.ctxParamNames[uniquePos]; exists {
+		return name
+	}
+	return cfg.CtxParamName
+}
+
+// getForeignCtx returns description of the foreign context type (see
+// ForeignCtxTypes) matching a given type or the type it points to (or
+// nil if there is none).
+func (cfg *config) getForeignCtx(t types.Type) *foreignCtxInfo {
+	if len(cfg.ForeignCtxTypes) == 0 || t == nil {
+		return nil
+	}
+	t = types.Unalias(t)
+	if p, ok := t.(*types.Pointer); ok {
+		t = types.Unalias(p.Elem())
+	}
+	named, ok := t.(*types.Named)
+	if !ok || named.Obj().Pkg() == nil {
+		return nil
+	}
+	for i, foreign := range cfg.ForeignCtxTypes {
+		if foreign.PkgPath == named.Obj().Pkg().Path() && foreign.TypeName == named.Obj().Name() {
+			return &cfg.ForeignCtxTypes[i]
+		}
 	}
-	return uniquePosInfo{pos, nil}
+	return nil
+}
+
+// isCtxAfterForeign determines if the first of given parameters is of
+// a foreign context type after which context parameter is placed
+// (rather than at the first position).
+func (cfg *config) isCtxAfterForeign(params *types.Tuple) bool {
+	if params.Len() == 0 {
+		return false
+	}
+	foreign := cfg.getForeignCtx(params.At(0).Type())
+	return foreign != nil && foreign.CtxExtract == ""
+}
+
+// isArtificialCtxReason checks if a given reason for using
+// artificial context can have its own expression specified in the
+// config file.
+func isArtificialCtxReason(reason string) bool {
+	if reason == defaultCtxReason || reason == initCallerCtxReason || reason == flowBarrierCallerCtxReason {
+		return true
+	}
+	for _, r := range artificialCtxReasons {
+		if r == reason {
+			return true
+		}
+	}
+	return false
+}
+
+// getInjectedCtxArgPos returns position of the context argument
+// injected at call sites of functions receiving injected context
+// parameter (see replacementInfo).
+func (cfg *config) getInjectedCtxArgPos() int {
+	if cfg.CtxLastEverywhere {
+		return -1
+	}
+	return 1
+}
+
+// getNilCallReplacement returns call replacement info for passing
+// artificial context as an argument for a given reason (the default
+// one if there is no expression specified for this reason).
+func (cfg *config) getNilCallReplacement(reason string) *replacementInfo {
+	if callReplacement, exists := cfg.nilCallReplacements[reason]; exists {
+		return callReplacement
+	}
+	return &cfg.nilCallReplacement
+}
+
+// getNilCallReason returns reason for passing artificial context as
+// an argument represented by given call replacement info (empty
+// string if artificial context is not passed at all).
+func (cfg *config) getNilCallReason(callReplacement *replacementInfo) string {
+	if callReplacement == &cfg.nilCallReplacement {
+		return defaultCtxReason
+	}
+	for reason, r := range cfg.nilCallReplacements {
+		if r == callReplacement {
+			return reason
+		}
+	}
+	return ""
+}
+
+// isNilCallReplacement checks if given call replacement info
+// represents passing artificial context as an argument.
+func (cfg *config) isNilCallReplacement(callReplacement *replacementInfo) bool {
+	return callReplacement != nil && cfg.getNilCallReason(callReplacement) != ""
+}
+
+// isPkgFrozen determines if exported functions of a package with a
+// given path keep their signatures (see FreezeExportedFuncsPkgPaths).
+func (cfg *config) isPkgFrozen(pkgPath string) bool {
+	for _, frozenPath := range cfg.FreezeExportedFuncsPkgPaths {
+		if strings.HasPrefix(pkgPath, frozenPath) {
+			return true
+		}
+	}
+	return false
 }
 
 // isPkgExternal determines if a package external that is if its path is:
-// - the same as that of the package where context is defined
+// - the same as that of the package where context is defined (unless
+// this package is to be transformed)
 // - the same as that of the package where leaf functions are defined
 // - when it's on the on the explicit list of external package paths.
 func (cfg *config) isPkgExternal(pkgPath string) bool {
-	if strings.HasPrefix(pkgPath, cfg.CtxPkgPath) {
+	if !cfg.TransformCtxPkg && strings.HasPrefix(pkgPath, cfg.CtxPkgPath) {
 		return true
 	}
 	if strings.HasPrefix(pkgPath, cfg.LibPkgPath) {
@@ -43,15 +163,175 @@ func (cfg *config) isPkgExternal(pkgPath string) bool {
 	return false
 }
 
+// isLibPkg determines if a package is the one where leaf functions
+// are defined - this includes external test package of this package
+// (whose path and name have additional "_test" suffix).
+func (cfg *config) isLibPkg(pkgPath string, pkgName string) bool {
+	return strings.TrimSuffix(pkgPath, "_test") == cfg.LibPkgPath && strings.TrimSuffix(pkgName, "_test") == cfg.LibPkgName
+}
+
+// initFilePrefix initializes prefix of the source files path - unless
+// specified in the config file, it is the root directory of the module
+// containing loaded packages (if any).
+func (cfg *config) initFilePrefix() {
+	if cfg.FilePrefix != "" {
+		cfg.filePrefix = cfg.FilePrefix
+		return
+	}
+	for _, p := range cfg.initial {
+		if len(p.GoFiles) > 0 {
+			cfg.filePrefix = findModuleRoot(filepath.Dir(p.GoFiles[0]))
+			return
+		}
+	}
+}
+
+// findModuleRoot returns the closest directory containing go.mod file,
+// starting from a given directory and moving up the directory tree
+// (empty string is returned if there is no such directory).
+func findModuleRoot(dir string) string {
+	for {
+		if info, err := os.Stat(filepath.Join(dir, "go.mod")); err == nil && !info.IsDir() {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// relPath returns file path relative to the source files path prefix
+// or the original path if the file is not located under this
+// prefix. Relative paths are slash-separated so that they are the
+// same on all platforms.
+func (cfg *config) relPath(path string) string {
+	if cfg.filePrefix == "" {
+		return path
+	}
+	rel, err := filepath.Rel(cfg.filePrefix, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return path
+	}
+	return filepath.ToSlash(rel)
+}
+
 // writeWarning writes a warning, either to std out or as a command to
 // script file issuing inline comments.
 func (cfg *config) writeWarning(fset *token.FileSet, pos token.Pos, msg string) {
 	p := fset.Position(pos)
 	if cfg.debugLevel > 0 {
 		m := make(map[string]string)
-		m["file"] = strings.TrimPrefix(fset.File(pos).Name(), cfg.filePrefix)
+		m["file"] = ""
+		if f := fset.File(pos); f != nil {
+			// position may be unknown (e.g. for synthetic functions)
+			m["file"] = cfg.relPath(f.Name())
+		}
 		m["line"] = strconv.Itoa(p.Line)
 		m["msg"] = msg
+		posKey := uniquePosInfo{pos: pos, fset: fset}
+		if cfg.warningsWritten[posKey][msg] {
+			// identical warning at the same position
+			return
+		}
+		if cfg.largeCode && cfg.fileWarningsWritten[m["file"]][msg] {
+			// identical warning in the same file (position may be
+			// represented differently in a different file set)
+			return
+		}
+		if cfg.warningsWritten == nil {
+			cfg.warningsWritten = make(map[uniquePosInfo]map[string]bool)
+			cfg.fileWarningsWritten = make(map[string]map[string]bool)
+		}
+		if cfg.warningsWritten[posKey] == nil {
+			cfg.warningsWritten[posKey] = make(map[string]bool)
+		}
+		cfg.warningsWritten[posKey][msg] = true
+		if cfg.fileWarningsWritten[m["file"]] == nil {
+			cfg.fileWarningsWritten[m["file"]] = make(map[string]bool)
+		}
+		cfg.fileWarningsWritten[m["file"]][msg] = true
 		cfg.debugData.Warnings = append(cfg.debugData.Warnings, m)
 	}
 }
+
+// isArtificialCtxAllowed checks if artificial context may be used in a
+// given file, that is if the file path (relative to the file prefix)
+// matches one of the patterns specified in ArtificialCtxAllowedFiles
+// (e.g. "cmd/*.go" matches "svc/cmd/main.go"). It is shared by Check
+// and the transformation phase so that both decide the same way.
+func (cfg *config) isArtificialCtxAllowed(filePath string) bool {
+	relPath := cfg.relPath(filePath)
+	for _, pattern := range cfg.ArtificialCtxAllowedFiles {
+		if matchesGlob(pattern, relPath) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesGlob determines if a given file path matches a given glob
+// pattern (see filepath.Match) where "**" matches any number of path
+// segments. The pattern is matched against trailing segments of the
+// path so that it does not depend on where the code base is located
+// (e.g. "*_test.go" matches test files in all directories).
+func matchesGlob(pattern string, path string) bool {
+	patternSegs := strings.Split(pattern, "/")
+	pathSegs := strings.Split(filepath.ToSlash(path), "/")
+	for i := range pathSegs {
+		if matchesGlobSegs(patternSegs, pathSegs[i:]) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesGlobSegs determines if given path segments match given glob
+// pattern segments.
+func matchesGlobSegs(patternSegs []string, pathSegs []string) bool {
+	if len(patternSegs) == 0 {
+		return len(pathSegs) == 0
+	}
+	if patternSegs[0] == "**" {
+		for i := 0; i <= len(pathSegs); i++ {
+			if matchesGlobSegs(patternSegs[1:], pathSegs[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(pathSegs) == 0 {
+		return false
+	}
+	if matched, _ := filepath.Match(patternSegs[0], pathSegs[0]); !matched {
+		return false
+	}
+	return matchesGlobSegs(patternSegs[1:], pathSegs[1:])
+}
+
+// canonicalIface returns the canonical instance of a given interface
+// (see addIface) or the interface itself if it has not been recorded.
+func (cfg *config) canonicalIface(iface *types.Interface) *types.Interface {
+	if canonical, exists := cfg.canonicalIfaces[iface]; exists {
+		return canonical
+	}
+	return iface
+}
+
+// implementsIface determines if a given type implements a given
+// interface, checking all copies of the interface (see addIface) as
+// the type may refer to types defined in any of them.
+func (cfg *config) implementsIface(t types.Type, iface *types.Interface) bool {
+	iface = cfg.canonicalIface(iface)
+	copies, exists := cfg.ifaceCopies[iface]
+	if !exists {
+		return types.Implements(t, iface)
+	}
+	for _, c := range copies {
+		if types.Implements(t, c) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,95 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package propagate
+
+import (
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFindModuleRoot(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "a", "b")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if r := findModuleRoot(dir); r != "" {
+		t.Errorf("unexpected module root %s", r)
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, "go.mod"), []byte("module m\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if r := findModuleRoot(dir); r != root {
+		t.Errorf("expected module root %s but found %s", root, r)
+	}
+}
+
+func TestMatchesGlob(t *testing.T) {
+	for _, c := range []struct {
+		pattern  string
+		path     string
+		expected bool
+	}{
+		{"*_test.go", "/src/pkg/a_test.go", true},
+		{"*_test.go", "/src/pkg/a.go", false},
+		{"cmd/**", "/src/cmd/tool/main.go", true},
+		{"cmd/**", "/src/pkg/main.go", false},
+		{"cmd/*/main.go", "cmd/tool/main.go", true},
+		{"cmd/*/main.go", "cmd/tool/sub/main.go", false},
+		{"pkg/**/gen.go", "/src/pkg/gen.go", true},
+		{"pkg/**/gen.go", "/src/pkg/a/b/gen.go", true},
+	} {
+		if matchesGlob(c.pattern, c.path) != c.expected {
+			t.Errorf("unexpected result of matching %s against %s", c.path, c.pattern)
+		}
+	}
+}
+
+func TestRelPath(t *testing.T) {
+	root := t.TempDir()
+	cfg := &config{filePrefix: root}
+	if p := cfg.relPath(filepath.Join(root, "a", "b.go")); p != "a/b.go" {
+		t.Errorf("unexpected relative path %s", p)
+	}
+	// files outside of the prefix keep their paths
+	outside := filepath.Join(filepath.Dir(root), "b.go")
+	if p := cfg.relPath(outside); p != outside {
+		t.Errorf("unexpected relative path %s", p)
+	}
+	cfg.filePrefix = ""
+	if p := cfg.relPath(outside); p != outside {
+		t.Errorf("unexpected relative path %s", p)
+	}
+}
+
+func TestWriteWarningDedup(t *testing.T) {
+	cfg := &config{debugLevel: 1}
+	fset := token.NewFileSet()
+	f := fset.AddFile("a.go", -1, 100)
+	f.SetLines([]int{0, 10, 20})
+	cfg.writeWarning(fset, f.Pos(15), "WARNING: a")
+	cfg.writeWarning(fset, f.Pos(15), "WARNING: a")
+	cfg.writeWarning(fset, f.Pos(15), "WARNING: b")
+	if len(cfg.debugData.Warnings) != 2 {
+		t.Errorf("unexpected number of warnings %d", len(cfg.debugData.Warnings))
+	}
+	// the same file loaded incrementally into another file set
+	cfg.largeCode = true
+	otherFset := token.NewFileSet()
+	other := otherFset.AddFile("a.go", -1, 100)
+	other.SetLines([]int{0, 5, 20})
+	cfg.writeWarning(otherFset, other.Pos(15), "WARNING: a")
+	if len(cfg.debugData.Warnings) != 2 {
+		t.Errorf("unexpected number of warnings %d", len(cfg.debugData.Warnings))
+	}
+}
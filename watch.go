@@ -0,0 +1,277 @@
+// Copyright (c) 2021 Uber Technologies, Inc.
+//
+// Licensed under the Uber Non-Commercial License (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at the root directory of this project.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package propagate
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"go/build"
+	"golang.org/x/tools/go/packages"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// watcher tracks modifications of files of the loaded packages by
+// periodically checking their modification times (see Options.Watch).
+// Directories of the loaded packages are periodically rescanned for
+// new Go files but their modification times are not checked as
+// transformed files are written to them. All methods can be called on
+// a nil value in which case they do nothing.
+type watcher struct {
+	mu sync.Mutex
+	// modTimes are modification times of watched files (zero for
+	// the ones that do not exist).
+	modTimes map[string]time.Time
+	// dirs are directories rescanned for new Go files (including
+	// their subdirectories for true values).
+	dirs map[string]bool
+}
+
+// newWatcher creates a watcher with no watched files.
+func newWatcher() *watcher {
+	return &watcher{modTimes: make(map[string]time.Time), dirs: make(map[string]bool)}
+}
+
+// addPackages starts watching files of given packages loaded for given
+// load paths (including packages that have not been loaded correctly
+// as they may be fixed) and rescanning their directories for new Go
+// files. Directories matching load paths ending with "/..." are
+// rescanned along with their subdirectories so that new packages are
+// noticed as well.
+func (w *watcher) addPackages(loadPaths []string, pkgs []*packages.Package) {
+	if w == nil {
+		return
+	}
+	var files []string
+	for _, p := range pkgs {
+		files = append(files, p.CompiledGoFiles...)
+	}
+	w.addFiles(files)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, p := range pkgs {
+		if len(p.GoFiles) > 0 {
+			w.addDir(filepath.Dir(p.GoFiles[0]), false)
+		}
+	}
+	for _, loadPath := range loadPaths {
+		if !strings.HasSuffix(loadPath, "/...") {
+			continue
+		}
+		root := strings.TrimSuffix(loadPath, "/...")
+		if build.IsLocalImport(root) || filepath.IsAbs(root) {
+			if abs, err := filepath.Abs(root); err == nil {
+				w.addDir(abs, true)
+			}
+			continue
+		}
+		for _, p := range pkgs {
+			if len(p.GoFiles) == 0 || (p.PkgPath != root && !strings.HasPrefix(p.PkgPath, root+"/")) {
+				continue
+			}
+			// directory of the package path's root is the
+			// package's directory without trailing elements of the
+			// package path following the root
+			dir := filepath.Dir(p.GoFiles[0])
+			if sub := filepath.FromSlash(strings.TrimPrefix(p.PkgPath, root)); strings.HasSuffix(dir, sub) {
+				w.addDir(strings.TrimSuffix(dir, sub), true)
+			}
+		}
+	}
+}
+
+// addDir starts rescanning a given directory (and its subdirectories
+// if recursive) for new Go files, watching the existing ones. It must
+// be called with the lock held.
+func (w *watcher) addDir(dir string, recursive bool) {
+	if watchedRecursive, exists := w.dirs[dir]; exists && (watchedRecursive || !recursive) {
+		return
+	}
+	w.dirs[dir] = recursive
+	for _, f := range goFiles(dir, recursive) {
+		if _, exists := w.modTimes[f]; !exists {
+			w.modTimes[f] = modTime(f)
+		}
+	}
+}
+
+// addFiles starts watching given files (unless they are already
+// watched).
+func (w *watcher) addFiles(files []string) {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, f := range files {
+		if _, exists := w.modTimes[f]; !exists {
+			w.modTimes[f] = modTime(f)
+		}
+	}
+}
+
+// changed returns (sorted) watched files modified since the last
+// check.
+func (w *watcher) changed() []string {
+	if w == nil {
+		return nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for dir, recursive := range w.dirs {
+		for _, f := range goFiles(dir, recursive) {
+			if _, exists := w.modTimes[f]; !exists {
+				// new file is reported as modified
+				w.modTimes[f] = time.Time{}
+			}
+		}
+	}
+	var changed []string
+	for path, t := range w.modTimes {
+		if newTime := modTime(path); !newTime.Equal(t) {
+			w.modTimes[path] = newTime
+			changed = append(changed, path)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// wait blocks until watched files are modified and no further
+// modifications happen for a given debounce time, checking them at a
+// given interval, and returns all modified files (or nil if a given
+// context is done first).
+func (w *watcher) wait(ctx context.Context, interval time.Duration, debounce time.Duration) []string {
+	changed := make(map[string]bool)
+	var last time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(interval):
+		}
+		for _, path := range w.changed() {
+			changed[path] = true
+			last = time.Now()
+		}
+		if len(changed) > 0 && time.Since(last) >= debounce {
+			break
+		}
+	}
+	paths := make([]string, 0, len(changed))
+	for path := range changed {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// goFiles returns Go files in a given directory (and in its
+// subdirectories if recursive, except for the ones ignored by the go
+// command).
+func goFiles(dir string, recursive bool) []string {
+	var files []string
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info.IsDir() {
+			name := info.Name()
+			if path != dir && (!recursive || name == "testdata" || strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_")) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if strings.HasSuffix(path, ".go") {
+			files = append(files, path)
+		}
+		return nil
+	})
+	return files
+}
+
+// modTime returns modification time of a given file (zero if it does
+// not exist).
+func modTime(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+// watch re-runs context propagation each time the watched files are
+// modified until a given context is done (it then returns the
+// context's error). The previous results are given by the content of
+// modified files produced by the initial run.
+func watch(ctx context.Context, configFilePaths []string, debugFilePath string, srcPaths []string, debugLevel int, opts *Options, prev map[string][]byte) error {
+	for {
+		fmt.Println("WATCHING FOR CHANGES")
+		changed := opts.watcher.wait(ctx, watchInterval, watchDebounce)
+		if changed == nil {
+			return ctx.Err()
+		}
+		fmt.Println("CHANGED:")
+		for _, path := range changed {
+			fmt.Println(path)
+		}
+		modified, err := tryPropagateChain(ctx, configFilePaths, debugFilePath, srcPaths, debugLevel, opts)
+		if err != nil {
+			fmt.Println("PROPAGATION FAILED: " + err.Error())
+			continue
+		}
+		writeModified(modified)
+		for _, path := range summarizeRerun(prev, modified) {
+			// transformed versions of files no longer modified
+			// are stale
+			os.Remove(path + ".mod")
+		}
+		prev = modified
+	}
+}
+
+// summarizeRerun prints a summary of differences between results of
+// two consecutive propagation runs (given by contents of modified
+// files) and returns (sorted) files no longer modified.
+func summarizeRerun(prev map[string][]byte, modified map[string][]byte) []string {
+	var added, updated, removed []string
+	for path, src := range modified {
+		if prevSrc, exists := prev[path]; !exists {
+			added = append(added, path)
+		} else if !bytes.Equal(prevSrc, src) {
+			updated = append(updated, path)
+		}
+	}
+	for path := range prev {
+		if _, exists := modified[path]; !exists {
+			removed = append(removed, path)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(updated)
+	sort.Strings(removed)
+	fmt.Println("FILES MODIFIED: " + strconv.Itoa(len(modified)) + " (NEWLY: " + strconv.Itoa(len(added)) + " CHANGED: " + strconv.Itoa(len(updated)) + " NO LONGER: " + strconv.Itoa(len(removed)) + ")")
+	for _, path := range added {
+		fmt.Println("NEWLY MODIFIED: " + path)
+	}
+	for _, path := range updated {
+		fmt.Println("MODIFIED DIFFERENTLY: " + path)
+	}
+	for _, path := range removed {
+		fmt.Println("NO LONGER MODIFIED: " + path)
+	}
+	return removed
+}